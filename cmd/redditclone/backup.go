@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/backup"
+	"os"
+)
+
+const (
+	backupUsage  = "usage: redditclone [-storage kind] backup [-secrets] [-sessions] file"
+	restoreUsage = "usage: redditclone [-storage kind] restore file"
+)
+
+// backupCommand handles the "backup" subcommand writing the archive of the whole storage
+func backupCommand(st *storage, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	secrets := fs.Bool("secrets", false, "keep the password hashes, tokens, two-factor settings and personal tokens of the users")
+	sessions := fs.Bool("sessions", false, "keep the sessions")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(backupUsage)
+	}
+
+	f, err := os.Create(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	manifest, err := newArchiver(st).Export(f, backup.Options{
+		Secrets:  *secrets,
+		Sessions: *sessions,
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backed up %v\n", manifest.Entities)
+	return nil
+}
+
+// restoreCommand handles the "restore" subcommand loading an archive written by backup
+func restoreCommand(st *storage, args []string) error {
+	if len(args) != 1 {
+		return errors.New(restoreUsage)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := newArchiver(st).Restore(f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %v from the backup of %s\n", manifest.Entities, manifest.Created)
+	return nil
+}
+
+func newArchiver(st *storage) *backup.Archiver {
+	return &backup.Archiver{
+		UsersRepo:     st.Users,
+		PostsRepo:     st.Posts,
+		SessionsRepo:  st.Sessions,
+		TwoFactorRepo: st.TwoFactor,
+		APITokensRepo: st.APITokens,
+	}
+}
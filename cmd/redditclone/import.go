@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/seed"
+	"os"
+)
+
+const (
+	importUsage       = "usage: redditclone [-storage kind] import [file]"
+	defaultImportFile = "./../../assets/posts.json"
+)
+
+// importCommand handles the "import" subcommand loading posts dumped from asperitas
+func importCommand(st *storage, args []string) error {
+	if len(args) > 1 {
+		return errors.New(importUsage)
+	}
+	file := defaultImportFile
+	if len(args) == 1 {
+		file = args[0]
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	im := &seed.Importer{
+		UsersRepo: st.Users,
+		PostsRepo: st.Posts,
+	}
+	stats, err := im.Import(f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d posts, created %d users\n", stats.Posts, stats.UsersCreated)
+	return nil
+}
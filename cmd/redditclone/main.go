@@ -1,93 +1,164 @@
 package main
 
 import (
-	"context"
-	"database/sql"
+	"flag"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/config"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/handlers"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ids"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ljwt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/mail"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/middleware"
-	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/posts"
-	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/oidc"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/throttle"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.uber.org/zap"
 )
 
 func main() {
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	zapLogger, _ := zap.NewProduction()
 	defer zapLogger.Sync()
 	logger := zapLogger.Sugar()
 
-	// MySql DB
-	// основные настройки к базе
-	dsn := "root:love1234@tcp(localhost:3306)/golang2?"
-	// указываем кодировку
-	dsn += "&charset=utf8"
-	// отказываемся от prapared statements
-	// параметры подставляются сразу
-	dsn += "&interpolateParams=true"
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+		args = args[1:]
+	}
 
-	db, err := sql.Open("mysql", dsn)
+	if command == "migrate" {
+		db, err := openMySQL(cfg.MySQL)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if cfg.Migrate {
+			err = migrateOnStart(db, logger)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+		err = migrateCommand(db, args)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 
-	db.SetMaxOpenConns(10)
+	storageCommands := map[string]func(*storage, []string) error{
+		"import":  importCommand,
+		"backup":  backupCommand,
+		"restore": restoreCommand,
+		"role":    roleCommand,
+	}
+	if run, ok := storageCommands[command]; ok {
+		st, err := openStorage(cfg, logger)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer st.Close()
+		err = run(st, args)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if command != "" {
+		log.Fatalf("unknown command %q", command)
+	}
 
-	err = db.Ping()
-	if err != nil {
-		log.Fatalln(err)
+	if len(cfg.JWT.KeyIDs()) == 0 {
+		if cfg.Storage != config.StorageMemory {
+			log.Fatalf("jwt key is required, set it with -jwt-key or -jwt-keys (%s)", config.EnvName("jwt-keys"))
+		}
+		// the memory sessions don't outlive the process anyway
+		cfg.JWT.Key = ids.GenerateID()
+		logger.Warnw("no jwt key configured, using a random one")
 	}
-	// -----
 
-	// Mongo DB
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	st, err := openStorage(cfg, logger)
 	if err != nil {
-		logger.Errorf("Can't connect to mongodb. %s", err.Error())
+		logger.Errorf("Can't open storage. %s", err.Error())
 		return
 	}
-	ctx, _ = context.WithTimeout(context.Background(), 2*time.Second)
-	err = client.Ping(ctx, readpref.Primary())
+	defer st.Close()
+	sm := st.Sessions
+	keys, err := newKeyring(cfg.JWT)
 	if err != nil {
-		logger.Errorf("MongoDB ping error. %s", err.Error())
+		logger.Errorf("Can't set up jwt keys. %s", err.Error())
 		return
 	}
-	defer func(c *mongo.Client) {
-		ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-		err := c.Disconnect(ctx)
-		if err != nil {
-			logger.Error("Can't disconnect from mongodb. %s", err.Error())
-		}
-	}(client)
-	coll := client.Database("asperitas").Collection("posts")
-	postsCollection := &posts.MongoCollection{
-		Сoll: coll,
+	tokens := ljwt.NewKeyringManager(keys, cfg.JWT.AccessTTL.Duration)
+	tokens.Verification = cfg.JWT.Verification
+
+	usersHandler := &handlers.UsersHandler{
+		Logger:          logger,
+		UsersRepo:       st.Users,
+		Sessions:        sm,
+		Tokens:          tokens,
+		AccountThrottle: newLoginThrottle(cfg.Login.Account),
+		IPThrottle:      newLoginThrottle(cfg.Login.IP),
+		Resets:          st.Resets,
+		Mailer:          newMailer(cfg.Mail),
+		ResetTTL:        cfg.Password.ResetTTL.Duration,
+		ResetURL:        cfg.Password.ResetURL,
+		Verifications:   st.Verifications,
+		VerifyTTL:       cfg.Email.VerifyTTL.Duration,
+		VerifyURL:       cfg.Email.VerifyURL,
+		TwoFactor:       st.TwoFactor,
+		TwoFactorLogins: st.TwoFactorLogins,
+		TwoFactorTTL:    cfg.TwoFactor.LoginTTL.Duration,
+		TwoFactorIssuer: cfg.TwoFactor.Issuer,
 	}
 
-	sm := session.NewSessionsManager(db)
+	postsHandler := &handlers.PostsHandler{
+		Logger:    logger,
+		UsersRepo: st.Users,
+		PostsRepo: st.Posts,
+	}
 
-	usersRepo := user.NewRepo(db)
-	postsRepo := posts.NewRepo(postsCollection)
+	healthHandler := &handlers.HealthHandler{
+		Logger:  logger,
+		Checks:  st.Checks,
+		Timeout: cfg.HealthTimeout.Duration,
+	}
 
-	usersHandler := &handlers.UsersHandler{
+	rolesHandler := &handlers.RolesHandler{
 		Logger:    logger,
-		UsersRepo: usersRepo,
-		Sessions:  sm,
+		UsersRepo: st.Users,
 	}
 
-	postsHandler := &handlers.PostsHandler{
-		Logger:    logger,
-		UsersRepo: usersRepo,
-		PostsRepo: postsRepo,
+	apiTokensHandler := &handlers.APITokensHandler{
+		Logger: logger,
+		Tokens: st.APITokens,
 	}
 
+	auth := middleware.AuthorizedUserMiddleware(sm, st.Users, st.APITokens, tokens.KeyFunc, logger)
+	verified := requireVerified(cfg.Email, st.Users, logger)
+
 	r := mux.NewRouter()
+	r.Use(middleware.AccessLog(logger))
+
+	r.HandleFunc("/healthz", healthHandler.Live).Methods("GET")
+	r.HandleFunc("/readyz", healthHandler.Ready).Methods("GET")
+
+	keysHandler := &handlers.KeysHandler{Keys: tokens}
+	r.HandleFunc("/.well-known/jwks.json", keysHandler.JWKS).Methods("GET")
 
 	// ar := mux.NewRouter()
 	// authRoute := middleware.Auth(sm, logger, r)
@@ -97,7 +168,7 @@ func main() {
 
 	postsRouter.HandleFunc("/{category}", postsHandler.GetListByCat).Methods("GET")
 
-	addChain := middleware.Chain(postsHandler.Add, middleware.AuthorizedUserMiddleware(sm, logger))
+	addChain := middleware.Chain(postsHandler.Add, verified, auth, middleware.AllowToken(apitoken.ScopePost))
 	postsRouter.HandleFunc("", addChain).Methods("POST")
 
 	// postsByUserRouter := r.PathPrefix("/api/user/{user_login}").Subrouter()
@@ -105,37 +176,187 @@ func main() {
 	postRouter := r.PathPrefix("/api/post").Subrouter()
 	postRouter.HandleFunc("/{id}", postsHandler.GetPostByID).Methods("GET")
 
-	deletePostChain := middleware.Chain(postsHandler.Delete, middleware.AuthorizedUserMiddleware(sm, logger))
+	deletePostChain := middleware.Chain(postsHandler.Delete, auth, middleware.AllowToken(apitoken.ScopePost))
 	postRouter.HandleFunc("/{id}", deletePostChain).Methods("DELETE")
 
-	addCommentChain := middleware.Chain(postsHandler.AddComment, middleware.AuthorizedUserMiddleware(sm, logger))
+	addCommentChain := middleware.Chain(postsHandler.AddComment, verified, auth, middleware.AllowToken(apitoken.ScopeComment))
 	postRouter.HandleFunc("/{id}", addCommentChain).Methods("POST")
 
-	deleteCommentChain := middleware.Chain(postsHandler.DeleteComment, middleware.AuthorizedUserMiddleware(sm, logger))
+	deleteCommentChain := middleware.Chain(postsHandler.DeleteComment, auth, middleware.AllowToken(apitoken.ScopeComment))
 	postRouter.HandleFunc("/{id}/{commentId}", deleteCommentChain).Methods("DELETE")
 
-	upvote := middleware.Chain(postsHandler.Upvote, middleware.AuthorizedUserMiddleware(sm, logger))
+	upvote := middleware.Chain(postsHandler.Upvote, verified, auth, middleware.AllowToken(apitoken.ScopeVote))
 	postRouter.HandleFunc("/{id}/upvote", upvote).Methods("GET")
 
-	unvote := middleware.Chain(postsHandler.Unvote, middleware.AuthorizedUserMiddleware(sm, logger))
+	unvote := middleware.Chain(postsHandler.Unvote, verified, auth, middleware.AllowToken(apitoken.ScopeVote))
 	postRouter.HandleFunc("/{id}/unvote", unvote).Methods("GET")
 
-	downvote := middleware.Chain(postsHandler.Downvote, middleware.AuthorizedUserMiddleware(sm, logger))
+	downvote := middleware.Chain(postsHandler.Downvote, verified, auth, middleware.AllowToken(apitoken.ScopeVote))
 	postRouter.HandleFunc("/{id}/downvote", downvote).Methods("GET")
 
 	usersRouter := r.PathPrefix("/api").Subrouter()
 	usersRouter.HandleFunc("/login", usersHandler.Login).Methods("POST")
-	usersRouter.HandleFunc("/logout", usersHandler.Logout).Methods("POST")
+	usersRouter.HandleFunc("/login/2fa", usersHandler.LoginTwoFactor).Methods("POST")
+	usersRouter.HandleFunc("/refresh", usersHandler.Refresh).Methods("POST")
+	logout := middleware.Chain(usersHandler.Logout, auth)
+	usersRouter.HandleFunc("/logout", logout).Methods("POST")
+	listSessions := middleware.Chain(usersHandler.ListSessions, auth)
+	usersRouter.HandleFunc("/sessions", listSessions).Methods("GET")
+	revokeAllSessions := middleware.Chain(usersHandler.RevokeAllSessions, auth)
+	usersRouter.HandleFunc("/sessions", revokeAllSessions).Methods("DELETE")
+	revokeSession := middleware.Chain(usersHandler.RevokeSession, auth)
+	usersRouter.HandleFunc("/sessions/{id}", revokeSession).Methods("DELETE")
 	usersRouter.HandleFunc("/register", usersHandler.Register).Methods("POST")
+	me := middleware.Chain(usersHandler.Me, auth, middleware.AllowToken(apitoken.ScopeRead))
+	usersRouter.HandleFunc("/me", me).Methods("GET")
+	changePassword := middleware.Chain(usersHandler.ChangePassword, auth)
+	usersRouter.HandleFunc("/password", changePassword).Methods("POST")
+	usersRouter.HandleFunc("/password/reset", usersHandler.RequestPasswordReset).Methods("POST")
+	usersRouter.HandleFunc("/password/reset/confirm", usersHandler.ResetPassword).Methods("POST")
+	setEmail := middleware.Chain(usersHandler.SetEmail, auth)
+	usersRouter.HandleFunc("/email", setEmail).Methods("POST")
+	usersRouter.HandleFunc("/email/verify", usersHandler.VerifyEmail).Methods("POST")
+	enrollTwoFactor := middleware.Chain(usersHandler.EnrollTwoFactor, auth)
+	usersRouter.HandleFunc("/2fa/enroll", enrollTwoFactor).Methods("POST")
+	enableTwoFactor := middleware.Chain(usersHandler.EnableTwoFactor, auth)
+	usersRouter.HandleFunc("/2fa/enable", enableTwoFactor).Methods("POST")
+	disableTwoFactor := middleware.Chain(usersHandler.DisableTwoFactor, auth)
+	usersRouter.HandleFunc("/2fa/disable", disableTwoFactor).Methods("POST")
+
+	// the personal tokens are managed with a session only, so a leaked token can't make more of them
+	createToken := middleware.Chain(apiTokensHandler.Create, auth)
+	usersRouter.HandleFunc("/tokens", createToken).Methods("POST")
+	listTokens := middleware.Chain(apiTokensHandler.List, auth)
+	usersRouter.HandleFunc("/tokens", listTokens).Methods("GET")
+	revokeToken := middleware.Chain(apiTokensHandler.Revoke, auth)
+	usersRouter.HandleFunc("/tokens/{id}", revokeToken).Methods("DELETE")
+
+	if cfg.OIDC.Enabled() {
+		oidcHandler := &handlers.OIDCHandler{
+			Logger:          logger,
+			UsersRepo:       st.Users,
+			Sessions:        sm,
+			Tokens:          tokens,
+			Provider:        newOIDCProvider(cfg.OIDC),
+			Logins:          oidc.NewMemoryLogins(cfg.OIDC.LoginTTL.Duration),
+			LoginTTL:        cfg.OIDC.LoginTTL.Duration,
+			TwoFactor:       st.TwoFactor,
+			TwoFactorLogins: st.TwoFactorLogins,
+			TwoFactorTTL:    cfg.TwoFactor.LoginTTL.Duration,
+		}
+		usersRouter.HandleFunc("/oidc/login", oidcHandler.Login).Methods("GET")
+		usersRouter.HandleFunc("/oidc/callback", oidcHandler.Callback).Methods("GET")
+	}
 
 	usersRouter.HandleFunc("/user/{user_login}", postsHandler.GetListByAuthor).Methods("GET")
 
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./../../template")))
+	adminRouter := r.PathPrefix("/api/admin").Subrouter()
+	listRoles := middleware.Chain(rolesHandler.List, middleware.RequirePermission(user.PermManageRoles), auth)
+	adminRouter.HandleFunc("/users/{username}/roles", listRoles).Methods("GET")
+	grantRole := middleware.Chain(rolesHandler.Grant, middleware.RequirePermission(user.PermManageRoles), auth)
+	adminRouter.HandleFunc("/users/{username}/roles/{role}", grantRole).Methods("PUT")
+	revokeRole := middleware.Chain(rolesHandler.Revoke, middleware.RequirePermission(user.PermManageRoles), auth)
+	adminRouter.HandleFunc("/users/{username}/roles/{role}", revokeRole).Methods("DELETE")
+
+	r.PathPrefix("/").Handler(http.FileServer(http.Dir(cfg.StaticDir)))
+
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: r,
+	}
+	stopJanitor := startJanitor(sm, cfg.Session.CleanupInterval.Duration, logger)
+	err = serve(srv, healthHandler, cfg, logger)
+	if err != nil {
+		logger.Errorf("Server error. %s", err.Error())
+	}
+	stopJanitor()
+	usersHandler.Wait()
+}
+
+// newLoginThrottle tracks the failed logins in memory, it returns nil when the throttling is disabled
+func newLoginThrottle(cfg config.Throttle) handlers.LoginThrottleInterface {
+	if cfg.LockoutDuration.Duration == 0 {
+		return nil
+	}
+	return throttle.NewMemoryTracker(throttle.Policy{
+		FreeAttempts:    cfg.FreeAttempts,
+		BaseDelay:       cfg.BaseDelay.Duration,
+		LockoutAttempts: cfg.LockoutAttempts,
+		LockoutDuration: cfg.LockoutDuration.Duration,
+	})
+}
+
+// requireVerified blocks the users with an unverified email when the policy is on, otherwise it lets everybody pass
+func requireVerified(cfg config.Email, users middleware.UsersSourceInterface, logger *zap.SugaredLogger) middleware.Middleware {
+	if !cfg.RequireVerified {
+		return func(next http.HandlerFunc) http.HandlerFunc { return next }
+	}
+	return middleware.RequireVerifiedEmail(users, logger)
+}
+
+// newMailer creates the mailer of the configured transport
+func newMailer(cfg config.Mail) mail.Mailer {
+	if cfg.Transport == config.MailSMTP {
+		return mail.NewSMTPMailer(cfg.SMTPAddr, cfg.From, cfg.SMTPUsername, cfg.SMTPPassword)
+	}
+	return mail.NewFileMailer(cfg.Dir, cfg.From)
+}
 
-	addr := ":8080"
-	logger.Infow("starting server",
-		"type", "START",
-		"addr", addr,
-	)
-	http.ListenAndServe(addr, r)
+// newOIDCProvider creates the OpenID Connect provider with the configured endpoints
+func newOIDCProvider(cfg config.OIDC) *oidc.Provider {
+	p := oidc.NewProvider(oidc.Config{
+		Issuer:       cfg.Issuer,
+		AuthURL:      cfg.AuthURL,
+		TokenURL:     cfg.TokenURL,
+		JWKSURL:      cfg.JWKSURL,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       cfg.Scopes,
+	})
+	p.Client = &http.Client{Timeout: 10 * time.Second}
+	return p
+}
+
+// newKeyring fills the keyring with the configured keys
+func newKeyring(cfg config.JWT) (*ljwt.Keyring, error) {
+	keys := ljwt.NewKeyring()
+	if cfg.Key != "" {
+		err := keys.Add(ljwt.LegacyKeyID, []byte(cfg.Key))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range cfg.Keys {
+		if key.PrivateKeyFile == "" {
+			err := keys.Add(key.ID, []byte(key.Secret))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data, err := ioutil.ReadFile(key.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := ljwt.ParsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %s", key.ID, err)
+		}
+		err = keys.AddPrivateKey(key.ID, privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %s", key.ID, err)
+		}
+	}
+	err := keys.Activate(cfg.Active())
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range cfg.RetiredKeys {
+		err = keys.Retire(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
 }
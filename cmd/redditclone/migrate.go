@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/migrate"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const migrateUsage = "usage: redditclone migrate up | down [steps] | version"
+
+// migrateCommand handles the "migrate" subcommand
+func migrateCommand(db *sql.DB, args []string) error {
+	migrator, err := migrate.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, v := range applied {
+			fmt.Printf("applied migration %d\n", v)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("bad number of steps %q", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(steps)
+		for _, v := range rolledBack {
+			fmt.Printf("rolled back migration %d\n", v)
+		}
+		return err
+	case "version":
+		version, err := migrator.Version()
+		if err != nil {
+			return err
+		}
+		latest := 0
+		if len(migrator.Migrations) > 0 {
+			latest = migrator.Migrations[len(migrator.Migrations)-1].Version
+		}
+		fmt.Printf("database version %d, latest migration %d\n", version, latest)
+		return nil
+	}
+	return errors.New(migrateUsage)
+}
+
+// migrateOnStart applies the pending migrations before serving
+func migrateOnStart(db *sql.DB, logger *zap.SugaredLogger) error {
+	migrator, err := migrate.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up()
+	for _, v := range applied {
+		logger.Infow("applied migration",
+			"version", v,
+		)
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+)
+
+const roleUsage = "usage: redditclone [-storage kind] role grant|revoke username role"
+
+// roleCommand handles the "role" subcommand, it grants the first admin role
+// when there is nobody to do it with the API yet
+func roleCommand(st *storage, args []string) error {
+	if len(args) != 3 {
+		return errors.New(roleUsage)
+	}
+	action, username, role := args[0], args[1], user.Role(args[2])
+	if !role.Grantable() {
+		return fmt.Errorf("unknown role %q, it's either %s or %s", role, user.RoleModerator, user.RoleAdmin)
+	}
+	u, err := st.Users.GetByUserName(username)
+	if err != nil {
+		return fmt.Errorf("user %q: %s", username, err)
+	}
+
+	switch action {
+	case "grant":
+		err = st.Users.GrantRole(u.ID, role)
+	case "revoke":
+		err = st.Users.RevokeRole(u.ID, role)
+	default:
+		return errors.New(roleUsage)
+	}
+	if err != nil {
+		return err
+	}
+	roles, err := st.Users.Roles(u.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s has the roles %v\n", username, roles)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/config"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/handlers"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// serve runs the server until SIGINT or SIGTERM and then shuts it down gracefully:
+// the readiness probe starts failing, the server keeps serving for the shutdown delay
+// and then waits for the requests in flight for up to the shutdown timeout
+func serve(srv *http.Server, health *handlers.HealthHandler, cfg *config.Config, logger *zap.SugaredLogger) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Infow("starting server",
+			"type", "START",
+			"addr", srv.Addr,
+		)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-stop:
+		logger.Infow("shutting down",
+			"type", "STOP",
+			"signal", sig.String(),
+		)
+	}
+
+	health.Drain()
+	time.Sleep(cfg.ShutdownDelay.Duration)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Infow("server stopped",
+		"type", "STOP",
+	)
+	return nil
+}
+
+// startJanitor purges the expired sessions in the background until the returned function is called,
+// the function waits for the purge in progress to finish
+func startJanitor(sessions session.PurgerInterface, interval time.Duration, logger *zap.SugaredLogger) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	janitor := &session.Janitor{
+		Sessions: sessions,
+		Interval: interval,
+		Logger:   logger,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		janitor.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
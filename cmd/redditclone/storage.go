@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/backup"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/config"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/handlers"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/middleware"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/onetime"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/posts"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/redis"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/seed"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/twofactor"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.uber.org/zap"
+)
+
+// sessionsManager is implemented by the session manager on any of the stores
+type sessionsManager interface {
+	handlers.SessionsManagerInterface
+	middleware.SessionsCheckerInterface
+	backup.SessionsRepoInterface
+	session.PurgerInterface
+}
+
+// postsRepo is implemented by all the Posts repositories
+type postsRepo interface {
+	handlers.PostsRepoInterface
+	seed.PostsRepoInterface
+	backup.PostsRepoInterface
+}
+
+// usersRepo is implemented by all the Users repositories
+type usersRepo interface {
+	handlers.UsersRepoInterface
+	handlers.RolesRepoInterface
+	handlers.IdentitiesRepoInterface
+	middleware.RolesSourceInterface
+	seed.UsersRepoInterface
+	backup.UsersRepoInterface
+}
+
+// apiTokensRepo is implemented by both the MySQL and in-memory personal token repositories
+type apiTokensRepo interface {
+	handlers.APITokensRepoInterface
+	middleware.APITokensCheckerInterface
+	backup.APITokensRepoInterface
+}
+
+// twoFactorRepo is implemented by both the MySQL and in-memory two-factor repositories
+type twoFactorRepo interface {
+	handlers.TwoFactorRepoInterface
+	backup.TwoFactorRepoInterface
+}
+
+// storage holds the repositories of the selected kind
+type storage struct {
+	Sessions  sessionsManager
+	Users     usersRepo
+	Posts     postsRepo
+	APITokens apiTokensRepo
+	Resets    handlers.OneTimeTokensInterface
+	// Verifications keep the email verification tokens
+	Verifications handlers.OneTimeTokensInterface
+	// TwoFactor keeps the TOTP settings, TwoFactorLogins the logins waiting for the code
+	TwoFactor       twoFactorRepo
+	TwoFactorLogins handlers.OneTimeTokensInterface
+	// Checks ping the databases for the readiness probe
+	Checks map[string]handlers.CheckFunc
+	// Close releases the connections, it is never nil
+	Close func()
+}
+
+// openStorage connects to the configured storage: mongo, mysql or memory
+func openStorage(cfg *config.Config, logger *zap.SugaredLogger) (*storage, error) {
+	hasher, err := user.NewHasher(cfg.Password.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	st := &storage{
+		Checks: map[string]handlers.CheckFunc{},
+		Close:  func() {},
+	}
+
+	if cfg.Storage == config.StorageMemory {
+		err = openSessions(cfg.Session, st, session.NewMemoryStore(), logger)
+		if err != nil {
+			return nil, err
+		}
+		usersRepo := user.NewMemoryRepo()
+		usersRepo.Hasher = hasher
+		st.Users = usersRepo
+		st.Posts = posts.NewMemoryRepo()
+		st.APITokens = apitoken.NewMemoryRepo()
+		st.Resets = onetime.NewMemoryRepo()
+		st.Verifications = onetime.NewMemoryRepo()
+		st.TwoFactor = twofactor.NewMemoryRepo()
+		st.TwoFactorLogins = onetime.NewMemoryRepo()
+		return st, nil
+	}
+	db, err := openMySQL(cfg.MySQL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Migrate {
+		err = migrateOnStart(db, logger)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	st.Checks["mysql"] = db.PingContext
+	st.Close = func() {
+		err := db.Close()
+		if err != nil {
+			logger.Errorf("Can't close mysql. %s", err.Error())
+		}
+	}
+	err = openSessions(cfg.Session, st, session.NewSQLStore(db), logger)
+	if err != nil {
+		st.Close()
+		return nil, err
+	}
+	usersRepo := user.NewRepo(db)
+	usersRepo.Hasher = hasher
+	st.Users = usersRepo
+	st.APITokens = apitoken.NewRepo(db)
+	st.Resets = onetime.NewRepo(db, onetime.PasswordResets)
+	st.Verifications = onetime.NewRepo(db, onetime.EmailVerifications)
+	st.TwoFactor = twofactor.NewRepo(db)
+	st.TwoFactorLogins = onetime.NewRepo(db, onetime.TwoFactorLogins)
+
+	if cfg.Storage == config.StorageMySQL {
+		st.Posts = posts.NewSQLRepo(db)
+		return st, nil
+	}
+
+	client, err := connectMongo(cfg.Mongo)
+	if err != nil {
+		st.Close()
+		return nil, fmt.Errorf("Can't connect to mongodb. %s", err.Error())
+	}
+	st.Checks["mongo"] = func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	}
+	closeMySQL := st.Close
+	st.Close = func() {
+		defer closeMySQL()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := client.Disconnect(ctx)
+		if err != nil {
+			logger.Errorf("Can't disconnect from mongodb. %s", err.Error())
+		}
+	}
+	coll := client.Database(cfg.Mongo.Database).Collection(cfg.Mongo.Collection)
+	postsCollection := &posts.MongoCollection{
+		Сoll: coll,
+	}
+	st.Posts = posts.NewRepo(postsCollection)
+	return st, nil
+}
+
+// openSessions sets up the sessions kept in the store of the users unless they are configured to be kept in redis
+func openSessions(cfg config.Session, st *storage, store session.SessionStore, logger *zap.SugaredLogger) error {
+	if cfg.Store == config.SessionStoreRedis {
+		client, err := redis.NewClient(cfg.RedisURL)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		err = client.Ping(ctx)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("Can't connect to redis. %s", err.Error())
+		}
+		st.Checks["redis"] = client.Ping
+		closeRest := st.Close
+		st.Close = func() {
+			defer closeRest()
+			err := client.Close()
+			if err != nil {
+				logger.Errorf("Can't close redis. %s", err.Error())
+			}
+		}
+		store = session.NewRedisStore(client)
+	}
+
+	sm := session.NewSessionsManager(store, cfg.TTL.Duration)
+	sm.IdleTimeout = cfg.IdleTimeout.Duration
+	if cfg.CacheTTL.Duration > 0 {
+		sm.Cache = session.NewCache(cfg.CacheTTL.Duration)
+	}
+	st.Sessions = sm
+	return nil
+}
+
+// openMySQL connects to the MySQL database keeping users, sessions and optionally posts
+func openMySQL(cfg config.MySQL) (*sql.DB, error) {
+	db, err := sql.Open("mysql", cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// connectMongo connects to the MongoDB server keeping posts
+func connectMongo(cfg config.Mongo) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err = client.Ping(ctx, readpref.Primary())
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB ping error. %s", err.Error())
+	}
+	return client, nil
+}
@@ -1,6 +1,6 @@
 module golang-stepik-2020q2/6/99_hw/redditclone
 
-go 1.12
+go 1.16
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.4.1
@@ -11,5 +11,6 @@ require (
 	github.com/stretchr/testify v1.4.0
 	go.mongodb.org/mongo-driver v1.3.5
 	go.uber.org/zap v1.15.0
+	golang.org/x/crypto v0.0.0-20190530122614-20be4c3c3ed5
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
 )
@@ -0,0 +1,105 @@
+package apitoken
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRepo keeps the personal tokens in memory, it's used to run the app without MySQL
+type MemoryRepo struct {
+	mu sync.Mutex
+	// data maps the secret hashes to the tokens
+	data map[string]*Token
+}
+
+// NewMemoryRepo creates a new in-memory repository of the personal tokens
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		data: make(map[string]*Token),
+	}
+}
+
+// Create creates a new token of the user and returns it with its secret, which is never stored
+func (repo *MemoryRepo) Create(userID, name string, scopes Scopes, expires time.Time) (*Token, string, error) {
+	tok, secret := newToken(userID, name, scopes, expires)
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored := *tok
+	repo.data[hashSecret(secret)] = &stored
+	return tok, secret, nil
+}
+
+// Check finds the token by its secret and updates its last used time
+func (repo *MemoryRepo) Check(secret string) (*Token, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored, ok := repo.data[hashSecret(secret)]
+	now := time.Now()
+	if !ok || stored.expired(now) {
+		return nil, ErrNoToken
+	}
+	if now.Sub(stored.LastUsed) >= LastUsedInterval {
+		stored.LastUsed = now
+	}
+	tok := *stored
+	return &tok, nil
+}
+
+// ListForUser retrieves the tokens of the user including the expired ones, the newest first
+func (repo *MemoryRepo) ListForUser(userID string) ([]*Token, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	tokens := []*Token{}
+	for _, stored := range repo.data {
+		if stored.UserID == userID {
+			tok := *stored
+			tokens = append(tokens, &tok)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.After(tokens[j].Created)
+	})
+	return tokens, nil
+}
+
+// Revoke deletes the token of the user by its id
+func (repo *MemoryRepo) Revoke(userID, id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for hash, stored := range repo.data {
+		if stored.ID == id && stored.UserID == userID {
+			delete(repo.data, hash)
+			return nil
+		}
+	}
+	return ErrNoToken
+}
+
+// All retrieves all the tokens with the hashes of their secrets, it's used for the backups
+func (repo *MemoryRepo) All() ([]*Stored, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	tokens := make([]*Stored, 0, len(repo.data))
+	for hash, tok := range repo.data {
+		tokens = append(tokens, &Stored{Token: *tok, SecretHash: hash})
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens, nil
+}
+
+// Save stores the token keeping its ID, the existing token with the same ID is replaced
+func (repo *MemoryRepo) Save(stored *Stored) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for hash, tok := range repo.data {
+		if tok.ID == stored.ID {
+			delete(repo.data, hash)
+		}
+	}
+	tok := stored.Token
+	repo.data[stored.SecretHash] = &tok
+	return nil
+}
@@ -0,0 +1,65 @@
+package apitoken
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRepo(t *testing.T) {
+	repo := NewMemoryRepo()
+
+	tok, secret, err := repo.Create("42", "bot", Scopes{ScopeVote}, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	checked, err := repo.Check(secret)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if checked.ID != tok.ID || !checked.Scopes.Has(ScopeVote) || checked.LastUsed.IsZero() {
+		t.Errorf("bad token %+v", checked)
+	}
+	if _, err = repo.Check(Prefix + "unknown"); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+
+	_, expiredSecret, _ := repo.Create("42", "old", Scopes{ScopeRead}, time.Now().Add(-time.Hour))
+	if _, err = repo.Check(expiredSecret); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+	repo.Create("43", "other", Scopes{ScopeRead}, time.Time{})
+
+	tokens, _ := repo.ListForUser("42")
+	if len(tokens) != 2 {
+		t.Errorf("bad tokens %+v", tokens)
+	}
+
+	// the restored tokens keep working with their secrets
+	all, err := repo.All()
+	if err != nil || len(all) != 3 || all[0].ID != tok.ID || all[0].SecretHash != hashSecret(secret) {
+		t.Fatalf("bad tokens %+v, %v", all, err)
+	}
+	restored := NewMemoryRepo()
+	for _, stored := range all {
+		if err = restored.Save(stored); err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+	restored.Save(all[0])
+	if checked, err = restored.Check(secret); err != nil || checked.ID != tok.ID {
+		t.Errorf("bad restored token %+v, %v", checked, err)
+	}
+	if tokens, _ = restored.ListForUser("42"); len(tokens) != 2 {
+		t.Errorf("bad restored tokens %+v", tokens)
+	}
+
+	if err = repo.Revoke("43", tok.ID); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+	if err = repo.Revoke("42", tok.ID); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if _, err = repo.Check(secret); err != ErrNoToken {
+		t.Errorf("revoked token accepted, err %v", err)
+	}
+}
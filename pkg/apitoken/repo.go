@@ -0,0 +1,162 @@
+package apitoken
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Repo keeps the personal tokens in MySQL
+type Repo struct {
+	DB *sql.DB
+}
+
+// NewRepo creates a new repository of the personal tokens
+func NewRepo(db *sql.DB) *Repo {
+	return &Repo{DB: db}
+}
+
+// tokenColumns are scanned by scanToken
+const tokenColumns = "id, userId, name, scopes, created, lastUsed, expires"
+
+// Create creates a new token of the user and returns it with its secret, which is never stored
+func (repo *Repo) Create(userID, name string, scopes Scopes, expires time.Time) (*Token, string, error) {
+	tok, secret := newToken(userID, name, scopes, expires)
+	_, err := repo.DB.Exec(
+		"INSERT INTO api_tokens (`id`, `userId`, `name`, `tokenHash`, `scopes`, `created`, `lastUsed`, `expires`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		tok.ID,
+		tok.UserID,
+		tok.Name,
+		hashSecret(secret),
+		tok.Scopes.String(),
+		tok.Created.Unix(),
+		toUnix(tok.LastUsed),
+		toUnix(tok.Expires),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	return tok, secret, nil
+}
+
+// Check finds the token by its secret and updates its last used time
+func (repo *Repo) Check(secret string) (*Token, error) {
+	tok, err := scanToken(repo.DB.
+		QueryRow("SELECT "+tokenColumns+" FROM api_tokens WHERE tokenHash = ?", hashSecret(secret)))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if tok.expired(now) {
+		return nil, ErrNoToken
+	}
+	if now.Sub(tok.LastUsed) >= LastUsedInterval {
+		// the updates are throttled, so a failed one is retried on the next request
+		_, err = repo.DB.Exec("UPDATE api_tokens SET lastUsed = ? WHERE id = ?", now.Unix(), tok.ID)
+		if err == nil {
+			tok.LastUsed = now
+		}
+	}
+	return tok, nil
+}
+
+// ListForUser retrieves the tokens of the user including the expired ones, the newest first
+func (repo *Repo) ListForUser(userID string) ([]*Token, error) {
+	rows, err := repo.DB.Query(
+		"SELECT "+tokenColumns+" FROM api_tokens WHERE userId = ? ORDER BY created DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		tok, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke deletes the token of the user by its id
+func (repo *Repo) Revoke(userID, id string) error {
+	result, err := repo.DB.Exec("DELETE FROM api_tokens WHERE id = ? AND userId = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoToken
+	}
+	return nil
+}
+
+// All retrieves all the tokens with the hashes of their secrets, it's used for the backups
+func (repo *Repo) All() ([]*Stored, error) {
+	rows, err := repo.DB.Query("SELECT " + tokenColumns + ", tokenHash FROM api_tokens ORDER BY created")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Stored{}
+	for rows.Next() {
+		stored := &Stored{}
+		var scopes string
+		var created, lastUsed, expires int64
+		err = rows.Scan(&stored.ID, &stored.UserID, &stored.Name, &scopes, &created, &lastUsed, &expires, &stored.SecretHash)
+		if err != nil {
+			return nil, err
+		}
+		stored.Scopes = splitScopes(scopes)
+		stored.Created = fromUnix(created)
+		stored.LastUsed = fromUnix(lastUsed)
+		stored.Expires = fromUnix(expires)
+		tokens = append(tokens, stored)
+	}
+	return tokens, rows.Err()
+}
+
+// Save stores the token keeping its ID, the existing token with the same ID is replaced
+func (repo *Repo) Save(stored *Stored) error {
+	_, err := repo.DB.Exec(
+		"INSERT INTO api_tokens (`id`, `userId`, `name`, `tokenHash`, `scopes`, `created`, `lastUsed`, `expires`) VALUES (?, ?, ?, ?, ?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE `userId` = VALUES(`userId`), `name` = VALUES(`name`), `tokenHash` = VALUES(`tokenHash`), "+
+			"`scopes` = VALUES(`scopes`), `created` = VALUES(`created`), `lastUsed` = VALUES(`lastUsed`), `expires` = VALUES(`expires`)",
+		stored.ID,
+		stored.UserID,
+		stored.Name,
+		stored.SecretHash,
+		stored.Scopes.String(),
+		toUnix(stored.Created),
+		toUnix(stored.LastUsed),
+		toUnix(stored.Expires),
+	)
+	return err
+}
+
+// scanToken reads the tokenColumns of a row
+func scanToken(row interface{ Scan(...interface{}) error }) (*Token, error) {
+	tok := &Token{}
+	var scopes string
+	var created, lastUsed, expires int64
+	err := row.Scan(&tok.ID, &tok.UserID, &tok.Name, &scopes, &created, &lastUsed, &expires)
+	if err != nil {
+		return nil, err
+	}
+	tok.Scopes = splitScopes(scopes)
+	tok.Created = fromUnix(created)
+	tok.LastUsed = fromUnix(lastUsed)
+	tok.Expires = fromUnix(expires)
+	return tok, nil
+}
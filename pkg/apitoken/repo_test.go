@@ -0,0 +1,164 @@
+package apitoken
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"vote", "read", "vote"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if !reflect.DeepEqual(scopes, Scopes{ScopeRead, ScopeVote}) || scopes.String() != "read,vote" {
+		t.Errorf("bad scopes %v", scopes)
+	}
+	if _, err = ParseScopes([]string{"read", "admin"}); err != ErrUnknownScope {
+		t.Errorf("expected %v, got %v", ErrUnknownScope, err)
+	}
+	if _, err = ParseScopes(nil); err != ErrNoScopes {
+		t.Errorf("expected %v, got %v", ErrNoScopes, err)
+	}
+}
+
+func TestRepo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepo(db)
+	now := time.Now()
+	columns := []string{"id", "userId", "name", "scopes", "created", "lastUsed", "expires"}
+
+	// only the hash of the secret is stored
+	var storedHash string
+	mock.
+		ExpectExec("INSERT INTO api_tokens").
+		WithArgs(sqlmock.AnyArg(), "42", "bot", hashArg{&storedHash}, "read,post", sqlmock.AnyArg(), int64(0), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tok, secret, err := repo.Create("42", "bot", Scopes{ScopeRead, ScopePost}, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if !strings.HasPrefix(secret, Prefix) || tok.ID == "" || storedHash != hashSecret(secret) || strings.Contains(storedHash, secret) {
+		t.Errorf("bad token %+v %s", tok, secret)
+	}
+
+	// a recently used token isn't updated
+	mock.
+		ExpectQuery("SELECT (.+) FROM api_tokens WHERE tokenHash").
+		WithArgs(hashSecret(secret)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(tok.ID, "42", "bot", "read,post", now.Unix(), now.Unix(), 0))
+	checked, err := repo.Check(secret)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if checked.UserID != "42" || !reflect.DeepEqual(checked.Scopes, Scopes{ScopeRead, ScopePost}) || !checked.Expires.IsZero() {
+		t.Errorf("bad token %+v", checked)
+	}
+
+	// a stale last used time is updated
+	mock.
+		ExpectQuery("SELECT (.+) FROM api_tokens WHERE tokenHash").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(tok.ID, "42", "bot", "read", now.Unix(), 0, now.Add(time.Hour).Unix()))
+	mock.
+		ExpectExec("UPDATE api_tokens SET lastUsed = \\? WHERE id = \\?").
+		WithArgs(sqlmock.AnyArg(), tok.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if checked, err = repo.Check(secret); err != nil || checked.LastUsed.IsZero() {
+		t.Errorf("bad token %+v, %v", checked, err)
+	}
+
+	// expired and unknown tokens
+	mock.
+		ExpectQuery("SELECT (.+) FROM api_tokens WHERE tokenHash").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(tok.ID, "42", "bot", "read", now.Unix(), 0, now.Add(-time.Hour).Unix()))
+	if _, err = repo.Check(secret); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+	mock.
+		ExpectQuery("SELECT (.+) FROM api_tokens WHERE tokenHash").
+		WillReturnRows(sqlmock.NewRows(columns))
+	if _, err = repo.Check(secret); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+	mock.
+		ExpectQuery("SELECT (.+) FROM api_tokens WHERE tokenHash").
+		WillReturnError(fmt.Errorf("db_error"))
+	if _, err = repo.Check(secret); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	mock.
+		ExpectQuery("SELECT (.+) FROM api_tokens WHERE userId = \\? ORDER BY created DESC").
+		WithArgs("42").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("t2", "42", "new", "vote", now.Unix(), 0, 0).
+			AddRow("t1", "42", "old", "read,comment", now.Add(-time.Hour).Unix(), 0, 0))
+	tokens, err := repo.ListForUser("42")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if len(tokens) != 2 || tokens[0].ID != "t2" || !reflect.DeepEqual(tokens[1].Scopes, Scopes{ScopeRead, ScopeComment}) {
+		t.Errorf("bad tokens %+v", tokens)
+	}
+
+	// the tokens of the other users can't be revoked
+	mock.
+		ExpectExec("DELETE FROM api_tokens WHERE id = \\? AND userId = \\?").
+		WithArgs("t1", "42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("DELETE FROM api_tokens WHERE id = \\? AND userId = \\?").
+		WithArgs("t1", "43").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err = repo.Revoke("42", "t1"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	if err = repo.Revoke("43", "t1"); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+
+	// the backups read and restore the tokens with their hashes
+	mock.
+		ExpectQuery("SELECT (.+), tokenHash FROM api_tokens ORDER BY created").
+		WillReturnRows(sqlmock.NewRows(append(columns, "tokenHash")).
+			AddRow("t1", "42", "bot", "vote", now.Unix(), 0, now.Add(time.Hour).Unix(), "hash"))
+	all, err := repo.All()
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if len(all) != 1 || all[0].SecretHash != "hash" || !all[0].LastUsed.IsZero() || all[0].Expires.Unix() != now.Add(time.Hour).Unix() {
+		t.Errorf("bad tokens %+v", all)
+	}
+	mock.
+		ExpectExec("INSERT INTO api_tokens (.+) ON DUPLICATE KEY UPDATE").
+		WithArgs("t1", "42", "bot", "hash", "vote", now.Unix(), int64(0), now.Add(time.Hour).Unix()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = repo.Save(all[0]); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// hashArg captures the stored hash
+type hashArg struct {
+	hash *string
+}
+
+func (a hashArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.hash = s
+	return ok
+}
@@ -0,0 +1,172 @@
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Prefix starts every personal token, so they are told from the JWTs and found by the secret scanners
+const Prefix = "rcpat_"
+
+// LastUsedInterval is how often the last used time of a token is updated
+const LastUsedInterval = time.Minute
+
+// Scope allows a personal token a kind of actions
+type Scope string
+
+// Scopes of the personal tokens
+const (
+	ScopeRead    Scope = "read"
+	ScopePost    Scope = "post"
+	ScopeVote    Scope = "vote"
+	ScopeComment Scope = "comment"
+)
+
+// AllScopes lists the known scopes in their order
+var AllScopes = Scopes{ScopeRead, ScopePost, ScopeVote, ScopeComment}
+
+var (
+	// ErrNoToken is returned for an unknown, expired or revoked token
+	ErrNoToken = errors.New("Token not found")
+	// ErrUnknownScope is returned for a scope not in AllScopes
+	ErrUnknownScope = errors.New("Unknown scope")
+	// ErrNoScopes is returned for a token without scopes, it would be of no use
+	ErrNoScopes = errors.New("Token needs at least one scope")
+)
+
+// Scopes are the scopes of a token
+type Scopes []Scope
+
+// Has reports whether the scope is among the scopes
+func (ss Scopes) Has(scope Scope) bool {
+	for _, s := range ss {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// String joins the scopes as they are stored
+func (ss Scopes) String() string {
+	parts := make([]string, 0, len(ss))
+	for _, s := range ss {
+		parts = append(parts, string(s))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseScopes checks the scopes and returns them without duplicates in the order of AllScopes
+func ParseScopes(names []string) (Scopes, error) {
+	requested := Scopes{}
+	for _, name := range names {
+		scope := Scope(name)
+		if !AllScopes.Has(scope) {
+			return nil, ErrUnknownScope
+		}
+		requested = append(requested, scope)
+	}
+	scopes := Scopes{}
+	for _, scope := range AllScopes {
+		if requested.Has(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, ErrNoScopes
+	}
+	return scopes, nil
+}
+
+// splitScopes parses the stored scopes skipping the unknown ones
+func splitScopes(value string) Scopes {
+	scopes := Scopes{}
+	for _, name := range strings.Split(value, ",") {
+		if AllScopes.Has(Scope(name)) {
+			scopes = append(scopes, Scope(name))
+		}
+	}
+	return scopes
+}
+
+// Token is a long-lived personal token of a user, only the hash of its secret is stored
+type Token struct {
+	// ID identifies the token to its user, it isn't a credential
+	ID       string
+	UserID   string
+	Name     string
+	Scopes   Scopes
+	Created  time.Time
+	LastUsed time.Time
+	// Expires is zero for the tokens which never expire
+	Expires time.Time
+}
+
+// Stored is a token with the hash of its secret as it's stored, the backups keep them
+type Stored struct {
+	Token
+	SecretHash string
+}
+
+// newToken creates a token with a random secret and returns it with the secret
+func newToken(userID, name string, scopes Scopes, expires time.Time) (*Token, string) {
+	now := time.Now()
+	return &Token{
+		ID:      hex.EncodeToString(randomBytes(8)),
+		UserID:  userID,
+		Name:    name,
+		Scopes:  scopes,
+		Created: now,
+		Expires: expires,
+	}, Prefix + base64.RawURLEncoding.EncodeToString(randomBytes(32))
+}
+
+// expired reports whether the token can't be used anymore
+func (t *Token) expired(now time.Time) bool {
+	return !t.Expires.IsZero() && t.Expires.Unix() < now.Unix()
+}
+
+// hashSecret is what is stored instead of the secret itself, the secrets are random so no salt is needed
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// fromUnix converts the stored unix seconds, 0 stands for no time
+func fromUnix(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// toUnix converts the time into the stored unix seconds, 0 stands for no time
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+type tokenKey string
+
+// TokenKey keeps the personal token the request is authorized with in the request context
+var TokenKey tokenKey = "apiTokenKey"
+
+// FromContext returns the personal token the request is authorized with, it's nil for the sessions
+func FromContext(ctx context.Context) *Token {
+	tok, _ := ctx.Value(TokenKey).(*Token)
+	return tok
+}
@@ -0,0 +1,549 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/posts"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/twofactor"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"io"
+	"time"
+)
+
+// FormatVersion is the version of the archives written by Export,
+// the archives of version 1 carry no two-factor settings and leave the restored ones as they are
+const FormatVersion = 2
+
+// twoFactorVersion is the first version with the two-factor settings of the users
+const twoFactorVersion = 2
+
+const (
+	manifestFile = "manifest.json"
+	usersFile    = "users.jsonl"
+	postsFile    = "posts.jsonl"
+	sessionsFile = "sessions.jsonl"
+	tokensFile   = "api_tokens.jsonl"
+)
+
+var (
+	// ErrUnsupportedVersion is returned when restoring an archive of an unknown format version
+	ErrUnsupportedVersion = errors.New("Unsupported backup format version")
+	// ErrNoManifest is returned when the archive doesn't start with the manifest
+	ErrNoManifest = errors.New("Backup manifest not found")
+	// ErrNoSessionsRepo is returned when the archive has sessions but there is nowhere to restore them
+	ErrNoSessionsRepo = errors.New("Backup has sessions but no Sessions Repo given")
+	// ErrNoTwoFactorRepo is returned when the secrets are backed up or restored without the two-factor settings
+	ErrNoTwoFactorRepo = errors.New("Backup has secrets but no Two-Factor Repo given")
+	// ErrNoAPITokensRepo is returned when the secrets are backed up or restored without the personal tokens
+	ErrNoAPITokensRepo = errors.New("Backup has secrets but no API Tokens Repo given")
+)
+
+// UsersRepoInterface describes the Users Repo methods needed for backups
+type UsersRepoInterface interface {
+	All() ([]*user.User, error)
+	Save(*user.User) error
+	SaveWithoutSecrets(*user.User) error
+	Roles(userID string) (user.Roles, error)
+	GrantRole(userID string, role user.Role) error
+	RevokeRole(userID string, role user.Role) error
+	Identities(userID string) ([]user.Identity, error)
+	LinkIdentity(userID string, identity user.Identity) error
+}
+
+// PostsRepoInterface describes the Posts Repo methods needed for backups
+type PostsRepoInterface interface {
+	All() ([]*posts.Post, error)
+	Save(*posts.Post) error
+}
+
+// SessionsRepoInterface describes the Sessions Manager methods needed for backups
+type SessionsRepoInterface interface {
+	All() ([]*session.Session, error)
+	Save(*session.Session) error
+}
+
+// TwoFactorRepoInterface describes the two-factor settings Repo methods needed for backups
+type TwoFactorRepoInterface interface {
+	Get(userID string) (*twofactor.Settings, error)
+	RecoveryCodeHashes(userID string) ([]string, error)
+	Restore(settings *twofactor.Settings, recoveryCodeHashes []string) error
+	Disable(userID string) error
+}
+
+// APITokensRepoInterface describes the personal tokens Repo methods needed for backups
+type APITokensRepoInterface interface {
+	All() ([]*apitoken.Stored, error)
+	Save(*apitoken.Stored) error
+}
+
+// Manifest describes the archive, it is always the first file of the archive
+type Manifest struct {
+	Version  int            `json:"version"`
+	Created  time.Time      `json:"created"`
+	Secrets  bool           `json:"secrets"`
+	Entities map[string]int `json:"entities"`
+}
+
+// Options control what gets exported
+type Options struct {
+	// Secrets adds the password hashes, tokens, two-factor settings and personal tokens of the users
+	Secrets bool
+	// Sessions adds the sessions
+	Sessions bool
+}
+
+// userRecord is a User as stored in the archive,
+// the password hash is binary so it is kept as bytes to survive JSON encoding
+type userRecord struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"emailVerified,omitempty"`
+	Admin         bool   `json:"admin"`
+	PasswordHash  []byte `json:"passwordHash,omitempty"`
+	Token         string `json:"token,omitempty"`
+	// Roles are the granted roles besides the admin one kept as the Admin flag
+	Roles []user.Role `json:"roles,omitempty"`
+	// Identities are the linked accounts at the OpenID Connect providers
+	Identities []user.Identity `json:"identities,omitempty"`
+	// TwoFactor is kept with the secrets only
+	TwoFactor *twoFactorRecord `json:"twoFactor,omitempty"`
+}
+
+// twoFactorRecord is the TOTP secret of a user with the hashes of the recovery codes left
+type twoFactorRecord struct {
+	Secret             string   `json:"secret"`
+	Enabled            bool     `json:"enabled"`
+	LastCounter        int64    `json:"lastCounter"`
+	RecoveryCodeHashes []string `json:"recoveryCodeHashes,omitempty"`
+}
+
+// sessionRecord is a Session as stored in the archive
+type sessionRecord struct {
+	ID        string `json:"id"`
+	UserID    string `json:"userId"`
+	Expires   int64  `json:"expires"`
+	Created   int64  `json:"created,omitempty"`
+	LastSeen  int64  `json:"lastSeen,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	IP        string `json:"ip,omitempty"`
+}
+
+// tokenRecord is a personal token as stored in the archive, only the hash of its secret is ever kept
+type tokenRecord struct {
+	ID         string          `json:"id"`
+	UserID     string          `json:"userId"`
+	Name       string          `json:"name"`
+	SecretHash string          `json:"secretHash"`
+	Scopes     apitoken.Scopes `json:"scopes"`
+	Created    int64           `json:"created"`
+	LastUsed   int64           `json:"lastUsed,omitempty"`
+	Expires    int64           `json:"expires,omitempty"`
+}
+
+// archiveFile is a JSON Lines file of the archive
+type archiveFile struct {
+	name    string
+	records []interface{}
+}
+
+// Archiver writes and restores tar archives with a JSON Lines file per entity
+type Archiver struct {
+	UsersRepo    UsersRepoInterface
+	PostsRepo    PostsRepoInterface
+	SessionsRepo SessionsRepoInterface
+	// TwoFactorRepo and APITokensRepo are needed for the secrets only
+	TwoFactorRepo TwoFactorRepoInterface
+	APITokensRepo APITokensRepoInterface
+}
+
+// Export writes all the users, posts and optionally personal tokens and sessions to w and returns the manifest written
+func (a *Archiver) Export(w io.Writer, opts Options) (*Manifest, error) {
+	users, err := a.UsersRepo.All()
+	if err != nil {
+		return nil, err
+	}
+	items, err := a.PostsRepo.All()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Secrets && a.TwoFactorRepo == nil {
+		return nil, ErrNoTwoFactorRepo
+	}
+	var tokens []*apitoken.Stored
+	if opts.Secrets {
+		if a.APITokensRepo == nil {
+			return nil, ErrNoAPITokensRepo
+		}
+		tokens, err = a.APITokensRepo.All()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var sessions []*session.Session
+	if opts.Sessions {
+		if a.SessionsRepo == nil {
+			return nil, ErrNoSessionsRepo
+		}
+		sessions, err = a.SessionsRepo.All()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	manifest := &Manifest{
+		Version:  FormatVersion,
+		Created:  time.Now(),
+		Secrets:  opts.Secrets,
+		Entities: map[string]int{"users": len(users), "posts": len(items)},
+	}
+
+	files := []*archiveFile{
+		{name: usersFile},
+		{name: postsFile},
+	}
+	for _, u := range users {
+		rec := userRecord{ID: u.ID, Username: u.Username, Email: u.Email, EmailVerified: u.EmailVerified, Admin: u.Admin}
+		roles, err := a.UsersRepo.Roles(u.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if role.Grantable() && role != user.RoleAdmin {
+				rec.Roles = append(rec.Roles, role)
+			}
+		}
+		identities, err := a.UsersRepo.Identities(u.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(identities) > 0 {
+			rec.Identities = identities
+		}
+		if opts.Secrets {
+			rec.PasswordHash = []byte(u.PasswordHash)
+			rec.Token = u.Token
+			rec.TwoFactor, err = a.exportTwoFactor(u.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		files[0].records = append(files[0].records, rec)
+	}
+	for _, post := range items {
+		files[1].records = append(files[1].records, post)
+	}
+	if opts.Secrets {
+		manifest.Entities["apiTokens"] = len(tokens)
+		records := make([]interface{}, 0, len(tokens))
+		for _, tok := range tokens {
+			records = append(records, tokenRecord{
+				ID:         tok.ID,
+				UserID:     tok.UserID,
+				Name:       tok.Name,
+				SecretHash: tok.SecretHash,
+				Scopes:     tok.Scopes,
+				Created:    unixOrZero(tok.Created),
+				LastUsed:   unixOrZero(tok.LastUsed),
+				Expires:    unixOrZero(tok.Expires),
+			})
+		}
+		files = append(files, &archiveFile{name: tokensFile, records: records})
+	}
+	if opts.Sessions {
+		manifest.Entities["sessions"] = len(sessions)
+		records := make([]interface{}, 0, len(sessions))
+		for _, sess := range sessions {
+			rec := sessionRecord{
+				ID:        sess.ID,
+				UserID:    sess.UserID,
+				Expires:   sess.Expires.Unix(),
+				UserAgent: sess.UserAgent,
+				IP:        sess.IP,
+			}
+			if !sess.Created.IsZero() {
+				rec.Created = sess.Created.Unix()
+			}
+			if !sess.LastSeen.IsZero() {
+				rec.LastSeen = sess.LastSeen.Unix()
+			}
+			records = append(records, rec)
+		}
+		files = append(files, &archiveFile{name: sessionsFile, records: records})
+	}
+
+	tw := tar.NewWriter(w)
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = writeFile(tw, manifestFile, body, manifest.Created)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		for _, rec := range f.records {
+			err = enc.Encode(rec)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = writeFile(tw, f.name, buf.Bytes(), manifest.Created)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, tw.Close()
+}
+
+// exportTwoFactor returns the two-factor settings of the user, nil when there are none
+func (a *Archiver) exportTwoFactor(userID string) (*twoFactorRecord, error) {
+	settings, err := a.TwoFactorRepo.Get(userID)
+	if err == twofactor.ErrNotEnrolled {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := a.TwoFactorRepo.RecoveryCodeHashes(userID)
+	if err != nil {
+		return nil, err
+	}
+	rec := &twoFactorRecord{Secret: settings.Secret, Enabled: settings.Enabled, LastCounter: settings.LastCounter}
+	if len(hashes) > 0 {
+		rec.RecoveryCodeHashes = hashes
+	}
+	return rec, nil
+}
+
+// restoreTwoFactor replaces the two-factor settings of a restored user with the archived ones
+func (a *Archiver) restoreTwoFactor(rec userRecord) error {
+	if rec.TwoFactor == nil {
+		return a.TwoFactorRepo.Disable(rec.ID)
+	}
+	settings := &twofactor.Settings{
+		UserID:      rec.ID,
+		Secret:      rec.TwoFactor.Secret,
+		Enabled:     rec.TwoFactor.Enabled,
+		LastCounter: rec.TwoFactor.LastCounter,
+	}
+	return a.TwoFactorRepo.Restore(settings, rec.TwoFactor.RecoveryCodeHashes)
+}
+
+// restoreRoles replaces the granted roles of a restored user with the archived ones
+func (a *Archiver) restoreRoles(rec userRecord) error {
+	current, err := a.UsersRepo.Roles(rec.ID)
+	if err != nil {
+		return err
+	}
+	archived := user.Roles(rec.Roles)
+	for _, role := range current {
+		if role.Grantable() && role != user.RoleAdmin && !archived.Has(role) {
+			err = a.UsersRepo.RevokeRole(rec.ID, role)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, role := range archived {
+		err = a.UsersRepo.GrantRole(rec.ID, role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unixOrZero converts the time into unix seconds, 0 stands for no time
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// timeOrZero converts unix seconds back, 0 stands for no time
+func timeOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func writeFile(tw *tar.Writer, name string, body []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(body)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(body)
+	return err
+}
+
+// Restore reads an archive written by Export and saves its entities keeping their ids,
+// the entities already stored with the same ids are replaced.
+// Users exported without secrets are restored without passwords and can't log in until they get new ones,
+// their two-factor settings are left as they are
+func (a *Archiver) Restore(r io.Reader) (*Manifest, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err == io.EOF || (err == nil && hdr.Name != manifestFile) {
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	err = json.NewDecoder(tr).Decode(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("bad manifest: %s", err)
+	}
+	if manifest.Version < 1 || manifest.Version > FormatVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	restoreTwoFactor := manifest.Secrets && manifest.Version >= twoFactorVersion
+	if restoreTwoFactor && a.TwoFactorRepo == nil {
+		return manifest, ErrNoTwoFactorRepo
+	}
+
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			return manifest, nil
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		switch hdr.Name {
+		case usersFile:
+			err = readLines(tr, func(dec *json.Decoder) error {
+				rec := userRecord{}
+				if err := dec.Decode(&rec); err != nil {
+					return err
+				}
+				u := &user.User{
+					ID:            rec.ID,
+					Username:      rec.Username,
+					Email:         rec.Email,
+					EmailVerified: rec.EmailVerified,
+					Admin:         rec.Admin,
+					PasswordHash:  string(rec.PasswordHash),
+					Token:         rec.Token,
+				}
+				// the archive without secrets mustn't wipe the passwords of the users restored over
+				save := a.UsersRepo.Save
+				if !manifest.Secrets {
+					save = a.UsersRepo.SaveWithoutSecrets
+				}
+				err := save(u)
+				if err != nil {
+					return err
+				}
+				for _, identity := range rec.Identities {
+					err = a.UsersRepo.LinkIdentity(rec.ID, identity)
+					if err != nil {
+						return err
+					}
+				}
+				if restoreTwoFactor {
+					err = a.restoreTwoFactor(rec)
+					if err != nil {
+						return err
+					}
+				}
+				return a.restoreRoles(rec)
+			})
+		case postsFile:
+			err = readLines(tr, func(dec *json.Decoder) error {
+				post := &posts.Post{}
+				if err := dec.Decode(post); err != nil {
+					return err
+				}
+				if post.Votes == nil {
+					post.Votes = []posts.Vote{}
+				}
+				if post.Comments == nil {
+					post.Comments = []posts.Comment{}
+				}
+				return a.PostsRepo.Save(post)
+			})
+		case tokensFile:
+			if a.APITokensRepo == nil {
+				return manifest, ErrNoAPITokensRepo
+			}
+			err = readLines(tr, func(dec *json.Decoder) error {
+				rec := tokenRecord{}
+				if err := dec.Decode(&rec); err != nil {
+					return err
+				}
+				return a.APITokensRepo.Save(&apitoken.Stored{
+					Token: apitoken.Token{
+						ID:       rec.ID,
+						UserID:   rec.UserID,
+						Name:     rec.Name,
+						Scopes:   rec.Scopes,
+						Created:  timeOrZero(rec.Created),
+						LastUsed: timeOrZero(rec.LastUsed),
+						Expires:  timeOrZero(rec.Expires),
+					},
+					SecretHash: rec.SecretHash,
+				})
+			})
+		case sessionsFile:
+			if a.SessionsRepo == nil {
+				return manifest, ErrNoSessionsRepo
+			}
+			err = readLines(tr, func(dec *json.Decoder) error {
+				rec := sessionRecord{}
+				if err := dec.Decode(&rec); err != nil {
+					return err
+				}
+				sess := &session.Session{
+					ID:        rec.ID,
+					UserID:    rec.UserID,
+					Expires:   time.Unix(rec.Expires, 0),
+					UserAgent: rec.UserAgent,
+					IP:        rec.IP,
+				}
+				if rec.Created != 0 {
+					sess.Created = time.Unix(rec.Created, 0)
+				}
+				if rec.LastSeen != 0 {
+					sess.LastSeen = time.Unix(rec.LastSeen, 0)
+				}
+				return a.SessionsRepo.Save(sess)
+			})
+		default:
+			// unknown files are skipped
+			continue
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("%s: %s", hdr.Name, err)
+		}
+	}
+}
+
+// readLines calls decode for every JSON line of r
+func readLines(r io.Reader, decode func(*json.Decoder) error) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for dec.More() {
+		err := decode(dec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/posts"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/twofactor"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newArchiver() *Archiver {
+	return &Archiver{
+		UsersRepo:     newUsersRepo(),
+		PostsRepo:     posts.NewMemoryRepo(),
+		SessionsRepo:  session.NewSessionsManager(session.NewMemoryStore(), time.Hour),
+		TwoFactorRepo: twofactor.NewMemoryRepo(),
+		APITokensRepo: apitoken.NewMemoryRepo(),
+	}
+}
+
+// fill stores a user with the two-factor authentication, a personal token, a post, a comment and a session
+func fill(t *testing.T, a *Archiver) (*user.User, *posts.Post, *session.Session, string) {
+	u, err := a.UsersRepo.(*user.MemoryRepo).Register(&user.User{Username: "login", Email: "login@example.com", Password: "password"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	u.Token = "token"
+	u.EmailVerified = true
+	if err = a.UsersRepo.Save(u); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if err = a.UsersRepo.GrantRole(u.ID, user.RoleModerator); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if err = a.UsersRepo.LinkIdentity(u.ID, user.Identity{Issuer: "https://idp.example.com", Subject: "42"}); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if err = a.TwoFactorRepo.(*twofactor.MemoryRepo).Enroll(u.ID, "SECRET"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if err = a.TwoFactorRepo.(*twofactor.MemoryRepo).Enable(u.ID, 100, []string{"aaaa-bbbb-cccc-dddd"}); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, secret, err := a.APITokensRepo.(*apitoken.MemoryRepo).Create(u.ID, "bot", apitoken.Scopes{apitoken.ScopeVote}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	repo := a.PostsRepo.(*posts.MemoryRepo)
+	post, err := repo.Add(&posts.Post{Title: "title", Author: posts.NewAuthor(u), Category: "music"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	post, err = repo.AddComment(post.ID, &posts.Comment{Author: posts.NewAuthor(u), Body: "body"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	sess, err := a.SessionsRepo.(*session.SessionsManager).Create(u.ID, session.Client{UserAgent: "curl/7.68.0", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	return u, post, sess, secret
+}
+
+func TestExportRestore(t *testing.T) {
+	src := newArchiver()
+	u, post, sess, secret := fill(t, src)
+
+	buf := &bytes.Buffer{}
+	manifest, err := src.Export(buf, Options{Secrets: true, Sessions: true})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if manifest.Version != FormatVersion || manifest.Entities["users"] != 1 ||
+		manifest.Entities["posts"] != 1 || manifest.Entities["sessions"] != 1 || manifest.Entities["apiTokens"] != 1 {
+		t.Errorf("bad manifest %+v", manifest)
+	}
+
+	dst := newArchiver()
+	restored, err := dst.Restore(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if !restored.Secrets || restored.Entities["posts"] != 1 {
+		t.Errorf("bad restored manifest %+v", restored)
+	}
+
+	usersRepo := dst.UsersRepo.(*user.MemoryRepo)
+	if _, err = usersRepo.Authorize("login", "password"); err != nil {
+		t.Errorf("restored user can't log in: %s", err)
+	}
+	gotUser, err := usersRepo.GetByID(u.ID)
+	if err != nil || gotUser.Token != "token" || gotUser.Email != "login@example.com" || !gotUser.EmailVerified {
+		t.Errorf("bad restored user %+v, %v", gotUser, err)
+	}
+	roles, err := usersRepo.Roles(u.ID)
+	if err != nil || !roles.Has(user.RoleModerator) {
+		t.Errorf("bad restored roles %v, %v", roles, err)
+	}
+	linked, err := usersRepo.GetByIdentity(user.Identity{Issuer: "https://idp.example.com", Subject: "42"})
+	if err != nil || linked.ID != u.ID {
+		t.Errorf("bad restored identity %v, %v", linked, err)
+	}
+
+	twoFactor := dst.TwoFactorRepo.(*twofactor.MemoryRepo)
+	settings, err := twoFactor.Get(u.ID)
+	if err != nil || settings.Secret != "SECRET" || !settings.Enabled || settings.LastCounter != 100 {
+		t.Errorf("bad restored two-factor settings %+v, %v", settings, err)
+	}
+	if err = twoFactor.UseRecoveryCode(u.ID, "aaaa-bbbb-cccc-dddd"); err != nil {
+		t.Errorf("restored recovery code doesn't work: %s", err)
+	}
+
+	tok, err := dst.APITokensRepo.(*apitoken.MemoryRepo).Check(secret)
+	if err != nil || tok.UserID != u.ID || tok.Name != "bot" || !tok.Scopes.Has(apitoken.ScopeVote) || tok.Expires.IsZero() {
+		t.Errorf("bad restored token %+v, %v", tok, err)
+	}
+
+	gotPost, err := dst.PostsRepo.(*posts.MemoryRepo).Get(post.ID)
+	if err != nil || gotPost.Title != "title" || len(gotPost.Votes) != 1 || gotPost.Score != 1 ||
+		len(gotPost.Comments) != 1 || gotPost.Comments[0].ID != post.Comments[0].ID ||
+		!gotPost.Created.Equal(post.Created) {
+		t.Errorf("bad restored post %+v, %v", gotPost, err)
+	}
+	if gotPost.Author != posts.NewAuthor(u) || gotPost.Comments[0].Author != posts.NewAuthor(u) {
+		t.Errorf("bad restored post author %+v", gotPost.Author)
+	}
+
+	gotSess, err := dst.SessionsRepo.(*session.SessionsManager).Check(sess.ID)
+	if err != nil || gotSess.UserID != u.ID || gotSess.Expires.Unix() != sess.Expires.Unix() ||
+		gotSess.Created.Unix() != sess.Created.Unix() || gotSess.UserAgent != "curl/7.68.0" || gotSess.IP != "127.0.0.1" {
+		t.Errorf("bad restored session %+v, %v", gotSess, err)
+	}
+
+	// restoring again replaces everything without duplicates
+	_, err = dst.Restore(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if err = twoFactor.UseRecoveryCode(u.ID, "aaaa-bbbb-cccc-dddd"); err != nil {
+		t.Errorf("recovery code isn't restored again: %s", err)
+	}
+	all, _ := dst.PostsRepo.All()
+	users, _ := dst.UsersRepo.All()
+	tokens, _ := dst.APITokensRepo.All()
+	if len(all) != 1 || len(users) != 1 || len(tokens) != 1 {
+		t.Errorf("expected 1 post, 1 user and 1 token, got %d, %d and %d", len(all), len(users), len(tokens))
+	}
+
+	// new users don't reuse restored ids
+	registered, err := usersRepo.Register(&user.User{Username: "another", Password: "password"})
+	if err != nil || registered.ID == u.ID {
+		t.Errorf("bad registered user %+v, %v", registered, err)
+	}
+}
+
+func TestExportWithoutSecrets(t *testing.T) {
+	src := newArchiver()
+	fill(t, src)
+
+	buf := &bytes.Buffer{}
+	manifest, err := src.Export(buf, Options{})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if manifest.Secrets {
+		t.Errorf("bad manifest %+v", manifest)
+	}
+	if _, ok := manifest.Entities["sessions"]; ok {
+		t.Errorf("sessions were exported %+v", manifest)
+	}
+
+	secrets := regexp.MustCompile(`"(passwordHash|token)":"[^"]|"(twoFactor|secretHash)":`)
+	names := []string{}
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+		body := &bytes.Buffer{}
+		body.ReadFrom(tr)
+		if secrets.MatchString(body.String()) {
+			t.Errorf("%s has secrets: %s", hdr.Name, body)
+		}
+	}
+	if strings.Join(names, ",") != "manifest.json,users.jsonl,posts.jsonl" {
+		t.Errorf("bad archive files %v", names)
+	}
+
+	dst := newArchiver()
+	if err = dst.TwoFactorRepo.(*twofactor.MemoryRepo).Enroll("1", "KEPT"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	_, err = dst.Restore(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if _, err = dst.UsersRepo.(*user.MemoryRepo).Authorize("login", "password"); err != user.ErrBadPass {
+		t.Errorf("expected %v, got %v", user.ErrBadPass, err)
+	}
+	if settings, err := dst.TwoFactorRepo.Get("1"); err != nil || settings.Secret != "KEPT" {
+		t.Errorf("two-factor settings not kept %+v, %v", settings, err)
+	}
+}
+
+func TestRestoreWithoutSecretsOverUsers(t *testing.T) {
+	src := newArchiver()
+	fill(t, src)
+	buf := &bytes.Buffer{}
+	if _, err := src.Export(buf, Options{}); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	dst := newArchiver()
+	u, _, _, _ := fill(t, dst)
+	if _, err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	// the users restored over keep their passwords and tokens
+	if _, err := dst.UsersRepo.(*user.MemoryRepo).Authorize("login", "password"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	restored, err := dst.UsersRepo.(*user.MemoryRepo).GetByID(u.ID)
+	if err != nil || restored.Token != "token" || !restored.EmailVerified {
+		t.Errorf("bad restored user %+v, %v", restored, err)
+	}
+}
+
+func TestRestoreTwoFactor(t *testing.T) {
+	a := newArchiver()
+	twoFactor := a.TwoFactorRepo.(*twofactor.MemoryRepo)
+	archive := func(version int, users ...userRecord) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		body, _ := json.Marshal(Manifest{Version: version, Secrets: true})
+		writeFile(tw, manifestFile, body, time.Now())
+		lines := &bytes.Buffer{}
+		for _, rec := range users {
+			json.NewEncoder(lines).Encode(rec)
+		}
+		writeFile(tw, usersFile, lines.Bytes(), time.Now())
+		tw.Close()
+		return buf
+	}
+
+	twoFactor.Enroll("1", "SECRET")
+	twoFactor.Enable("1", 100, []string{"aaaa-bbbb-cccc-dddd"})
+
+	// the archives of version 1 have no two-factor settings to restore
+	if _, err := a.Restore(archive(1, userRecord{ID: "1", Username: "login"})); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if settings, err := twoFactor.Get("1"); err != nil || !settings.Enabled {
+		t.Errorf("bad two-factor settings %+v, %v", settings, err)
+	}
+
+	// a user backed up without them has them disabled
+	if _, err := a.Restore(archive(FormatVersion, userRecord{ID: "1", Username: "login"})); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if _, err := twoFactor.Get("1"); err != twofactor.ErrNotEnrolled {
+		t.Errorf("expected %v, got %v", twofactor.ErrNotEnrolled, err)
+	}
+
+	a.TwoFactorRepo = nil
+	if _, err := a.Restore(archive(FormatVersion)); err != ErrNoTwoFactorRepo {
+		t.Errorf("expected %v, got %v", ErrNoTwoFactorRepo, err)
+	}
+	if _, err := a.Export(&bytes.Buffer{}, Options{Secrets: true}); err != ErrNoTwoFactorRepo {
+		t.Errorf("expected %v, got %v", ErrNoTwoFactorRepo, err)
+	}
+}
+
+func TestNoAPITokensRepo(t *testing.T) {
+	src := newArchiver()
+	fill(t, src)
+	buf := &bytes.Buffer{}
+	if _, err := src.Export(buf, Options{Secrets: true}); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	dst := newArchiver()
+	dst.APITokensRepo = nil
+	if _, err := dst.Restore(buf); err != ErrNoAPITokensRepo {
+		t.Errorf("expected %v, got %v", ErrNoAPITokensRepo, err)
+	}
+	if _, err := dst.Export(&bytes.Buffer{}, Options{Secrets: true}); err != ErrNoAPITokensRepo {
+		t.Errorf("expected %v, got %v", ErrNoAPITokensRepo, err)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	a := newArchiver()
+
+	_, err := a.Restore(bytes.NewReader(nil))
+	if err != ErrNoManifest {
+		t.Errorf("expected %v, got %v", ErrNoManifest, err)
+	}
+
+	archive := func(files map[string]interface{}, order ...string) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		for _, name := range order {
+			body, _ := json.Marshal(files[name])
+			writeFile(tw, name, body, time.Now())
+		}
+		tw.Close()
+		return buf
+	}
+
+	buf := archive(map[string]interface{}{"posts.jsonl": map[string]string{}}, "posts.jsonl")
+	if _, err = a.Restore(buf); err != ErrNoManifest {
+		t.Errorf("expected %v, got %v", ErrNoManifest, err)
+	}
+
+	buf = archive(map[string]interface{}{"manifest.json": Manifest{Version: FormatVersion + 1}}, "manifest.json")
+	if _, err = a.Restore(buf); err != ErrUnsupportedVersion {
+		t.Errorf("expected %v, got %v", ErrUnsupportedVersion, err)
+	}
+
+	buf = archive(map[string]interface{}{
+		"manifest.json": Manifest{Version: FormatVersion},
+		"posts.jsonl":   "not a post",
+	}, "manifest.json", "posts.jsonl")
+	if _, err = a.Restore(buf); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	buf = archive(map[string]interface{}{
+		"manifest.json":  Manifest{Version: FormatVersion},
+		"sessions.jsonl": sessionRecord{ID: "id"},
+	}, "manifest.json", "sessions.jsonl")
+	a.SessionsRepo = nil
+	if _, err = a.Restore(buf); err != ErrNoSessionsRepo {
+		t.Errorf("expected %v, got %v", ErrNoSessionsRepo, err)
+	}
+	if _, err = a.Export(&bytes.Buffer{}, Options{Sessions: true}); err != ErrNoSessionsRepo {
+		t.Errorf("expected %v, got %v", ErrNoSessionsRepo, err)
+	}
+}
+
+// newUsersRepo creates a Users Repo with fast password hashing
+func newUsersRepo() *user.MemoryRepo {
+	repo := user.NewMemoryRepo()
+	repo.Hasher = &user.BcryptHasher{Cost: bcrypt.MinCost}
+	return repo
+}
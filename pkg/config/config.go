@@ -0,0 +1,679 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ljwt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the names of the environment variables overriding the config,
+// e.g. REDDITCLONE_MYSQL_DSN overrides the -mysql-dsn flag
+const EnvPrefix = "REDDITCLONE_"
+
+// minKeyLength is the shortest JWT key accepted
+const minKeyLength = 16
+
+// LegacyJWTKeyID is the id of the single JWT key given with -jwt-key
+const LegacyJWTKeyID = ljwt.LegacyKeyID
+
+// Storage kinds
+const (
+	StorageMongo  = "mongo"
+	StorageMySQL  = "mysql"
+	StorageMemory = "memory"
+)
+
+// Session stores
+const (
+	// SessionStoreDefault keeps the sessions with the users: in MySQL or in memory
+	SessionStoreDefault = ""
+	SessionStoreRedis   = "redis"
+)
+
+// Mail transports
+const (
+	MailSMTP = "smtp"
+	MailFile = "file"
+)
+
+// Config is the whole application configuration
+type Config struct {
+	// Addr is the address the HTTP server listens on
+	Addr string `json:"addr"`
+	// StaticDir keeps the front end app
+	StaticDir string `json:"staticDir"`
+	// Storage is where to keep the data: mongo (MySQL + MongoDB), mysql or memory
+	Storage string `json:"storage"`
+	// Migrate applies the pending MySQL migrations on start
+	Migrate bool `json:"migrate"`
+	// ShutdownDelay keeps serving after the readiness probe starts failing on shutdown,
+	// so that the load balancer has time to stop routing new requests here
+	ShutdownDelay Duration `json:"shutdownDelay"`
+	// ShutdownTimeout limits draining the requests in flight on shutdown
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// HealthTimeout limits every dependency check of the readiness probe
+	HealthTimeout Duration  `json:"healthTimeout"`
+	MySQL         MySQL     `json:"mysql"`
+	Mongo         Mongo     `json:"mongo"`
+	JWT           JWT       `json:"jwt"`
+	Session       Session   `json:"session"`
+	Password      Password  `json:"password"`
+	Login         Login     `json:"login"`
+	OIDC          OIDC      `json:"oidc"`
+	Mail          Mail      `json:"mail"`
+	Email         Email     `json:"email"`
+	TwoFactor     TwoFactor `json:"twoFactor"`
+}
+
+// MySQL configures the MySQL connection keeping users, sessions and optionally posts
+type MySQL struct {
+	DSN          string `json:"dsn"`
+	MaxOpenConns int    `json:"maxOpenConns"`
+}
+
+// Mongo configures the MongoDB connection keeping posts
+type Mongo struct {
+	URI        string `json:"uri"`
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+}
+
+// JWT configures the tokens issued on login
+type JWT struct {
+	// Key is the single key used before the keyring, it's added to the keyring as "default"
+	Key string `json:"key"`
+	// Keys verify the tokens, a key is rotated by adding a new one, activating it
+	// and retiring the old one once the access tokens signed with it have expired
+	Keys JWTKeys `json:"keys"`
+	// ActiveKey is the id of the key signing the new tokens, it may be omitted when there is a single key
+	ActiveKey string `json:"activeKey"`
+	// RetiredKeys are the ids of the keys whose tokens aren't accepted anymore
+	RetiredKeys StringList `json:"retiredKeys"`
+	// Verification is the kind of the keys whose tokens are accepted: any, symmetric (HMAC) or asymmetric
+	Verification string `json:"verification"`
+	// AccessTTL is the lifetime of the access tokens, the refresh tokens live as long as the session
+	AccessTTL Duration `json:"accessTtl"`
+}
+
+// JWTKey is a key of the JWT keyring, either an HMAC secret or
+// a PEM file with an RSA (RS256) or Ed25519 (EdDSA) private key
+type JWTKey struct {
+	ID             string `json:"id"`
+	Secret         string `json:"secret,omitempty"`
+	PrivateKeyFile string `json:"privateKeyFile,omitempty"`
+}
+
+// JWTKeys is written as "id:secret,id:@private_key.pem" in the flags and environment
+type JWTKeys []JWTKey
+
+// String returns the keys as they are set
+func (k *JWTKeys) String() string {
+	parts := make([]string, 0, len(*k))
+	for _, key := range *k {
+		if key.PrivateKeyFile != "" {
+			parts = append(parts, key.ID+":@"+key.PrivateKeyFile)
+			continue
+		}
+		parts = append(parts, key.ID+":"+key.Secret)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set replaces the keys
+func (k *JWTKeys) Set(value string) error {
+	keys := JWTKeys{}
+	for _, part := range splitList(value) {
+		pair := strings.SplitN(part, ":", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("key %q isn't id:secret or id:@file", part)
+		}
+		if strings.HasPrefix(pair[1], "@") {
+			keys = append(keys, JWTKey{ID: pair[0], PrivateKeyFile: pair[1][1:]})
+			continue
+		}
+		keys = append(keys, JWTKey{ID: pair[0], Secret: pair[1]})
+	}
+	*k = keys
+	return nil
+}
+
+// StringList is written as "a,b" in the flags and environment
+type StringList []string
+
+// String returns the list as it is set
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set replaces the list
+func (l *StringList) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+// KeyIDs returns the ids of all the configured keys including the legacy one
+func (j *JWT) KeyIDs() []string {
+	ids := []string{}
+	if j.Key != "" {
+		ids = append(ids, LegacyJWTKeyID)
+	}
+	for _, key := range j.Keys {
+		ids = append(ids, key.ID)
+	}
+	return ids
+}
+
+// Active returns the id of the key signing the new tokens
+func (j *JWT) Active() string {
+	if j.ActiveKey != "" {
+		return j.ActiveKey
+	}
+	if ids := j.KeyIDs(); len(ids) == 1 {
+		return ids[0]
+	}
+	return ""
+}
+
+// Session configures the user sessions
+type Session struct {
+	// TTL is the absolute lifetime of a session and its refresh tokens
+	TTL Duration `json:"ttl"`
+	// IdleTimeout expires the sessions unused for that long, it's disabled when zero
+	IdleTimeout Duration `json:"idleTimeout"`
+	// CleanupInterval is the time between the purges of the expired sessions, they are never purged when zero
+	CleanupInterval Duration `json:"cleanupInterval"`
+	// Store is where the sessions are kept: with the users by default or in redis
+	Store    string `json:"store"`
+	RedisURL string `json:"redisUrl"`
+	// CacheTTL is how long a checked session is accepted without the store, the cache is disabled when zero.
+	// The sessions revoked on another instance are accepted by this one for up to the ttl
+	CacheTTL Duration `json:"cacheTtl"`
+}
+
+// Password configures the password hashing
+type Password struct {
+	// Hasher is the scheme of the new hashes: bcrypt or argon2id,
+	// the hashes of other schemes are upgraded on login
+	Hasher string `json:"hasher"`
+	// ResetTTL is the lifetime of the emailed reset links
+	ResetTTL Duration `json:"resetTtl"`
+	// ResetURL is the front end page the reset token is appended to as ?token=
+	ResetURL string `json:"resetUrl"`
+}
+
+// Mail configures sending the emails, e.g. the password reset links
+type Mail struct {
+	// Transport is smtp or file, the file one writes the emails into Dir instead of sending them
+	Transport    string `json:"transport"`
+	From         string `json:"from"`
+	SMTPAddr     string `json:"smtpAddr"`
+	SMTPUsername string `json:"smtpUsername"`
+	SMTPPassword string `json:"smtpPassword"`
+	Dir          string `json:"dir"`
+}
+
+// Email configures the verification of the user emails
+type Email struct {
+	// VerifyTTL is the lifetime of the emailed verification links
+	VerifyTTL Duration `json:"verifyTtl"`
+	// VerifyURL is the front end page the verification token is appended to as ?token=
+	VerifyURL string `json:"verifyUrl"`
+	// RequireVerified lets only the users with a verified email post, comment and vote
+	RequireVerified bool `json:"requireVerified"`
+}
+
+// TwoFactor configures the TOTP two-factor authentication the users turn on themselves
+type TwoFactor struct {
+	// Issuer names the app in the authenticator apps
+	Issuer string `json:"issuer"`
+	// LoginTTL is the time to enter the code after the password
+	LoginTTL Duration `json:"loginTtl"`
+}
+
+// validate checks the settings of the transport
+func (m *Mail) validate() []string {
+	errs := []string{}
+	switch m.Transport {
+	case MailSMTP:
+		if m.SMTPAddr == "" {
+			errs = append(errs, "mail smtp addr is required")
+		}
+	case MailFile:
+		if m.Dir == "" {
+			errs = append(errs, "mail dir is required")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("unknown mail transport %q", m.Transport))
+	}
+	if m.From == "" {
+		errs = append(errs, "mail from is required")
+	}
+	return errs
+}
+
+// Login configures the throttling of the failed logins per account and per client IP
+type Login struct {
+	Account Throttle `json:"account"`
+	IP      Throttle `json:"ip"`
+}
+
+// Throttle configures the wait after the failed logins: the free attempts fail without a wait,
+// then the wait doubles with every failure until the lockout
+type Throttle struct {
+	FreeAttempts int      `json:"freeAttempts"`
+	BaseDelay    Duration `json:"baseDelay"`
+	// LockoutAttempts failures lock the logins out, they are never locked out when zero
+	LockoutAttempts int `json:"lockoutAttempts"`
+	// LockoutDuration is the longest wait, the throttling is disabled when it's zero
+	LockoutDuration Duration `json:"lockoutDuration"`
+}
+
+// OIDC configures the sign in with an OpenID Connect provider, it's enabled when the client id is set.
+// The endpoints are given explicitly, so a local stand-in provider can be used in the tests
+type OIDC struct {
+	// Issuer is the iss claim of the provider's ID tokens
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authUrl"`
+	TokenURL string `json:"tokenUrl"`
+	JWKSURL  string `json:"jwksUrl"`
+	ClientID string `json:"clientId"`
+	// ClientSecret may be empty for a public client, PKCE is used anyway
+	ClientSecret string `json:"clientSecret"`
+	// RedirectURL is the /api/oidc/callback URL of the app as registered at the provider
+	RedirectURL string     `json:"redirectUrl"`
+	Scopes      StringList `json:"scopes"`
+	// LoginTTL is the time the user has to sign in at the provider
+	LoginTTL Duration `json:"loginTtl"`
+}
+
+// Enabled reports whether the users can sign in with the provider
+func (o *OIDC) Enabled() bool {
+	return o.ClientID != ""
+}
+
+// validate checks the provider endpoints are complete when it's enabled
+func (o *OIDC) validate() []string {
+	errs := []string{}
+	if o.Enabled() && (o.Issuer == "" || o.AuthURL == "" || o.TokenURL == "" || o.JWKSURL == "" || o.RedirectURL == "") {
+		errs = append(errs, "oidc issuer, auth, token, jwks and redirect urls are required with the client id")
+	}
+	if o.LoginTTL.Duration <= 0 {
+		errs = append(errs, "oidc login ttl must be positive")
+	}
+	return errs
+}
+
+// negative reports whether any of the settings is negative
+func (t *Throttle) negative() bool {
+	return t.FreeAttempts < 0 || t.LockoutAttempts < 0 || t.BaseDelay.Duration < 0 || t.LockoutDuration.Duration < 0
+}
+
+// validate checks the keyring, the keys are required to serve only, so no keys are checked by the caller
+func (j *JWT) validate() []string {
+	errs := []string{}
+	if j.Key != "" && len(j.Key) < minKeyLength {
+		errs = append(errs, fmt.Sprintf("jwt key must be at least %d characters long", minKeyLength))
+	}
+	switch j.Verification {
+	case ljwt.VerifyAny, ljwt.VerifySymmetric, ljwt.VerifyAsymmetric:
+	default:
+		errs = append(errs, fmt.Sprintf("unknown jwt verification %q", j.Verification))
+	}
+	// known maps the key ids to whether the key is symmetric
+	known := map[string]bool{}
+	if j.Key != "" {
+		known[LegacyJWTKeyID] = true
+	}
+	for _, key := range j.Keys {
+		_, duplicate := known[key.ID]
+		switch {
+		case key.ID == "" || strings.ContainsAny(key.ID, ",:"):
+			errs = append(errs, fmt.Sprintf("bad jwt key id %q", key.ID))
+		case duplicate:
+			errs = append(errs, fmt.Sprintf("duplicate jwt key id %q", key.ID))
+		case (key.Secret == "") == (key.PrivateKeyFile == ""):
+			errs = append(errs, fmt.Sprintf("jwt key %q needs either a secret or a private key file", key.ID))
+		case key.PrivateKeyFile == "" && len(key.Secret) < minKeyLength:
+			errs = append(errs, fmt.Sprintf("jwt key %q must be at least %d characters long", key.ID, minKeyLength))
+		}
+		known[key.ID] = key.PrivateKeyFile == ""
+	}
+	if len(known) == 0 {
+		return errs
+	}
+	active := j.Active()
+	symmetric, ok := known[active]
+	switch {
+	case active == "":
+		errs = append(errs, "jwt active key is required with several keys")
+	case !ok:
+		errs = append(errs, fmt.Sprintf("unknown jwt active key %q", active))
+	case symmetric && j.Verification == ljwt.VerifyAsymmetric,
+		!symmetric && j.Verification == ljwt.VerifySymmetric:
+		errs = append(errs, fmt.Sprintf("jwt active key %q isn't accepted by the %s verification", active, j.Verification))
+	}
+	for _, id := range j.RetiredKeys {
+		if _, ok := known[id]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown jwt retired key %q", id))
+		}
+		if id == active {
+			errs = append(errs, fmt.Sprintf("jwt active key %q can't be retired", id))
+		}
+	}
+	return errs
+}
+
+// splitList splits a comma separated list skipping the empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Duration is a time.Duration written as "1h30m" in the config file
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Default returns the config used for a local development setup
+func Default() *Config {
+	return &Config{
+		Addr:            ":8080",
+		StaticDir:       "./../../template",
+		Storage:         StorageMongo,
+		ShutdownTimeout: Duration{15 * time.Second},
+		HealthTimeout:   Duration{2 * time.Second},
+		MySQL: MySQL{
+			DSN:          "root@tcp(localhost:3306)/golang2?charset=utf8&interpolateParams=true",
+			MaxOpenConns: 10,
+		},
+		Mongo: Mongo{
+			URI:        "mongodb://localhost:27017",
+			Database:   "asperitas",
+			Collection: "posts",
+		},
+		JWT: JWT{
+			Verification: ljwt.VerifyAny,
+			AccessTTL:    Duration{15 * time.Minute},
+		},
+		Session: Session{
+			TTL:             Duration{30 * 24 * time.Hour},
+			IdleTimeout:     Duration{7 * 24 * time.Hour},
+			CleanupInterval: Duration{10 * time.Minute},
+			RedisURL:        "redis://localhost:6379/0",
+		},
+		Password: Password{
+			Hasher:   "bcrypt",
+			ResetTTL: Duration{time.Hour},
+			ResetURL: "http://localhost:8080/reset-password",
+		},
+		Login: Login{
+			Account: Throttle{
+				FreeAttempts:    5,
+				BaseDelay:       Duration{time.Second},
+				LockoutAttempts: 20,
+				LockoutDuration: Duration{15 * time.Minute},
+			},
+			// many users may share an IP behind a NAT
+			IP: Throttle{
+				FreeAttempts:    20,
+				BaseDelay:       Duration{time.Second},
+				LockoutAttempts: 100,
+				LockoutDuration: Duration{15 * time.Minute},
+			},
+		},
+		OIDC: OIDC{
+			Scopes:   StringList{"openid", "email", "profile"},
+			LoginTTL: Duration{10 * time.Minute},
+		},
+		// the emails are kept in files until a mail server is configured
+		Mail: Mail{
+			Transport: MailFile,
+			From:      "redditclone@localhost",
+			SMTPAddr:  "localhost:25",
+			Dir:       "./mail",
+		},
+		Email: Email{
+			VerifyTTL: Duration{24 * time.Hour},
+			VerifyURL: "http://localhost:8080/verify-email",
+		},
+		TwoFactor: TwoFactor{
+			Issuer:   "redditclone",
+			LoginTTL: Duration{5 * time.Minute},
+		},
+	}
+}
+
+// flagSet binds the flags to the config fields, the current values become the defaults
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("redditclone", flag.ContinueOnError)
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	fs.StringVar(&c.StaticDir, "static-dir", c.StaticDir, "directory with the front end app")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "where to keep the data: mongo (MySQL + MongoDB), mysql or memory")
+	fs.BoolVar(&c.Migrate, "migrate", c.Migrate, "apply the pending MySQL migrations on start")
+	fs.DurationVar(&c.ShutdownDelay.Duration, "shutdown-delay", c.ShutdownDelay.Duration, "time to keep serving after the readiness probe starts failing on shutdown")
+	fs.DurationVar(&c.ShutdownTimeout.Duration, "shutdown-timeout", c.ShutdownTimeout.Duration, "time to finish the requests in flight on shutdown")
+	fs.DurationVar(&c.HealthTimeout.Duration, "health-timeout", c.HealthTimeout.Duration, "timeout of every readiness check")
+	fs.StringVar(&c.MySQL.DSN, "mysql-dsn", c.MySQL.DSN, "MySQL data source name")
+	fs.IntVar(&c.MySQL.MaxOpenConns, "mysql-max-open-conns", c.MySQL.MaxOpenConns, "maximum number of open MySQL connections")
+	fs.StringVar(&c.Mongo.URI, "mongo-uri", c.Mongo.URI, "MongoDB connection string")
+	fs.StringVar(&c.Mongo.Database, "mongo-database", c.Mongo.Database, "MongoDB database keeping posts")
+	fs.StringVar(&c.Mongo.Collection, "mongo-collection", c.Mongo.Collection, "MongoDB collection keeping posts")
+	fs.StringVar(&c.JWT.Key, "jwt-key", c.JWT.Key, "single key signing the JWT tokens, it's the \""+LegacyJWTKeyID+"\" key of the keyring")
+	fs.Var(&c.JWT.Keys, "jwt-keys", "JWT keyring as id:secret,id:@private_key.pem")
+	fs.StringVar(&c.JWT.ActiveKey, "jwt-active-key", c.JWT.ActiveKey, "id of the key signing the new JWT tokens")
+	fs.Var(&c.JWT.RetiredKeys, "jwt-retired-keys", "comma separated ids of the keys whose JWT tokens aren't accepted anymore")
+	fs.StringVar(&c.JWT.Verification, "jwt-verification", c.JWT.Verification, "kind of the keys whose JWT tokens are accepted: any, symmetric or asymmetric")
+	fs.DurationVar(&c.JWT.AccessTTL.Duration, "jwt-access-ttl", c.JWT.AccessTTL.Duration, "access token lifetime")
+	fs.DurationVar(&c.Session.TTL.Duration, "session-ttl", c.Session.TTL.Duration, "absolute session and refresh token lifetime")
+	fs.DurationVar(&c.Session.IdleTimeout.Duration, "session-idle-timeout", c.Session.IdleTimeout.Duration, "time an unused session expires after, 0 disables it")
+	fs.DurationVar(&c.Session.CleanupInterval.Duration, "session-cleanup-interval", c.Session.CleanupInterval.Duration, "time between the purges of the expired sessions, 0 disables them")
+	fs.StringVar(&c.Session.Store, "session-store", c.Session.Store, "where to keep the sessions: redis or empty to keep them with the users")
+	fs.StringVar(&c.Session.RedisURL, "session-redis-url", c.Session.RedisURL, "redis://[[username]:password@]host[:port][/db] of the redis session store")
+	fs.DurationVar(&c.Session.CacheTTL.Duration, "session-cache-ttl", c.Session.CacheTTL.Duration, "time a checked session is accepted without the session store, 0 disables the cache")
+	fs.StringVar(&c.Password.Hasher, "password-hasher", c.Password.Hasher, "password hashing scheme: bcrypt or argon2id")
+	fs.DurationVar(&c.Password.ResetTTL.Duration, "password-reset-ttl", c.Password.ResetTTL.Duration, "lifetime of the emailed password reset links")
+	fs.StringVar(&c.Password.ResetURL, "password-reset-url", c.Password.ResetURL, "front end page the password reset token is appended to as ?token=")
+	c.Login.Account.bind(fs, "login-account", "per account")
+	c.Login.IP.bind(fs, "login-ip", "per client IP")
+	fs.StringVar(&c.OIDC.Issuer, "oidc-issuer", c.OIDC.Issuer, "issuer of the OpenID Connect provider's ID tokens")
+	fs.StringVar(&c.OIDC.AuthURL, "oidc-auth-url", c.OIDC.AuthURL, "OpenID Connect authorization endpoint")
+	fs.StringVar(&c.OIDC.TokenURL, "oidc-token-url", c.OIDC.TokenURL, "OpenID Connect token endpoint")
+	fs.StringVar(&c.OIDC.JWKSURL, "oidc-jwks-url", c.OIDC.JWKSURL, "OpenID Connect provider's key set")
+	fs.StringVar(&c.OIDC.ClientID, "oidc-client-id", c.OIDC.ClientID, "client id registered at the OpenID Connect provider, it enables the sign in with it")
+	fs.StringVar(&c.OIDC.ClientSecret, "oidc-client-secret", c.OIDC.ClientSecret, "client secret registered at the OpenID Connect provider")
+	fs.StringVar(&c.OIDC.RedirectURL, "oidc-redirect-url", c.OIDC.RedirectURL, "public URL of /api/oidc/callback registered at the OpenID Connect provider")
+	fs.Var(&c.OIDC.Scopes, "oidc-scopes", "comma separated scopes requested from the OpenID Connect provider")
+	fs.DurationVar(&c.OIDC.LoginTTL.Duration, "oidc-login-ttl", c.OIDC.LoginTTL.Duration, "time to sign in at the OpenID Connect provider")
+	fs.StringVar(&c.Mail.Transport, "mail-transport", c.Mail.Transport, "how the emails are sent: smtp or file (written into -mail-dir)")
+	fs.StringVar(&c.Mail.From, "mail-from", c.Mail.From, "sender address of the emails")
+	fs.StringVar(&c.Mail.SMTPAddr, "mail-smtp-addr", c.Mail.SMTPAddr, "host:port of the SMTP server")
+	fs.StringVar(&c.Mail.SMTPUsername, "mail-smtp-username", c.Mail.SMTPUsername, "SMTP login, no login is done when it's empty")
+	fs.StringVar(&c.Mail.SMTPPassword, "mail-smtp-password", c.Mail.SMTPPassword, "SMTP password")
+	fs.StringVar(&c.Mail.Dir, "mail-dir", c.Mail.Dir, "directory the file transport writes the emails into")
+	fs.DurationVar(&c.Email.VerifyTTL.Duration, "email-verify-ttl", c.Email.VerifyTTL.Duration, "lifetime of the emailed verification links")
+	fs.StringVar(&c.Email.VerifyURL, "email-verify-url", c.Email.VerifyURL, "front end page the email verification token is appended to as ?token=")
+	fs.BoolVar(&c.Email.RequireVerified, "email-require-verified", c.Email.RequireVerified, "let only the users with a verified email post, comment and vote")
+	fs.StringVar(&c.TwoFactor.Issuer, "2fa-issuer", c.TwoFactor.Issuer, "name of the app in the authenticator apps")
+	fs.DurationVar(&c.TwoFactor.LoginTTL.Duration, "2fa-login-ttl", c.TwoFactor.LoginTTL.Duration, "time to enter the two-factor code after the password")
+	return fs
+}
+
+// bind binds the throttle flags starting with the prefix
+func (t *Throttle) bind(fs *flag.FlagSet, prefix, per string) {
+	fs.IntVar(&t.FreeAttempts, prefix+"-free-attempts", t.FreeAttempts, "failed logins "+per+" without a wait")
+	fs.DurationVar(&t.BaseDelay.Duration, prefix+"-base-delay", t.BaseDelay.Duration, "wait after the first failed login "+per+" over the free ones, it doubles with every next one")
+	fs.IntVar(&t.LockoutAttempts, prefix+"-lockout-attempts", t.LockoutAttempts, "failed logins "+per+" locking the logins out, 0 disables the lockout")
+	fs.DurationVar(&t.LockoutDuration.Duration, prefix+"-lockout-duration", t.LockoutDuration.Duration, "lockout time and the longest wait "+per+", 0 disables the throttling")
+}
+
+// EnvName returns the environment variable overriding the flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Load builds the config from the defaults, the optional JSON config file,
+// the environment variables and the command line flags, each one overriding the previous.
+// The config file is given with the -config flag or REDDITCLONE_CONFIG variable.
+// It returns the arguments left after the flags
+func Load(args []string, getenv func(string) string) (*Config, []string, error) {
+	// the flags are parsed first to find the config file, but applied last
+	fs := Default().flagSet()
+	configFile := fs.String("config", getenv(EnvName("config")), "JSON config file")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	cfg := Default()
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = json.Unmarshal(data, cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad config file %s: %s", *configFile, err)
+		}
+	}
+
+	cfgFlags := cfg.flagSet()
+	cfgFlags.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if value := getenv(EnvName(f.Name)); value != "" {
+			err = cfgFlags.Set(f.Name, value)
+			if err != nil {
+				err = fmt.Errorf("bad %s: %s", EnvName(f.Name), err)
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, value := range set {
+		if name == "config" {
+			continue
+		}
+		err = cfgFlags.Set(name, value)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// Validate checks that the config is complete
+func (c *Config) Validate() error {
+	errs := []string{}
+	if c.Addr == "" {
+		errs = append(errs, "addr is empty")
+	}
+	switch c.Storage {
+	case StorageMongo:
+		if c.Mongo.URI == "" || c.Mongo.Database == "" || c.Mongo.Collection == "" {
+			errs = append(errs, "mongo uri, database and collection are required")
+		}
+		fallthrough
+	case StorageMySQL:
+		if c.MySQL.DSN == "" {
+			errs = append(errs, "mysql dsn is required")
+		}
+		if c.MySQL.MaxOpenConns < 1 {
+			errs = append(errs, "mysql maxOpenConns must be positive")
+		}
+	case StorageMemory:
+	default:
+		errs = append(errs, fmt.Sprintf("unknown storage %q", c.Storage))
+	}
+	errs = append(errs, c.JWT.validate()...)
+	errs = append(errs, c.OIDC.validate()...)
+	errs = append(errs, c.Mail.validate()...)
+	if c.ShutdownDelay.Duration < 0 || c.ShutdownTimeout.Duration < 0 {
+		errs = append(errs, "shutdown delay and timeout can't be negative")
+	}
+	if c.HealthTimeout.Duration <= 0 {
+		errs = append(errs, "health timeout must be positive")
+	}
+	if c.JWT.AccessTTL.Duration <= 0 {
+		errs = append(errs, "jwt access ttl must be positive")
+	}
+	if c.Session.TTL.Duration <= 0 {
+		errs = append(errs, "session ttl must be positive")
+	}
+	if c.Session.IdleTimeout.Duration < 0 || c.Session.CleanupInterval.Duration < 0 || c.Session.CacheTTL.Duration < 0 {
+		errs = append(errs, "session idle timeout, cleanup interval and cache ttl can't be negative")
+	}
+	switch c.Session.Store {
+	case SessionStoreDefault:
+	case SessionStoreRedis:
+		if c.Session.RedisURL == "" {
+			errs = append(errs, "session redis url is required")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("unknown session store %q", c.Session.Store))
+	}
+	if c.Password.Hasher != "bcrypt" && c.Password.Hasher != "argon2id" {
+		errs = append(errs, fmt.Sprintf("unknown password hasher %q", c.Password.Hasher))
+	}
+	if c.Password.ResetTTL.Duration <= 0 {
+		errs = append(errs, "password reset ttl must be positive")
+	}
+	if c.Password.ResetURL == "" {
+		errs = append(errs, "password reset url is required")
+	}
+	if c.Email.VerifyTTL.Duration <= 0 {
+		errs = append(errs, "email verify ttl must be positive")
+	}
+	if c.Email.VerifyURL == "" {
+		errs = append(errs, "email verify url is required")
+	}
+	if c.TwoFactor.Issuer == "" {
+		errs = append(errs, "2fa issuer is required")
+	}
+	if c.TwoFactor.LoginTTL.Duration <= 0 {
+		errs = append(errs, "2fa login ttl must be positive")
+	}
+	if c.Login.Account.negative() || c.Login.IP.negative() {
+		errs = append(errs, "login throttling can't be negative")
+	}
+	if len(errs) > 0 {
+		return errors.New("bad config: " + strings.Join(errs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, args, err := Load([]string{"migrate", "up"}, env(nil))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("results not match, want %+v, have %+v", Default(), cfg)
+	}
+	if strings.Join(args, " ") != "migrate up" {
+		t.Errorf("bad args %v", args)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(file, []byte(`{
+		"addr": ":9000",
+		"storage": "mysql",
+		"mysql": {"dsn": "file_dsn"},
+		"mongo": {"database": "file_db"},
+		"jwt": {"key": "file_key_0123456789"},
+		"session": {"ttl": "30m", "store": "redis"},
+		"login": {"ip": {"freeAttempts": 50}},
+		"mail": {"transport": "smtp", "smtpAddr": "mail.example.com:587"},
+		"email": {"requireVerified": true}
+	}`), 0600)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	cfg, args, err := Load(
+		[]string{"-config", file, "-addr", ":7000", "-migrate", "import", "posts.json"},
+		env(map[string]string{
+			"REDDITCLONE_ADDR":                           ":8000",
+			"REDDITCLONE_MYSQL_DSN":                      "env_dsn",
+			"REDDITCLONE_LOGIN_ACCOUNT_LOCKOUT_DURATION": "1h",
+			"REDDITCLONE_SESSION_CACHE_TTL":              "5s",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	expected := Default()
+	expected.Addr = ":7000"
+	expected.Storage = StorageMySQL
+	expected.Migrate = true
+	expected.MySQL.DSN = "env_dsn"
+	expected.Mongo.Database = "file_db"
+	expected.JWT.Key = "file_key_0123456789"
+	expected.Session.TTL = Duration{30 * time.Minute}
+	expected.Session.Store = SessionStoreRedis
+	expected.Session.CacheTTL = Duration{5 * time.Second}
+	expected.Login.IP.FreeAttempts = 50
+	expected.Login.Account.LockoutDuration = Duration{time.Hour}
+	expected.Mail.Transport = MailSMTP
+	expected.Mail.SMTPAddr = "mail.example.com:587"
+	expected.Email.RequireVerified = true
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("results not match, want %+v, have %+v", expected, cfg)
+	}
+	if strings.Join(args, " ") != "import posts.json" {
+		t.Errorf("bad args %v", args)
+	}
+
+	// the config file can be given in the environment as well
+	cfg, _, err = Load(nil, env(map[string]string{"REDDITCLONE_CONFIG": file}))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if cfg.Addr != ":9000" {
+		t.Errorf("config file wasn't loaded: %+v", cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := []struct {
+		args []string
+		env  map[string]string
+	}{
+		{args: []string{"-unknown"}},
+		{args: []string{"-config", "/no/such/file.json"}},
+		{args: []string{"-storage", "redis"}},
+		{args: []string{"-jwt-key", "short"}},
+		{args: []string{"-session-ttl", "0s"}},
+		{args: []string{"-jwt-access-ttl", "0s"}},
+		{args: []string{"-session-idle-timeout", "-1s"}},
+		{args: []string{"-session-cleanup-interval", "-1s"}},
+		{args: []string{"-session-cache-ttl", "-1s"}},
+		{args: []string{"-session-store", "memcached"}},
+		{args: []string{"-session-store", "redis", "-session-redis-url", ""}},
+		{args: []string{"-jwt-keys", "no_secret"}},
+		{args: []string{"-jwt-keys", "k1:short"}},
+		{args: []string{"-jwt-keys", "k1:first_secret_key,k1:second_secret_key", "-jwt-active-key", "k1"}},
+		{args: []string{"-jwt-keys", "k1:first_secret_key,k2:second_secret_key"}},
+		{args: []string{"-jwt-keys", "k1:first_secret_key", "-jwt-active-key", "k2"}},
+		{args: []string{"-jwt-keys", "k1:first_secret_key", "-jwt-retired-keys", "k1"}},
+		{args: []string{"-jwt-keys", "k1:first_secret_key", "-jwt-retired-keys", "k0"}},
+		{args: []string{"-jwt-keys", "k1:first_secret_key", "-jwt-verification", "asymmetric"}},
+		{args: []string{"-jwt-keys", "k1:@key.pem", "-jwt-verification", "symmetric"}},
+		{args: []string{"-jwt-verification", "none"}},
+		{args: []string{"-login-account-free-attempts", "-1"}},
+		{args: []string{"-login-ip-lockout-duration", "-1s"}},
+		{args: []string{"-health-timeout", "0s"}},
+		{args: []string{"-password-hasher", "md5"}},
+		{args: []string{"-oidc-client-id", "client", "-oidc-issuer", "https://idp.example.com"}},
+		{args: []string{"-oidc-login-ttl", "0s"}},
+		{args: []string{"-password-reset-ttl", "0s"}},
+		{args: []string{"-password-reset-url", ""}},
+		{args: []string{"-mail-transport", "sendmail"}},
+		{args: []string{"-mail-transport", "smtp", "-mail-smtp-addr", ""}},
+		{args: []string{"-mail-dir", ""}},
+		{args: []string{"-mail-from", ""}},
+		{args: []string{"-email-verify-ttl", "0s"}},
+		{args: []string{"-email-verify-url", ""}},
+		{args: []string{"-2fa-issuer", ""}},
+		{args: []string{"-2fa-login-ttl", "0s"}},
+		{args: []string{"-mysql-dsn", ""}},
+		{args: []string{"-storage", "mongo", "-mongo-uri", ""}},
+		{env: map[string]string{"REDDITCLONE_MYSQL_MAX_OPEN_CONNS": "many"}},
+	}
+	for i, c := range cases {
+		_, _, err := Load(c.args, env(c.env))
+		if err == nil {
+			t.Errorf("[%d] expected error, got nil", i)
+		}
+	}
+
+	// the memory storage needs no databases
+	_, _, err := Load([]string{"-storage", "memory", "-mysql-dsn", ""}, env(nil))
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+	cfg, _, err := Load([]string{
+		"-jwt-key", "legacy_secret_key",
+		"-jwt-active-key", "k2",
+		"-jwt-retired-keys", "default",
+	}, env(map[string]string{
+		"REDDITCLONE_JWT_KEYS": "k1:first_secret_key,k2:second:secret:key",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	expected := JWTKeys{
+		{ID: "k1", Secret: "first_secret_key"},
+		{ID: "k2", Secret: "second:secret:key"},
+	}
+	if !reflect.DeepEqual(cfg.JWT.Keys, expected) {
+		t.Errorf("bad keys %+v", cfg.JWT.Keys)
+	}
+	if !reflect.DeepEqual(cfg.JWT.KeyIDs(), []string{LegacyJWTKeyID, "k1", "k2"}) ||
+		cfg.JWT.Active() != "k2" || !reflect.DeepEqual(cfg.JWT.RetiredKeys, StringList{LegacyJWTKeyID}) {
+		t.Errorf("bad keyring %+v", cfg.JWT)
+	}
+
+	// a single key is active by default
+	cfg, _, err = Load([]string{"-jwt-keys", "k1:first_secret_key"}, env(nil))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if cfg.JWT.Active() != "k1" {
+		t.Errorf("bad active key %q", cfg.JWT.Active())
+	}
+
+	// the private keys are read from the files
+	cfg, _, err = Load([]string{
+		"-jwt-keys", "k1:first_secret_key,k2:@/etc/redditclone/k2.pem",
+		"-jwt-active-key", "k2",
+		"-jwt-verification", "asymmetric",
+		"-jwt-retired-keys", "k1",
+	}, env(nil))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if cfg.JWT.Keys[1] != (JWTKey{ID: "k2", PrivateKeyFile: "/etc/redditclone/k2.pem"}) ||
+		cfg.JWT.Keys.String() != "k1:first_secret_key,k2:@/etc/redditclone/k2.pem" {
+		t.Errorf("bad keys %+v", cfg.JWT.Keys)
+	}
+}
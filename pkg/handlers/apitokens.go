@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// maxTokenNameLength limits the names of the personal tokens
+const maxTokenNameLength = 100
+
+// maxTokensPerUser limits the personal tokens a user may have
+const maxTokensPerUser = 50
+
+// APITokensRepoInterface keeps the personal tokens of the users
+type APITokensRepoInterface interface {
+	Create(userID, name string, scopes apitoken.Scopes, expires time.Time) (*apitoken.Token, string, error)
+	ListForUser(userID string) ([]*apitoken.Token, error)
+	Revoke(userID, id string) error
+}
+
+// APITokensHandler lets the users manage their personal tokens for the bots and scripts
+type APITokensHandler struct {
+	Logger *zap.SugaredLogger
+	Tokens APITokensRepoInterface
+}
+
+type tokenForm struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is zero for a token which never expires
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+// tokenView is a personal token as shown to its user, the secret is shown once on creation only
+type tokenView struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Scopes   apitoken.Scopes `json:"scopes"`
+	Created  time.Time       `json:"created"`
+	LastUsed *time.Time      `json:"lastUsed,omitempty"`
+	Expires  *time.Time      `json:"expires,omitempty"`
+	Token    string          `json:"token,omitempty"`
+}
+
+func newTokenView(tok *apitoken.Token) tokenView {
+	view := tokenView{ID: tok.ID, Name: tok.Name, Scopes: tok.Scopes, Created: tok.Created}
+	if !tok.LastUsed.IsZero() {
+		view.LastUsed = &tok.LastUsed
+	}
+	if !tok.Expires.IsZero() {
+		view.Expires = &tok.Expires
+	}
+	return view
+}
+
+// Create creates a new personal token of the current user and returns its secret
+func (h *APITokensHandler) Create(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	tf := &tokenForm{}
+	err = json.NewDecoder(r.Body).Decode(tf)
+	if err != nil {
+		jsonMessage(w, http.StatusBadRequest, "can't parse the token")
+		return
+	}
+	if tf.Name == "" || len(tf.Name) > maxTokenNameLength {
+		jsonMessage(w, http.StatusBadRequest, "token name is required and must be short")
+		return
+	}
+	if tf.ExpiresInDays < 0 {
+		jsonMessage(w, http.StatusBadRequest, "token expiry can't be negative")
+		return
+	}
+	scopes, err := apitoken.ParseScopes(tf.Scopes)
+	if err != nil {
+		jsonMessage(w, http.StatusBadRequest, "scopes must be some of read, post, vote and comment")
+		return
+	}
+
+	existing, err := h.Tokens.ListForUser(sess.UserID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxTokensPerUser {
+		jsonMessage(w, http.StatusConflict, "too many tokens, revoke the unused ones")
+		return
+	}
+
+	var expires time.Time
+	if tf.ExpiresInDays > 0 {
+		expires = time.Now().AddDate(0, 0, tf.ExpiresInDays)
+	}
+	tok, secret, err := h.Tokens.Create(sess.UserID, tf.Name, scopes, expires)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	h.logger(r).Infow("Personal token created", "tokenId", tok.ID, "scopes", tok.Scopes.String())
+
+	view := newTokenView(tok)
+	view.Token = secret
+	result, _ := json.Marshal(view)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(result)
+}
+
+// List returns the personal tokens of the current user without their secrets
+func (h *APITokensHandler) List(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := h.Tokens.ListForUser(sess.UserID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]tokenView, 0, len(tokens))
+	for _, tok := range tokens {
+		views = append(views, newTokenView(tok))
+	}
+	result, _ := json.Marshal(views)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
+
+// Revoke revokes a personal token of the current user by its id
+func (h *APITokensHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Tokens.Revoke(sess.UserID, mux.Vars(r)["id"])
+	if err == apitoken.ErrNoToken {
+		jsonMessage(w, http.StatusNotFound, "token not found")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	jsonMessage(w, http.StatusOK, "success")
+}
+
+// logger returns the request-scoped logger
+func (h *APITokensHandler) logger(r *http.Request) *zap.SugaredLogger {
+	return logging.FromContext(r.Context(), h.Logger)
+}
@@ -0,0 +1,80 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: apitokens.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	apitoken "golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	reflect "reflect"
+	time "time"
+)
+
+// MockAPITokensRepoInterface is a mock of APITokensRepoInterface interface
+type MockAPITokensRepoInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockAPITokensRepoInterfaceMockRecorder
+}
+
+// MockAPITokensRepoInterfaceMockRecorder is the mock recorder for MockAPITokensRepoInterface
+type MockAPITokensRepoInterfaceMockRecorder struct {
+	mock *MockAPITokensRepoInterface
+}
+
+// NewMockAPITokensRepoInterface creates a new mock instance
+func NewMockAPITokensRepoInterface(ctrl *gomock.Controller) *MockAPITokensRepoInterface {
+	mock := &MockAPITokensRepoInterface{ctrl: ctrl}
+	mock.recorder = &MockAPITokensRepoInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockAPITokensRepoInterface) EXPECT() *MockAPITokensRepoInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method
+func (m *MockAPITokensRepoInterface) Create(userID, name string, scopes apitoken.Scopes, expires time.Time) (*apitoken.Token, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", userID, name, scopes, expires)
+	ret0, _ := ret[0].(*apitoken.Token)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Create indicates an expected call of Create
+func (mr *MockAPITokensRepoInterfaceMockRecorder) Create(userID, name, scopes, expires interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAPITokensRepoInterface)(nil).Create), userID, name, scopes, expires)
+}
+
+// ListForUser mocks base method
+func (m *MockAPITokensRepoInterface) ListForUser(userID string) ([]*apitoken.Token, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListForUser", userID)
+	ret0, _ := ret[0].([]*apitoken.Token)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListForUser indicates an expected call of ListForUser
+func (mr *MockAPITokensRepoInterfaceMockRecorder) ListForUser(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListForUser", reflect.TypeOf((*MockAPITokensRepoInterface)(nil).ListForUser), userID)
+}
+
+// Revoke mocks base method
+func (m *MockAPITokensRepoInterface) Revoke(userID, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Revoke", userID, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Revoke indicates an expected call of Revoke
+func (mr *MockAPITokensRepoInterfaceMockRecorder) Revoke(userID, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Revoke", reflect.TypeOf((*MockAPITokensRepoInterface)(nil).Revoke), userID, id)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func TestAPITokensHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tokens := NewMockAPITokensRepoInterface(ctrl)
+	service := APITokensHandler{
+		Logger: zap.NewNop().Sugar(),
+		Tokens: tokens,
+	}
+	created := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	tok := &apitoken.Token{ID: "t1", UserID: "42", Name: "bot", Scopes: apitoken.Scopes{apitoken.ScopeRead, apitoken.ScopeVote}, Created: created}
+
+	request := func(method, body string, vars map[string]string) *http.Request {
+		req := httptest.NewRequest(method, "/api/tokens", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, &session.Session{ID: "sess_id", UserID: "42"}))
+		return mux.SetURLVars(req, vars)
+	}
+
+	cases := []struct {
+		name   string
+		expect func()
+		handle func(http.ResponseWriter, *http.Request)
+		req    *http.Request
+		status int
+		body   string
+	}{
+		{
+			name: "create",
+			expect: func() {
+				tokens.EXPECT().ListForUser("42").Return([]*apitoken.Token{}, nil)
+				tokens.EXPECT().Create("42", "bot", apitoken.Scopes{apitoken.ScopeRead, apitoken.ScopeVote}, time.Time{}).
+					Return(tok, apitoken.Prefix+"secret", nil)
+			},
+			handle: service.Create,
+			req:    request("POST", `{"name":"bot","scopes":["vote","read"]}`, nil),
+			status: http.StatusCreated,
+			body:   `{"id":"t1","name":"bot","scopes":["read","vote"],"created":"2020-07-01T12:00:00Z","token":"rcpat_secret"}`,
+		},
+		{
+			name: "create expiring",
+			expect: func() {
+				tokens.EXPECT().ListForUser("42").Return([]*apitoken.Token{}, nil)
+				tokens.EXPECT().Create("42", "bot", apitoken.Scopes{apitoken.ScopePost}, gomock.Any()).
+					DoAndReturn(func(_, _ string, _ apitoken.Scopes, expires time.Time) (*apitoken.Token, string, error) {
+						if d := time.Until(expires); d < 29*24*time.Hour || d > 31*24*time.Hour {
+							t.Errorf("bad expiry %s", expires)
+						}
+						return tok, apitoken.Prefix + "secret", nil
+					})
+			},
+			handle: service.Create,
+			req:    request("POST", `{"name":"bot","scopes":["post"],"expiresInDays":30}`, nil),
+			status: http.StatusCreated,
+		},
+		{
+			name:   "unknown scope",
+			expect: func() {},
+			handle: service.Create,
+			req:    request("POST", `{"name":"bot","scopes":["admin"]}`, nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no name",
+			expect: func() {},
+			handle: service.Create,
+			req:    request("POST", `{"scopes":["read"]}`, nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "negative expiry",
+			expect: func() {},
+			handle: service.Create,
+			req:    request("POST", `{"name":"bot","scopes":["read"],"expiresInDays":-1}`, nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "too many tokens",
+			expect: func() {
+				tokens.EXPECT().ListForUser("42").Return(make([]*apitoken.Token, maxTokensPerUser), nil)
+			},
+			handle: service.Create,
+			req:    request("POST", `{"name":"bot","scopes":["read"]}`, nil),
+			status: http.StatusConflict,
+		},
+		{
+			name: "list hides the secrets",
+			expect: func() {
+				tokens.EXPECT().ListForUser("42").Return([]*apitoken.Token{tok}, nil)
+			},
+			handle: service.List,
+			req:    request("GET", "", nil),
+			status: http.StatusOK,
+			body:   `[{"id":"t1","name":"bot","scopes":["read","vote"],"created":"2020-07-01T12:00:00Z"}]`,
+		},
+		{
+			name: "list error",
+			expect: func() {
+				tokens.EXPECT().ListForUser("42").Return(nil, errors.New("db_error"))
+			},
+			handle: service.List,
+			req:    request("GET", "", nil),
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "revoke",
+			expect: func() {
+				tokens.EXPECT().Revoke("42", "t1").Return(nil)
+			},
+			handle: service.Revoke,
+			req:    request("DELETE", "", map[string]string{"id": "t1"}),
+			status: http.StatusOK,
+		},
+		{
+			name: "revoke unknown",
+			expect: func() {
+				tokens.EXPECT().Revoke("42", "t2").Return(apitoken.ErrNoToken)
+			},
+			handle: service.Revoke,
+			req:    request("DELETE", "", map[string]string{"id": "t2"}),
+			status: http.StatusNotFound,
+		},
+	}
+	for _, c := range cases {
+		c.expect()
+		w := httptest.NewRecorder()
+		c.handle(w, c.req)
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("[%s] bad body %s", c.name, w.Body.String())
+		}
+	}
+}
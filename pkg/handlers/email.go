@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/mail"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/onetime"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// verifyThrottlePrefix keeps the verification emails apart from the logins in the account throttle
+const verifyThrottlePrefix = "verify:"
+
+type emailForm struct {
+	Email string `json:"email"`
+}
+
+type verifyEmailForm struct {
+	Token string `json:"token"`
+}
+
+// SetEmail replaces the email of the current user and sends a verification link to it
+func (h *UsersHandler) SetEmail(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	form := &emailForm{}
+	err = json.NewDecoder(r.Body).Decode(form)
+	if err != nil {
+		jsonMessage(w, http.StatusBadRequest, "email is required")
+		return
+	}
+	email, err := user.NormalizeEmail(form.Email)
+	if err != nil {
+		jsonMessage(w, http.StatusBadRequest, "invalid email address")
+		return
+	}
+
+	u, err := h.UsersRepo.GetByID(sess.UserID)
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusUnauthorized, "user not found")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if u.EmailVerified && strings.EqualFold(u.Email, email) {
+		jsonMessage(w, http.StatusOK, "email is already verified")
+		return
+	}
+
+	// throttled per user id like the reset emails, so no spelling of the username gets a fresh budget
+	wait, err := h.mailWait(verifyThrottlePrefix + u.ID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		jsonMessage(w, http.StatusTooManyRequests, "too many emails, try again later")
+		return
+	}
+
+	err = h.UsersRepo.SetEmail(u.ID, email)
+	if err == user.ErrEmailExists {
+		jsonMessage(w, http.StatusConflict, "email is already taken")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	u.Email = email
+
+	// the links sent to the previous email must not verify the new one
+	err = h.Verifications.RevokeForUser(u.ID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	h.sendVerificationLink(r, u)
+	jsonMessage(w, http.StatusAccepted, "a verification link has been sent to the email")
+}
+
+// sendVerificationLink creates a verification token of the user's email and emails it, the failures are only logged,
+// the user asks for another link setting the email again. The token is bound to the email it's sent to
+func (h *UsersHandler) sendVerificationLink(r *http.Request, u *user.User) {
+	token, err := h.Verifications.Create(u.ID, u.Email, time.Now().Add(h.VerifyTTL))
+	if err != nil {
+		h.logger(r).Errorf(`Can't create verification token. %s`, err.Error())
+		return
+	}
+	err = h.Mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Follow the link to verify your email, it's valid for %s:\n\n%s\n\n"+
+			"If you didn't sign up, ignore this email.\n",
+			u.Username, h.VerifyTTL, tokenLink(h.VerifyURL, token)),
+	})
+	if err != nil {
+		h.logger(r).Errorf(`Can't send verification link. %s`, err.Error())
+	}
+}
+
+// VerifyEmail marks the email of the user verified with the emailed token
+func (h *UsersHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	form := &verifyEmailForm{}
+	err := json.NewDecoder(r.Body).Decode(form)
+	if err != nil || form.Token == "" {
+		jsonMessage(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	userID, email, err := h.Verifications.Take(form.Token)
+	if err == onetime.ErrNoToken {
+		jsonMessage(w, http.StatusBadRequest, "invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	// the email replaced after the link was sent isn't verified, even if the replacement raced the link
+	err = h.UsersRepo.VerifyEmail(userID, email)
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusBadRequest, "invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	jsonMessage(w, http.StatusOK, "email verified")
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/mail"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/onetime"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+)
+
+func TestHandlerSetEmail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	verifications := NewMockOneTimeTokensInterface(ctrl)
+	accounts := NewMockLoginThrottleInterface(ctrl)
+	mailer := mail.NewMemoryMailer()
+	service := UsersHandler{
+		Logger:          zap.NewNop().Sugar(),
+		UsersRepo:       userRepo,
+		AccountThrottle: accounts,
+		Verifications:   verifications,
+		Mailer:          mailer,
+		VerifyTTL:       24 * time.Hour,
+		VerifyURL:       "https://example.com/verify",
+	}
+
+	sess := &session.Session{ID: "current_id", UserID: "id_test"}
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/email", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, sess))
+		w := httptest.NewRecorder()
+		service.SetEmail(w, req)
+		return w
+	}
+
+	// the link is sent to the new email
+	userRepo.EXPECT().GetByID("id_test").Return(&user.User{ID: "id_test", Username: "login_test"}, nil)
+	accounts.EXPECT().Try("verify:id_test").Return(time.Duration(0), nil)
+	userRepo.EXPECT().SetEmail("id_test", "login@example.com").Return(nil)
+	verifications.EXPECT().RevokeForUser("id_test").Return(nil)
+	verifications.EXPECT().Create("id_test", "login@example.com", gomock.Any()).Return("verify_token", nil)
+	if w := send(`{"email":" login@example.com "}`); w.Code != http.StatusAccepted {
+		t.Errorf("expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	sent := mailer.Messages()
+	if len(sent) != 1 || sent[0].To != "login@example.com" ||
+		!strings.Contains(sent[0].Body, "https://example.com/verify?token=verify_token") {
+		t.Fatalf("bad messages %+v", sent)
+	}
+
+	// the verified email isn't sent again
+	verified := &user.User{ID: "id_test", Username: "login_test", Email: "login@example.com", EmailVerified: true}
+	userRepo.EXPECT().GetByID("id_test").Return(verified, nil)
+	if w := send(`{"email":"login@example.com"}`); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(&user.User{ID: "id_test", Username: "login_test"}, nil)
+	accounts.EXPECT().Try("verify:id_test").Return(time.Minute, nil)
+	w := send(`{"email":"login@example.com"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected status %d with Retry-After, got %d %v", http.StatusTooManyRequests, w.Code, w.Header())
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(&user.User{ID: "id_test", Username: "login_test"}, nil)
+	accounts.EXPECT().Try("verify:id_test").Return(time.Duration(0), nil)
+	userRepo.EXPECT().SetEmail("id_test", "taken@example.com").Return(user.ErrEmailExists)
+	if w := send(`{"email":"taken@example.com"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	for _, body := range []string{`{"email":"not an email"}`, `{}`, `not json`} {
+		if w := send(body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(nil, errors.New("db_error"))
+	if w := send(`{"email":"login@example.com"}`); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	if len(mailer.Messages()) != 1 {
+		t.Errorf("expected 1 message, got %+v", mailer.Messages())
+	}
+}
+
+func TestHandlerVerifyEmail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	verifications := NewMockOneTimeTokensInterface(ctrl)
+	service := UsersHandler{
+		Logger:        zap.NewNop().Sugar(),
+		UsersRepo:     userRepo,
+		Verifications: verifications,
+	}
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/email/verify", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		service.VerifyEmail(w, req)
+		return w
+	}
+
+	verifications.EXPECT().Take("verify_token").Return("id_test", "login@example.com", nil)
+	userRepo.EXPECT().VerifyEmail("id_test", "login@example.com").Return(nil)
+	if w := send(`{"token":"verify_token"}`); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	verifications.EXPECT().Take("verify_token").Return("", "", onetime.ErrNoToken)
+	if w := send(`{"token":"verify_token"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// the email was replaced after the link was sent
+	verifications.EXPECT().Take("verify_token").Return("id_test", "old@example.com", nil)
+	userRepo.EXPECT().VerifyEmail("id_test", "old@example.com").Return(user.ErrNoUser)
+	if w := send(`{"token":"verify_token"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	verifications.EXPECT().Take("verify_token").Return("", "", errors.New("db_error"))
+	if w := send(`{"token":"verify_token"}`); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	if w := send(`{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestHandlerRegisterSendsVerification(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	sessRepo := NewMockSessionsManagerInterface(ctrl)
+	tokens := NewMockTokensManagerInterface(ctrl)
+	verifications := NewMockOneTimeTokensInterface(ctrl)
+	mailer := mail.NewMemoryMailer()
+	service := UsersHandler{
+		Logger:        zap.NewNop().Sugar(),
+		UsersRepo:     userRepo,
+		Sessions:      sessRepo,
+		Tokens:        tokens,
+		Verifications: verifications,
+		Mailer:        mailer,
+		VerifyTTL:     24 * time.Hour,
+		VerifyURL:     "https://example.com/verify",
+	}
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/register", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		service.Register(w, req)
+		return w
+	}
+
+	registered := &user.User{ID: "id_test", Username: "login_test", Email: "login@example.com"}
+	userRepo.EXPECT().Register(&user.User{Username: "login_test", Password: "s3cure-enough", Email: "login@example.com"}).Return(registered, nil)
+	verifications.EXPECT().Create("id_test", "login@example.com", gomock.Any()).Return("verify_token", nil)
+	sessRepo.EXPECT().Create("id_test", gomock.Any()).Return(&session.Session{ID: "sess_id", UserID: "id_test"}, nil)
+	sessRepo.EXPECT().NewRefreshToken("sess_id").Return("refresh_token", nil)
+	tokens.EXPECT().IssueNewToken("id_test", "login_test", "sess_id").Return("access_token", nil)
+	if w := send(`{"username":"login_test","password":"s3cure-enough","email":" login@example.com"}`); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if sent := mailer.Messages(); len(sent) != 1 || sent[0].To != "login@example.com" {
+		t.Fatalf("bad messages %+v", sent)
+	}
+
+	userRepo.EXPECT().Register(gomock.Any()).Return(nil, user.ErrEmailExists)
+	w := send(`{"username":"login_test","password":"s3cure-enough","email":"login@example.com"}`)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), `"param":"email"`) {
+		t.Errorf("expected status %d, got %d %s", http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	}
+
+	w = send(`{"username":"login_test","password":"s3cure-enough","email":"nope"}`)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), `"param":"email"`) {
+		t.Errorf("expected status %d, got %d %s", http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CheckFunc reports whether a dependency like a database is available
+type CheckFunc func(context.Context) error
+
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	Logger *zap.SugaredLogger
+	// Checks are run on every readiness probe, the keys name the dependencies
+	Checks map[string]CheckFunc
+	// Timeout limits every check
+	Timeout time.Duration
+
+	draining int32
+}
+
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Drain makes the readiness probe fail so that no new requests are routed here during shutdown
+func (h *HealthHandler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Live reports that the process is up and serving
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, &healthStatus{Status: "ok"})
+}
+
+// Ready reports whether all the dependencies are available
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.draining) == 1 {
+		writeHealth(w, http.StatusServiceUnavailable, &healthStatus{Status: "draining"})
+		return
+	}
+
+	res := &healthStatus{
+		Status: "ok",
+		Checks: make(map[string]string, len(h.Checks)),
+	}
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for name, check := range h.Checks {
+		wg.Add(1)
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), h.Timeout)
+			defer cancel()
+			err := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				h.logger(r).Warnw("readiness check failed",
+					"check", name,
+					"error", err.Error(),
+				)
+				res.Status = "unavailable"
+				res.Checks[name] = err.Error()
+				return
+			}
+			res.Checks[name] = "ok"
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if res.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, res)
+}
+
+func writeHealth(w http.ResponseWriter, status int, res *healthStatus) {
+	result, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(result)
+}
+
+// logger returns the request-scoped logger
+func (h *HealthHandler) logger(r *http.Request) *zap.SugaredLogger {
+	return logging.FromContext(r.Context(), h.Logger)
+}
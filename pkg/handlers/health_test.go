@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestHealthLive(t *testing.T) {
+	h := &HealthHandler{Logger: zap.NewNop().Sugar()}
+
+	w := httptest.NewRecorder()
+	h.Live(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	body, _ := ioutil.ReadAll(w.Result().Body)
+	if w.Code != http.StatusOK || string(body) != `{"status":"ok"}` {
+		t.Errorf("bad response %d %s", w.Code, body)
+	}
+}
+
+func TestHealthReady(t *testing.T) {
+	mysqlErr := error(nil)
+	h := &HealthHandler{
+		Logger:  zap.NewNop().Sugar(),
+		Timeout: 50 * time.Millisecond,
+		Checks: map[string]CheckFunc{
+			"mysql": func(context.Context) error {
+				return mysqlErr
+			},
+			"mongo": func(ctx context.Context) error {
+				return nil
+			},
+		},
+	}
+
+	// all dependencies are up
+	w := httptest.NewRecorder()
+	h.Ready(w, httptest.NewRequest("GET", "/readyz", nil))
+	body, _ := ioutil.ReadAll(w.Result().Body)
+	if w.Code != http.StatusOK || string(body) != `{"status":"ok","checks":{"mongo":"ok","mysql":"ok"}}` {
+		t.Errorf("bad response %d %s", w.Code, body)
+	}
+
+	// a failing dependency
+	mysqlErr = errors.New("connection refused")
+	w = httptest.NewRecorder()
+	h.Ready(w, httptest.NewRequest("GET", "/readyz", nil))
+	body, _ = ioutil.ReadAll(w.Result().Body)
+	if w.Code != http.StatusServiceUnavailable ||
+		string(body) != `{"status":"unavailable","checks":{"mongo":"ok","mysql":"connection refused"}}` {
+		t.Errorf("bad response %d %s", w.Code, body)
+	}
+
+	// a hanging dependency is cut by the timeout
+	mysqlErr = nil
+	h.Checks["mongo"] = func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	w = httptest.NewRecorder()
+	h.Ready(w, httptest.NewRequest("GET", "/readyz", nil))
+	body, _ = ioutil.ReadAll(w.Result().Body)
+	if w.Code != http.StatusServiceUnavailable ||
+		string(body) != `{"status":"unavailable","checks":{"mongo":"context deadline exceeded","mysql":"ok"}}` {
+		t.Errorf("bad response %d %s", w.Code, body)
+	}
+
+	// no new traffic while shutting down
+	h.Drain()
+	w = httptest.NewRecorder()
+	h.Ready(w, httptest.NewRequest("GET", "/readyz", nil))
+	body, _ = ioutil.ReadAll(w.Result().Body)
+	if w.Code != http.StatusServiceUnavailable || string(body) != `{"status":"draining"}` {
+		t.Errorf("bad response %d %s", w.Code, body)
+	}
+}
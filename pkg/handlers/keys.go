@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ljwt"
+	"net/http"
+)
+
+// KeySetInterface provides the public keys verifying the tokens
+type KeySetInterface interface {
+	JWKS() ljwt.JWKSet
+}
+
+// KeysHandler publishes the public keys, so other services verify the tokens without the server secrets
+type KeysHandler struct {
+	Keys KeySetInterface
+}
+
+// JWKS returns the JSON Web Key Set of the keys which aren't retired
+func (h *KeysHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	result, _ := json.Marshal(h.Keys.JWKS())
+	w.Header().Set("Content-Type", "application/json")
+	// the verifiers refetch the set on an unknown kid anyway, so it may be cached for a while
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
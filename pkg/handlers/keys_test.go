@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ljwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerJWKS(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys := ljwt.NewKeyring()
+	keys.Add("hmac", []byte("my_super_secret_key"))
+	keys.AddPrivateKey("ed", edKey)
+	service := KeysHandler{Keys: ljwt.NewKeyringManager(keys, time.Minute)}
+
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+
+	service.JWKS(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("bad response %d %v", w.Code, w.Header())
+	}
+	set := ljwt.JWKSet{}
+	err := json.Unmarshal(w.Body.Bytes(), &set)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	// the HMAC secrets are never published
+	if len(set.Keys) != 1 || set.Keys[0].KeyID != "ed" || set.Keys[0].Algorithm != "EdDSA" {
+		t.Errorf("bad key set %s", w.Body.String())
+	}
+}
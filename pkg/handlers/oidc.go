@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ids"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/oidc"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// oidcStateCookie ties the callback to the browser which started the login
+const oidcStateCookie = "oidc_state"
+
+// maxUsernameAttempts limits the usernames tried for a new external user
+const maxUsernameAttempts = 5
+
+// OIDCProviderInterface is the OpenID Connect provider the users sign in with
+type OIDCProviderInterface interface {
+	AuthCodeURL(login oidc.Login) string
+	Exchange(ctx context.Context, code string, login oidc.Login) (*oidc.Identity, error)
+}
+
+// OIDCLoginsInterface keeps the logins started and not finished yet
+type OIDCLoginsInterface interface {
+	Save(login oidc.Login) error
+	Take(state string) (oidc.Login, error)
+}
+
+// IdentitiesRepoInterface describes the Users Repo methods for the external identities
+type IdentitiesRepoInterface interface {
+	GetByIdentity(user.Identity) (*user.User, error)
+	RegisterExternal(*user.User, user.Identity) (*user.User, error)
+}
+
+// OIDCHandler signs the users in with an OpenID Connect provider,
+// the accounts are created on the first sign in and get the usual session and tokens
+type OIDCHandler struct {
+	Logger    *zap.SugaredLogger
+	UsersRepo IdentitiesRepoInterface
+	Sessions  SessionsManagerInterface
+	Tokens    TokensManagerInterface
+	Provider  OIDCProviderInterface
+	Logins    OIDCLoginsInterface
+	// LoginTTL is the time the user has to sign in at the provider
+	LoginTTL time.Duration
+	// TwoFactor and TwoFactorLogins are the ones of the UsersHandler, the users who enabled it
+	// finish the sign in posting a code to its LoginTwoFactor; it's disabled when nil
+	TwoFactor       TwoFactorRepoInterface
+	TwoFactorLogins OneTimeTokensInterface
+	TwoFactorTTL    time.Duration
+}
+
+// Login redirects the user to the provider
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	login := oidc.NewLogin()
+	err := h.Logins.Save(login)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    login.State,
+		Path:     "/api/oidc",
+		MaxAge:   int(h.LoginTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax sends the cookie with the top-level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, h.Provider.AuthCodeURL(login), http.StatusFound)
+}
+
+// Callback finishes the login when the provider sends the user back with the code
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		jsonMessage(w, http.StatusBadRequest, "sign in wasn't started here, try again")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc", MaxAge: -1})
+
+	login, err := h.Logins.Take(state)
+	if err == oidc.ErrNoLogin {
+		jsonMessage(w, http.StatusBadRequest, "sign in expired, try again")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if providerErr := query.Get("error"); providerErr != "" {
+		h.logger(r).Warnw("Provider denied sign in", "error", providerErr, "description", query.Get("error_description"))
+		jsonMessage(w, http.StatusUnauthorized, "sign in failed")
+		return
+	}
+
+	identity, err := h.Provider.Exchange(r.Context(), query.Get("code"), login)
+	if err != nil {
+		h.logger(r).Warnw("Sign in failed", "err", err)
+		jsonMessage(w, http.StatusUnauthorized, "sign in failed")
+		return
+	}
+
+	u, err := h.user(identity)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	twoFactor, err := twoFactorEnabled(h.TwoFactor, u.ID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if twoFactor {
+		writeTwoFactorChallenge(w, h.logger(r), h.TwoFactorLogins, h.TwoFactorTTL, u, http.StatusOK, "")
+		return
+	}
+	startSession(w, r, h.logger(r), h.Sessions, h.Tokens, u)
+}
+
+// user returns the user linked to the identity, creating them on the first sign in.
+// The identities are never linked to the existing users by the username or email,
+// as anybody could register them at the provider
+func (h *OIDCHandler) user(identity *oidc.Identity) (*user.User, error) {
+	linked := user.Identity{Issuer: identity.Issuer, Subject: identity.Subject}
+	u, err := h.UsersRepo.GetByIdentity(linked)
+	if err != user.ErrNoUser {
+		return u, err
+	}
+
+	base := externalUsername(identity)
+	username := base
+	// the email verified by the provider counts as verified here
+	email := ""
+	if identity.EmailVerified {
+		email, _ = user.NormalizeEmail(identity.Email)
+	}
+	for i := 0; i < maxUsernameAttempts; i++ {
+		u, err = h.UsersRepo.RegisterExternal(&user.User{Username: username, Email: email, EmailVerified: email != ""}, linked)
+		switch err {
+		case user.ErrUserExists:
+			username = base + "-" + ids.GenerateID()[:4]
+			continue
+		case user.ErrEmailExists:
+			// the email belongs to another account, the user sets another one later
+			email = ""
+			continue
+		case user.ErrIdentityLinked:
+			// a concurrent callback has just created the user
+			return h.UsersRepo.GetByIdentity(linked)
+		}
+		return u, err
+	}
+	return nil, err
+}
+
+// externalUsername makes the username of a new user from their preferred username or email,
+// it follows the username rules leaving room for the suffix added on a collision
+func externalUsername(identity *oidc.Identity) string {
+	name := identity.PreferredUsername
+	if name == "" {
+		name = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		}
+		return -1
+	}, name)
+	name = strings.TrimLeft(name, "._-")
+	if max := user.MaxUsernameLength - len("-0000"); len(name) > max {
+		name = name[:max]
+	}
+	if user.ValidateUsername(name) != nil {
+		name = "user"
+	}
+	return name
+}
+
+// logger returns the request-scoped logger
+func (h *OIDCHandler) logger(r *http.Request) *zap.SugaredLogger {
+	return logging.FromContext(r.Context(), h.Logger)
+}
@@ -0,0 +1,170 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: oidc.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+	oidc "golang-stepik-2020q2/6/99_hw/redditclone/pkg/oidc"
+	user "golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	reflect "reflect"
+)
+
+// MockOIDCProviderInterface is a mock of OIDCProviderInterface interface
+type MockOIDCProviderInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockOIDCProviderInterfaceMockRecorder
+}
+
+// MockOIDCProviderInterfaceMockRecorder is the mock recorder for MockOIDCProviderInterface
+type MockOIDCProviderInterfaceMockRecorder struct {
+	mock *MockOIDCProviderInterface
+}
+
+// NewMockOIDCProviderInterface creates a new mock instance
+func NewMockOIDCProviderInterface(ctrl *gomock.Controller) *MockOIDCProviderInterface {
+	mock := &MockOIDCProviderInterface{ctrl: ctrl}
+	mock.recorder = &MockOIDCProviderInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockOIDCProviderInterface) EXPECT() *MockOIDCProviderInterfaceMockRecorder {
+	return m.recorder
+}
+
+// AuthCodeURL mocks base method
+func (m *MockOIDCProviderInterface) AuthCodeURL(login oidc.Login) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthCodeURL", login)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// AuthCodeURL indicates an expected call of AuthCodeURL
+func (mr *MockOIDCProviderInterfaceMockRecorder) AuthCodeURL(login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthCodeURL", reflect.TypeOf((*MockOIDCProviderInterface)(nil).AuthCodeURL), login)
+}
+
+// Exchange mocks base method
+func (m *MockOIDCProviderInterface) Exchange(ctx context.Context, code string, login oidc.Login) (*oidc.Identity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Exchange", ctx, code, login)
+	ret0, _ := ret[0].(*oidc.Identity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Exchange indicates an expected call of Exchange
+func (mr *MockOIDCProviderInterfaceMockRecorder) Exchange(ctx, code, login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exchange", reflect.TypeOf((*MockOIDCProviderInterface)(nil).Exchange), ctx, code, login)
+}
+
+// MockOIDCLoginsInterface is a mock of OIDCLoginsInterface interface
+type MockOIDCLoginsInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockOIDCLoginsInterfaceMockRecorder
+}
+
+// MockOIDCLoginsInterfaceMockRecorder is the mock recorder for MockOIDCLoginsInterface
+type MockOIDCLoginsInterfaceMockRecorder struct {
+	mock *MockOIDCLoginsInterface
+}
+
+// NewMockOIDCLoginsInterface creates a new mock instance
+func NewMockOIDCLoginsInterface(ctrl *gomock.Controller) *MockOIDCLoginsInterface {
+	mock := &MockOIDCLoginsInterface{ctrl: ctrl}
+	mock.recorder = &MockOIDCLoginsInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockOIDCLoginsInterface) EXPECT() *MockOIDCLoginsInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Save mocks base method
+func (m *MockOIDCLoginsInterface) Save(login oidc.Login) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Save", login)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Save indicates an expected call of Save
+func (mr *MockOIDCLoginsInterfaceMockRecorder) Save(login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockOIDCLoginsInterface)(nil).Save), login)
+}
+
+// Take mocks base method
+func (m *MockOIDCLoginsInterface) Take(state string) (oidc.Login, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Take", state)
+	ret0, _ := ret[0].(oidc.Login)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Take indicates an expected call of Take
+func (mr *MockOIDCLoginsInterfaceMockRecorder) Take(state interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Take", reflect.TypeOf((*MockOIDCLoginsInterface)(nil).Take), state)
+}
+
+// MockIdentitiesRepoInterface is a mock of IdentitiesRepoInterface interface
+type MockIdentitiesRepoInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdentitiesRepoInterfaceMockRecorder
+}
+
+// MockIdentitiesRepoInterfaceMockRecorder is the mock recorder for MockIdentitiesRepoInterface
+type MockIdentitiesRepoInterfaceMockRecorder struct {
+	mock *MockIdentitiesRepoInterface
+}
+
+// NewMockIdentitiesRepoInterface creates a new mock instance
+func NewMockIdentitiesRepoInterface(ctrl *gomock.Controller) *MockIdentitiesRepoInterface {
+	mock := &MockIdentitiesRepoInterface{ctrl: ctrl}
+	mock.recorder = &MockIdentitiesRepoInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockIdentitiesRepoInterface) EXPECT() *MockIdentitiesRepoInterfaceMockRecorder {
+	return m.recorder
+}
+
+// GetByIdentity mocks base method
+func (m *MockIdentitiesRepoInterface) GetByIdentity(arg0 user.Identity) (*user.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByIdentity", arg0)
+	ret0, _ := ret[0].(*user.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByIdentity indicates an expected call of GetByIdentity
+func (mr *MockIdentitiesRepoInterfaceMockRecorder) GetByIdentity(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByIdentity", reflect.TypeOf((*MockIdentitiesRepoInterface)(nil).GetByIdentity), arg0)
+}
+
+// RegisterExternal mocks base method
+func (m *MockIdentitiesRepoInterface) RegisterExternal(arg0 *user.User, arg1 user.Identity) (*user.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegisterExternal", arg0, arg1)
+	ret0, _ := ret[0].(*user.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RegisterExternal indicates an expected call of RegisterExternal
+func (mr *MockIdentitiesRepoInterfaceMockRecorder) RegisterExternal(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterExternal", reflect.TypeOf((*MockIdentitiesRepoInterface)(nil).RegisterExternal), arg0, arg1)
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"errors"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/oidc"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/twofactor"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+)
+
+func TestOIDCHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	usersRepo := NewMockIdentitiesRepoInterface(ctrl)
+	sessions := NewMockSessionsManagerInterface(ctrl)
+	tokens := NewMockTokensManagerInterface(ctrl)
+	provider := NewMockOIDCProviderInterface(ctrl)
+	logins := NewMockOIDCLoginsInterface(ctrl)
+	twoFactor := NewMockTwoFactorRepoInterface(ctrl)
+	twoFactorLogins := NewMockOneTimeTokensInterface(ctrl)
+	service := OIDCHandler{
+		Logger:          zap.NewNop().Sugar(),
+		UsersRepo:       usersRepo,
+		Sessions:        sessions,
+		Tokens:          tokens,
+		Provider:        provider,
+		Logins:          logins,
+		LoginTTL:        10 * time.Minute,
+		TwoFactor:       twoFactor,
+		TwoFactorLogins: twoFactorLogins,
+		TwoFactorTTL:    5 * time.Minute,
+	}
+
+	// login redirects to the provider and remembers the state in the browser
+	var started oidc.Login
+	logins.EXPECT().Save(gomock.Any()).DoAndReturn(func(login oidc.Login) error {
+		started = login
+		return nil
+	})
+	provider.EXPECT().AuthCodeURL(gomock.Any()).Return("https://idp.example.com/authorize?state=s")
+	w := httptest.NewRecorder()
+	service.Login(w, httptest.NewRequest("GET", "/api/oidc/login", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://idp.example.com/authorize?state=s" {
+		t.Errorf("bad redirect %d %s", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != started.State || !cookies[0].HttpOnly || started.State == "" {
+		t.Errorf("bad state cookie %v", cookies)
+	}
+
+	callback := func(state, cookie, params string) *http.Request {
+		req := httptest.NewRequest("GET", "/api/oidc/callback?state="+state+params, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: cookie})
+		}
+		return req
+	}
+	login := oidc.Login{State: "state", Verifier: "verifier", Nonce: "nonce"}
+	identity := &oidc.Identity{Issuer: "https://idp.example.com", Subject: "42", Email: "Jane Doe@example.com"}
+	linked := user.Identity{Issuer: "https://idp.example.com", Subject: "42"}
+	jane := &user.User{ID: "7", Username: "JaneDoe"}
+	sess := &session.Session{ID: "sess_id", UserID: "7"}
+	signedIn := func() {
+		twoFactor.EXPECT().Get("7").Return(nil, twofactor.ErrNotEnrolled)
+		sessions.EXPECT().Create("7", gomock.Any()).Return(sess, nil)
+		sessions.EXPECT().NewRefreshToken("sess_id").Return("refresh", nil)
+		tokens.EXPECT().IssueNewToken("7", "JaneDoe", "sess_id").Return("token", nil)
+	}
+
+	cases := []struct {
+		name   string
+		expect func()
+		req    *http.Request
+		status int
+		body   string
+	}{
+		{
+			name: "first sign in creates the user",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(identity, nil)
+				usersRepo.EXPECT().GetByIdentity(linked).Return(nil, user.ErrNoUser)
+				usersRepo.EXPECT().RegisterExternal(&user.User{Username: "JaneDoe"}, linked).Return(jane, nil)
+				signedIn()
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusOK,
+			body:   `{"token":"token","refreshToken":"refresh"}`,
+		},
+		{
+			name: "next sign in finds the user",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(identity, nil)
+				usersRepo.EXPECT().GetByIdentity(linked).Return(jane, nil)
+				signedIn()
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusOK,
+		},
+		{
+			name: "taken username gets a suffix",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(identity, nil)
+				usersRepo.EXPECT().GetByIdentity(linked).Return(nil, user.ErrNoUser)
+				usersRepo.EXPECT().RegisterExternal(&user.User{Username: "JaneDoe"}, linked).Return(nil, user.ErrUserExists)
+				usersRepo.EXPECT().RegisterExternal(gomock.Any(), linked).DoAndReturn(func(u *user.User, _ user.Identity) (*user.User, error) {
+					if !strings.HasPrefix(u.Username, "JaneDoe-") {
+						t.Errorf("bad username %s", u.Username)
+					}
+					return jane, nil
+				})
+				signedIn()
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusOK,
+		},
+		{
+			name: "verified email is kept unless taken",
+			expect: func() {
+				verified := &oidc.Identity{Issuer: identity.Issuer, Subject: "42", Email: "jane@example.com", EmailVerified: true, PreferredUsername: "JaneDoe"}
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(verified, nil)
+				usersRepo.EXPECT().GetByIdentity(linked).Return(nil, user.ErrNoUser)
+				withEmail := &user.User{Username: "JaneDoe", Email: "jane@example.com", EmailVerified: true}
+				usersRepo.EXPECT().RegisterExternal(withEmail, linked).Return(nil, user.ErrEmailExists)
+				usersRepo.EXPECT().RegisterExternal(&user.User{Username: "JaneDoe"}, linked).Return(jane, nil)
+				signedIn()
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusOK,
+		},
+		{
+			name: "two-factor user is asked for the code",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(identity, nil)
+				usersRepo.EXPECT().GetByIdentity(linked).Return(jane, nil)
+				twoFactor.EXPECT().Get("7").Return(&twofactor.Settings{UserID: "7", Enabled: true}, nil)
+				twoFactorLogins.EXPECT().Create("7", "", gomock.Any()).Return("2fa_token", nil)
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusOK,
+			body:   `{"twoFactorRequired":true,"twoFactorToken":"2fa_token"}`,
+		},
+		{
+			name: "enrolled user without two-factor signs in",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(identity, nil)
+				usersRepo.EXPECT().GetByIdentity(linked).Return(jane, nil)
+				twoFactor.EXPECT().Get("7").Return(&twofactor.Settings{UserID: "7"}, nil)
+				sessions.EXPECT().Create("7", gomock.Any()).Return(sess, nil)
+				sessions.EXPECT().NewRefreshToken("sess_id").Return("refresh", nil)
+				tokens.EXPECT().IssueNewToken("7", "JaneDoe", "sess_id").Return("token", nil)
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusOK,
+			body:   `{"token":"token","refreshToken":"refresh"}`,
+		},
+		{
+			name: "two-factor repo error",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(identity, nil)
+				usersRepo.EXPECT().GetByIdentity(linked).Return(jane, nil)
+				twoFactor.EXPECT().Get("7").Return(nil, errors.New("db_error"))
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "state of another browser",
+			expect: func() {},
+			req:    callback("state", "other", "&code=code"),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no state cookie",
+			expect: func() {},
+			req:    callback("state", "", "&code=code"),
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "expired login",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(oidc.Login{}, oidc.ErrNoLogin)
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "denied by the provider",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+			},
+			req:    callback("state", "state", "&error=access_denied"),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "bad id token",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(nil, oidc.ErrBadIDToken)
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "repo error",
+			expect: func() {
+				logins.EXPECT().Take("state").Return(login, nil)
+				provider.EXPECT().Exchange(gomock.Any(), "code", login).Return(identity, nil)
+				usersRepo.EXPECT().GetByIdentity(linked).Return(nil, errors.New("db_error"))
+			},
+			req:    callback("state", "state", "&code=code"),
+			status: http.StatusInternalServerError,
+		},
+	}
+	for _, c := range cases {
+		c.expect()
+		w := httptest.NewRecorder()
+		service.Callback(w, c.req)
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("[%s] bad body %s", c.name, w.Body.String())
+		}
+	}
+}
+
+func TestExternalUsername(t *testing.T) {
+	cases := []struct {
+		identity *oidc.Identity
+		username string
+	}{
+		{&oidc.Identity{PreferredUsername: "jane.doe"}, "jane.doe"},
+		{&oidc.Identity{Email: "Jane Doe@example.com"}, "JaneDoe"},
+		{&oidc.Identity{PreferredUsername: "__Jané"}, "Jan"},
+		{&oidc.Identity{PreferredUsername: strings.Repeat("a", 40)}, strings.Repeat("a", 27)},
+		{&oidc.Identity{PreferredUsername: "admin"}, "user"},
+		{&oidc.Identity{PreferredUsername: "jo"}, "user"},
+		{&oidc.Identity{}, "user"},
+	}
+	for _, c := range cases {
+		if username := externalUsername(c.identity); username != c.username {
+			t.Errorf("expected %q, got %q", c.username, username)
+		}
+	}
+}
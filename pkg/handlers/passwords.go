@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/mail"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/onetime"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// resetThrottlePrefix keeps the reset requests apart from the logins in the account throttle
+const resetThrottlePrefix = "reset:"
+
+// OneTimeTokensInterface keeps the single use tokens emailed to the users
+type OneTimeTokensInterface interface {
+	Create(userID, subject string, expires time.Time) (string, error)
+	Take(token string) (string, string, error)
+	RevokeForUser(userID string) error
+}
+
+type changePasswordForm struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type resetRequestForm struct {
+	Username string `json:"username"`
+}
+
+type resetForm struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// ChangePassword replaces the password of the current user and revokes their other sessions
+func (h *UsersHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	form := &changePasswordForm{}
+	err = json.NewDecoder(r.Body).Decode(form)
+	if err != nil || form.NewPassword == "" {
+		jsonMessage(w, http.StatusBadRequest, "current and new passwords are required")
+		return
+	}
+
+	u, err := h.UsersRepo.GetByID(sess.UserID)
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusUnauthorized, "user not found")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	err = user.ValidatePassword(form.NewPassword, u.Username)
+	if err != nil {
+		writeFieldErrors(w, user.ValidationError{{Field: "newPassword", Err: err}})
+		return
+	}
+
+	// the current password is guessed no faster than on login
+	ip := clientFromRequest(r).IP
+	wait, err := h.loginTry(accountKey(u.ID), ip)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		jsonMessage(w, http.StatusTooManyRequests, "too many attempts, try again later")
+		return
+	}
+
+	_, err = h.UsersRepo.Authorize(u.Username, form.CurrentPassword)
+	if err == user.ErrNoUser || err == user.ErrBadPass {
+		h.logger(r).Warnw("Password change failed", "username", u.Username, "err", err)
+		wait, err = h.loginFailed(accountKey(u.ID), ip)
+		if err != nil {
+			h.logger(r).Errorf(`Can't track failed login. %s`, err.Error())
+		}
+		if wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		jsonMessage(w, http.StatusForbidden, "current password is wrong")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	err = h.loginSucceeded(accountKey(u.ID), ip)
+	if err != nil {
+		h.logger(r).Errorf(`Can't reset failed logins. %s`, err.Error())
+	}
+
+	err = h.UsersRepo.SetPassword(u.ID, form.NewPassword)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	h.revokeResets(r, u.ID)
+
+	sessions, err := h.Sessions.ListForUser(u.ID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	for _, other := range sessions {
+		if other.ID == sess.ID {
+			continue
+		}
+		err = h.Sessions.Destroy(other.ID)
+		if err != nil {
+			h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+			http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	jsonMessage(w, http.StatusOK, "password changed")
+}
+
+// RequestPasswordReset emails a reset link to the account, the answer is the same
+// whether the account exists or not, so the accounts can't be enumerated
+func (h *UsersHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	form := &resetRequestForm{}
+	err := json.NewDecoder(r.Body).Decode(form)
+	if err != nil || form.Username == "" {
+		jsonMessage(w, http.StatusBadRequest, "username is required")
+		return
+	}
+
+	// the account is looked up and emailed after the answer, so it takes as long whether the account exists or not;
+	// the request context is canceled once answered, the sending keeps only its logger
+	ctx := logging.NewContext(context.Background(), h.logger(r), &logging.Entry{})
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		h.sendResetLink(ctx, form.Username)
+	}()
+	jsonMessage(w, http.StatusAccepted, "if the account has a verified email, a reset link has been sent to it")
+}
+
+// sendResetLink creates a reset token of the account and emails it, the failures are only logged
+// The emails are throttled per user id, so the variants of the username share the budget
+func (h *UsersHandler) sendResetLink(ctx context.Context, username string) {
+	logger := logging.FromContext(ctx, h.Logger)
+	u, err := h.UsersRepo.GetByUserName(username)
+	if err == user.ErrNoUser {
+		logger.Infow("Password reset of an unknown user", "username", username)
+		return
+	}
+	if err != nil {
+		logger.Errorf(`Can't reset password. %s`, err.Error())
+		return
+	}
+	if u.Email == "" {
+		logger.Infow("Password reset of a user without email", "username", username)
+		return
+	}
+	// an unverified address may belong to somebody else
+	if !u.EmailVerified {
+		logger.Infow("Password reset of a user with unverified email", "username", username)
+		return
+	}
+
+	wait, err := h.mailWait(resetThrottlePrefix + u.ID)
+	if err != nil {
+		logger.Errorf(`Can't track password resets. %s`, err.Error())
+		return
+	}
+	if wait > 0 {
+		logger.Warnw("Password reset throttled", "username", u.Username)
+		return
+	}
+
+	token, err := h.Resets.Create(u.ID, "", time.Now().Add(h.ResetTTL))
+	if err != nil {
+		logger.Errorf(`Can't create reset token. %s`, err.Error())
+		return
+	}
+	err = h.Mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your account. "+
+			"Follow the link to choose a new one, it's valid for %s:\n\n%s\n\n"+
+			"If it wasn't you, ignore this email and your password stays the same.\n",
+			u.Username, h.ResetTTL, tokenLink(h.ResetURL, token)),
+	})
+	if err != nil {
+		logger.Errorf(`Can't send reset link. %s`, err.Error())
+	}
+}
+
+// mailWait counts an email sent to the account and returns the wait before the next one can be sent,
+// the free emails are sent at once and the next ones get further apart, so the mailbox can't be flooded
+func (h *UsersHandler) mailWait(key string) (time.Duration, error) {
+	if h.AccountThrottle == nil {
+		return 0, nil
+	}
+	return h.AccountThrottle.Try(key)
+}
+
+// tokenLink appends the token to the page URL
+func tokenLink(page, token string) string {
+	sep := "?"
+	if strings.Contains(page, "?") {
+		sep = "&"
+	}
+	return page + sep + "token=" + url.QueryEscape(token)
+}
+
+// ResetPassword sets a new password with the emailed reset token and revokes all the sessions of the user
+func (h *UsersHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	form := &resetForm{}
+	err := json.NewDecoder(r.Body).Decode(form)
+	if err != nil || form.Token == "" || form.NewPassword == "" {
+		jsonMessage(w, http.StatusBadRequest, "token and new password are required")
+		return
+	}
+	// the user isn't known before the token is taken, so the password isn't checked against the username
+	err = user.ValidatePassword(form.NewPassword, "")
+	if err != nil {
+		writeFieldErrors(w, user.ValidationError{{Field: "newPassword", Err: err}})
+		return
+	}
+
+	userID, _, err := h.Resets.Take(form.Token)
+	if err == onetime.ErrNoToken {
+		jsonMessage(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	err = h.UsersRepo.SetPassword(userID, form.NewPassword)
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	h.revokeResets(r, userID)
+
+	err = h.Sessions.DestroyAllForUser(userID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	jsonMessage(w, http.StatusOK, "password changed")
+}
+
+// revokeResets makes the other reset links of the user useless once the password is changed
+func (h *UsersHandler) revokeResets(r *http.Request, userID string) {
+	if h.Resets == nil {
+		return
+	}
+	err := h.Resets.RevokeForUser(userID)
+	if err != nil {
+		h.logger(r).Errorf(`Can't revoke reset tokens. %s`, err.Error())
+	}
+}
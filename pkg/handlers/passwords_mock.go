@@ -0,0 +1,79 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: passwords.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+	time "time"
+)
+
+// MockOneTimeTokensInterface is a mock of OneTimeTokensInterface interface
+type MockOneTimeTokensInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockOneTimeTokensInterfaceMockRecorder
+}
+
+// MockOneTimeTokensInterfaceMockRecorder is the mock recorder for MockOneTimeTokensInterface
+type MockOneTimeTokensInterfaceMockRecorder struct {
+	mock *MockOneTimeTokensInterface
+}
+
+// NewMockOneTimeTokensInterface creates a new mock instance
+func NewMockOneTimeTokensInterface(ctrl *gomock.Controller) *MockOneTimeTokensInterface {
+	mock := &MockOneTimeTokensInterface{ctrl: ctrl}
+	mock.recorder = &MockOneTimeTokensInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockOneTimeTokensInterface) EXPECT() *MockOneTimeTokensInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method
+func (m *MockOneTimeTokensInterface) Create(userID, subject string, expires time.Time) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", userID, subject, expires)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create
+func (mr *MockOneTimeTokensInterfaceMockRecorder) Create(userID, subject, expires interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockOneTimeTokensInterface)(nil).Create), userID, subject, expires)
+}
+
+// Take mocks base method
+func (m *MockOneTimeTokensInterface) Take(token string) (string, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Take", token)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Take indicates an expected call of Take
+func (mr *MockOneTimeTokensInterfaceMockRecorder) Take(token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Take", reflect.TypeOf((*MockOneTimeTokensInterface)(nil).Take), token)
+}
+
+// RevokeForUser mocks base method
+func (m *MockOneTimeTokensInterface) RevokeForUser(userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeForUser", userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeForUser indicates an expected call of RevokeForUser
+func (mr *MockOneTimeTokensInterfaceMockRecorder) RevokeForUser(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeForUser", reflect.TypeOf((*MockOneTimeTokensInterface)(nil).RevokeForUser), userID)
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/mail"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/onetime"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+)
+
+func TestHandlerChangePassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sessRepo := NewMockSessionsManagerInterface(ctrl)
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	resets := NewMockOneTimeTokensInterface(ctrl)
+	accounts := NewMockLoginThrottleInterface(ctrl)
+	service := UsersHandler{
+		Logger:          zap.NewNop().Sugar(),
+		UsersRepo:       userRepo,
+		Sessions:        sessRepo,
+		Resets:          resets,
+		AccountThrottle: accounts,
+	}
+
+	current := &session.Session{ID: "current_id", UserID: "id_test"}
+	other := &session.Session{ID: "other_id", UserID: "id_test"}
+	u := &user.User{ID: "id_test", Username: "login_test"}
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/password", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, current))
+		w := httptest.NewRecorder()
+		service.ChangePassword(w, req)
+		return w
+	}
+	form := `{"currentPassword":"old_password","newPassword":"new_password"}`
+
+	// the other sessions are revoked
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	userRepo.EXPECT().Authorize("login_test", "old_password").Return(u, nil)
+	accounts.EXPECT().Reset("user:id_test").Return(nil)
+	userRepo.EXPECT().SetPassword("id_test", "new_password").Return(nil)
+	resets.EXPECT().RevokeForUser("id_test").Return(nil)
+	sessRepo.EXPECT().ListForUser("id_test").Return([]*session.Session{current, other}, nil)
+	sessRepo.EXPECT().Destroy("other_id").Return(nil)
+	if w := send(form); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// a wrong current password counts as a failed login
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	userRepo.EXPECT().Authorize("login_test", "old_password").Return(nil, user.ErrBadPass)
+	accounts.EXPECT().Wait("user:id_test").Return(time.Duration(0), nil)
+	if w := send(form); w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Minute, nil)
+	w := send(form)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected status %d with Retry-After, got %d %v", http.StatusTooManyRequests, w.Code, w.Header())
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	w = send(`{"currentPassword":"old_password","newPassword":"login_test1"}`)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), `"param":"newPassword"`) {
+		t.Errorf("expected status %d, got %d %s", http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	}
+
+	for _, body := range []string{`{"currentPassword":"old_password"}`, `not json`} {
+		if w := send(body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	userRepo.EXPECT().Authorize("login_test", "old_password").Return(u, nil)
+	accounts.EXPECT().Reset("user:id_test").Return(nil)
+	userRepo.EXPECT().SetPassword("id_test", "new_password").Return(errors.New("db_error"))
+	if w := send(form); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(nil, user.ErrNoUser)
+	if w := send(form); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestHandlerPasswordReset(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sessRepo := NewMockSessionsManagerInterface(ctrl)
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	resets := NewMockOneTimeTokensInterface(ctrl)
+	mailer := mail.NewMemoryMailer()
+	service := UsersHandler{
+		Logger:    zap.NewNop().Sugar(),
+		UsersRepo: userRepo,
+		Sessions:  sessRepo,
+		Resets:    resets,
+		Mailer:    mailer,
+		ResetTTL:  time.Hour,
+		ResetURL:  "https://example.com/reset",
+	}
+	// the email is sent after the answer
+	request := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/password/reset", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		service.RequestPasswordReset(w, req)
+		service.Wait()
+		return w
+	}
+	confirm := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/password/reset/confirm", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		service.ResetPassword(w, req)
+		return w
+	}
+
+	// the link is emailed
+	u := &user.User{ID: "id_test", Username: "login_test", Email: "login@example.com", EmailVerified: true}
+	userRepo.EXPECT().GetByUserName("login_test").Return(u, nil)
+	resets.EXPECT().Create("id_test", "", gomock.Any()).Return("reset_token", nil)
+	w := request(`{"username":"login_test"}`)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	answer := w.Body.String()
+	sent := mailer.Messages()
+	if len(sent) != 1 || sent[0].To != "login@example.com" ||
+		!strings.Contains(sent[0].Body, "https://example.com/reset?token=reset_token") {
+		t.Fatalf("bad messages %+v", sent)
+	}
+
+	// the unknown users and the users without a verified email get the same answer and no email
+	userRepo.EXPECT().GetByUserName("unknown").Return(nil, user.ErrNoUser)
+	userRepo.EXPECT().GetByUserName("no_email").Return(&user.User{ID: "2", Username: "no_email"}, nil)
+	userRepo.EXPECT().GetByUserName("unverified").Return(&user.User{ID: "3", Username: "unverified", Email: "unverified@example.com"}, nil)
+	for _, name := range []string{"unknown", "no_email", "unverified"} {
+		w = request(`{"username":"` + name + `"}`)
+		if w.Code != http.StatusAccepted || w.Body.String() != answer {
+			t.Errorf("expected status %d %s, got %d %s", http.StatusAccepted, answer, w.Code, w.Body.String())
+		}
+	}
+	if len(mailer.Messages()) != 1 {
+		t.Errorf("unexpected messages %+v", mailer.Messages())
+	}
+	if w = request(`{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// the token sets the password and revokes all the sessions
+	resets.EXPECT().Take("reset_token").Return("id_test", "", nil)
+	userRepo.EXPECT().SetPassword("id_test", "new_password").Return(nil)
+	resets.EXPECT().RevokeForUser("id_test").Return(nil)
+	sessRepo.EXPECT().DestroyAllForUser("id_test").Return(nil)
+	if w = confirm(`{"token":"reset_token","newPassword":"new_password"}`); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	resets.EXPECT().Take("reset_token").Return("", "", onetime.ErrNoToken)
+	if w = confirm(`{"token":"reset_token","newPassword":"new_password"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	resets.EXPECT().Take("reset_token").Return("", "", errors.New("db_error"))
+	if w = confirm(`{"token":"reset_token","newPassword":"new_password"}`); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	// a weak password doesn't use up the token
+	if w = confirm(`{"token":"reset_token","newPassword":"12345678"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	for _, body := range []string{`{"token":"reset_token"}`, `{"newPassword":"new_password"}`, `not json`} {
+		if w = confirm(body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestHandlerPasswordResetThrottle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	accounts := NewMockLoginThrottleInterface(ctrl)
+	service := UsersHandler{
+		Logger:          zap.NewNop().Sugar(),
+		UsersRepo:       userRepo,
+		AccountThrottle: accounts,
+	}
+
+	// the throttled request is answered the same but nothing is sent,
+	// the variants of the username share the budget of the user
+	u := &user.User{ID: "id_test", Username: "login_test", Email: "login@example.com", EmailVerified: true}
+	for _, username := range []string{"login_test", "LOGIN_TEST"} {
+		userRepo.EXPECT().GetByUserName(username).Return(u, nil)
+		accounts.EXPECT().Try("reset:id_test").Return(time.Minute, nil)
+		req := httptest.NewRequest("POST", "/api/password/reset", strings.NewReader(`{"username":"`+username+`"}`))
+		w := httptest.NewRecorder()
+		service.RequestPasswordReset(w, req)
+		service.Wait()
+		if w.Code != http.StatusAccepted {
+			t.Errorf("expected status %d, got %d", http.StatusAccepted, w.Code)
+		}
+	}
+
+	// the unknown users use up no budget
+	userRepo.EXPECT().GetByUserName("unknown").Return(nil, user.ErrNoUser)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/password/reset", strings.NewReader(`{"username":"unknown"}`))
+	service.RequestPasswordReset(w, req)
+	service.Wait()
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+}
+
+// blockingMailer holds the emails until released
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan mail.Message
+}
+
+func (m *blockingMailer) Send(msg mail.Message) error {
+	<-m.release
+	m.sent <- msg
+	return nil
+}
+
+func TestHandlerPasswordResetAnswersFirst(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	resets := NewMockOneTimeTokensInterface(ctrl)
+	mailer := &blockingMailer{release: make(chan struct{}), sent: make(chan mail.Message, 1)}
+	service := UsersHandler{
+		Logger:    zap.NewNop().Sugar(),
+		UsersRepo: userRepo,
+		Resets:    resets,
+		Mailer:    mailer,
+		ResetTTL:  time.Hour,
+		ResetURL:  "https://example.com/reset",
+	}
+
+	// the answer doesn't wait for the email, so it takes as long for the existing accounts
+	u := &user.User{ID: "id_test", Username: "login_test", Email: "login@example.com", EmailVerified: true}
+	userRepo.EXPECT().GetByUserName("login_test").Return(u, nil)
+	resets.EXPECT().Create("id_test", "", gomock.Any()).Return("reset_token", nil)
+	req := httptest.NewRequest("POST", "/api/password/reset", strings.NewReader(`{"username":"login_test"}`))
+	ctx, cancel := context.WithCancel(req.Context())
+	w := httptest.NewRecorder()
+	service.RequestPasswordReset(w, req.WithContext(ctx))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+
+	// the email is still sent once the request is over
+	cancel()
+	close(mailer.release)
+	service.Wait()
+	if msg := <-mailer.sent; msg.To != "login@example.com" {
+		t.Errorf("bad message %+v", msg)
+	}
+}
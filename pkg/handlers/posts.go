@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/posts"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/utils"
 	"io"
 	"net/http"
@@ -20,8 +22,8 @@ type PostsRepoInterface interface {
 	GetByAuthor(string) ([]*posts.Post, error)
 	Add(*posts.Post) (*posts.Post, error)
 	AddComment(string, *posts.Comment) (*posts.Post, error)
-	DeleteComment(string, string) (*posts.Post, error)
-	Delete(string, string) error
+	DeleteComment(string, string, posts.Actor) (*posts.Post, error)
+	Delete(string, posts.Actor) error
 	Vote(string, posts.Vote) (*posts.Post, error)
 	Unvote(string, string) (*posts.Post, error)
 }
@@ -104,7 +106,7 @@ func (h *PostsHandler) Add(w http.ResponseWriter, r *http.Request) {
 	newPost := &posts.Post{}
 	err := json.NewDecoder(r.Body).Decode(newPost)
 	if err != nil {
-		h.Logger.Errorf(`BadRequest. %s`, err.Error())
+		h.logger(r).Errorf(`BadRequest. %s`, err.Error())
 		jsonMessage := utils.GetJSONMessageAsString(err.Error())
 		http.Error(w, jsonMessage, http.StatusBadRequest)
 		return
@@ -112,19 +114,20 @@ func (h *PostsHandler) Add(w http.ResponseWriter, r *http.Request) {
 
 	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 
 	author, err := h.UsersRepo.GetByID(sess.UserID)
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. Could not find user. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. Could not find user. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 	author.PasswordHash = "" // a security measure :)
-	newPost.Author = *author
+	author.Email = ""
+	newPost.Author = posts.NewAuthor(author)
 
 	createdPost, err := h.PostsRepo.Add(newPost)
 	if err != nil {
@@ -142,17 +145,16 @@ func (h *PostsHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	sess, err := session.SessionFromContext(r.Context())
+	actor, err := h.actor(r)
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 
-	err = h.PostsRepo.Delete(id, sess.UserID)
+	err = h.PostsRepo.Delete(id, actor)
 	if err != nil {
-		jsonMessage := utils.GetJSONMessageAsString(err.Error())
-		http.Error(w, jsonMessage, http.StatusInternalServerError)
+		h.deletionError(w, r, err)
 		return
 	}
 	w.Header().Add("Content-Type", "application/json")
@@ -167,7 +169,7 @@ func (h *PostsHandler) Upvote(w http.ResponseWriter, r *http.Request) {
 
 	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
@@ -176,8 +178,7 @@ func (h *PostsHandler) Upvote(w http.ResponseWriter, r *http.Request) {
 
 	post, err := h.PostsRepo.Vote(id, *vote)
 	if err != nil {
-		jsonMessage := utils.GetJSONMessageAsString(err.Error())
-		http.Error(w, jsonMessage, http.StatusInternalServerError)
+		h.voteError(w, r, err)
 		return
 	}
 
@@ -193,15 +194,14 @@ func (h *PostsHandler) Unvote(w http.ResponseWriter, r *http.Request) {
 
 	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 
 	post, err := h.PostsRepo.Unvote(id, sess.UserID)
 	if err != nil {
-		jsonMessage := utils.GetJSONMessageAsString(err.Error())
-		http.Error(w, jsonMessage, http.StatusInternalServerError)
+		h.voteError(w, r, err)
 		return
 	}
 
@@ -217,7 +217,7 @@ func (h *PostsHandler) Downvote(w http.ResponseWriter, r *http.Request) {
 
 	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
@@ -225,8 +225,7 @@ func (h *PostsHandler) Downvote(w http.ResponseWriter, r *http.Request) {
 
 	post, err := h.PostsRepo.Vote(id, *vote)
 	if err != nil {
-		jsonMessage := utils.GetJSONMessageAsString(err.Error())
-		http.Error(w, jsonMessage, http.StatusInternalServerError)
+		h.voteError(w, r, err)
 		return
 	}
 
@@ -251,19 +250,20 @@ func (h *PostsHandler) AddComment(w http.ResponseWriter, r *http.Request) {
 
 	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 
 	author, err := h.UsersRepo.GetByID(sess.UserID)
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. Could not find user. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. Could not find user. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 	author.PasswordHash = "" // a security measure :)
-	newComment.Author = *author
+	author.Email = ""
+	newComment.Author = posts.NewAuthor(author)
 
 	post, err := h.PostsRepo.AddComment(postID, newComment)
 	if err != nil {
@@ -282,20 +282,66 @@ func (h *PostsHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 	postID := vars["id"]
 	commentID := vars["commentId"]
 
-	_, err := session.SessionFromContext(r.Context())
+	actor, err := h.actor(r)
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 
-	post, err := h.PostsRepo.DeleteComment(postID, commentID)
+	post, err := h.PostsRepo.DeleteComment(postID, commentID, actor)
 	if err != nil {
-		jsonMessage := utils.GetJSONMessageAsString(err.Error())
-		http.Error(w, jsonMessage, http.StatusInternalServerError)
+		h.deletionError(w, r, err)
 		return
 	}
 	w.Header().Add("Content-Type", "application/json")
 	result, _ := json.Marshal(post)
 	w.Write(result)
 }
+
+// actor returns the session user, the moderators may delete anyone's posts and comments
+func (h *PostsHandler) actor(r *http.Request) (posts.Actor, error) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		return posts.Actor{}, err
+	}
+	return posts.Actor{
+		UserID:    sess.UserID,
+		Moderator: user.RolesFromContext(r.Context()).Can(user.PermModerate),
+	}, nil
+}
+
+// deletionError answers the failed deletion of a post or comment
+func (h *PostsHandler) deletionError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case posts.ErrNoPost, posts.ErrNoComment:
+		status = http.StatusNotFound
+	case posts.ErrNotAuthor:
+		h.logger(r).Warnw("Deletion forbidden", "err", err)
+		status = http.StatusForbidden
+	}
+	jsonMessage := utils.GetJSONMessageAsString(err.Error())
+	http.Error(w, jsonMessage, status)
+}
+
+// voteError answers the failed vote, the votes failed under contention are retried by the client
+func (h *PostsHandler) voteError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case posts.ErrNoPost:
+		status = http.StatusNotFound
+	case posts.ErrConcurrentUpdate:
+		h.logger(r).Warnw("Vote conflicted", "err", err)
+		status = http.StatusConflict
+	default:
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+	}
+	jsonMessage := utils.GetJSONMessageAsString(err.Error())
+	http.Error(w, jsonMessage, status)
+}
+
+// logger returns the request-scoped logger
+func (h *PostsHandler) logger(r *http.Request) *zap.SugaredLogger {
+	return logging.FromContext(r.Context(), h.Logger)
+}
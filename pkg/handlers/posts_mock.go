@@ -124,22 +124,22 @@ func (mr *MockPostsRepoInterfaceMockRecorder) AddComment(arg0, arg1 interface{})
 }
 
 // DeleteComment mocks base method
-func (m *MockPostsRepoInterface) DeleteComment(arg0, arg1 string) (*posts.Post, error) {
+func (m *MockPostsRepoInterface) DeleteComment(arg0, arg1 string, arg2 posts.Actor) (*posts.Post, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteComment", arg0, arg1)
+	ret := m.ctrl.Call(m, "DeleteComment", arg0, arg1, arg2)
 	ret0, _ := ret[0].(*posts.Post)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // DeleteComment indicates an expected call of DeleteComment
-func (mr *MockPostsRepoInterfaceMockRecorder) DeleteComment(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockPostsRepoInterfaceMockRecorder) DeleteComment(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteComment", reflect.TypeOf((*MockPostsRepoInterface)(nil).DeleteComment), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteComment", reflect.TypeOf((*MockPostsRepoInterface)(nil).DeleteComment), arg0, arg1, arg2)
 }
 
 // Delete mocks base method
-func (m *MockPostsRepoInterface) Delete(arg0, arg1 string) error {
+func (m *MockPostsRepoInterface) Delete(arg0 string, arg1 posts.Actor) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Delete", arg0, arg1)
 	ret0, _ := ret[0].(error)
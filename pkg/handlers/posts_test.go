@@ -9,6 +9,7 @@ import (
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
@@ -46,7 +47,7 @@ func TestHandlerGet(t *testing.T) {
 		Type:     "text",
 		Title:    "title",
 		Text:     "text",
-		Author:   *resultUser,
+		Author:   posts.NewAuthor(resultUser),
 		Category: "music",
 		Votes: []posts.Vote{
 			{User: uid, Vote: 1},
@@ -54,7 +55,7 @@ func TestHandlerGet(t *testing.T) {
 		Comments: []posts.Comment{
 			{
 				Created: time.Now(),
-				Author:  *resultUser,
+				Author:  posts.NewAuthor(resultUser),
 				Body:    "comment_body",
 				ID:      cid,
 			},
@@ -259,7 +260,7 @@ func TestHandlerUpdate(t *testing.T) {
 		Type:     "text",
 		Title:    "title",
 		Text:     "text",
-		Author:   *resultUser,
+		Author:   posts.NewAuthor(resultUser),
 		Category: "programming",
 		Votes: []posts.Vote{
 			{User: uid, Vote: 1},
@@ -378,7 +379,7 @@ func TestHandlerUpdate(t *testing.T) {
 
 	///////////////////////////////////////////////
 	// delete post. positive one
-	postsRepo.EXPECT().Delete(pid, uid).Return(nil)
+	postsRepo.EXPECT().Delete(pid, posts.Actor{UserID: uid}).Return(nil)
 
 	req = httptest.NewRequest("POST", "/", bytes.NewReader(bts))
 	req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, &session.Session{
@@ -420,7 +421,7 @@ func TestHandlerUpdate(t *testing.T) {
 	}
 
 	// delete post. repo err
-	postsRepo.EXPECT().Delete(pid, uid).Return(errors.New("Error deleting a Post"))
+	postsRepo.EXPECT().Delete(pid, posts.Actor{UserID: uid}).Return(errors.New("Error deleting a Post"))
 
 	req = httptest.NewRequest("POST", "/", bytes.NewReader(bts))
 	req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, &session.Session{
@@ -449,7 +450,7 @@ func TestHandlerUpdate(t *testing.T) {
 	cbody := "commentbody"
 	comment := &posts.Comment{
 		// Created: time.Now(),
-		Author: *resultUser,
+		Author: posts.NewAuthor(resultUser),
 		Body:   cbody,
 		ID:     "", // cid,
 	}
@@ -583,7 +584,7 @@ func TestHandlerUpdate(t *testing.T) {
 	/////////////////////////////////////
 	resultPost.Comments = nil
 	// delete comment. good
-	postsRepo.EXPECT().DeleteComment(pid, cid).Return(resultPost, nil)
+	postsRepo.EXPECT().DeleteComment(pid, cid, posts.Actor{UserID: uid}).Return(resultPost, nil)
 
 	req = httptest.NewRequest("POST", "/", nil)
 	req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, &session.Session{
@@ -611,7 +612,7 @@ func TestHandlerUpdate(t *testing.T) {
 	}
 
 	// delete comment. repo delete err
-	postsRepo.EXPECT().DeleteComment(pid, cid).Return(nil, errors.New("DB error"))
+	postsRepo.EXPECT().DeleteComment(pid, cid, posts.Actor{UserID: uid}).Return(nil, errors.New("DB error"))
 
 	req = httptest.NewRequest("POST", "/", nil)
 	req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, &session.Session{
@@ -742,3 +743,186 @@ func TestHandlerUpdate(t *testing.T) {
 		return
 	}
 }
+
+func TestHandlerDeleteAuthorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	postsRepo := NewMockPostsRepoInterface(ctrl)
+	service := PostsHandler{
+		PostsRepo: postsRepo,
+		Logger:    zap.NewNop().Sugar(),
+	}
+
+	request := func(vars map[string]string, roles user.Roles) *http.Request {
+		req := httptest.NewRequest("DELETE", "/", nil)
+		ctx := context.WithValue(req.Context(), session.SessionKey, &session.Session{
+			ID:      "sessionid",
+			UserID:  "userid",
+			Expires: time.Now().Add(time.Hour),
+		})
+		ctx = context.WithValue(ctx, user.RolesKey, roles)
+		return mux.SetURLVars(req.WithContext(ctx), vars)
+	}
+	postVars := map[string]string{"id": "postid"}
+	commentVars := map[string]string{"id": "postid", "commentId": "commentid"}
+	plain := user.Roles{user.RoleUser}
+	moderator := user.Roles{user.RoleUser, user.RoleModerator}
+
+	cases := []struct {
+		name   string
+		expect func()
+		delete func(http.ResponseWriter, *http.Request)
+		vars   map[string]string
+		roles  user.Roles
+		status int
+	}{
+		{
+			name: "post of another user",
+			expect: func() {
+				postsRepo.EXPECT().Delete("postid", posts.Actor{UserID: "userid"}).Return(posts.ErrNotAuthor)
+			},
+			delete: service.Delete,
+			vars:   postVars,
+			roles:  plain,
+			status: http.StatusForbidden,
+		},
+		{
+			name: "post by moderator",
+			expect: func() {
+				postsRepo.EXPECT().Delete("postid", posts.Actor{UserID: "userid", Moderator: true}).Return(nil)
+			},
+			delete: service.Delete,
+			vars:   postVars,
+			roles:  moderator,
+			status: http.StatusOK,
+		},
+		{
+			name: "missing post",
+			expect: func() {
+				postsRepo.EXPECT().Delete("postid", posts.Actor{UserID: "userid"}).Return(posts.ErrNoPost)
+			},
+			delete: service.Delete,
+			vars:   postVars,
+			roles:  plain,
+			status: http.StatusNotFound,
+		},
+		{
+			name: "comment of another user",
+			expect: func() {
+				postsRepo.EXPECT().DeleteComment("postid", "commentid", posts.Actor{UserID: "userid"}).Return(nil, posts.ErrNotAuthor)
+			},
+			delete: service.DeleteComment,
+			vars:   commentVars,
+			roles:  plain,
+			status: http.StatusForbidden,
+		},
+		{
+			name: "comment by admin",
+			expect: func() {
+				postsRepo.EXPECT().DeleteComment("postid", "commentid", posts.Actor{UserID: "userid", Moderator: true}).Return(&posts.Post{}, nil)
+			},
+			delete: service.DeleteComment,
+			vars:   commentVars,
+			roles:  user.Roles{user.RoleUser, user.RoleAdmin},
+			status: http.StatusOK,
+		},
+		{
+			name: "missing comment",
+			expect: func() {
+				postsRepo.EXPECT().DeleteComment("postid", "commentid", posts.Actor{UserID: "userid"}).Return(nil, posts.ErrNoComment)
+			},
+			delete: service.DeleteComment,
+			vars:   commentVars,
+			roles:  plain,
+			status: http.StatusNotFound,
+		},
+	}
+	for _, c := range cases {
+		c.expect()
+		w := httptest.NewRecorder()
+		c.delete(w, request(c.vars, c.roles))
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+	}
+}
+
+func TestHandlerVoteErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	postsRepo := NewMockPostsRepoInterface(ctrl)
+	service := PostsHandler{
+		PostsRepo: postsRepo,
+		Logger:    zap.NewNop().Sugar(),
+	}
+
+	request := func() *http.Request {
+		req := httptest.NewRequest("POST", "/", nil)
+		ctx := context.WithValue(req.Context(), session.SessionKey, &session.Session{
+			ID:      "sessionid",
+			UserID:  "userid",
+			Expires: time.Now().Add(time.Hour),
+		})
+		return mux.SetURLVars(req.WithContext(ctx), map[string]string{"id": "postid"})
+	}
+	up := posts.Vote{User: "userid", Vote: 1}
+	down := posts.Vote{User: "userid", Vote: -1}
+
+	cases := []struct {
+		name   string
+		expect func()
+		vote   func(http.ResponseWriter, *http.Request)
+		status int
+	}{
+		{
+			name: "upvote under contention",
+			expect: func() {
+				postsRepo.EXPECT().Vote("postid", up).Return(nil, posts.ErrConcurrentUpdate)
+			},
+			vote:   service.Upvote,
+			status: http.StatusConflict,
+		},
+		{
+			name: "downvote under contention",
+			expect: func() {
+				postsRepo.EXPECT().Vote("postid", down).Return(nil, posts.ErrConcurrentUpdate)
+			},
+			vote:   service.Downvote,
+			status: http.StatusConflict,
+		},
+		{
+			name: "unvote under contention",
+			expect: func() {
+				postsRepo.EXPECT().Unvote("postid", "userid").Return(nil, posts.ErrConcurrentUpdate)
+			},
+			vote:   service.Unvote,
+			status: http.StatusConflict,
+		},
+		{
+			name: "missing post",
+			expect: func() {
+				postsRepo.EXPECT().Vote("postid", up).Return(nil, posts.ErrNoPost)
+			},
+			vote:   service.Upvote,
+			status: http.StatusNotFound,
+		},
+		{
+			name: "repo error",
+			expect: func() {
+				postsRepo.EXPECT().Unvote("postid", "userid").Return(nil, errors.New("db_error"))
+			},
+			vote:   service.Unvote,
+			status: http.StatusInternalServerError,
+		},
+	}
+	for _, c := range cases {
+		c.expect()
+		w := httptest.NewRecorder()
+		c.vote(w, request())
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+	}
+}
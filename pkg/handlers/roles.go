@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// RolesRepoInterface describes the Users Repo methods managing the roles
+type RolesRepoInterface interface {
+	GetByUserName(string) (*user.User, error)
+	Roles(userID string) (user.Roles, error)
+	GrantRole(userID string, role user.Role) error
+	RevokeRole(userID string, role user.Role) error
+}
+
+// RolesHandler lets the admins grant and revoke the roles of the users
+type RolesHandler struct {
+	Logger    *zap.SugaredLogger
+	UsersRepo RolesRepoInterface
+}
+
+// rolesView is a user with their roles
+type rolesView struct {
+	ID       string     `json:"id"`
+	Username string     `json:"username"`
+	Roles    user.Roles `json:"roles"`
+}
+
+// List returns the roles of the user
+func (h *RolesHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+	h.writeRoles(w, r, u)
+}
+
+// Grant grants the role to the user
+func (h *RolesHandler) Grant(w http.ResponseWriter, r *http.Request) {
+	role := user.Role(mux.Vars(r)["role"])
+	if !role.Grantable() {
+		jsonMessage(w, http.StatusBadRequest, "unknown role")
+		return
+	}
+	u, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.UsersRepo.GrantRole(u.ID, role)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	h.logger(r).Infow("Role granted", "targetUserId", u.ID, "role", role)
+	h.writeRoles(w, r, u)
+}
+
+// Revoke revokes the role from the user, the admins can't revoke their own admin role
+// so that there is always somebody left to manage the roles
+func (h *RolesHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	role := user.Role(mux.Vars(r)["role"])
+	if !role.Grantable() {
+		jsonMessage(w, http.StatusBadRequest, "unknown role")
+		return
+	}
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	u, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+	if u.ID == sess.UserID && role == user.RoleAdmin {
+		jsonMessage(w, http.StatusConflict, "can't revoke your own admin role")
+		return
+	}
+
+	err = h.UsersRepo.RevokeRole(u.ID, role)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	h.logger(r).Infow("Role revoked", "targetUserId", u.ID, "role", role)
+	h.writeRoles(w, r, u)
+}
+
+// user finds the user from the URL, it answers the request itself when it fails
+func (h *RolesHandler) user(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	u, err := h.UsersRepo.GetByUserName(mux.Vars(r)["username"])
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusNotFound, "user not found")
+		return nil, false
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return u, true
+}
+
+// writeRoles responds with the current roles of the user
+func (h *RolesHandler) writeRoles(w http.ResponseWriter, r *http.Request, u *user.User) {
+	roles, err := h.UsersRepo.Roles(u.ID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	result, _ := json.Marshal(rolesView{ID: u.ID, Username: u.Username, Roles: roles})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
+
+// logger returns the request-scoped logger
+func (h *RolesHandler) logger(r *http.Request) *zap.SugaredLogger {
+	return logging.FromContext(r.Context(), h.Logger)
+}
@@ -0,0 +1,92 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: roles.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	user "golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	reflect "reflect"
+)
+
+// MockRolesRepoInterface is a mock of RolesRepoInterface interface
+type MockRolesRepoInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockRolesRepoInterfaceMockRecorder
+}
+
+// MockRolesRepoInterfaceMockRecorder is the mock recorder for MockRolesRepoInterface
+type MockRolesRepoInterfaceMockRecorder struct {
+	mock *MockRolesRepoInterface
+}
+
+// NewMockRolesRepoInterface creates a new mock instance
+func NewMockRolesRepoInterface(ctrl *gomock.Controller) *MockRolesRepoInterface {
+	mock := &MockRolesRepoInterface{ctrl: ctrl}
+	mock.recorder = &MockRolesRepoInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockRolesRepoInterface) EXPECT() *MockRolesRepoInterfaceMockRecorder {
+	return m.recorder
+}
+
+// GetByUserName mocks base method
+func (m *MockRolesRepoInterface) GetByUserName(arg0 string) (*user.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByUserName", arg0)
+	ret0, _ := ret[0].(*user.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByUserName indicates an expected call of GetByUserName
+func (mr *MockRolesRepoInterfaceMockRecorder) GetByUserName(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByUserName", reflect.TypeOf((*MockRolesRepoInterface)(nil).GetByUserName), arg0)
+}
+
+// Roles mocks base method
+func (m *MockRolesRepoInterface) Roles(userID string) (user.Roles, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Roles", userID)
+	ret0, _ := ret[0].(user.Roles)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Roles indicates an expected call of Roles
+func (mr *MockRolesRepoInterfaceMockRecorder) Roles(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Roles", reflect.TypeOf((*MockRolesRepoInterface)(nil).Roles), userID)
+}
+
+// GrantRole mocks base method
+func (m *MockRolesRepoInterface) GrantRole(userID string, role user.Role) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GrantRole", userID, role)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GrantRole indicates an expected call of GrantRole
+func (mr *MockRolesRepoInterfaceMockRecorder) GrantRole(userID, role interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GrantRole", reflect.TypeOf((*MockRolesRepoInterface)(nil).GrantRole), userID, role)
+}
+
+// RevokeRole mocks base method
+func (m *MockRolesRepoInterface) RevokeRole(userID string, role user.Role) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRole", userID, role)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRole indicates an expected call of RevokeRole
+func (mr *MockRolesRepoInterfaceMockRecorder) RevokeRole(userID, role interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRole", reflect.TypeOf((*MockRolesRepoInterface)(nil).RevokeRole), userID, role)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func TestRolesHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	usersRepo := NewMockRolesRepoInterface(ctrl)
+	service := RolesHandler{
+		Logger:    zap.NewNop().Sugar(),
+		UsersRepo: usersRepo,
+	}
+	alice := &user.User{ID: "1", Username: "alice"}
+	admin := &user.User{ID: "2", Username: "admin", Admin: true}
+
+	request := func(username, role string) *http.Request {
+		req := httptest.NewRequest("PUT", "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, &session.Session{
+			ID:      "sess_id",
+			UserID:  admin.ID,
+			Expires: time.Now().Add(time.Hour),
+		}))
+		return mux.SetURLVars(req, map[string]string{"username": username, "role": role})
+	}
+
+	cases := []struct {
+		name   string
+		expect func()
+		handle func(http.ResponseWriter, *http.Request)
+		req    *http.Request
+		status int
+		body   string
+	}{
+		{
+			name: "list",
+			expect: func() {
+				usersRepo.EXPECT().GetByUserName("alice").Return(alice, nil)
+				usersRepo.EXPECT().Roles("1").Return(user.Roles{user.RoleUser}, nil)
+			},
+			handle: service.List,
+			req:    request("alice", ""),
+			status: http.StatusOK,
+			body:   `{"id":"1","username":"alice","roles":["user"]}`,
+		},
+		{
+			name: "grant",
+			expect: func() {
+				usersRepo.EXPECT().GetByUserName("alice").Return(alice, nil)
+				usersRepo.EXPECT().GrantRole("1", user.RoleModerator).Return(nil)
+				usersRepo.EXPECT().Roles("1").Return(user.Roles{user.RoleUser, user.RoleModerator}, nil)
+			},
+			handle: service.Grant,
+			req:    request("alice", "moderator"),
+			status: http.StatusOK,
+			body:   `{"id":"1","username":"alice","roles":["user","moderator"]}`,
+		},
+		{
+			name:   "grant implied role",
+			expect: func() {},
+			handle: service.Grant,
+			req:    request("alice", "user"),
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "grant to unknown user",
+			expect: func() {
+				usersRepo.EXPECT().GetByUserName("bob").Return(nil, user.ErrNoUser)
+			},
+			handle: service.Grant,
+			req:    request("bob", "admin"),
+			status: http.StatusNotFound,
+		},
+		{
+			name: "grant error",
+			expect: func() {
+				usersRepo.EXPECT().GetByUserName("alice").Return(alice, nil)
+				usersRepo.EXPECT().GrantRole("1", user.RoleAdmin).Return(errors.New("db_error"))
+			},
+			handle: service.Grant,
+			req:    request("alice", "admin"),
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "revoke",
+			expect: func() {
+				usersRepo.EXPECT().GetByUserName("alice").Return(alice, nil)
+				usersRepo.EXPECT().RevokeRole("1", user.RoleModerator).Return(nil)
+				usersRepo.EXPECT().Roles("1").Return(user.Roles{user.RoleUser}, nil)
+			},
+			handle: service.Revoke,
+			req:    request("alice", "moderator"),
+			status: http.StatusOK,
+		},
+		{
+			name: "revoke own admin role",
+			expect: func() {
+				usersRepo.EXPECT().GetByUserName("admin").Return(admin, nil)
+			},
+			handle: service.Revoke,
+			req:    request("admin", "admin"),
+			status: http.StatusConflict,
+		},
+		{
+			name:   "revoke unknown role",
+			expect: func() {},
+			handle: service.Revoke,
+			req:    request("alice", "root"),
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		c.expect()
+		w := httptest.NewRecorder()
+		c.handle(w, c.req)
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("[%s] bad body %s", c.name, w.Body.String())
+		}
+	}
+}
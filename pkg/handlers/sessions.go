@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sessionView is a session as shown to its user, the session id itself is never shown
+type sessionView struct {
+	ID        string    `json:"id"`
+	Current   bool      `json:"current"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Expires   time.Time `json:"expires"`
+	UserAgent string    `json:"userAgent"`
+	IP        string    `json:"ip"`
+}
+
+// ListSessions returns the active sessions of the current user
+func (h *UsersHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	current, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := h.Sessions.ListForUser(current.UserID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, sess := range sessions {
+		views = append(views, sessionView{
+			ID:        sess.PublicID(),
+			Current:   sess.ID == current.ID,
+			Created:   sess.Created,
+			LastSeen:  sess.LastSeen,
+			Expires:   sess.Expires,
+			UserAgent: sess.UserAgent,
+			IP:        sess.IP,
+		})
+	}
+
+	result, _ := json.Marshal(views)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
+
+// RevokeSession revokes a session of the current user by the id from the sessions list
+func (h *UsersHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	current, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := h.Sessions.ListForUser(current.UserID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	for _, sess := range sessions {
+		if sess.PublicID() != id {
+			continue
+		}
+		err = h.Sessions.Destroy(sess.ID)
+		if err != nil {
+			h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+			http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+			return
+		}
+		jsonMessage(w, http.StatusOK, "success")
+		return
+	}
+
+	jsonMessage(w, http.StatusNotFound, "session not found")
+}
+
+// RevokeAllSessions revokes all the sessions of the current user including the current one
+func (h *UsersHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	current, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Sessions.DestroyAllForUser(current.UserID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	jsonMessage(w, http.StatusOK, "success")
+}
+
+// clientFromRequest describes the client the session is created for
+func clientFromRequest(r *http.Request) session.Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return session.Client{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
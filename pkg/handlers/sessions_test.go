@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func TestHandlerSessions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sessRepo := NewMockSessionsManagerInterface(ctrl)
+	service := UsersHandler{
+		Logger:   zap.NewNop().Sugar(),
+		Sessions: sessRepo,
+	}
+
+	now := time.Now()
+	current := &session.Session{
+		ID: "current_id", UserID: "id_test", Expires: now.Add(time.Hour),
+		Created: now, LastSeen: now, UserAgent: "Firefox", IP: "10.0.0.1",
+	}
+	other := &session.Session{
+		ID: "other_id", UserID: "id_test", Expires: now.Add(time.Hour),
+		Created: now.Add(-time.Hour), LastSeen: now.Add(-time.Minute), UserAgent: "curl", IP: "10.0.0.2",
+	}
+	withSession := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), session.SessionKey, current))
+	}
+
+	// list
+	sessRepo.EXPECT().ListForUser("id_test").Return([]*session.Session{current, other}, nil)
+	req := withSession(httptest.NewRequest("GET", "/api/sessions", nil))
+	w := httptest.NewRecorder()
+
+	service.ListSessions(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	views := []sessionView{}
+	err := json.Unmarshal(w.Body.Bytes(), &views)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if len(views) != 2 || !views[0].Current || views[1].Current ||
+		views[1].ID != other.PublicID() || views[1].UserAgent != "curl" || views[1].IP != "10.0.0.2" {
+		t.Errorf("bad sessions %+v", views)
+	}
+	for _, id := range []string{current.ID, other.ID} {
+		if bytes.Contains(w.Body.Bytes(), []byte(id)) {
+			t.Errorf("session id %s is shown", id)
+		}
+	}
+
+	sessRepo.EXPECT().ListForUser("id_test").Return(nil, errors.New("db_error"))
+	req = withSession(httptest.NewRequest("GET", "/api/sessions", nil))
+	w = httptest.NewRecorder()
+	service.ListSessions(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	// revoke one
+	sessRepo.EXPECT().ListForUser("id_test").Return([]*session.Session{current, other}, nil)
+	sessRepo.EXPECT().Destroy(other.ID).Return(nil)
+	req = withSession(httptest.NewRequest("DELETE", "/api/sessions/"+other.PublicID(), nil))
+	req = mux.SetURLVars(req, map[string]string{"id": other.PublicID()})
+	w = httptest.NewRecorder()
+	service.RevokeSession(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// the sessions of other users can't be found
+	sessRepo.EXPECT().ListForUser("id_test").Return([]*session.Session{current}, nil)
+	req = withSession(httptest.NewRequest("DELETE", "/api/sessions/unknown", nil))
+	req = mux.SetURLVars(req, map[string]string{"id": "unknown"})
+	w = httptest.NewRecorder()
+	service.RevokeSession(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	// revoke all
+	sessRepo.EXPECT().DestroyAllForUser("id_test").Return(nil)
+	req = withSession(httptest.NewRequest("DELETE", "/api/sessions", nil))
+	w = httptest.NewRecorder()
+	service.RevokeAllSessions(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	sessRepo.EXPECT().DestroyAllForUser("id_test").Return(errors.New("db_error"))
+	req = withSession(httptest.NewRequest("DELETE", "/api/sessions", nil))
+	w = httptest.NewRecorder()
+	service.RevokeAllSessions(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	// no session
+	for _, handler := range []http.HandlerFunc{service.ListSessions, service.RevokeSession, service.RevokeAllSessions} {
+		w = httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/onetime"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/twofactor"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TwoFactorRepoInterface keeps the TOTP settings and the recovery codes of the users
+type TwoFactorRepoInterface interface {
+	Get(userID string) (*twofactor.Settings, error)
+	Enroll(userID, secret string) error
+	Enable(userID string, counter int64, recoveryCodes []string) error
+	UseCode(userID string, counter int64) error
+	UseRecoveryCode(userID, code string) error
+	Disable(userID string) error
+}
+
+type twoFactorCodeForm struct {
+	Code string `json:"code"`
+}
+
+type twoFactorLoginForm struct {
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+// twoFactorChallenge asks the client to finish the login posting a code with the token
+type twoFactorChallenge struct {
+	Message           string `json:"message,omitempty"`
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	TwoFactorToken    string `json:"twoFactorToken"`
+}
+
+type twoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesView struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// twoFactorEnabled reports whether the user finishes the login with a code
+func twoFactorEnabled(repo TwoFactorRepoInterface, userID string) (bool, error) {
+	if repo == nil {
+		return false, nil
+	}
+	settings, err := repo.Get(userID)
+	if err == twofactor.ErrNotEnrolled {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return settings.Enabled, nil
+}
+
+// writeTwoFactorChallenge responds with a new token of the second login step instead of the session
+func writeTwoFactorChallenge(w http.ResponseWriter, logger *zap.SugaredLogger, logins OneTimeTokensInterface, ttl time.Duration, u *user.User, status int, message string) {
+	token, err := logins.Create(u.ID, "", time.Now().Add(ttl))
+	if err != nil {
+		logger.Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	result, _ := json.Marshal(twoFactorChallenge{Message: message, TwoFactorRequired: true, TwoFactorToken: token})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(result)
+}
+
+// useSecondFactor checks the TOTP or recovery code of the user and uses it up, so it doesn't work again
+func (h *UsersHandler) useSecondFactor(settings *twofactor.Settings, code string) error {
+	counter, err := twofactor.Verify(settings.Secret, code, time.Now(), settings.LastCounter)
+	if err == nil {
+		return h.TwoFactor.UseCode(settings.UserID, counter)
+	}
+	if err != twofactor.ErrBadCode {
+		return err
+	}
+	return h.TwoFactor.UseRecoveryCode(settings.UserID, code)
+}
+
+// LoginTwoFactor finishes the login of a user with the two-factor authentication, the session is created
+// only here. A wrong code is throttled like a wrong password and answered with a new token to retry
+func (h *UsersHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	form := &twoFactorLoginForm{}
+	err := json.NewDecoder(r.Body).Decode(form)
+	if err != nil || form.Token == "" || form.Code == "" {
+		jsonMessage(w, http.StatusBadRequest, "token and code are required")
+		return
+	}
+
+	userID, _, err := h.TwoFactorLogins.Take(form.Token)
+	if err == onetime.ErrNoToken {
+		jsonMessage(w, http.StatusUnauthorized, "invalid or expired login, log in again")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	u, err := h.UsersRepo.GetByID(userID)
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusUnauthorized, "user not found")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	ip := clientFromRequest(r).IP
+	wait, err := h.loginTry(accountKey(u.ID), ip)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		writeTwoFactorChallenge(w, h.logger(r), h.TwoFactorLogins, h.TwoFactorTTL, u, http.StatusTooManyRequests, "too many login attempts, try again later")
+		return
+	}
+
+	settings, err := h.TwoFactor.Get(u.ID)
+	if err != nil && err != twofactor.ErrNotEnrolled {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	// the two-factor authentication disabled meanwhile has nothing to check
+	if err == nil && settings.Enabled {
+		err = h.useSecondFactor(settings, form.Code)
+		if err == twofactor.ErrBadCode {
+			h.logger(r).Warnw("Two-factor login failed", "username", u.Username)
+			wait, err = h.loginFailed(accountKey(u.ID), ip)
+			if err != nil {
+				h.logger(r).Errorf(`Can't track failed login. %s`, err.Error())
+			}
+			if wait > 0 {
+				setRetryAfter(w, wait)
+			}
+			writeTwoFactorChallenge(w, h.logger(r), h.TwoFactorLogins, h.TwoFactorTTL, u, http.StatusUnauthorized, "invalid code")
+			return
+		}
+		if err != nil {
+			h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+			http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = h.loginSucceeded(accountKey(u.ID), ip)
+	if err != nil {
+		h.logger(r).Errorf(`Can't reset failed logins. %s`, err.Error())
+	}
+
+	startSession(w, r, h.logger(r), h.Sessions, h.Tokens, u)
+}
+
+// EnrollTwoFactor creates a new TOTP secret of the current user and responds with its otpauth URI,
+// the secret is enabled by EnableTwoFactor with its first code
+func (h *UsersHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	u, err := h.UsersRepo.GetByID(sess.UserID)
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusUnauthorized, "user not found")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	secret := twofactor.NewSecret()
+	err = h.TwoFactor.Enroll(u.ID, secret)
+	if err == twofactor.ErrEnabled {
+		jsonMessage(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	result, _ := json.Marshal(twoFactorEnrollment{Secret: secret, URI: twofactor.URI(h.TwoFactorIssuer, u.Username, secret)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
+
+// EnableTwoFactor turns on the enrolled secret of the current user verified by its first code
+// and responds with the recovery codes, they are shown only this time
+func (h *UsersHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	form := &twoFactorCodeForm{}
+	err = json.NewDecoder(r.Body).Decode(form)
+	if err != nil || form.Code == "" {
+		jsonMessage(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	settings, err := h.TwoFactor.Get(sess.UserID)
+	if err == twofactor.ErrNotEnrolled {
+		jsonMessage(w, http.StatusConflict, "two-factor authentication isn't enrolled")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if settings.Enabled {
+		jsonMessage(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	codes := twofactor.NewRecoveryCodes()
+	counter, err := twofactor.Verify(settings.Secret, form.Code, time.Now(), settings.LastCounter)
+	if err == nil {
+		err = h.TwoFactor.Enable(settings.UserID, counter, codes)
+	}
+	if err == twofactor.ErrBadCode {
+		jsonMessage(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	result, _ := json.Marshal(recoveryCodesView{RecoveryCodes: codes})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
+
+// DisableTwoFactor turns off the two-factor authentication of the current user with a code or a recovery code,
+// so a stolen session can't turn it off. The codes are guessed no faster than on login
+func (h *UsersHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	form := &twoFactorCodeForm{}
+	err = json.NewDecoder(r.Body).Decode(form)
+	if err != nil {
+		jsonMessage(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	u, err := h.UsersRepo.GetByID(sess.UserID)
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusUnauthorized, "user not found")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := h.TwoFactor.Get(u.ID)
+	if err == twofactor.ErrNotEnrolled {
+		jsonMessage(w, http.StatusConflict, "two-factor authentication isn't enabled")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	// a pending enrollment is dropped without a code
+	if settings.Enabled {
+		ip := clientFromRequest(r).IP
+		wait, err := h.loginTry(accountKey(u.ID), ip)
+		if err != nil {
+			h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+			http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			setRetryAfter(w, wait)
+			jsonMessage(w, http.StatusTooManyRequests, "too many attempts, try again later")
+			return
+		}
+
+		err = h.useSecondFactor(settings, form.Code)
+		if err == twofactor.ErrBadCode {
+			h.logger(r).Warnw("Two-factor disabling failed", "username", u.Username)
+			wait, err = h.loginFailed(accountKey(u.ID), ip)
+			if err != nil {
+				h.logger(r).Errorf(`Can't track failed login. %s`, err.Error())
+			}
+			if wait > 0 {
+				setRetryAfter(w, wait)
+			}
+			jsonMessage(w, http.StatusForbidden, "invalid code")
+			return
+		}
+		if err != nil {
+			h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+			http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+			return
+		}
+		err = h.loginUndo(accountKey(u.ID), ip)
+		if err != nil {
+			h.logger(r).Errorf(`Can't track failed login. %s`, err.Error())
+		}
+	}
+
+	err = h.TwoFactor.Disable(u.ID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	jsonMessage(w, http.StatusOK, "two-factor authentication disabled")
+}
@@ -0,0 +1,119 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: twofactor.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	twofactor "golang-stepik-2020q2/6/99_hw/redditclone/pkg/twofactor"
+	reflect "reflect"
+)
+
+// MockTwoFactorRepoInterface is a mock of TwoFactorRepoInterface interface
+type MockTwoFactorRepoInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockTwoFactorRepoInterfaceMockRecorder
+}
+
+// MockTwoFactorRepoInterfaceMockRecorder is the mock recorder for MockTwoFactorRepoInterface
+type MockTwoFactorRepoInterfaceMockRecorder struct {
+	mock *MockTwoFactorRepoInterface
+}
+
+// NewMockTwoFactorRepoInterface creates a new mock instance
+func NewMockTwoFactorRepoInterface(ctrl *gomock.Controller) *MockTwoFactorRepoInterface {
+	mock := &MockTwoFactorRepoInterface{ctrl: ctrl}
+	mock.recorder = &MockTwoFactorRepoInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockTwoFactorRepoInterface) EXPECT() *MockTwoFactorRepoInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method
+func (m *MockTwoFactorRepoInterface) Get(userID string) (*twofactor.Settings, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", userID)
+	ret0, _ := ret[0].(*twofactor.Settings)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get
+func (mr *MockTwoFactorRepoInterfaceMockRecorder) Get(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockTwoFactorRepoInterface)(nil).Get), userID)
+}
+
+// Enroll mocks base method
+func (m *MockTwoFactorRepoInterface) Enroll(userID, secret string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enroll", userID, secret)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Enroll indicates an expected call of Enroll
+func (mr *MockTwoFactorRepoInterfaceMockRecorder) Enroll(userID, secret interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enroll", reflect.TypeOf((*MockTwoFactorRepoInterface)(nil).Enroll), userID, secret)
+}
+
+// Enable mocks base method
+func (m *MockTwoFactorRepoInterface) Enable(userID string, counter int64, recoveryCodes []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enable", userID, counter, recoveryCodes)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Enable indicates an expected call of Enable
+func (mr *MockTwoFactorRepoInterfaceMockRecorder) Enable(userID, counter, recoveryCodes interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enable", reflect.TypeOf((*MockTwoFactorRepoInterface)(nil).Enable), userID, counter, recoveryCodes)
+}
+
+// UseCode mocks base method
+func (m *MockTwoFactorRepoInterface) UseCode(userID string, counter int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseCode", userID, counter)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseCode indicates an expected call of UseCode
+func (mr *MockTwoFactorRepoInterfaceMockRecorder) UseCode(userID, counter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseCode", reflect.TypeOf((*MockTwoFactorRepoInterface)(nil).UseCode), userID, counter)
+}
+
+// UseRecoveryCode mocks base method
+func (m *MockTwoFactorRepoInterface) UseRecoveryCode(userID, code string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", userID, code)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode
+func (mr *MockTwoFactorRepoInterfaceMockRecorder) UseRecoveryCode(userID, code interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockTwoFactorRepoInterface)(nil).UseRecoveryCode), userID, code)
+}
+
+// Disable mocks base method
+func (m *MockTwoFactorRepoInterface) Disable(userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disable", userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disable indicates an expected call of Disable
+func (mr *MockTwoFactorRepoInterfaceMockRecorder) Disable(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disable", reflect.TypeOf((*MockTwoFactorRepoInterface)(nil).Disable), userID)
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/onetime"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/twofactor"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+)
+
+func TestHandlerTwoFactorLogin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sessRepo := NewMockSessionsManagerInterface(ctrl)
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	tokens := NewMockTokensManagerInterface(ctrl)
+	accounts := NewMockLoginThrottleInterface(ctrl)
+	twoFactor := NewMockTwoFactorRepoInterface(ctrl)
+	logins := NewMockOneTimeTokensInterface(ctrl)
+	service := UsersHandler{
+		Logger:          zap.NewNop().Sugar(),
+		UsersRepo:       userRepo,
+		Sessions:        sessRepo,
+		Tokens:          tokens,
+		AccountThrottle: accounts,
+		TwoFactor:       twoFactor,
+		TwoFactorLogins: logins,
+		TwoFactorTTL:    5 * time.Minute,
+	}
+	login := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username":"login_test","password":"password"}`))
+		w := httptest.NewRecorder()
+		service.Login(w, req)
+		return w
+	}
+	secondStep := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/login/2fa", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		service.LoginTwoFactor(w, req)
+		return w
+	}
+	challenge := func(w *httptest.ResponseRecorder) string {
+		got := &twoFactorChallenge{}
+		if err := json.Unmarshal(w.Body.Bytes(), got); err != nil || !got.TwoFactorRequired {
+			t.Errorf("bad challenge %s", w.Body.String())
+		}
+		return got.TwoFactorToken
+	}
+
+	u := &user.User{ID: "id_test", Username: "login_test"}
+	secret := twofactor.NewSecret()
+	settings := &twofactor.Settings{UserID: "id_test", Secret: secret, Enabled: true}
+	counter := twofactor.Counter(time.Now())
+	code, _ := twofactor.Code(secret, counter)
+
+	// the password gives no session, the attempt is taken back but the failed logins aren't reset yet
+	userRepo.EXPECT().GetByUserName("login_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	userRepo.EXPECT().Authorize("login_test", "password").Return(u, nil)
+	twoFactor.EXPECT().Get("id_test").Return(settings, nil)
+	accounts.EXPECT().Undo("user:id_test").Return(nil)
+	logins.EXPECT().Create("id_test", "", gomock.Any()).Return("login_token", nil)
+	w := login()
+	if w.Code != http.StatusOK || challenge(w) != "login_token" {
+		t.Errorf("expected challenge, got %d %s", w.Code, w.Body.String())
+	}
+
+	// a wrong code counts as a failed login and gets a new token
+	logins.EXPECT().Take("login_token").Return("id_test", "", nil)
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	twoFactor.EXPECT().Get("id_test").Return(settings, nil)
+	twoFactor.EXPECT().UseRecoveryCode("id_test", "000000").Return(twofactor.ErrBadCode)
+	accounts.EXPECT().Wait("user:id_test").Return(time.Duration(0), nil)
+	logins.EXPECT().Create("id_test", "", gomock.Any()).Return("next_token", nil)
+	w = secondStep(`{"token":"login_token","code":"000000"}`)
+	if w.Code != http.StatusUnauthorized || challenge(w) != "next_token" {
+		t.Errorf("expected challenge, got %d %s", w.Code, w.Body.String())
+	}
+
+	// the code starts the session
+	logins.EXPECT().Take("next_token").Return("id_test", "", nil)
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	twoFactor.EXPECT().Get("id_test").Return(settings, nil)
+	twoFactor.EXPECT().UseCode("id_test", counter).Return(nil)
+	accounts.EXPECT().Reset("user:id_test").Return(nil)
+	sessRepo.EXPECT().Create("id_test", gomock.Any()).Return(&session.Session{ID: "sess_id", UserID: "id_test"}, nil)
+	sessRepo.EXPECT().NewRefreshToken("sess_id").Return("refresh_token", nil)
+	tokens.EXPECT().IssueNewToken("id_test", "login_test", "sess_id").Return("access_token", nil)
+	w = secondStep(`{"token":"next_token","code":"` + code + `"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"token":"access_token"`) {
+		t.Errorf("expected tokens, got %d %s", w.Code, w.Body.String())
+	}
+
+	// a recovery code works as well
+	logins.EXPECT().Take("login_token").Return("id_test", "", nil)
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	twoFactor.EXPECT().Get("id_test").Return(settings, nil)
+	twoFactor.EXPECT().UseRecoveryCode("id_test", "aaaa-bbbb-cccc-dddd").Return(nil)
+	accounts.EXPECT().Reset("user:id_test").Return(nil)
+	sessRepo.EXPECT().Create("id_test", gomock.Any()).Return(&session.Session{ID: "sess_id", UserID: "id_test"}, nil)
+	sessRepo.EXPECT().NewRefreshToken("sess_id").Return("refresh_token", nil)
+	tokens.EXPECT().IssueNewToken("id_test", "login_test", "sess_id").Return("access_token", nil)
+	if w = secondStep(`{"token":"login_token","code":"aaaa-bbbb-cccc-dddd"}`); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	logins.EXPECT().Take("login_token").Return("id_test", "", nil)
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Minute, nil)
+	logins.EXPECT().Create("id_test", "", gomock.Any()).Return("next_token", nil)
+	w = secondStep(`{"token":"login_token","code":"000000"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" || challenge(w) != "next_token" {
+		t.Errorf("expected status %d with Retry-After, got %d %v", http.StatusTooManyRequests, w.Code, w.Header())
+	}
+
+	logins.EXPECT().Take("used_token").Return("", "", onetime.ErrNoToken)
+	if w = secondStep(`{"token":"used_token","code":"000000"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	if w = secondStep(`{"token":"login_token"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	userRepo.EXPECT().GetByUserName("login_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	userRepo.EXPECT().Authorize("login_test", "password").Return(u, nil)
+	twoFactor.EXPECT().Get("id_test").Return(nil, errors.New("db_error"))
+	if w = login(); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	// the users without the two-factor authentication get the session right away
+	userRepo.EXPECT().GetByUserName("login_test").Return(u, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	userRepo.EXPECT().Authorize("login_test", "password").Return(u, nil)
+	twoFactor.EXPECT().Get("id_test").Return(&twofactor.Settings{UserID: "id_test", Secret: secret}, nil)
+	accounts.EXPECT().Reset("user:id_test").Return(nil)
+	sessRepo.EXPECT().Create("id_test", gomock.Any()).Return(&session.Session{ID: "sess_id", UserID: "id_test"}, nil)
+	sessRepo.EXPECT().NewRefreshToken("sess_id").Return("refresh_token", nil)
+	tokens.EXPECT().IssueNewToken("id_test", "login_test", "sess_id").Return("access_token", nil)
+	if w = login(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"token":"access_token"`) {
+		t.Errorf("expected tokens, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandlerTwoFactorSettings(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := NewMockUsersRepoInterface(ctrl)
+	accounts := NewMockLoginThrottleInterface(ctrl)
+	twoFactor := NewMockTwoFactorRepoInterface(ctrl)
+	service := UsersHandler{
+		Logger:          zap.NewNop().Sugar(),
+		UsersRepo:       userRepo,
+		AccountThrottle: accounts,
+		TwoFactor:       twoFactor,
+		TwoFactorIssuer: "redditclone",
+	}
+	sess := &session.Session{ID: "sess_id", UserID: "id_test"}
+	send := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/2fa", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, sess))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	u := &user.User{ID: "id_test", Username: "login_test"}
+
+	// the enrollment returns the secret as an otpauth URI
+	var secret string
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	twoFactor.EXPECT().Enroll("id_test", gomock.Any()).DoAndReturn(func(userID, s string) error {
+		secret = s
+		return nil
+	})
+	w := send(service.EnrollTwoFactor, "")
+	enrollment := &twoFactorEnrollment{}
+	if err := json.Unmarshal(w.Body.Bytes(), enrollment); err != nil || w.Code != http.StatusOK ||
+		enrollment.Secret != secret || enrollment.URI != twofactor.URI("redditclone", "login_test", secret) {
+		t.Errorf("bad enrollment %d %s", w.Code, w.Body.String())
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	twoFactor.EXPECT().Enroll("id_test", gomock.Any()).Return(twofactor.ErrEnabled)
+	if w = send(service.EnrollTwoFactor, ""); w.Code != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	// the first code enables it and the recovery codes are shown
+	pending := &twofactor.Settings{UserID: "id_test", Secret: secret}
+	counter := twofactor.Counter(time.Now())
+	code, _ := twofactor.Code(secret, counter)
+	var stored []string
+	twoFactor.EXPECT().Get("id_test").Return(pending, nil)
+	twoFactor.EXPECT().Enable("id_test", counter, gomock.Any()).DoAndReturn(func(userID string, counter int64, codes []string) error {
+		stored = codes
+		return nil
+	})
+	w = send(service.EnableTwoFactor, `{"code":"`+code+`"}`)
+	shown := &recoveryCodesView{}
+	if err := json.Unmarshal(w.Body.Bytes(), shown); err != nil || w.Code != http.StatusOK ||
+		len(shown.RecoveryCodes) != twofactor.RecoveryCodes || strings.Join(shown.RecoveryCodes, ",") != strings.Join(stored, ",") {
+		t.Errorf("bad recovery codes %d %s", w.Code, w.Body.String())
+	}
+
+	twoFactor.EXPECT().Get("id_test").Return(pending, nil)
+	if w = send(service.EnableTwoFactor, `{"code":"000000"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	twoFactor.EXPECT().Get("id_test").Return(nil, twofactor.ErrNotEnrolled)
+	if w = send(service.EnableTwoFactor, `{"code":"`+code+`"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	if w = send(service.EnableTwoFactor, `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// disabling takes a code, so a stolen session can't do it
+	enabled := &twofactor.Settings{UserID: "id_test", Secret: secret, Enabled: true}
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	twoFactor.EXPECT().Get("id_test").Return(enabled, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	twoFactor.EXPECT().UseRecoveryCode("id_test", "000000").Return(twofactor.ErrBadCode)
+	accounts.EXPECT().Wait("user:id_test").Return(time.Duration(0), nil)
+	if w = send(service.DisableTwoFactor, `{"code":"000000"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	twoFactor.EXPECT().Get("id_test").Return(enabled, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Duration(0), nil)
+	twoFactor.EXPECT().UseCode("id_test", counter).Return(nil)
+	accounts.EXPECT().Undo("user:id_test").Return(nil)
+	twoFactor.EXPECT().Disable("id_test").Return(nil)
+	if w = send(service.DisableTwoFactor, `{"code":"`+code+`"}`); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	twoFactor.EXPECT().Get("id_test").Return(enabled, nil)
+	accounts.EXPECT().Try("user:id_test").Return(time.Minute, nil)
+	if w = send(service.DisableTwoFactor, `{"code":"`+code+`"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	userRepo.EXPECT().GetByID("id_test").Return(u, nil)
+	twoFactor.EXPECT().Get("id_test").Return(nil, twofactor.ErrNotEnrolled)
+	if w = send(service.DisableTwoFactor, `{"code":"`+code+`"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+}
@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
-	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ljwt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/mail"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/utils"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -18,11 +23,32 @@ type UsersRepoInterface interface {
 	GetByUserName(string) (*user.User, error)
 	Register(*user.User) (*user.User, error)
 	Authorize(string, string) (*user.User, error)
+	SetPassword(userID, password string) error
+	SetEmail(userID, email string) error
+	VerifyEmail(userID, email string) error
 }
 
 // SessionsManagerInterface partially defines interface of the SM
 type SessionsManagerInterface interface {
-	Create(string) (*session.Session, error)
+	Create(string, session.Client) (*session.Session, error)
+	NewRefreshToken(sessionID string) (string, error)
+	Refresh(refreshToken string) (*session.Session, string, error)
+	Destroy(sessionID string) error
+	DestroyAllForUser(userID string) error
+	ListForUser(userID string) ([]*session.Session, error)
+}
+
+// TokensManagerInterface issues the tokens returned on login
+type TokensManagerInterface interface {
+	IssueNewToken(userID, username, sessionID string) (string, error)
+}
+
+// LoginThrottleInterface tracks the failed logins by a key, e.g. the account or the client IP
+type LoginThrottleInterface interface {
+	Wait(key string) (time.Duration, error)
+	Try(key string) (time.Duration, error)
+	Undo(key string) error
+	Reset(key string) error
 }
 
 // UsersHandler struct contains necessary attributes to handle Users
@@ -31,6 +57,30 @@ type UsersHandler struct {
 	Logger    *zap.SugaredLogger
 	UsersRepo UsersRepoInterface
 	Sessions  SessionsManagerInterface
+	Tokens    TokensManagerInterface
+	// AccountThrottle and IPThrottle slow down the password guessing, they are disabled when nil
+	AccountThrottle LoginThrottleInterface
+	IPThrottle      LoginThrottleInterface
+	// Resets and Mailer deliver the password reset links valid for ResetTTL,
+	// the link is ResetURL with the token appended
+	Resets   OneTimeTokensInterface
+	Mailer   mail.Mailer
+	ResetTTL time.Duration
+	ResetURL string
+	// Verifications deliver the email verification links the same way
+	Verifications OneTimeTokensInterface
+	VerifyTTL     time.Duration
+	VerifyURL     string
+	// TwoFactor keeps the TOTP settings, the users who enabled it finish the login with a code
+	// posted with a token of TwoFactorLogins valid for TwoFactorTTL; it's disabled when nil
+	TwoFactor       TwoFactorRepoInterface
+	TwoFactorLogins OneTimeTokensInterface
+	TwoFactorTTL    time.Duration
+	// TwoFactorIssuer names the app in the authenticator apps
+	TwoFactorIssuer string
+
+	// background tracks the emails sent after the answer
+	background sync.WaitGroup
 }
 
 type loginForm struct {
@@ -38,9 +88,33 @@ type loginForm struct {
 	Password string `json:"password"`
 }
 
+type registerForm struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+// fieldError is an invalid field of the request body as the front end app shows it: "<param> <msg>"
+type fieldError struct {
+	Location string `json:"location"`
+	Param    string `json:"param"`
+	Value    string `json:"value,omitempty"`
+	Msg      string `json:"msg"`
+}
+
+type fieldErrors struct {
+	Errors []fieldError `json:"errors"`
+}
+
+type refreshForm struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
 // RetObj is the object expected by the front end app and being returned
 type RetObj struct {
 	Token string `json:"token"`
+	// RefreshToken gets the next token when the short-lived one expires
+	RefreshToken string `json:"refreshToken,omitempty"`
 }
 
 // Login takes care about loggin a User in
@@ -50,75 +124,378 @@ func (h *UsersHandler) Login(w http.ResponseWriter, r *http.Request) {
 	lf := &loginForm{}
 	err = json.Unmarshal(request, lf)
 	if err != nil {
-		h.Logger.Errorf(`BadRequest. %s`, err.Error())
+		h.logger(r).Errorf(`BadRequest. %s`, err.Error())
 		http.Error(w, `BadRequest. Can't parse request body'`, http.StatusBadRequest)
 		return
 	}
 
+	account, err := h.loginAccount(lf.Login)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	ip := clientFromRequest(r).IP
+	wait, err := h.loginTry(account, ip)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		jsonMessage(w, http.StatusTooManyRequests, "too many login attempts, try again later")
+		return
+	}
+
 	uAuth, err := h.UsersRepo.Authorize(lf.Login, lf.Password)
+	if err == user.ErrNoUser || err == user.ErrBadPass {
+		// the same answer for both, so the accounts can't be enumerated
+		h.logger(r).Warnw("Login failed", "username", lf.Login, "err", err)
+		wait, err = h.loginFailed(account, ip)
+		if err != nil {
+			h.logger(r).Errorf(`Can't track failed login. %s`, err.Error())
+		}
+		if wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		jsonMessage(w, http.StatusUnauthorized, "invalid username or password")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	// the failed logins are kept till the second factor succeeds, so the password doesn't reset the code guessing
+	twoFactor, err := twoFactorEnabled(h.TwoFactor, uAuth.ID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+	if twoFactor {
+		err = h.loginUndo(account, ip)
+		if err != nil {
+			h.logger(r).Errorf(`Can't track failed login. %s`, err.Error())
+		}
+		writeTwoFactorChallenge(w, h.logger(r), h.TwoFactorLogins, h.TwoFactorTTL, uAuth, http.StatusOK, "")
+		return
+	}
+
+	err = h.loginSucceeded(accountKey(uAuth.ID), ip)
+	if err != nil {
+		h.logger(r).Errorf(`Can't reset failed logins. %s`, err.Error())
+	}
+
+	startSession(w, r, h.logger(r), h.Sessions, h.Tokens, uAuth)
+	// http.Redirect(w, r, "/", 302)
+}
+
+// startSession creates a new session of the user and responds with its tokens
+func startSession(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, sessions SessionsManagerInterface, tokens TokensManagerInterface, u *user.User) {
+	sess, err := sessions.Create(u.ID, clientFromRequest(r))
+	if err != nil {
+		logger.Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, err := sessions.NewRefreshToken(sess.ID)
+	if err != nil {
+		logger.Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	writeTokens(w, logger, tokens, u, sess, refreshToken)
+}
+
+// loginTry counts the login attempt to the account as failed before the password is checked,
+// so the parallel attempts can't all pass, and returns how long the client waits when it isn't allowed yet.
+// The attempt waiting isn't counted by any throttle
+func (h *UsersHandler) loginTry(account, ip string) (time.Duration, error) {
+	var wait time.Duration
+	counted := []throttleKey{}
+	for _, tk := range h.throttles(account, ip) {
+		w, err := tk.throttle.Try(tk.key)
+		if err != nil {
+			undoAttempts(counted)
+			return 0, err
+		}
+		if w > wait {
+			wait = w
+		}
+		if w == 0 {
+			counted = append(counted, tk)
+		}
+	}
+	if wait > 0 {
+		return wait, undoAttempts(counted)
+	}
+	return 0, nil
+}
+
+// loginFailed keeps the failed attempt counted and returns the wait before the next one
+func (h *UsersHandler) loginFailed(account, ip string) (time.Duration, error) {
+	var wait time.Duration
+	var waitErr error
+	for _, tk := range h.throttles(account, ip) {
+		w, err := tk.throttle.Wait(tk.key)
+		if err != nil {
+			waitErr = err
+			continue
+		}
+		if w > wait {
+			wait = w
+		}
+	}
+	return wait, waitErr
+}
+
+// loginSucceeded resets the failures of the account and takes the attempt back from the IP
+func (h *UsersHandler) loginSucceeded(account, ip string) error {
+	var err error
+	if h.AccountThrottle != nil {
+		err = h.AccountThrottle.Reset(account)
+	}
+	if h.IPThrottle != nil {
+		if undoErr := h.IPThrottle.Undo(ip); undoErr != nil {
+			err = undoErr
+		}
+	}
+	return err
+}
+
+// loginUndo takes the attempt back, e.g. the right password still waiting for the second factor
+func (h *UsersHandler) loginUndo(account, ip string) error {
+	return undoAttempts(h.throttles(account, ip))
+}
+
+// undoAttempts takes back the attempts counted by the throttles, the last error is returned
+func undoAttempts(counted []throttleKey) error {
+	var undoErr error
+	for _, tk := range counted {
+		if err := tk.throttle.Undo(tk.key); err != nil {
+			undoErr = err
+		}
+	}
+	return undoErr
+}
+
+// loginAccount returns the account throttle key of the login. The known users are keyed by their id,
+// so every spelling of the username the storage matches, e.g. in case or accents, shares one budget
+func (h *UsersHandler) loginAccount(login string) (string, error) {
+	if h.AccountThrottle == nil {
+		return "", nil
+	}
+	u, err := h.UsersRepo.GetByUserName(login)
+	if err == user.ErrNoUser {
+		return unknownAccountKey(login), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return accountKey(u.ID), nil
+}
+
+// accountKey is the account throttle key of the user
+func accountKey(userID string) string {
+	return "user:" + userID
+}
+
+// unknownAccountKey is the account throttle key of a login matching no user,
+// the variants of the login in case and surrounding spaces share one budget
+func unknownAccountKey(login string) string {
+	return "login:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+type throttleKey struct {
+	throttle LoginThrottleInterface
+	key      string
+}
+
+// throttles lists the enabled throttles with their keys, the IP only takes the successful attempt back
+// and doesn't reset as an attacker could reset it logging into their own account
+func (h *UsersHandler) throttles(account, ip string) []throttleKey {
+	throttles := []throttleKey{}
+	if h.AccountThrottle != nil {
+		throttles = append(throttles, throttleKey{h.AccountThrottle, account})
+	}
+	if h.IPThrottle != nil {
+		throttles = append(throttles, throttleKey{h.IPThrottle, ip})
+	}
+	return throttles
+}
+
+// setRetryAfter tells the client the wait in whole seconds rounded up
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
+// Refresh exchanges the refresh token for a new access token and the next refresh token
+func (h *UsersHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	rf := &refreshForm{}
+	err := json.NewDecoder(r.Body).Decode(rf)
+	if err != nil || rf.RefreshToken == "" {
+		jsonMessage(w, http.StatusBadRequest, "refresh token is required")
+		return
+	}
+
+	sess, refreshToken, err := h.Sessions.Refresh(rf.RefreshToken)
+	if err == session.ErrRefreshReused {
+		h.logger(r).Warnw("Refresh token reused, the session is revoked")
+		jsonMessage(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+	if err == session.ErrNoAuth {
+		jsonMessage(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	u, err := h.UsersRepo.GetByID(sess.UserID)
 	if err == user.ErrNoUser {
-		h.Logger.Errorf(`BadRequest. %s`, err.Error())
-		jsonMessage(w, http.StatusBadRequest, "user not found")
+		jsonMessage(w, http.StatusUnauthorized, "user not found")
 		return
 	}
-	if err == user.ErrBadPass {
-		h.Logger.Errorf(`BadRequest. %s`, err.Error())
-		jsonMessage(w, http.StatusBadRequest, "invalid password")
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
+
+	writeTokens(w, h.logger(r), h.Tokens, u, sess, refreshToken)
+}
+
+// writeTokens issues a new access token and responds with it and the refresh token
+func writeTokens(w http.ResponseWriter, logger *zap.SugaredLogger, tokens TokensManagerInterface, u *user.User, sess *session.Session, refreshToken string) {
+	tokenString, err := tokens.IssueNewToken(u.ID, u.Username, sess.ID)
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		logger.Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 
-	// Session
-	sess, err := h.Sessions.Create(uAuth.ID)
+	result, _ := json.Marshal(RetObj{Token: tokenString, RefreshToken: refreshToken})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
+
+// Me returns the current user with their roles, the scripts check their personal tokens with it
+func (h *UsersHandler) Me(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
-		h.Logger.Errorf(`InternalServerError. %s`, err.Error())
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
 		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
 
-	// generate a JWT token
-	tokenString, err := ljwt.IssueNewToken(uAuth.ID, uAuth.Username, sess.ID)
+	u, err := h.UsersRepo.GetByID(sess.UserID)
+	if err == user.ErrNoUser {
+		jsonMessage(w, http.StatusUnauthorized, "user not found")
+		return
+	}
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
 
-	result, _ := json.Marshal(RetObj{Token: tokenString})
+	result, _ := json.Marshal(rolesView{ID: u.ID, Username: u.Username, Roles: user.RolesFromContext(r.Context())})
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(result)
-	// http.Redirect(w, r, "/", 302)
 }
 
-// Register creates a new User in the repository
+// Register creates a new User in the repository and logs them in, the invalid fields are listed in the answer
 func (h *UsersHandler) Register(w http.ResponseWriter, r *http.Request) {
-	var u user.User
-
-	err := json.NewDecoder(r.Body).Decode(&u)
+	form := &registerForm{}
+	err := json.NewDecoder(r.Body).Decode(form)
 	if err != nil {
 		jsonMessage := utils.GetJSONMessageAsString("couldn't parse user's information")
 		http.Error(w, jsonMessage, http.StatusBadRequest)
 		return
 	}
 
-	_, err = h.UsersRepo.Register(&u)
+	u := &user.User{Username: form.Username, Password: form.Password, Email: form.Email}
+	err = user.ValidateRegistration(u)
+	if errs, ok := err.(user.ValidationError); ok {
+		writeFieldErrors(w, errs)
+		return
+	}
+
+	registered, err := h.UsersRepo.Register(u)
+	if err == user.ErrUserExists {
+		writeFieldErrors(w, user.ValidationError{{Field: "username", Value: u.Username, Err: user.ErrAlreadyTaken}})
+		return
+	}
+	if err == user.ErrEmailExists {
+		writeFieldErrors(w, user.ValidationError{{Field: "email", Value: u.Email, Err: user.ErrAlreadyTaken}})
+		return
+	}
 	if err != nil {
-		jsonMessage := utils.GetJSONMessageAsString(err.Error())
-		http.Error(w, jsonMessage, http.StatusInternalServerError)
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 		return
 	}
+	if registered.Email != "" {
+		h.sendVerificationLink(r, registered)
+	}
 
-	http.Redirect(w, r, "/api/login", 307)
+	startSession(w, r, h.logger(r), h.Sessions, h.Tokens, registered)
 }
 
-// Logout destroys User's credentials
+// Logout destroys User's credentials, the current session is revoked with its refresh tokens
 func (h *UsersHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	// h.Sessions.DestroyCurrent(w, r)
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Sessions.Destroy(sess.ID)
+	if err != nil {
+		h.logger(r).Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return
+	}
+
 	http.Redirect(w, r, "/", 302)
 }
 
+// writeFieldErrors responds with the invalid fields of the request body
+func writeFieldErrors(w http.ResponseWriter, errs user.ValidationError) {
+	result := fieldErrors{Errors: make([]fieldError, 0, len(errs))}
+	for _, fe := range errs {
+		result.Errors = append(result.Errors, fieldError{Location: "body", Param: fe.Field, Value: fe.Value, Msg: fe.Err.Error()})
+	}
+	body, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	w.Write(body)
+}
+
 func jsonMessage(w http.ResponseWriter, status int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	w.Write([]byte(`{"message":"` + message + `"}`))
 }
+
+// logger returns the request-scoped logger
+func (h *UsersHandler) logger(r *http.Request) *zap.SugaredLogger {
+	return logging.FromContext(r.Context(), h.Logger)
+}
+
+// Wait waits for the emails still being sent after the answers, e.g. on shutdown
+func (h *UsersHandler) Wait() {
+	h.background.Wait()
+}
@@ -9,6 +9,7 @@ import (
 	session "golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
 	user "golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
 	reflect "reflect"
+	time "time"
 )
 
 // MockUsersRepoInterface is a mock of UsersRepoInterface interface
@@ -94,6 +95,48 @@ func (mr *MockUsersRepoInterfaceMockRecorder) Authorize(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Authorize", reflect.TypeOf((*MockUsersRepoInterface)(nil).Authorize), arg0, arg1)
 }
 
+// SetPassword mocks base method
+func (m *MockUsersRepoInterface) SetPassword(userID, password string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPassword", userID, password)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPassword indicates an expected call of SetPassword
+func (mr *MockUsersRepoInterfaceMockRecorder) SetPassword(userID, password interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPassword", reflect.TypeOf((*MockUsersRepoInterface)(nil).SetPassword), userID, password)
+}
+
+// SetEmail mocks base method
+func (m *MockUsersRepoInterface) SetEmail(userID, email string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEmail", userID, email)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEmail indicates an expected call of SetEmail
+func (mr *MockUsersRepoInterfaceMockRecorder) SetEmail(userID, email interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEmail", reflect.TypeOf((*MockUsersRepoInterface)(nil).SetEmail), userID, email)
+}
+
+// VerifyEmail mocks base method
+func (m *MockUsersRepoInterface) VerifyEmail(userID, email string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyEmail", userID, email)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VerifyEmail indicates an expected call of VerifyEmail
+func (mr *MockUsersRepoInterfaceMockRecorder) VerifyEmail(userID, email interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyEmail", reflect.TypeOf((*MockUsersRepoInterface)(nil).VerifyEmail), userID, email)
+}
+
 // MockSessionsManagerInterface is a mock of SessionsManagerInterface interface
 type MockSessionsManagerInterface struct {
 	ctrl     *gomock.Controller
@@ -118,16 +161,209 @@ func (m *MockSessionsManagerInterface) EXPECT() *MockSessionsManagerInterfaceMoc
 }
 
 // Create mocks base method
-func (m *MockSessionsManagerInterface) Create(arg0 string) (*session.Session, error) {
+func (m *MockSessionsManagerInterface) Create(arg0 string, arg1 session.Client) (*session.Session, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Create", arg0)
+	ret := m.ctrl.Call(m, "Create", arg0, arg1)
 	ret0, _ := ret[0].(*session.Session)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Create indicates an expected call of Create
-func (mr *MockSessionsManagerInterfaceMockRecorder) Create(arg0 interface{}) *gomock.Call {
+func (mr *MockSessionsManagerInterfaceMockRecorder) Create(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSessionsManagerInterface)(nil).Create), arg0, arg1)
+}
+
+// NewRefreshToken mocks base method
+func (m *MockSessionsManagerInterface) NewRefreshToken(sessionID string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewRefreshToken", sessionID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NewRefreshToken indicates an expected call of NewRefreshToken
+func (mr *MockSessionsManagerInterfaceMockRecorder) NewRefreshToken(sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewRefreshToken", reflect.TypeOf((*MockSessionsManagerInterface)(nil).NewRefreshToken), sessionID)
+}
+
+// Refresh mocks base method
+func (m *MockSessionsManagerInterface) Refresh(refreshToken string) (*session.Session, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Refresh", refreshToken)
+	ret0, _ := ret[0].(*session.Session)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Refresh indicates an expected call of Refresh
+func (mr *MockSessionsManagerInterfaceMockRecorder) Refresh(refreshToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Refresh", reflect.TypeOf((*MockSessionsManagerInterface)(nil).Refresh), refreshToken)
+}
+
+// Destroy mocks base method
+func (m *MockSessionsManagerInterface) Destroy(sessionID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Destroy", sessionID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Destroy indicates an expected call of Destroy
+func (mr *MockSessionsManagerInterfaceMockRecorder) Destroy(sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Destroy", reflect.TypeOf((*MockSessionsManagerInterface)(nil).Destroy), sessionID)
+}
+
+// DestroyAllForUser mocks base method
+func (m *MockSessionsManagerInterface) DestroyAllForUser(userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DestroyAllForUser", userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DestroyAllForUser indicates an expected call of DestroyAllForUser
+func (mr *MockSessionsManagerInterfaceMockRecorder) DestroyAllForUser(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DestroyAllForUser", reflect.TypeOf((*MockSessionsManagerInterface)(nil).DestroyAllForUser), userID)
+}
+
+// ListForUser mocks base method
+func (m *MockSessionsManagerInterface) ListForUser(userID string) ([]*session.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListForUser", userID)
+	ret0, _ := ret[0].([]*session.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListForUser indicates an expected call of ListForUser
+func (mr *MockSessionsManagerInterfaceMockRecorder) ListForUser(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListForUser", reflect.TypeOf((*MockSessionsManagerInterface)(nil).ListForUser), userID)
+}
+
+// MockTokensManagerInterface is a mock of TokensManagerInterface interface
+type MockTokensManagerInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockTokensManagerInterfaceMockRecorder
+}
+
+// MockTokensManagerInterfaceMockRecorder is the mock recorder for MockTokensManagerInterface
+type MockTokensManagerInterfaceMockRecorder struct {
+	mock *MockTokensManagerInterface
+}
+
+// NewMockTokensManagerInterface creates a new mock instance
+func NewMockTokensManagerInterface(ctrl *gomock.Controller) *MockTokensManagerInterface {
+	mock := &MockTokensManagerInterface{ctrl: ctrl}
+	mock.recorder = &MockTokensManagerInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockTokensManagerInterface) EXPECT() *MockTokensManagerInterfaceMockRecorder {
+	return m.recorder
+}
+
+// IssueNewToken mocks base method
+func (m *MockTokensManagerInterface) IssueNewToken(userID, username, sessionID string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IssueNewToken", userID, username, sessionID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IssueNewToken indicates an expected call of IssueNewToken
+func (mr *MockTokensManagerInterfaceMockRecorder) IssueNewToken(userID, username, sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IssueNewToken", reflect.TypeOf((*MockTokensManagerInterface)(nil).IssueNewToken), userID, username, sessionID)
+}
+
+// MockLoginThrottleInterface is a mock of LoginThrottleInterface interface
+type MockLoginThrottleInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockLoginThrottleInterfaceMockRecorder
+}
+
+// MockLoginThrottleInterfaceMockRecorder is the mock recorder for MockLoginThrottleInterface
+type MockLoginThrottleInterfaceMockRecorder struct {
+	mock *MockLoginThrottleInterface
+}
+
+// NewMockLoginThrottleInterface creates a new mock instance
+func NewMockLoginThrottleInterface(ctrl *gomock.Controller) *MockLoginThrottleInterface {
+	mock := &MockLoginThrottleInterface{ctrl: ctrl}
+	mock.recorder = &MockLoginThrottleInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockLoginThrottleInterface) EXPECT() *MockLoginThrottleInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Wait mocks base method
+func (m *MockLoginThrottleInterface) Wait(key string) (time.Duration, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Wait", key)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Wait indicates an expected call of Wait
+func (mr *MockLoginThrottleInterfaceMockRecorder) Wait(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Wait", reflect.TypeOf((*MockLoginThrottleInterface)(nil).Wait), key)
+}
+
+// Try mocks base method
+func (m *MockLoginThrottleInterface) Try(key string) (time.Duration, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Try", key)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Try indicates an expected call of Try
+func (mr *MockLoginThrottleInterfaceMockRecorder) Try(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Try", reflect.TypeOf((*MockLoginThrottleInterface)(nil).Try), key)
+}
+
+// Undo mocks base method
+func (m *MockLoginThrottleInterface) Undo(key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Undo", key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Undo indicates an expected call of Undo
+func (mr *MockLoginThrottleInterfaceMockRecorder) Undo(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Undo", reflect.TypeOf((*MockLoginThrottleInterface)(nil).Undo), key)
+}
+
+// Reset mocks base method
+func (m *MockLoginThrottleInterface) Reset(key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reset", key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Reset indicates an expected call of Reset
+func (mr *MockLoginThrottleInterfaceMockRecorder) Reset(key interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSessionsManagerInterface)(nil).Create), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reset", reflect.TypeOf((*MockLoginThrottleInterface)(nil).Reset), key)
 }
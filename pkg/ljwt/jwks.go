@@ -0,0 +1,87 @@
+package ljwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N and E are the RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are the Ed25519 curve name and public key
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet is served at /.well-known/jwks.json for the services verifying the tokens
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys verifying the tokens, the HMAC keys are never published
+func (m *Manager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	ids, keys := m.Keys.PublicKeys()
+	for i, publicKey := range keys {
+		switch publicKey := publicKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     ids[i],
+				Use:       "sig",
+				Algorithm: "RS256",
+				N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     ids[i],
+				Use:       "sig",
+				Algorithm: SigningMethodEdDSA.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+	return set
+}
+
+// PublicKey decodes the key and returns it with the signing method it verifies
+func (k *JWK) PublicKey() (jwt.SigningMethod, crypto.PublicKey, error) {
+	switch {
+	case k.KeyType == "RSA" && (k.Algorithm == "" || k.Algorithm == "RS256"):
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad modulus of key %q: %s", k.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad exponent of key %q: %s", k.KeyID, err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, nil, fmt.Errorf("bad rsa key %q", k.KeyID)
+		}
+		return jwt.SigningMethodRS256, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case k.KeyType == "OKP" && k.Curve == "Ed25519" && (k.Algorithm == "" || k.Algorithm == SigningMethodEdDSA.Alg()):
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, nil, fmt.Errorf("bad ed25519 key %q", k.KeyID)
+		}
+		return SigningMethodEdDSA, ed25519.PublicKey(x), nil
+	}
+	return nil, nil, fmt.Errorf("unsupported key %q of type %s", k.KeyID, k.KeyType)
+}
@@ -0,0 +1,198 @@
+package ljwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// LegacyKeyID identifies the single key configured before the keyring,
+// the tokens without the kid header are verified with it
+const LegacyKeyID = "default"
+
+var (
+	// ErrUnknownKey is returned for a key id missing in the keyring
+	ErrUnknownKey = errors.New("Unknown signing key")
+	// ErrKeyRetired is returned for a retired key, it can neither sign nor verify
+	ErrKeyRetired = errors.New("Signing key is retired")
+	// ErrNoActiveKey is returned when there is no key to sign the new tokens with
+	ErrNoActiveKey = errors.New("No active signing key")
+	// ErrUnsupportedKey is returned for the private keys other than RSA and Ed25519
+	ErrUnsupportedKey = errors.New("Unsupported private key type")
+)
+
+// Keyring holds the keys signing and verifying the tokens.
+// A key is rotated by adding a new one, activating it once all the instances know it
+// and retiring the old one once the tokens signed with it have expired
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string]*key
+	active string
+}
+
+type key struct {
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	retired   bool
+}
+
+// NewKeyring creates an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string]*key),
+	}
+}
+
+// Add adds an HMAC key signing with HS256, it doesn't sign the tokens until activated
+func (k *Keyring) Add(id string, secret []byte) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("key secret is required")
+	}
+	return k.add(id, &key{
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	})
+}
+
+// AddPrivateKey adds an RSA key signing with RS256 or an Ed25519 key signing with EdDSA,
+// it doesn't sign the tokens until activated
+func (k *Keyring) AddPrivateKey(id string, privateKey crypto.Signer) error {
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return k.add(id, &key{
+			method:    jwt.SigningMethodRS256,
+			signKey:   privateKey,
+			verifyKey: &privateKey.PublicKey,
+		})
+	case ed25519.PrivateKey:
+		return k.add(id, &key{
+			method:    SigningMethodEdDSA,
+			signKey:   privateKey,
+			verifyKey: privateKey.Public(),
+		})
+	}
+	return ErrUnsupportedKey
+}
+
+func (k *Keyring) add(id string, stored *key) error {
+	if id == "" {
+		return fmt.Errorf("key id is required")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("key %q is already added", id)
+	}
+	k.keys[id] = stored
+	return nil
+}
+
+// Activate makes the key sign the new tokens, the previous active key keeps verifying them
+func (k *Keyring) Activate(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	stored, ok := k.keys[id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if stored.retired {
+		return ErrKeyRetired
+	}
+	k.active = id
+	return nil
+}
+
+// Retire stops accepting the tokens signed with the key, the active key can't be retired
+func (k *Keyring) Retire(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	stored, ok := k.keys[id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if k.active == id {
+		return fmt.Errorf("key %q is active", id)
+	}
+	stored.retired = true
+	return nil
+}
+
+// Active returns the id, signing method and key signing the new tokens
+func (k *Keyring) Active() (string, jwt.SigningMethod, interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	stored, ok := k.keys[k.active]
+	if !ok {
+		return "", nil, nil, ErrNoActiveKey
+	}
+	return k.active, stored.method, stored.signKey, nil
+}
+
+// Verifying returns the signing method and key verifying the tokens signed with the key
+func (k *Keyring) Verifying(id string) (jwt.SigningMethod, interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	stored, ok := k.keys[id]
+	if !ok {
+		return nil, nil, ErrUnknownKey
+	}
+	if stored.retired {
+		return nil, nil, ErrKeyRetired
+	}
+	return stored.method, stored.verifyKey, nil
+}
+
+// PublicKeys returns the public keys of the asymmetric keys which aren't retired by their ids
+func (k *Keyring) PublicKeys() ([]string, []crypto.PublicKey) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := []string{}
+	for id, stored := range k.keys {
+		if !stored.retired && !isSymmetric(stored.method) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	keys := make([]crypto.PublicKey, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, k.keys[id].verifyKey)
+	}
+	return ids, keys
+}
+
+// ParsePrivateKeyPEM parses an RSA or Ed25519 private key in the PKCS #8 or PKCS #1 PEM format,
+// e.g. made with `openssl genpkey -algorithm ed25519`
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return signer, nil
+}
+
+// isSymmetric reports whether the method signs and verifies with the same secret
+func isSymmetric(method jwt.SigningMethod) bool {
+	_, ok := method.(*jwt.SigningMethodHMAC)
+	return ok
+}
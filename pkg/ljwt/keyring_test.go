@@ -0,0 +1,216 @@
+package ljwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	keys := NewKeyring()
+	if err := keys.Add("2020-01", []byte("first_secret_key")); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if err := keys.Add("2020-01", []byte("another_secret")); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	m := NewKeyringManager(keys, time.Minute)
+	if _, err := m.IssueNewToken("42", "login", "sess_id"); err != ErrNoActiveKey {
+		t.Errorf("expected %v, got %v", ErrNoActiveKey, err)
+	}
+	if err := keys.Activate("unknown"); err != ErrUnknownKey {
+		t.Errorf("expected %v, got %v", ErrUnknownKey, err)
+	}
+	keys.Activate("2020-01")
+
+	old, err := m.IssueNewToken("42", "login", "sess_id")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	assertKid(t, m, old, "2020-01")
+
+	// the new key verifies the tokens before and signs them after the activation
+	keys.Add("2020-02", []byte("second_secret_key"))
+	keys.Activate("2020-02")
+	if err := keys.Retire("2020-02"); err == nil {
+		t.Errorf("active key was retired")
+	}
+	current, _ := m.IssueNewToken("42", "login", "sess_id")
+	assertKid(t, m, current, "2020-02")
+	if _, err = jwt.Parse(old, m.KeyFunc); err != nil {
+		t.Errorf("token of the previous key isn't accepted: %s", err)
+	}
+
+	keys.Retire("2020-01")
+	if _, err = jwt.Parse(old, m.KeyFunc); err == nil {
+		t.Errorf("token of the retired key is accepted")
+	}
+	if err = keys.Activate("2020-01"); err != ErrKeyRetired {
+		t.Errorf("expected %v, got %v", ErrKeyRetired, err)
+	}
+	if _, err = jwt.Parse(current, m.KeyFunc); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+}
+
+func TestKeyFuncLegacyTokens(t *testing.T) {
+	m := NewManager("legacy_secret_key", time.Minute)
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("legacy_secret_key"))
+	if _, err := jwt.Parse(legacy, m.KeyFunc); err != nil {
+		t.Errorf("token without kid isn't accepted: %s", err)
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{})
+	unknown.Header["kid"] = "unknown"
+	tokenString, _ := unknown.SignedString([]byte("legacy_secret_key"))
+	if _, err := jwt.Parse(tokenString, m.KeyFunc); err == nil {
+		t.Errorf("token of an unknown key is accepted")
+	}
+
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err := jwt.Parse(none, m.KeyFunc); err == nil {
+		t.Errorf("unsigned token is accepted")
+	}
+}
+
+func assertKid(t *testing.T, m *Manager, tokenString, kid string) {
+	t.Helper()
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, m.KeyFunc)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if token.Header["kid"] != kid || claims.User.ID != "42" || claims.ExpiresAt == 0 {
+		t.Errorf("bad token %v %+v", token.Header, claims)
+	}
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	keys := NewKeyring()
+	keys.Add("hmac", []byte("hmac_secret_key_0"))
+	keys.AddPrivateKey("rsa", rsaKey)
+	keys.AddPrivateKey("ed", edKey)
+	m := NewKeyringManager(keys, time.Minute)
+
+	tokens := map[string]string{}
+	for id, alg := range map[string]string{"hmac": "HS256", "rsa": "RS256", "ed": "EdDSA"} {
+		keys.Activate(id)
+		tokens[id], err = m.IssueNewToken("42", "login", "sess_id")
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+		token, err := jwt.Parse(tokens[id], m.KeyFunc)
+		if err != nil {
+			t.Fatalf("[%s] unexpected err: %s", id, err)
+		}
+		if token.Header["alg"] != alg || token.Header["kid"] != id {
+			t.Errorf("bad header %v", token.Header)
+		}
+	}
+
+	// the verification mode limits the keys accepted
+	m.Verification = VerifyAsymmetric
+	if _, err = jwt.Parse(tokens["hmac"], m.KeyFunc); err == nil {
+		t.Errorf("symmetric token accepted by the asymmetric verification")
+	}
+	if _, err = jwt.Parse(tokens["ed"], m.KeyFunc); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	m.Verification = VerifySymmetric
+	if _, err = jwt.Parse(tokens["rsa"], m.KeyFunc); err == nil {
+		t.Errorf("asymmetric token accepted by the symmetric verification")
+	}
+	m.Verification = VerifyAny
+
+	// the public key can't be used as an HMAC secret
+	publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{})
+	forged.Header["kid"] = "rsa"
+	forgedString, _ := forged.SignedString(publicDER)
+	if _, err = jwt.Parse(forgedString, m.KeyFunc); err == nil {
+		t.Errorf("token signed with the public key as HMAC secret is accepted")
+	}
+
+	// only the asymmetric keys which aren't retired are published
+	keys.Activate("ed")
+	keys.Retire("rsa")
+	set := m.JWKS()
+	if len(set.Keys) != 1 || set.Keys[0].KeyID != "ed" || set.Keys[0].KeyType != "OKP" ||
+		set.Keys[0].X != base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)) {
+		t.Errorf("bad key set %+v", set)
+	}
+	keys2 := NewKeyring()
+	keys2.AddPrivateKey("rsa", rsaKey)
+	set = NewKeyringManager(keys2, time.Minute).JWKS()
+	if len(set.Keys) != 1 || set.Keys[0].Algorithm != "RS256" || set.Keys[0].E != "AQAB" || set.Keys[0].N == "" {
+		t.Errorf("bad key set %+v", set)
+	}
+
+	// the published keys decode back
+	method, publicKey, err := set.Keys[0].PublicKey()
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if method != jwt.SigningMethodRS256 || publicKey.(*rsa.PublicKey).N.Cmp(rsaKey.N) != 0 {
+		t.Errorf("bad decoded key %v", publicKey)
+	}
+	edJWK := JWK{KeyType: "OKP", KeyID: "ed", Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey))}
+	if _, publicKey, err = edJWK.PublicKey(); err != nil || !edKey.Public().(ed25519.PublicKey).Equal(publicKey) {
+		t.Errorf("bad decoded key %v, %v", publicKey, err)
+	}
+	if _, _, err = (&JWK{KeyType: "EC", KeyID: "ec"}).PublicKey(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPKCS8, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+
+	blocks := []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		{Type: "PRIVATE KEY", Bytes: pkcs8},
+	}
+	for _, block := range blocks {
+		signer, err := ParsePrivateKeyPEM(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+		if err = NewKeyring().AddPrivateKey("id", signer); err != nil {
+			t.Errorf("unexpected err: %s", err)
+		}
+	}
+
+	signer, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if err = NewKeyring().AddPrivateKey("id", signer); err != ErrUnsupportedKey {
+		t.Errorf("expected %v, got %v", ErrUnsupportedKey, err)
+	}
+	if _, err = ParsePrivateKeyPEM([]byte("not a pem")); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -2,18 +2,58 @@ package ljwt
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-var (
-	// Create the JWT key used to create the signature
-	jwtKey = []byte(ServerKey)
+// DefaultAccessTTL is the lifetime of the access tokens when no other is given
+const DefaultAccessTTL = 15 * time.Minute
+
+// Verification modes limiting the keys accepted by the Manager
+const (
+	// VerifyAny accepts the tokens signed with any key of the keyring
+	VerifyAny = "any"
+	// VerifySymmetric accepts the tokens signed with the HMAC keys only
+	VerifySymmetric = "symmetric"
+	// VerifyAsymmetric accepts the tokens signed with the RSA and Ed25519 keys only
+	VerifyAsymmetric = "asymmetric"
 )
 
-// IssueNewToken returns a new JWT for a given user
-func IssueNewToken(userID, username, sessionID string) (string, error) {
-	// expirationTime := time.Now().Add(15 * time.Minute)
+// Manager issues and verifies the JWT tokens signed with the server keys
+type Manager struct {
+	// Keys form the server side signature, the key id is put into the kid header
+	Keys *Keyring
+	// AccessTTL is the lifetime of the issued access tokens,
+	// the clients get new ones with their refresh tokens
+	AccessTTL time.Duration
+	// Verification is the kind of the keys accepted by KeyFunc, VerifyAny when empty
+	Verification string
+}
+
+// NewManager creates a new Manager signing the tokens with the single key
+func NewManager(key string, accessTTL time.Duration) *Manager {
+	keys := NewKeyring()
+	keys.Add(LegacyKeyID, []byte(key))
+	keys.Activate(LegacyKeyID)
+	return NewKeyringManager(keys, accessTTL)
+}
+
+// NewKeyringManager creates a new Manager signing the tokens with the active key of the keyring
+func NewKeyringManager(keys *Keyring, accessTTL time.Duration) *Manager {
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTTL
+	}
+	return &Manager{
+		Keys:      keys,
+		AccessTTL: accessTTL,
+	}
+}
+
+// IssueNewToken returns a new short-lived JWT for a given user
+func (m *Manager) IssueNewToken(userID, username, sessionID string) (string, error) {
+	now := time.Now()
 	tokenUser := TokenUser{
 		Username: username,
 		ID:       userID,
@@ -21,18 +61,51 @@ func IssueNewToken(userID, username, sessionID string) (string, error) {
 	claims := &Claims{
 		User:      tokenUser,
 		SessionID: sessionID,
-		// StandardClaims: jwt.StandardClaims{
-		// 	// In JWT, the expiry time is expressed as unix milliseconds
-		// 	ExpiresAt: expirationTime.Unix(),
-		// },
+		StandardClaims: jwt.StandardClaims{
+			// In JWT, the times are expressed as unix seconds
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(m.AccessTTL).Unix(),
+		},
 	}
 
+	kid, method, signKey, err := m.Keys.Active()
+	if err != nil {
+		return "", err
+	}
 	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
 	// Create the JWT string
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := token.SignedString(signKey)
 	if err != nil {
 		return "", errors.New("Internal server error")
 	}
 	return tokenString, nil
 }
+
+// KeyFunc returns the key verifying the token signature, it is passed to jwt.Parse.
+// The tokens without the kid header were issued before the keyring and are verified with the legacy key
+func (m *Manager) KeyFunc(token *jwt.Token) (interface{}, error) {
+	kid := LegacyKeyID
+	if value, ok := token.Header["kid"]; ok {
+		kid, ok = value.(string)
+		if !ok {
+			return nil, fmt.Errorf("bad kid header %v", value)
+		}
+	}
+	method, verifyKey, err := m.Keys.Verifying(kid)
+	if err != nil {
+		return nil, err
+	}
+	// the algorithm must be the key's one, otherwise e.g. a public key could be used as an HMAC secret
+	if token.Method.Alg() != method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	switch {
+	case m.Verification == VerifySymmetric && !isSymmetric(method),
+		m.Verification == VerifyAsymmetric && isSymmetric(method):
+		return nil, fmt.Errorf("signing method %v isn't accepted", token.Header["alg"])
+	}
+	return verifyKey, nil
+}
@@ -16,7 +16,3 @@ type Claims struct {
 	SessionID string    `json:"sessionId"`
 	jwt.StandardClaims
 }
-
-// ServerKey is used to form the server side signature
-// TODO: use env variable instead
-var ServerKey = "my_super_secret_key"
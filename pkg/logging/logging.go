@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the request id between services and back to the client
+const RequestIDHeader = "X-Request-ID"
+
+// Redacted replaces the credentials in the logs
+const Redacted = "[REDACTED]"
+
+type ctxKey int
+
+const (
+	loggerKey ctxKey = iota
+	entryKey
+)
+
+// Entry collects the request attributes known only deep inside the handlers chain
+type Entry struct {
+	UserID string
+}
+
+// NewContext returns a context carrying the request-scoped logger and access log entry
+func NewContext(ctx context.Context, logger *zap.SugaredLogger, entry *Entry) context.Context {
+	ctx = context.WithValue(ctx, loggerKey, logger)
+	return context.WithValue(ctx, entryKey, entry)
+}
+
+// FromContext returns the request-scoped logger or the fallback one outside of requests
+func FromContext(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	logger, ok := ctx.Value(loggerKey).(*zap.SugaredLogger)
+	if !ok || logger == nil {
+		return fallback
+	}
+	return logger
+}
+
+// WithUserID records the authenticated user in the access log entry
+// and returns the context with the request-scoped logger annotated with the user id
+func WithUserID(ctx context.Context, userID string) context.Context {
+	if entry, ok := ctx.Value(entryKey).(*Entry); ok {
+		entry.UserID = userID
+	}
+	if logger, ok := ctx.Value(loggerKey).(*zap.SugaredLogger); ok && logger != nil {
+		ctx = context.WithValue(ctx, loggerKey, logger.With("userId", userID))
+	}
+	return ctx
+}
+
+// sensitiveParams are the query parameters never written to the logs
+var sensitiveParams = []string{"token", "password", "secret", "code", "key", "signature"}
+
+// RedactURL returns the path and query of the URL with the credentials replaced
+func RedactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	query := u.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		for _, s := range sensitiveParams {
+			if strings.Contains(lower, s) {
+				query[name] = []string{Redacted}
+				break
+			}
+		}
+	}
+	return u.Path + "?" + query.Encode()
+}
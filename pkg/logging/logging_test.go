@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRedactURL(t *testing.T) {
+	cases := map[string]string{
+		"/api/posts/":                          "/api/posts/",
+		"/api/posts/?page=2":                   "/api/posts/?page=2",
+		"/reset?token=abc&Password=x&page=2":   "/reset?Password=%5BREDACTED%5D&page=2&token=%5BREDACTED%5D",
+		"/callback?code=abc&state=s&api_key=k": "/callback?api_key=%5BREDACTED%5D&code=%5BREDACTED%5D&state=s",
+	}
+	for raw, expected := range cases {
+		u, _ := url.Parse(raw)
+		if res := RedactURL(u); res != expected {
+			t.Errorf("bad redacted %s: want %s, have %s", raw, expected, res)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	fallback := zap.NewNop().Sugar()
+	if FromContext(context.Background(), fallback) != fallback {
+		t.Errorf("expected the fallback logger")
+	}
+
+	logger := zap.NewNop().Sugar()
+	entry := &Entry{}
+	ctx := NewContext(context.Background(), logger, entry)
+	if FromContext(ctx, fallback) != logger {
+		t.Errorf("expected the request logger")
+	}
+
+	ctx = WithUserID(ctx, "42")
+	if entry.UserID != "42" {
+		t.Errorf("user id wasn't recorded")
+	}
+	if FromContext(ctx, fallback) == logger || FromContext(ctx, fallback) == fallback {
+		t.Errorf("expected the logger annotated with the user id")
+	}
+}
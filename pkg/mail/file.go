@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes every email into a .eml file in the directory instead of sending it,
+// so the emails can be read in a local setup without a mail server
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// NewFileMailer creates a mailer writing the emails into the directory
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{Dir: dir, From: from}
+}
+
+// Send writes the message into a new file named after the time it's sent
+func (m *FileMailer) Send(msg Message) error {
+	now := time.Now()
+	data, err := msg.Bytes(m.From, now)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(m.Dir, 0700)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := now.UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), data, 0600)
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"strings"
+	"time"
+)
+
+// ErrBadHeader is returned for an address or subject with line breaks, they would inject headers
+var ErrBadHeader = errors.New("Line break in a mail header")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends the emails, the SMTP one delivers them and the others keep them for the tests
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Bytes formats the message from the sender as it's sent over SMTP
+func (m Message) Bytes(from string, date time.Time) ([]byte, error) {
+	for _, header := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrBadHeader
+		}
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + m.To + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	buf.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	body := strings.Replace(m.Body, "\r\n", "\n", -1)
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,139 @@
+package mail
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{To: "jane@example.com", Subject: "Сброс пароля", Body: "line 1\nline 2"}
+	data, err := msg.Bytes("noreply@example.com", time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	text := string(data)
+	for _, part := range []string{
+		"From: noreply@example.com\r\n",
+		"To: jane@example.com\r\n",
+		"Subject: =?utf-8?q?",
+		"Date: Mon, 01 Jun 2020 12:00:00 +0000\r\n",
+		"\r\n\r\nline 1\r\nline 2",
+	} {
+		if !strings.Contains(text, part) {
+			t.Errorf("no %q in %q", part, text)
+		}
+	}
+
+	msg.To = "jane@example.com\r\nBcc: all@example.com"
+	if _, err = msg.Bytes("noreply@example.com", time.Now()); err != ErrBadHeader {
+		t.Errorf("expected %v, got %v", ErrBadHeader, err)
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	msg := Message{To: "jane@example.com", Subject: "Hi", Body: "Hello"}
+	if err := m.Send(msg); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if !reflect.DeepEqual(m.Messages(), []Message{msg}) {
+		t.Errorf("bad messages %v", m.Messages())
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := NewFileMailer(dir, "noreply@example.com")
+	for i := 0; i < 2; i++ {
+		if err := m.Send(Message{To: "jane@example.com", Subject: "Hi", Body: "Hello"}); err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected 2 files, got %v, %v", files, err)
+	}
+	data, _ := ioutil.ReadFile(files[0])
+	if !strings.Contains(string(data), "To: jane@example.com\r\n") {
+		t.Errorf("bad file %q", data)
+	}
+
+	if err = m.Send(Message{To: "jane@example.com", Subject: "Hi\nBcc: all@example.com"}); err != ErrBadHeader {
+		t.Errorf("expected %v, got %v", ErrBadHeader, err)
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %s", err)
+	}
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go serveSMTP(ln, received)
+
+	m := NewSMTPMailer(ln.Addr().String(), "noreply@example.com", "", "")
+	err = m.Send(Message{To: "jane@example.com", Subject: "Hi", Body: "Hello"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	commands := <-received
+	expected := []string{"MAIL FROM:<noreply@example.com>", "RCPT TO:<jane@example.com>", "DATA", "Hello", "QUIT"}
+	for _, cmd := range expected {
+		found := false
+		for _, c := range commands {
+			found = found || strings.HasPrefix(c, cmd)
+		}
+		if !found {
+			t.Errorf("no %q in %q", cmd, commands)
+		}
+	}
+}
+
+// serveSMTP answers a single client with the least of SMTP and sends the lines it got
+func serveSMTP(ln net.Listener, received chan<- []string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		received <- nil
+		return
+	}
+	defer conn.Close()
+
+	lines := []string{}
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("220 localhost\r\n"))
+	data := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		switch {
+		case data && line == ".":
+			data = false
+			conn.Write([]byte("250 queued\r\n"))
+		case data:
+		case line == "DATA":
+			data = true
+			conn.Write([]byte("354 go ahead\r\n"))
+		case line == "QUIT":
+			conn.Write([]byte("221 bye\r\n"))
+			received <- lines
+			return
+		default:
+			conn.Write([]byte("250 ok\r\n"))
+		}
+	}
+	received <- lines
+}
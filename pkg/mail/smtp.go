@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer delivers the emails through an SMTP server
+type SMTPMailer struct {
+	// Addr is the host:port of the server
+	Addr string
+	From string
+	// Auth logs in to the server, no login is done when it's nil
+	Auth smtp.Auth
+}
+
+// NewSMTPMailer creates a mailer logging in with the username and password when the username is set
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{Addr: addr, From: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send sends the message, the server's STARTTLS is used when it's offered
+func (m *SMTPMailer) Send(msg Message) error {
+	data, err := msg.Bytes(m.From, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, data)
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ids"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// validRequestID limits the request ids accepted from clients so they can't forge log lines
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// statusWriter remembers the response status and size
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// AccessLog assigns every request an id, taken from the X-Request-ID header when the client sends one,
+// puts the request-scoped logger into the context and logs the request when it is served.
+// It's meant for mux.Router.Use so that the route template is known
+func AccessLog(logger *zap.SugaredLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestID := r.Header.Get(logging.RequestIDHeader)
+			if !validRequestID.MatchString(requestID) {
+				requestID = ids.GenerateID()
+			}
+			w.Header().Set(logging.RequestIDHeader, requestID)
+
+			route := r.URL.Path
+			if current := mux.CurrentRoute(r); current != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					route = tmpl
+				}
+			}
+
+			reqLogger := logger.With("requestId", requestID)
+			entry := &logging.Entry{}
+			ctx := logging.NewContext(r.Context(), reqLogger, entry)
+
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+
+			reqLogger.Infow("access",
+				"method", r.Method,
+				"route", route,
+				"url", logging.RedactURL(r.URL),
+				"status", sw.status,
+				"latency", time.Since(start).String(),
+				"bytes", sw.bytes,
+				"userId", entry.UserID,
+				"remoteAddr", r.RemoteAddr,
+				"userAgent", r.UserAgent(),
+			)
+		})
+	}
+}
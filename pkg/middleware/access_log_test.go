@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type fakeSessions struct{}
+
+func (fakeSessions) Check(id string) (*session.Session, error) {
+	if id != "sess_id" {
+		return nil, session.ErrNoAuth
+	}
+	return &session.Session{ID: id, UserID: "42", Expires: time.Now().Add(time.Hour)}, nil
+}
+
+func TestAccessLog(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core).Sugar()
+	key := []byte("test_key")
+	keyFunc := func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}
+
+	r := mux.NewRouter()
+	r.Use(AccessLog(logger))
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context(), nil).Infow("in handler")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}
+	r.HandleFunc("/api/post/{id}", Chain(handler, AuthorizedUserMiddleware(fakeSessions{}, fakeRoles(nil), nil, keyFunc, logger))).Methods("POST")
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user":      map[string]interface{}{"id": "42", "username": "login"},
+		"sessionId": "sess_id",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+	}).SignedString(key)
+
+	req := httptest.NewRequest("POST", "/api/post/123?token=secret&page=2", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(logging.RequestIDHeader, "client-id-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Header().Get(logging.RequestIDHeader) != "client-id-1" {
+		t.Errorf("request id wasn't propagated: %v", w.Header())
+	}
+
+	entries := logs.AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 log entries, got %d", len(entries))
+	}
+	inHandler := entries[0].ContextMap()
+	if inHandler["requestId"] != "client-id-1" || inHandler["userId"] != "42" {
+		t.Errorf("bad handler log context %v", inHandler)
+	}
+	access := entries[1].ContextMap()
+	expected := map[string]interface{}{
+		"requestId": "client-id-1",
+		"method":    "POST",
+		"route":     "/api/post/{id}",
+		"url":       "/api/post/123?page=2&token=%5BREDACTED%5D",
+		"status":    int64(http.StatusCreated),
+		"bytes":     int64(len("created")),
+		"userId":    "42",
+	}
+	for k, v := range expected {
+		if access[k] != v {
+			t.Errorf("bad access log %s: want %v, have %v", k, v, access[k])
+		}
+	}
+
+	for _, e := range logs.All() {
+		for _, f := range e.Context {
+			if strings.Contains(f.String, token) || strings.Contains(f.String, "sess_id") {
+				t.Errorf("credentials leaked into %s: %v", e.Message, f)
+			}
+		}
+	}
+
+	// a forged request id is replaced
+	req = httptest.NewRequest("POST", "/api/post/123", nil)
+	req.Header.Set(logging.RequestIDHeader, "bad\nid")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if id := w.Header().Get(logging.RequestIDHeader); id == "" || id == "bad\nid" {
+		t.Errorf("bad request id %q", id)
+	}
+}
@@ -2,11 +2,14 @@ package middleware
 
 import (
 	"context"
-	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ljwt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/logging"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/utils"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"go.uber.org/zap"
@@ -23,15 +26,53 @@ func Chain(f http.HandlerFunc, middlewares ...Middleware) http.HandlerFunc {
 	return f
 }
 
+// SessionsCheckerInterface validates the sessions referenced by JWT tokens
+type SessionsCheckerInterface interface {
+	Check(string) (*session.Session, error)
+}
+
+// RolesSourceInterface loads the roles of the authorized users
+type RolesSourceInterface interface {
+	Roles(userID string) (user.Roles, error)
+}
+
+// UsersSourceInterface loads the authorized users
+type UsersSourceInterface interface {
+	GetByID(string) (*user.User, error)
+}
+
+// APITokensCheckerInterface validates the personal tokens
+type APITokensCheckerInterface interface {
+	Check(secret string) (*apitoken.Token, error)
+}
+
+type allowedScopeKey string
+
+// scopeKey keeps the scope a personal token needs for the route
+var scopeKey allowedScopeKey = "allowedScope"
+
+// AllowToken lets the personal tokens with the scope through AuthorizedUserMiddleware,
+// the routes without it accept the sessions only. It must run before AuthorizedUserMiddleware,
+// so it goes after it in the Chain
+func AllowToken(scope apitoken.Scope) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopeKey, scope)))
+		}
+	}
+}
+
 // AuthorizedUserMiddleware makes sure that a user is authorized to make a call
-func AuthorizedUserMiddleware(sm *session.SessionsManager, logger *zap.SugaredLogger) Middleware {
+// and puts their session and roles into the request context,
+// keyFunc returns the key verifying the token signature.
+// The personal tokens are accepted on the routes allowing them, the token is put into the context
+// together with a session of the token's user which has no id
+func AuthorizedUserMiddleware(sm SessionsCheckerInterface, roles RolesSourceInterface, tokens APITokensCheckerInterface, keyFunc jwt.Keyfunc, logger *zap.SugaredLogger) Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 
+			reqLogger := logging.FromContext(r.Context(), logger)
 			tokenString := r.Header.Get("Authorization")
-			logger.Infow("Auth middleware",
-				"token", tokenString,
-			)
 			if len(tokenString) < len("Bearer ") {
 				jsonMessage := utils.GetJSONMessageAsString("Missing Authorization Header")
 				http.Error(w, jsonMessage, http.StatusUnauthorized)
@@ -39,63 +80,173 @@ func AuthorizedUserMiddleware(sm *session.SessionsManager, logger *zap.SugaredLo
 			}
 			tokenString = strings.Replace(tokenString, "Bearer ", "", 1)
 
-			claims := jwt.MapClaims{}
-
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(ljwt.ServerKey), nil
-			})
-
-			if err != nil || !token.Valid {
-				jsonMessage := utils.GetJSONMessageAsString(err.Error())
-				http.Error(w, jsonMessage, http.StatusUnauthorized)
-				return
+			var sess *session.Session
+			var tok *apitoken.Token
+			var ok bool
+			if strings.HasPrefix(tokenString, apitoken.Prefix) {
+				tok, ok = checkAPIToken(w, r, tokens, tokenString, reqLogger)
+				if !ok {
+					return
+				}
+				sess = &session.Session{UserID: tok.UserID, Expires: tok.Expires}
+			} else {
+				sess, ok = checkSession(w, sm, tokenString, keyFunc, reqLogger)
+				if !ok {
+					return
+				}
 			}
 
-			var interf jwt.MapClaims
-			var tokenUsername string
-			var sessionID string
-			var tokenUserID string
-			var ok bool
-			if interf, ok = claims["user"].(map[string]interface{}); !ok {
-				jsonMessage := utils.GetJSONMessageAsString("Error getting user's authorisations")
-				http.Error(w, jsonMessage, http.StatusUnauthorized)
+			userRoles, err := roles.Roles(sess.UserID)
+			if err == user.ErrNoUser {
+				reqLogger.Infow("Session of a deleted user", "userId", sess.UserID)
+				http.Error(w, `Unauthorized`, http.StatusUnauthorized)
 				return
 			}
-			if sessionID, ok = claims["sessionId"].(string); !ok {
-				jsonMessage := utils.GetJSONMessageAsString("Error getting Session Id")
-				http.Error(w, jsonMessage, http.StatusUnauthorized)
+			if err != nil {
+				reqLogger.Errorf(`InternalServerError. %s`, err.Error())
+				http.Error(w, `InternalServerError`, http.StatusInternalServerError)
 				return
 			}
-			if tokenUsername, ok = interf["username"].(string); !ok {
-				jsonMessage := utils.GetJSONMessageAsString("Error getting user's authorisation attribute")
-				http.Error(w, jsonMessage, http.StatusUnauthorized)
-				return
+
+			ctx := logging.WithUserID(r.Context(), sess.UserID)
+			ctx = context.WithValue(ctx, session.SessionKey, sess)
+			ctx = context.WithValue(ctx, user.RolesKey, userRoles)
+			if tok != nil {
+				ctx = context.WithValue(ctx, apitoken.TokenKey, tok)
 			}
-			if tokenUserID, ok = interf["id"].(string); !ok {
-				jsonMessage := utils.GetJSONMessageAsString("Error getting user's authorisation attribute")
-				http.Error(w, jsonMessage, http.StatusUnauthorized)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// checkSession verifies the JWT and returns the session it references, it answers the request itself when it fails
+func checkSession(w http.ResponseWriter, sm SessionsCheckerInterface, tokenString string, keyFunc jwt.Keyfunc, reqLogger *zap.SugaredLogger) (*session.Session, bool) {
+	claims := jwt.MapClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
+
+	if err != nil || !token.Valid {
+		jsonMessage := utils.GetJSONMessageAsString(err.Error())
+		http.Error(w, jsonMessage, http.StatusUnauthorized)
+		return nil, false
+	}
+	// the tokens issued before the expiry was introduced must not live forever
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		jsonMessage := utils.GetJSONMessageAsString("Token has no expiry")
+		http.Error(w, jsonMessage, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var interf jwt.MapClaims
+	var tokenUsername string
+	var sessionID string
+	var tokenUserID string
+	var ok bool
+	if interf, ok = claims["user"].(map[string]interface{}); !ok {
+		jsonMessage := utils.GetJSONMessageAsString("Error getting user's authorisations")
+		http.Error(w, jsonMessage, http.StatusUnauthorized)
+		return nil, false
+	}
+	if sessionID, ok = claims["sessionId"].(string); !ok {
+		jsonMessage := utils.GetJSONMessageAsString("Error getting Session Id")
+		http.Error(w, jsonMessage, http.StatusUnauthorized)
+		return nil, false
+	}
+	if tokenUsername, ok = interf["username"].(string); !ok {
+		jsonMessage := utils.GetJSONMessageAsString("Error getting user's authorisation attribute")
+		http.Error(w, jsonMessage, http.StatusUnauthorized)
+		return nil, false
+	}
+	if tokenUserID, ok = interf["id"].(string); !ok {
+		jsonMessage := utils.GetJSONMessageAsString("Error getting user's authorisation attribute")
+		http.Error(w, jsonMessage, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	sess, err := sm.Check(sessionID)
+	if err != nil {
+		// the session id is a credential as well, so only the user is logged
+		reqLogger.Infow("Error when checking session",
+			"userId", tokenUserID,
+			"userName", tokenUsername,
+		)
+		http.Error(w, `Unauthorized`, http.StatusUnauthorized)
+		return nil, false
+	}
+	return sess, true
+}
+
+// checkAPIToken returns the personal token if the route allows it with its scope,
+// it answers the request itself when it fails
+func checkAPIToken(w http.ResponseWriter, r *http.Request, tokens APITokensCheckerInterface, secret string, reqLogger *zap.SugaredLogger) (*apitoken.Token, bool) {
+	scope, allowed := r.Context().Value(scopeKey).(apitoken.Scope)
+	if !allowed || tokens == nil {
+		jsonMessage := utils.GetJSONMessageAsString("Personal tokens aren't accepted here")
+		http.Error(w, jsonMessage, http.StatusForbidden)
+		return nil, false
+	}
+
+	tok, err := tokens.Check(secret)
+	if err == apitoken.ErrNoToken {
+		http.Error(w, `Unauthorized`, http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		reqLogger.Errorf(`InternalServerError. %s`, err.Error())
+		http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !tok.Scopes.Has(scope) {
+		reqLogger.Infow("Personal token lacks the scope", "userId", tok.UserID, "tokenId", tok.ID, "scope", scope)
+		jsonMessage := utils.GetJSONMessageAsString("Token lacks the " + string(scope) + " scope")
+		http.Error(w, jsonMessage, http.StatusForbidden)
+		return nil, false
+	}
+	return tok, true
+}
+
+// RequirePermission lets through only the users whose roles grant the permission,
+// it must run after AuthorizedUserMiddleware, so it goes before it in the Chain
+func RequirePermission(p user.Permission) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !user.RolesFromContext(r.Context()).Can(p) {
+				jsonMessage := utils.GetJSONMessageAsString("Permission denied")
+				http.Error(w, jsonMessage, http.StatusForbidden)
 				return
 			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
 
-			logger.Infow("Auth passed",
-				"userId", tokenUserID,
-				"userName", tokenUsername,
-				"sessionId", sessionID,
-			)
-
-			sess, err := sm.Check(sessionID)
+// RequireVerifiedEmail lets through only the users who have verified their email,
+// it must run after AuthorizedUserMiddleware, so it goes before it in the Chain
+func RequireVerifiedEmail(users UsersSourceInterface, logger *zap.SugaredLogger) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			sess, err := session.SessionFromContext(r.Context())
 			if err != nil {
-				logger.Infow("Error when checking session",
-					"token_string", tokenString,
-				)
 				http.Error(w, `Unauthorized`, http.StatusUnauthorized)
 				return
 			}
-
-			ctx := r.Context()
-			ctx = context.WithValue(ctx, session.SessionKey, sess)
-
-			next.ServeHTTP(w, r.WithContext(ctx))
+			u, err := users.GetByID(sess.UserID)
+			if err == user.ErrNoUser {
+				http.Error(w, `Unauthorized`, http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				logging.FromContext(r.Context(), logger).Errorf(`InternalServerError. %s`, err.Error())
+				http.Error(w, `InternalServerError`, http.StatusInternalServerError)
+				return
+			}
+			if !u.EmailVerified {
+				jsonMessage := utils.GetJSONMessageAsString("Email isn't verified")
+				http.Error(w, jsonMessage, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
 		}
 	}
 }
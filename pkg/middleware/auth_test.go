@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/apitoken"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/session"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+// fakeRoles keeps the roles of the users by their ids, everybody is a plain user when it is nil
+type fakeRoles map[string]user.Roles
+
+func (f fakeRoles) Roles(userID string) (user.Roles, error) {
+	if f == nil {
+		return user.Roles{user.RoleUser}, nil
+	}
+	roles, ok := f[userID]
+	if !ok {
+		return nil, user.ErrNoUser
+	}
+	if roles == nil {
+		return nil, errors.New("db_error")
+	}
+	return roles, nil
+}
+
+func TestAuthorizedUserMiddlewareExpiry(t *testing.T) {
+	key := []byte("test_key")
+	keyFunc := func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}
+	handler := Chain(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, AuthorizedUserMiddleware(fakeSessions{}, fakeRoles(nil), nil, keyFunc, zap.NewNop().Sugar()))
+
+	now := time.Now()
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		status int
+	}{
+		{name: "valid", claims: jwt.MapClaims{"exp": now.Add(time.Minute).Unix(), "nbf": now.Unix()}, status: http.StatusOK},
+		{name: "expired", claims: jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}, status: http.StatusUnauthorized},
+		{name: "not yet valid", claims: jwt.MapClaims{"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Minute).Unix()}, status: http.StatusUnauthorized},
+		{name: "no expiry", claims: jwt.MapClaims{}, status: http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		c.claims["user"] = map[string]interface{}{"id": "42", "username": "login"}
+		c.claims["sessionId"] = "sess_id"
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c.claims).SignedString(key)
+
+		req := httptest.NewRequest("POST", "/api/posts", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+	}
+}
+
+func TestAuthorizedUserMiddlewareRoles(t *testing.T) {
+	key := []byte("test_key")
+	keyFunc := func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user":      map[string]interface{}{"id": "42", "username": "login"},
+		"sessionId": "sess_id",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+	}).SignedString(key)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprint(user.RolesFromContext(r.Context()))))
+	}
+
+	cases := []struct {
+		name       string
+		roles      fakeRoles
+		permission user.Permission
+		status     int
+		body       string
+	}{
+		{name: "moderator", roles: fakeRoles{"42": {user.RoleUser, user.RoleModerator}}, status: http.StatusOK, body: "[user moderator]"},
+		{name: "permitted", roles: fakeRoles{"42": {user.RoleUser, user.RoleAdmin}}, permission: user.PermManageRoles, status: http.StatusOK},
+		{name: "forbidden", roles: fakeRoles{"42": {user.RoleUser, user.RoleModerator}}, permission: user.PermManageRoles, status: http.StatusForbidden},
+		{name: "deleted user", roles: fakeRoles{}, status: http.StatusUnauthorized},
+		{name: "roles error", roles: fakeRoles{"42": nil}, status: http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		middlewares := []Middleware{AuthorizedUserMiddleware(fakeSessions{}, c.roles, nil, keyFunc, zap.NewNop().Sugar())}
+		if c.permission != "" {
+			middlewares = append([]Middleware{RequirePermission(c.permission)}, middlewares...)
+		}
+		req := httptest.NewRequest("POST", "/api/posts", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		Chain(handler, middlewares...)(w, req)
+
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("[%s] bad roles in context %s", c.name, w.Body.String())
+		}
+	}
+
+	// the permission is denied without the auth middleware
+	w := httptest.NewRecorder()
+	RequirePermission(user.PermModerate)(handler)(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+// fakeTokens keeps the personal tokens by their secrets, a nil token stands for a db error
+type fakeTokens map[string]*apitoken.Token
+
+func (f fakeTokens) Check(secret string) (*apitoken.Token, error) {
+	tok, ok := f[secret]
+	if !ok {
+		return nil, apitoken.ErrNoToken
+	}
+	if tok == nil {
+		return nil, errors.New("db_error")
+	}
+	return tok, nil
+}
+
+func TestAuthorizedUserMiddlewareAPITokens(t *testing.T) {
+	key := []byte("test_key")
+	keyFunc := func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}
+	tokens := fakeTokens{
+		apitoken.Prefix + "voter":  {ID: "t1", UserID: "42", Scopes: apitoken.Scopes{apitoken.ScopeRead, apitoken.ScopeVote}},
+		apitoken.Prefix + "broken": nil,
+	}
+	auth := AuthorizedUserMiddleware(fakeSessions{}, fakeRoles(nil), tokens, keyFunc, zap.NewNop().Sugar())
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := session.SessionFromContext(r.Context())
+		tok := apitoken.FromContext(r.Context())
+		w.Write([]byte(fmt.Sprintf("%s %s %t", sess.UserID, sess.ID, tok != nil)))
+	}
+	sessionToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user":      map[string]interface{}{"id": "42", "username": "login"},
+		"sessionId": "sess_id",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+	}).SignedString(key)
+
+	cases := []struct {
+		name   string
+		token  string
+		scope  apitoken.Scope
+		status int
+		body   string
+	}{
+		{name: "token with the scope", token: apitoken.Prefix + "voter", scope: apitoken.ScopeVote, status: http.StatusOK, body: "42  true"},
+		{name: "token without the scope", token: apitoken.Prefix + "voter", scope: apitoken.ScopePost, status: http.StatusForbidden},
+		{name: "session only route", token: apitoken.Prefix + "voter", status: http.StatusForbidden},
+		{name: "unknown token", token: apitoken.Prefix + "unknown", scope: apitoken.ScopeVote, status: http.StatusUnauthorized},
+		{name: "tokens error", token: apitoken.Prefix + "broken", scope: apitoken.ScopeVote, status: http.StatusInternalServerError},
+		{name: "session on a token route", token: sessionToken, scope: apitoken.ScopePost, status: http.StatusOK, body: "42 sess_id false"},
+	}
+	for _, c := range cases {
+		middlewares := []Middleware{auth}
+		if c.scope != "" {
+			middlewares = append(middlewares, AllowToken(c.scope))
+		}
+		req := httptest.NewRequest("POST", "/api/posts", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		w := httptest.NewRecorder()
+		Chain(handler, middlewares...)(w, req)
+
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("[%s] bad context %s", c.name, w.Body.String())
+		}
+	}
+}
+
+// fakeUsers keeps the users by their ids, a nil user stands for a db error
+type fakeUsers map[string]*user.User
+
+func (f fakeUsers) GetByID(userID string) (*user.User, error) {
+	u, ok := f[userID]
+	if !ok {
+		return nil, user.ErrNoUser
+	}
+	if u == nil {
+		return nil, errors.New("db_error")
+	}
+	return u, nil
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	cases := []struct {
+		name   string
+		users  fakeUsers
+		status int
+	}{
+		{name: "verified", users: fakeUsers{"42": {ID: "42", Email: "jane@example.com", EmailVerified: true}}, status: http.StatusOK},
+		{name: "unverified", users: fakeUsers{"42": {ID: "42", Email: "jane@example.com"}}, status: http.StatusForbidden},
+		{name: "no email", users: fakeUsers{"42": {ID: "42"}}, status: http.StatusForbidden},
+		{name: "deleted user", users: fakeUsers{}, status: http.StatusUnauthorized},
+		{name: "db error", users: fakeUsers{"42": nil}, status: http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/api/posts", nil)
+		req = req.WithContext(context.WithValue(req.Context(), session.SessionKey, &session.Session{ID: "sess_id", UserID: "42"}))
+		w := httptest.NewRecorder()
+		RequireVerifiedEmail(c.users, zap.NewNop().Sugar())(handler)(w, req)
+		if w.Code != c.status {
+			t.Errorf("[%s] expected status %d, got %d", c.name, c.status, w.Code)
+		}
+	}
+
+	// nobody is let through without the auth middleware
+	w := httptest.NewRecorder()
+	RequireVerifiedEmail(fakeUsers{}, zap.NewNop().Sugar())(handler)(w, httptest.NewRequest("POST", "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,297 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationsFS contains the SQL files named as <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+const (
+	// lockName is the MySQL named lock held while migrating, so the instances started together don't race
+	lockName = "redditclone_migrate"
+	// lockTimeout is how long in seconds to wait for the other instance to finish migrating
+	lockTimeout = 60
+)
+
+var (
+	// ErrDirty is returned when a migration was interrupted half-way
+	// or the database schema_migrations table knows a version missing from the files
+	ErrDirty = errors.New("Database has an interrupted or unknown migration version")
+	// ErrNoDown is returned when a migration to roll back has no down file
+	ErrNoDown = errors.New("Migration can't be rolled back")
+	// ErrLocked is returned when another process doesn't finish migrating in time
+	ErrLocked = errors.New("Migrations are being applied by another process")
+)
+
+// Migration is a single versioned change of the database schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies Migrations to the database and keeps track of them in schema_migrations
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []*Migration
+}
+
+// NewMigrator creates a Migrator with the migrations embedded into the binary
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load(migrationsFS)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+	}, nil
+}
+
+// Load reads the migrations from the "migrations" directory of the file system sorted by version
+func Load(fsys fs.FS) ([]*Migration, error) {
+	files, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		name := f.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("bad migration file name %s", name)
+		}
+
+		body, err := fs.ReadFile(fsys, "migrations/"+name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[1] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, parts[1])
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// ensureTable creates the schema_migrations table on the first run
+func (m *Migrator) ensureTable() error {
+	_, err := m.DB.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (" +
+		"`version` int NOT NULL, " +
+		"`name` varchar(255) NOT NULL, " +
+		"`applied` bigint NOT NULL, " +
+		"PRIMARY KEY (`version`))")
+	return err
+}
+
+// lock takes the named lock on a dedicated connection and returns the func releasing it,
+// the lock is released by MySQL as well if the connection is lost
+func (m *Migrator) lock() (func(), error) {
+	ctx := context.Background()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked sql.NullInt64
+	err = conn.
+		QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, lockTimeout).
+		Scan(&locked)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if locked.Int64 != 1 {
+		conn.Close()
+		return nil, ErrLocked
+	}
+	return func() {
+		var released sql.NullInt64
+		conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", lockName).Scan(&released)
+		conn.Close()
+	}, nil
+}
+
+// Version returns the latest applied migration version, 0 for a fresh database.
+// The migration being applied or rolled back is kept with the zero applied time,
+// so the one interrupted half-way makes the database dirty until it's fixed by hand
+func (m *Migrator) Version() (int, error) {
+	err := m.ensureTable()
+	if err != nil {
+		return 0, err
+	}
+	var dirty int
+	err = m.DB.
+		QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE applied = 0").
+		Scan(&dirty)
+	if err != nil {
+		return 0, err
+	}
+	if dirty != 0 {
+		return 0, ErrDirty
+	}
+	var version int
+	err = m.DB.
+		QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").
+		Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	if version != 0 && m.find(version) < 0 {
+		return 0, ErrDirty
+	}
+	return version, nil
+}
+
+// Up applies all the pending migrations and returns the versions applied
+func (m *Migrator) Up() ([]int, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []int{}
+	for _, migration := range m.Migrations {
+		if migration.Version <= current {
+			continue
+		}
+		_, err = m.DB.Exec(
+			"INSERT INTO schema_migrations (`version`, `name`, `applied`) VALUES (?, ?, 0)",
+			migration.Version,
+			migration.Name,
+		)
+		if err != nil {
+			return applied, err
+		}
+		err = m.exec(migration.Up)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s failed: %s", migration.Version, migration.Name, err)
+		}
+		_, err = m.DB.Exec("UPDATE schema_migrations SET applied = UNIX_TIMESTAMP() WHERE version = ?", migration.Version)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration.Version)
+	}
+	return applied, nil
+}
+
+// Down rolls back the given number of latest applied migrations and returns the versions rolled back
+func (m *Migrator) Down(steps int) ([]int, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	rolledBack := []int{}
+	for i := m.find(current); i >= 0 && len(rolledBack) < steps; i-- {
+		migration := m.Migrations[i]
+		if migration.Down == "" {
+			return rolledBack, ErrNoDown
+		}
+		_, err = m.DB.Exec("UPDATE schema_migrations SET applied = 0 WHERE version = ?", migration.Version)
+		if err != nil {
+			return rolledBack, err
+		}
+		err = m.exec(migration.Down)
+		if err != nil {
+			return rolledBack, fmt.Errorf("migration %d_%s rollback failed: %s", migration.Version, migration.Name, err)
+		}
+		_, err = m.DB.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+		if err != nil {
+			return rolledBack, err
+		}
+		rolledBack = append(rolledBack, migration.Version)
+	}
+	return rolledBack, nil
+}
+
+// exec runs every statement of a migration file one by one,
+// MySQL commits DDL implicitly so there is no point in a transaction here
+func (m *Migrator) exec(script string) error {
+	for _, stmt := range Statements(script) {
+		_, err := m.DB.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) find(version int) int {
+	for i, migration := range m.Migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// Statements splits an SQL script into separate statements ended with ";"
+func Statements(script string) []string {
+	res := []string{}
+	for _, stmt := range strings.Split(script, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt != "" {
+			res = append(res, stmt)
+		}
+	}
+	return res
+}
@@ -0,0 +1,245 @@
+package migrate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load(migrationsFS)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatalf("no migrations embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migrations must be numbered without gaps, got %d at %d", m.Version, i)
+		}
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_second.up.sql":  {Data: []byte("CREATE TABLE b (id int)")},
+		"migrations/0001_first.up.sql":   {Data: []byte("CREATE TABLE a (id int)")},
+		"migrations/0001_first.down.sql": {Data: []byte("DROP TABLE a")},
+		"migrations/README":              {Data: []byte("ignored")},
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	expected := []*Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a (id int)", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b (id int)"},
+	}
+	if !reflect.DeepEqual(migrations, expected) {
+		t.Errorf("results not match, want %v, have %v", expected, migrations)
+	}
+
+	// bad file name
+	_, err = Load(fstest.MapFS{
+		"migrations/first.up.sql": {Data: []byte("CREATE TABLE a (id int)")},
+	})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	// no up file
+	_, err = Load(fstest.MapFS{
+		"migrations/0001_first.down.sql": {Data: []byte("DROP TABLE a")},
+	})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func testMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	m := &Migrator{
+		DB: db,
+		Migrations: []*Migration{
+			{Version: 1, Name: "first", Up: "CREATE TABLE a (id int);\nCREATE TABLE aa (id int);", Down: "DROP TABLE a;"},
+			{Version: 2, Name: "second", Up: "CREATE TABLE b (id int);", Down: "DROP TABLE b;"},
+			{Version: 3, Name: "third", Up: "CREATE TABLE c (id int);"},
+		},
+	}
+	return m, mock, func() { db.Close() }
+}
+
+func expectLock(mock sqlmock.Sqlmock, locked int) {
+	mock.
+		ExpectQuery("SELECT GET_LOCK\\(\\?, \\?\\)").
+		WithArgs("redditclone_migrate", 60).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(locked))
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.
+		ExpectQuery("SELECT RELEASE_LOCK\\(\\?\\)").
+		WithArgs("redditclone_migrate").
+		WillReturnRows(sqlmock.NewRows([]string{"released"}).AddRow(1))
+}
+
+func expectDirty(mock sqlmock.Sqlmock, dirty int) {
+	mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("SELECT COUNT\\(\\*\\) FROM schema_migrations WHERE applied = 0").
+		WillReturnRows(sqlmock.NewRows([]string{"dirty"}).AddRow(dirty))
+}
+
+func expectVersion(mock sqlmock.Sqlmock, version int) {
+	expectDirty(mock, 0)
+	mock.
+		ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 0\\) FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+}
+
+func TestUp(t *testing.T) {
+	m, mock, closeDB := testMigrator(t)
+	defer closeDB()
+
+	// only the pending migrations are applied, each is marked dirty until it's done
+	expectLock(mock, 1)
+	expectVersion(mock, 1)
+	for _, item := range []struct {
+		version int
+		name    string
+		stmt    string
+	}{{2, "second", "CREATE TABLE b"}, {3, "third", "CREATE TABLE c"}} {
+		mock.
+			ExpectExec("INSERT INTO schema_migrations (.+) VALUES \\(\\?, \\?, 0\\)").
+			WithArgs(item.version, item.name).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(item.stmt).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.
+			ExpectExec("UPDATE schema_migrations SET applied = UNIX_TIMESTAMP\\(\\) WHERE version = ?").
+			WithArgs(item.version).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	expectUnlock(mock)
+
+	applied, err := m.Up()
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(applied, []int{2, 3}) {
+		t.Errorf("bad applied versions %v", applied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+
+	// every statement of a file is executed, the failed one stops the run and leaves the migration dirty
+	expectLock(mock, 1)
+	expectVersion(mock, 0)
+	mock.
+		ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(1, "first").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("CREATE TABLE a ").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE aa").WillReturnError(fmt.Errorf("db_error"))
+	expectUnlock(mock)
+
+	applied, err = m.Up()
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if len(applied) != 0 {
+		t.Errorf("bad applied versions %v", applied)
+	}
+
+	// the interrupted migration must be fixed by hand
+	expectLock(mock, 1)
+	expectDirty(mock, 1)
+	expectUnlock(mock)
+
+	_, err = m.Up()
+	if err != ErrDirty {
+		t.Errorf("expected %v, got %v", ErrDirty, err)
+	}
+
+	// unknown version in the database
+	expectLock(mock, 1)
+	expectVersion(mock, 42)
+	expectUnlock(mock)
+
+	_, err = m.Up()
+	if err != ErrDirty {
+		t.Errorf("expected %v, got %v", ErrDirty, err)
+	}
+
+	// another process holds the lock too long
+	expectLock(mock, 0)
+
+	_, err = m.Up()
+	if err != ErrLocked {
+		t.Errorf("expected %v, got %v", ErrLocked, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
+
+func TestDown(t *testing.T) {
+	m, mock, closeDB := testMigrator(t)
+	defer closeDB()
+
+	expectLock(mock, 1)
+	expectVersion(mock, 2)
+	for _, item := range []struct {
+		version int
+		stmt    string
+	}{{2, "DROP TABLE b"}, {1, "DROP TABLE a"}} {
+		mock.
+			ExpectExec("UPDATE schema_migrations SET applied = 0 WHERE version = ?").
+			WithArgs(item.version).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(item.stmt).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.
+			ExpectExec("DELETE FROM schema_migrations WHERE version = ?").
+			WithArgs(item.version).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	expectUnlock(mock)
+
+	rolledBack, err := m.Down(5)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(rolledBack, []int{2, 1}) {
+		t.Errorf("bad rolled back versions %v", rolledBack)
+	}
+
+	// no down file
+	expectLock(mock, 1)
+	expectVersion(mock, 3)
+	expectUnlock(mock)
+
+	_, err = m.Down(1)
+	if err != ErrNoDown {
+		t.Errorf("expected %v, got %v", ErrNoDown, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
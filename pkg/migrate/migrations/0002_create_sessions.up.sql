@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `sessions` (
+  `id` varchar(64) NOT NULL,
+  `userId` varchar(64) NOT NULL,
+  `expires` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `userId` (`userId`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
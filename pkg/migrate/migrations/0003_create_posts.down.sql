@@ -0,0 +1,3 @@
+DROP TABLE `post_comments`;
+DROP TABLE `post_votes`;
+DROP TABLE `posts`;
@@ -0,0 +1,40 @@
+CREATE TABLE `posts` (
+  `id` varchar(36) NOT NULL,
+  `title` varchar(255) NOT NULL,
+  `url` varchar(2048) NOT NULL DEFAULT '',
+  `authorId` varchar(64) NOT NULL,
+  `authorUsername` varchar(255) NOT NULL,
+  `category` varchar(64) NOT NULL,
+  `score` int NOT NULL DEFAULT 0,
+  `views` int NOT NULL DEFAULT 0,
+  `type` varchar(16) NOT NULL,
+  `text` text NOT NULL,
+  `upvotePercentage` tinyint unsigned NOT NULL DEFAULT 0,
+  `created` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `category` (`category`),
+  KEY `authorUsername` (`authorUsername`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `post_votes` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `postId` varchar(36) NOT NULL,
+  `userId` varchar(64) NOT NULL,
+  `vote` tinyint NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `postId_userId` (`postId`, `userId`),
+  CONSTRAINT `post_votes_post` FOREIGN KEY (`postId`) REFERENCES `posts` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `post_comments` (
+  `seq` bigint NOT NULL AUTO_INCREMENT,
+  `id` varchar(36) NOT NULL,
+  `postId` varchar(36) NOT NULL,
+  `authorId` varchar(64) NOT NULL,
+  `authorUsername` varchar(255) NOT NULL,
+  `body` text NOT NULL,
+  `created` bigint NOT NULL,
+  PRIMARY KEY (`seq`),
+  UNIQUE KEY `id` (`id`),
+  CONSTRAINT `post_comments_post` FOREIGN KEY (`postId`) REFERENCES `posts` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `refresh_tokens` (
+  `tokenHash` char(64) NOT NULL,
+  `sessionId` varchar(64) NOT NULL,
+  `used` tinyint(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`tokenHash`),
+  KEY `sessionId` (`sessionId`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
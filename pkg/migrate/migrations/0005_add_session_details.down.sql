@@ -0,0 +1,5 @@
+ALTER TABLE `sessions`
+  DROP COLUMN `created`,
+  DROP COLUMN `lastSeen`,
+  DROP COLUMN `userAgent`,
+  DROP COLUMN `ip`;
@@ -0,0 +1,5 @@
+ALTER TABLE `sessions`
+  ADD COLUMN `created` bigint NOT NULL DEFAULT 0,
+  ADD COLUMN `lastSeen` bigint NOT NULL DEFAULT 0,
+  ADD COLUMN `userAgent` varchar(255) NOT NULL DEFAULT '',
+  ADD COLUMN `ip` varchar(45) NOT NULL DEFAULT '';
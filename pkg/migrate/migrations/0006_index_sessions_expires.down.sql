@@ -0,0 +1 @@
+ALTER TABLE `sessions` DROP KEY `expires`;
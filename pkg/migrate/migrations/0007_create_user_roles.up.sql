@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `user_roles` (
+  `userId` int NOT NULL,
+  `role` varchar(32) NOT NULL,
+  PRIMARY KEY (`userId`, `role`),
+  CONSTRAINT `user_roles_user` FOREIGN KEY (`userId`) REFERENCES `users` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `user_identities` (
+  `issuer` varchar(255) NOT NULL,
+  `subject` varchar(255) NOT NULL,
+  `userId` int NOT NULL,
+  PRIMARY KEY (`issuer`, `subject`),
+  KEY `userId` (`userId`),
+  CONSTRAINT `user_identities_user` FOREIGN KEY (`userId`) REFERENCES `users` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `api_tokens` (
+  `id` varchar(32) NOT NULL,
+  `userId` int NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `tokenHash` char(64) NOT NULL,
+  `scopes` varchar(255) NOT NULL,
+  `created` bigint NOT NULL,
+  `lastUsed` bigint NOT NULL DEFAULT 0,
+  `expires` bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `tokenHash` (`tokenHash`),
+  KEY `userId` (`userId`),
+  CONSTRAINT `api_tokens_user` FOREIGN KEY (`userId`) REFERENCES `users` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
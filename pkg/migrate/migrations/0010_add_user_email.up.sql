@@ -0,0 +1,2 @@
+ALTER TABLE `users`
+  ADD COLUMN `email` varchar(255) NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `password_resets` (
+  `tokenHash` char(64) NOT NULL,
+  `userId` int NOT NULL,
+  `expires` bigint NOT NULL,
+  PRIMARY KEY (`tokenHash`),
+  KEY `userId` (`userId`),
+  CONSTRAINT `password_resets_user` FOREIGN KEY (`userId`) REFERENCES `users` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
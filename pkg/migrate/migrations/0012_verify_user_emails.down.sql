@@ -0,0 +1,9 @@
+DROP TABLE `email_verifications`;
+ALTER TABLE `users` DROP KEY `email`;
+UPDATE `users` JOIN `user_email_duplicates` AS `duplicate` ON `duplicate`.`userId` = `users`.`id`
+  SET `users`.`email` = `duplicate`.`email`;
+DROP TABLE `user_email_duplicates`;
+UPDATE `users` SET `email` = '' WHERE `email` IS NULL;
+ALTER TABLE `users`
+  DROP COLUMN `emailVerified`,
+  MODIFY COLUMN `email` varchar(255) NOT NULL DEFAULT '';
@@ -0,0 +1,24 @@
+ALTER TABLE `users`
+  MODIFY COLUMN `email` varchar(255) NULL DEFAULT NULL,
+  ADD COLUMN `emailVerified` tinyint(1) NOT NULL DEFAULT 0;
+UPDATE `users` SET `email` = NULL WHERE `email` = '';
+CREATE TABLE IF NOT EXISTS `user_email_duplicates` (
+  `userId` int NOT NULL,
+  `email` varchar(255) NOT NULL,
+  PRIMARY KEY (`userId`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+INSERT INTO `user_email_duplicates` (`userId`, `email`)
+  SELECT `users`.`id`, `users`.`email` FROM `users`
+  JOIN `users` AS `earlier` ON `earlier`.`email` = `users`.`email` AND `earlier`.`id` < `users`.`id`
+  GROUP BY `users`.`id`, `users`.`email`;
+UPDATE `users` JOIN `user_email_duplicates` AS `duplicate` ON `duplicate`.`userId` = `users`.`id`
+  SET `users`.`email` = NULL;
+ALTER TABLE `users` ADD UNIQUE KEY `email` (`email`);
+CREATE TABLE IF NOT EXISTS `email_verifications` (
+  `tokenHash` char(64) NOT NULL,
+  `userId` int NOT NULL,
+  `expires` bigint NOT NULL,
+  PRIMARY KEY (`tokenHash`),
+  KEY `userId` (`userId`),
+  CONSTRAINT `email_verifications_user` FOREIGN KEY (`userId`) REFERENCES `users` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
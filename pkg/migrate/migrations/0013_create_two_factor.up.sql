@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS `two_factor` (
+  `userId` int NOT NULL,
+  `secret` varchar(64) NOT NULL,
+  `enabled` tinyint(1) NOT NULL DEFAULT 0,
+  `lastCounter` bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY (`userId`),
+  CONSTRAINT `two_factor_user` FOREIGN KEY (`userId`) REFERENCES `users` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `recovery_codes` (
+  `userId` int NOT NULL,
+  `codeHash` char(64) NOT NULL,
+  PRIMARY KEY (`userId`, `codeHash`),
+  CONSTRAINT `recovery_codes_user` FOREIGN KEY (`userId`) REFERENCES `users` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `two_factor_logins` (
+  `tokenHash` char(64) NOT NULL,
+  `userId` int NOT NULL,
+  `expires` bigint NOT NULL,
+  PRIMARY KEY (`tokenHash`),
+  KEY `userId` (`userId`),
+  CONSTRAINT `two_factor_logins_user` FOREIGN KEY (`userId`) REFERENCES `users` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
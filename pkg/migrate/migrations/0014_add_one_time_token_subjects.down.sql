@@ -0,0 +1,3 @@
+ALTER TABLE `two_factor_logins` DROP COLUMN `subject`;
+ALTER TABLE `email_verifications` DROP COLUMN `subject`;
+ALTER TABLE `password_resets` DROP COLUMN `subject`;
@@ -0,0 +1,3 @@
+ALTER TABLE `password_resets` ADD COLUMN `subject` varchar(255) NOT NULL DEFAULT '' AFTER `userId`;
+ALTER TABLE `email_verifications` ADD COLUMN `subject` varchar(255) NOT NULL DEFAULT '' AFTER `userId`;
+ALTER TABLE `two_factor_logins` ADD COLUMN `subject` varchar(255) NOT NULL DEFAULT '' AFTER `userId`;
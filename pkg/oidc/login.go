@@ -0,0 +1,111 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// minSweep is the number of the pending logins the first sweep of the expired ones starts at
+const minSweep = 1024
+
+// ErrNoLogin is returned for an unknown or expired state
+var ErrNoLogin = errors.New("Login not found or expired")
+
+// Login is a sign in started at the provider and not finished yet
+type Login struct {
+	// State ties the callback to the login, it's passed through the provider
+	State string
+	// Verifier is the PKCE code verifier, only its challenge is sent to the provider
+	Verifier string
+	// Nonce ties the ID token to the login
+	Nonce   string
+	Expires time.Time
+}
+
+// NewLogin starts a new login with random state, verifier and nonce
+func NewLogin() Login {
+	return Login{
+		State:    randomString(),
+		Verifier: randomString(),
+		Nonce:    randomString(),
+	}
+}
+
+// Challenge returns the S256 PKCE code challenge of the verifier
+func (l *Login) Challenge() string {
+	sum := sha256.Sum256([]byte(l.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomString returns 256 random bits, base64url encoded as PKCE needs
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// MemoryLogins keeps the pending logins in memory, so the callback must come to the same instance
+type MemoryLogins struct {
+	mu   sync.Mutex
+	data map[string]Login
+	// nextSweep is the number of the pending logins the next sweep starts at
+	nextSweep int
+	now       func() time.Time
+	// TTL is the time the user has to sign in at the provider
+	TTL time.Duration
+}
+
+// NewMemoryLogins creates a new in-memory store of the pending logins
+func NewMemoryLogins(ttl time.Duration) *MemoryLogins {
+	return &MemoryLogins{
+		data:      make(map[string]Login),
+		nextSweep: minSweep,
+		now:       time.Now,
+		TTL:       ttl,
+	}
+}
+
+// Save stores the login until it expires
+func (s *MemoryLogins) Save(login Login) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	login.Expires = now.Add(s.TTL)
+	s.data[login.State] = login
+	if len(s.data) >= s.nextSweep {
+		s.sweep(now)
+	}
+	return nil
+}
+
+// Take returns the login by its state and forgets it, so every login finishes once only
+func (s *MemoryLogins) Take(state string) (Login, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	login, ok := s.data[state]
+	if !ok {
+		return Login{}, ErrNoLogin
+	}
+	delete(s.data, state)
+	if !s.now().Before(login.Expires) {
+		return Login{}, ErrNoLogin
+	}
+	return login, nil
+}
+
+// sweep drops the expired logins, the lock must be held
+func (s *MemoryLogins) sweep(now time.Time) {
+	for state, login := range s.data {
+		if !now.Before(login.Expires) {
+			delete(s.data, state)
+		}
+	}
+	s.nextSweep = 2 * len(s.data)
+	if s.nextSweep < minSweep {
+		s.nextSweep = minSweep
+	}
+}
@@ -0,0 +1,43 @@
+package oidc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLogins(t *testing.T) {
+	now := time.Now()
+	logins := NewMemoryLogins(10 * time.Minute)
+	logins.now = func() time.Time { return now }
+
+	login := NewLogin()
+	if login.State == login.Verifier || len(login.Verifier) != 43 {
+		t.Errorf("bad login %+v", login)
+	}
+	logins.Save(login)
+	taken, err := logins.Take(login.State)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if taken.Verifier != login.Verifier || taken.Nonce != login.Nonce {
+		t.Errorf("bad login %+v", taken)
+	}
+	if _, err = logins.Take(login.State); err != ErrNoLogin {
+		t.Errorf("login taken twice, err %v", err)
+	}
+
+	logins.Save(login)
+	now = now.Add(10 * time.Minute)
+	if _, err = logins.Take(login.State); err != ErrNoLogin {
+		t.Errorf("expired login taken, err %v", err)
+	}
+
+	for i := 0; i < minSweep-1; i++ {
+		logins.Save(NewLogin())
+	}
+	now = now.Add(10 * time.Minute)
+	logins.Save(login)
+	if len(logins.data) != 1 {
+		t.Errorf("expired logins weren't swept, %d left", len(logins.data))
+	}
+}
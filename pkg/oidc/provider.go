@@ -0,0 +1,306 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ljwt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// maxResponseSize limits the responses read from the provider
+const maxResponseSize = 1 << 20
+
+// keysRefreshInterval is the shortest time between the key set fetches,
+// an unknown key id refetches the set as the provider may have rotated its keys
+const keysRefreshInterval = time.Minute
+
+// clockSkew is the difference of the clocks tolerated checking the ID token times
+const clockSkew = time.Minute
+
+// ErrBadIDToken is returned when the ID token fails the verification
+var ErrBadIDToken = errors.New("Bad ID token")
+
+// Config configures the provider endpoints and the client registered at it
+type Config struct {
+	// Issuer is the iss claim of the ID tokens, it identifies the provider
+	Issuer   string
+	AuthURL  string
+	TokenURL string
+	JWKSURL  string
+	ClientID string
+	// ClientSecret is empty for the public clients relying on PKCE only
+	ClientSecret string
+	// RedirectURL is the callback the provider sends the users back to
+	RedirectURL string
+	Scopes      []string
+}
+
+// Identity is the user as the provider knows them
+type Identity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// Provider signs the users in with the OpenID Connect authorization code flow with PKCE
+type Provider struct {
+	Config Config
+	// Client makes the requests to the provider, http.DefaultClient is used when it's nil
+	Client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]ljwt.JWK
+	keysFetched time.Time
+	now         func() time.Time
+}
+
+// NewProvider creates a new provider with the configured endpoints
+func NewProvider(cfg Config) *Provider {
+	return &Provider{
+		Config: cfg,
+		now:    time.Now,
+	}
+}
+
+// AuthCodeURL returns the provider URL the user signs in at
+func (p *Provider) AuthCodeURL(login Login) string {
+	scopes := p.Config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {login.Challenge()},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.Config.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.Config.AuthURL + separator + params.Encode()
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems the authorization code of the login and returns the verified identity
+func (p *Provider) Exchange(ctx context.Context, code string, login Login) (*Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"code_verifier": {login.Verifier},
+	}
+	req, err := http.NewRequest("POST", p.Config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	tr := &tokenResponse{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(tr)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint answered %d: %s %s", resp.StatusCode, tr.Error, tr.ErrorDescription)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad token response: %s", err)
+	}
+	if tr.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return p.verify(ctx, tr.IDToken, login.Nonce)
+}
+
+// idTokenClaims are the claims of the ID token used to sign in
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Valid is called by jwt-go, the claims are checked against the provider config by verify
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+// audience is a single string or an array of them
+type audience []string
+
+// UnmarshalJSON accepts both forms
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	*a = list
+	return err
+}
+
+// verify checks the signature and claims of the ID token
+func (p *Provider) verify(ctx context.Context, rawToken, nonce string) (*Identity, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		jwk, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		method, publicKey, err := jwk.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return publicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrBadIDToken, err)
+	}
+
+	now := p.now()
+	switch {
+	case claims.Issuer != p.Config.Issuer:
+		return nil, fmt.Errorf("%s: unexpected issuer %q", ErrBadIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.Config.ClientID):
+		return nil, fmt.Errorf("%s: issued to %v", ErrBadIDToken, claims.Audience)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.Config.ClientID:
+		return nil, fmt.Errorf("%s: authorized party %q", ErrBadIDToken, claims.AuthorizedParty)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%s: no subject", ErrBadIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%s: nonce mismatch", ErrBadIDToken)
+	case !now.Before(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%s: expired", ErrBadIDToken)
+	case now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%s: issued in the future", ErrBadIDToken)
+	}
+	return &Identity{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the provider key by its id, the single key of the set is used for the tokens without one
+func (p *Provider) key(ctx context.Context, kid string) (ljwt.JWK, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	jwk, ok := p.findKey(kid)
+	if ok {
+		return jwk, nil
+	}
+	if p.keys != nil && p.now().Sub(p.keysFetched) < keysRefreshInterval {
+		return ljwt.JWK{}, fmt.Errorf("unknown key %q", kid)
+	}
+	err := p.fetchKeys(ctx)
+	if err != nil {
+		return ljwt.JWK{}, err
+	}
+	jwk, ok = p.findKey(kid)
+	if !ok {
+		return ljwt.JWK{}, fmt.Errorf("unknown key %q", kid)
+	}
+	return jwk, nil
+}
+
+// findKey looks the key up in the fetched set, the lock must be held
+func (p *Provider) findKey(kid string) (ljwt.JWK, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, jwk := range p.keys {
+			return jwk, true
+		}
+	}
+	jwk, ok := p.keys[kid]
+	return jwk, ok
+}
+
+// fetchKeys replaces the signing keys with the ones published by the provider, the lock must be held
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	req, err := http.NewRequest("GET", p.Config.JWKSURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+		return fmt.Errorf("jwks endpoint answered %d", resp.StatusCode)
+	}
+	set := ljwt.JWKSet{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&set)
+	if err != nil {
+		return fmt.Errorf("bad jwks: %s", err)
+	}
+
+	p.keys = make(map[string]ljwt.JWK, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use == "" || jwk.Use == "sig" {
+			p.keys[jwk.KeyID] = jwk
+		}
+	}
+	p.keysFetched = p.now()
+	return nil
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client == nil {
+		return http.DefaultClient
+	}
+	return p.Client
+}
@@ -0,0 +1,219 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ljwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// fakeProvider is a stand-in identity provider issuing the ID tokens signed with its keyring
+type fakeProvider struct {
+	server  *httptest.Server
+	keys    *ljwt.Keyring
+	manager *ljwt.Manager
+	// codes maps the issued codes to their PKCE challenges
+	codes map[string]string
+	// claims are put into the next ID token
+	claims      jwt.MapClaims
+	jwksFetches int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	fp := &fakeProvider{keys: ljwt.NewKeyring(), codes: map[string]string{}}
+	fp.keys.AddPrivateKey("k1", rsaKey)
+	fp.keys.Activate("k1")
+	fp.manager = ljwt.NewKeyringManager(fp.keys, time.Minute)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		fp.jwksFetches++
+		json.NewEncoder(w).Encode(fp.manager.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		challenge, ok := fp.codes[r.FormValue("code")]
+		verifier := Login{Verifier: r.FormValue("code_verifier")}
+		switch {
+		case id != "client" || secret != "secret":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		case !ok || challenge != verifier.Challenge() || r.FormValue("grant_type") != "authorization_code":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": fp.sign(t, fp.claims), "token_type": "Bearer"})
+	})
+	fp.server = httptest.NewServer(mux)
+	return fp
+}
+
+func (fp *fakeProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	kid, method, key, err := fp.keys.Active()
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	return signed
+}
+
+func (fp *fakeProvider) config() Config {
+	return Config{
+		Issuer:       fp.server.URL,
+		AuthURL:      fp.server.URL + "/authorize",
+		TokenURL:     fp.server.URL + "/token",
+		JWKSURL:      fp.server.URL + "/jwks",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/oidc/callback",
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := NewProvider(Config{AuthURL: "https://idp.example.com/authorize?tenant=1", ClientID: "client", RedirectURL: "http://localhost/cb"})
+	login := NewLogin()
+	u, err := url.Parse(p.AuthCodeURL(login))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	q := u.Query()
+	if q.Get("tenant") != "1" || q.Get("response_type") != "code" || q.Get("client_id") != "client" ||
+		q.Get("state") != login.State || q.Get("nonce") != login.Nonce || q.Get("scope") != "openid email profile" {
+		t.Errorf("bad auth url %s", u)
+	}
+	// the verifier itself is never sent to the authorization endpoint
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") != login.Challenge() ||
+		strings.Contains(u.String(), login.Verifier) {
+		t.Errorf("bad pkce params %s", u)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	fp := newFakeProvider(t)
+	defer fp.server.Close()
+	p := NewProvider(fp.config())
+	login := NewLogin()
+	now := time.Now()
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":                fp.server.URL,
+			"sub":                "248289761001",
+			"aud":                "client",
+			"exp":                now.Add(time.Hour).Unix(),
+			"iat":                now.Unix(),
+			"nonce":              login.Nonce,
+			"email":              "jane@example.com",
+			"email_verified":     true,
+			"preferred_username": "jane",
+		}
+	}
+
+	fp.codes["code"] = login.Challenge()
+	fp.claims = validClaims()
+	identity, err := p.Exchange(context.Background(), "code", login)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	expected := Identity{Issuer: fp.server.URL, Subject: "248289761001", Email: "jane@example.com", EmailVerified: true, PreferredUsername: "jane"}
+	if *identity != expected {
+		t.Errorf("bad identity %+v", identity)
+	}
+
+	cases := []struct {
+		name   string
+		login  Login
+		change func(jwt.MapClaims)
+	}{
+		{name: "wrong verifier", login: Login{Verifier: "other", Nonce: login.Nonce}},
+		{name: "wrong nonce", login: Login{Verifier: login.Verifier, Nonce: "other"}},
+		{name: "other issuer", login: login, change: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "other audience", login: login, change: func(c jwt.MapClaims) { c["aud"] = []string{"other", "another"} }},
+		{name: "expired", login: login, change: func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() }},
+		{name: "no subject", login: login, change: func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, c := range cases {
+		fp.claims = validClaims()
+		if c.change != nil {
+			c.change(fp.claims)
+		}
+		_, err = p.Exchange(context.Background(), "code", c.login)
+		if err == nil {
+			t.Errorf("[%s] expected error, got nil", c.name)
+		}
+	}
+
+	// several audiences are accepted when the token is authorized to the client
+	fp.claims = validClaims()
+	fp.claims["aud"] = []string{"client", "other"}
+	fp.claims["azp"] = "client"
+	if _, err = p.Exchange(context.Background(), "code", login); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	// the client must authenticate
+	p.Config.ClientSecret = "wrong"
+	if _, err = p.Exchange(context.Background(), "code", login); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestExchangeKeyRotation(t *testing.T) {
+	fp := newFakeProvider(t)
+	defer fp.server.Close()
+	p := NewProvider(fp.config())
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	login := NewLogin()
+	fp.codes["code"] = login.Challenge()
+	fp.claims = jwt.MapClaims{
+		"iss": fp.server.URL, "sub": "1", "aud": "client", "nonce": login.Nonce,
+		"exp": now.Add(time.Hour).Unix(), "iat": now.Unix(),
+	}
+	if _, err := p.Exchange(context.Background(), "code", login); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	// the token signed with a new key fetches the keys again, but not more often than the refresh interval
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	fp.keys.AddPrivateKey("k2", rsaKey)
+	fp.keys.Activate("k2")
+	if _, err := p.Exchange(context.Background(), "code", login); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	now = now.Add(keysRefreshInterval)
+	if _, err := p.Exchange(context.Background(), "code", login); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	if fp.jwksFetches != 2 {
+		t.Errorf("bad number of key set fetches %d", fp.jwksFetches)
+	}
+
+	// the token signed with an unpublished key is rejected
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, fp.claims)
+	forged.Header["kid"] = "k2"
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forgedString, _ := forged.SignedString(other)
+	if _, err := p.verify(context.Background(), forgedString, login.Nonce); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -0,0 +1,75 @@
+package onetime
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryRepo keeps the tokens of a kind in memory, it's used to run the app without MySQL
+type MemoryRepo struct {
+	mu sync.Mutex
+	// data maps the token hashes to the tokens
+	data map[string]token
+}
+
+type token struct {
+	userID  string
+	subject string
+	expires time.Time
+}
+
+// NewMemoryRepo creates a new in-memory repository of the tokens
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		data: make(map[string]token),
+	}
+}
+
+// Create creates a new token of the user valid until expires and returns it, only its hash is stored.
+// The subject is what the token is issued for, e.g. the email to verify, it's empty when the user is enough
+func (repo *MemoryRepo) Create(userID, subject string, expires time.Time) (string, error) {
+	secret := newSecret()
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	// the tokens are few and short-lived, so the expired ones are dropped on the way
+	repo.purge(time.Now())
+	repo.data[hashSecret(secret)] = token{userID: userID, subject: subject, expires: expires}
+	return secret, nil
+}
+
+// Take uses up the token and returns the id of its user with its subject, a token is taken once
+func (repo *MemoryRepo) Take(secret string) (string, string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	hash := hashSecret(secret)
+	tok, ok := repo.data[hash]
+	if !ok {
+		return "", "", ErrNoToken
+	}
+	delete(repo.data, hash)
+	if tok.expires.Unix() < time.Now().Unix() {
+		return "", "", ErrNoToken
+	}
+	return tok.userID, tok.subject, nil
+}
+
+// RevokeForUser deletes all the tokens of the user, e.g. the reset links once their password is changed
+func (repo *MemoryRepo) RevokeForUser(userID string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for hash, tok := range repo.data {
+		if tok.userID == userID {
+			delete(repo.data, hash)
+		}
+	}
+	return nil
+}
+
+// purge deletes the tokens expired before the time, the caller holds the lock
+func (repo *MemoryRepo) purge(before time.Time) {
+	for hash, tok := range repo.data {
+		if tok.expires.Unix() < before.Unix() {
+			delete(repo.data, hash)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package onetime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRepo(t *testing.T) {
+	repo := NewMemoryRepo()
+
+	secret, err := repo.Create("42", "jane@example.com", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if _, ok := repo.data[secret]; ok {
+		t.Errorf("the token is stored as is")
+	}
+	userID, subject, err := repo.Take(secret)
+	if err != nil || userID != "42" || subject != "jane@example.com" {
+		t.Errorf("bad user %q with %q, %v", userID, subject, err)
+	}
+	// a token is used once
+	if _, _, err = repo.Take(secret); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+
+	expired, _ := repo.Create("42", "", time.Now().Add(-time.Minute))
+	if _, _, err = repo.Take(expired); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+
+	first, _ := repo.Create("42", "", time.Now().Add(time.Hour))
+	other, _ := repo.Create("43", "", time.Now().Add(time.Hour))
+	if err = repo.RevokeForUser("42"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if _, _, err = repo.Take(first); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+	if userID, _, err = repo.Take(other); err != nil || userID != "43" {
+		t.Errorf("bad user %q, %v", userID, err)
+	}
+
+	// the expired tokens don't pile up
+	repo.Create("42", "", time.Now().Add(-time.Minute))
+	repo.Create("42", "", time.Now().Add(time.Hour))
+	if len(repo.data) != 1 {
+		t.Errorf("expected 1 token, got %d", len(repo.data))
+	}
+}
@@ -0,0 +1,86 @@
+package onetime
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Repo keeps the tokens of a kind in a MySQL table, all the tables have the same columns
+type Repo struct {
+	DB    *sql.DB
+	Table string
+}
+
+// NewRepo creates a new repository of the tokens in the table, e.g. PasswordResets
+func NewRepo(db *sql.DB, table string) *Repo {
+	return &Repo{DB: db, Table: table}
+}
+
+// Create creates a new token of the user valid until expires and returns it, only its hash is stored.
+// The subject is what the token is issued for, e.g. the email to verify, it's empty when the user is enough
+func (repo *Repo) Create(userID, subject string, expires time.Time) (string, error) {
+	// the expired tokens of the user are dropped on the way, so they don't pile up
+	_, err := repo.DB.Exec("DELETE FROM "+repo.Table+" WHERE userId = ? AND expires < ?", userID, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	secret := newSecret()
+	_, err = repo.DB.Exec(
+		"INSERT INTO "+repo.Table+" (`tokenHash`, `userId`, `subject`, `expires`) VALUES (?, ?, ?, ?)",
+		hashSecret(secret),
+		userID,
+		subject,
+		expires.Unix(),
+	)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Take uses up the token and returns the id of its user with its subject, a token is taken once
+func (repo *Repo) Take(secret string) (string, string, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	userID, subject, expires, err := repo.take(tx, hashSecret(secret))
+	if err != nil {
+		tx.Rollback()
+		return "", "", err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return "", "", err
+	}
+	if expires < time.Now().Unix() {
+		return "", "", ErrNoToken
+	}
+	return userID, subject, nil
+}
+
+// take deletes the token locked for the update, so the concurrent requests can't both use it
+func (repo *Repo) take(tx *sql.Tx, hash string) (string, string, int64, error) {
+	var userID, subject string
+	var expires int64
+	err := tx.
+		QueryRow("SELECT userId, subject, expires FROM "+repo.Table+" WHERE tokenHash = ? FOR UPDATE", hash).
+		Scan(&userID, &subject, &expires)
+	if err == sql.ErrNoRows {
+		return "", "", 0, ErrNoToken
+	}
+	if err != nil {
+		return "", "", 0, err
+	}
+	_, err = tx.Exec("DELETE FROM "+repo.Table+" WHERE tokenHash = ?", hash)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return userID, subject, expires, nil
+}
+
+// RevokeForUser deletes all the tokens of the user, e.g. the reset links once their password is changed
+func (repo *Repo) RevokeForUser(userID string) error {
+	_, err := repo.DB.Exec("DELETE FROM "+repo.Table+" WHERE userId = ?", userID)
+	return err
+}
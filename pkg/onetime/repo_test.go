@@ -0,0 +1,118 @@
+package onetime
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// hashArg matches any token hash and keeps it
+type hashArg struct {
+	hash *string
+}
+
+func (a hashArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.hash = s
+	return ok && len(s) == 64
+}
+
+func TestRepo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepo(db, EmailVerifications)
+	expires := time.Now().Add(time.Hour)
+
+	// only the hash of the token is stored
+	var storedHash string
+	mock.
+		ExpectExec("DELETE FROM email_verifications WHERE userId = (.+) AND expires").
+		WithArgs("42", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectExec("INSERT INTO email_verifications").
+		WithArgs(hashArg{&storedHash}, "42", "jane@example.com", expires.Unix()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	secret, err := repo.Create("42", "jane@example.com", expires)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if storedHash != hashSecret(secret) || strings.Contains(storedHash, secret) {
+		t.Errorf("bad token %s stored as %s", secret, storedHash)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT userId, subject, expires FROM email_verifications WHERE tokenHash = (.+) FOR UPDATE").
+		WithArgs(storedHash).
+		WillReturnRows(sqlmock.NewRows([]string{"userId", "subject", "expires"}).AddRow("42", "jane@example.com", expires.Unix()))
+	mock.
+		ExpectExec("DELETE FROM email_verifications WHERE tokenHash").
+		WithArgs(storedHash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	userID, subject, err := repo.Take(secret)
+	if err != nil || userID != "42" || subject != "jane@example.com" {
+		t.Errorf("bad user %q with %q, %v", userID, subject, err)
+	}
+
+	// the used token is gone
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT userId, subject, expires FROM email_verifications").
+		WithArgs(storedHash).
+		WillReturnRows(sqlmock.NewRows([]string{"userId", "subject", "expires"}))
+	mock.ExpectRollback()
+	if _, _, err = repo.Take(secret); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+
+	// the expired token is deleted but not accepted
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT userId, subject, expires FROM email_verifications").
+		WillReturnRows(sqlmock.NewRows([]string{"userId", "subject", "expires"}).AddRow("42", "", time.Now().Add(-time.Minute).Unix()))
+	mock.
+		ExpectExec("DELETE FROM email_verifications WHERE tokenHash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if _, _, err = repo.Take("expired"); err != ErrNoToken {
+		t.Errorf("expected %v, got %v", ErrNoToken, err)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT userId, subject, expires FROM email_verifications").
+		WillReturnError(fmt.Errorf("db_error"))
+	mock.ExpectRollback()
+	if _, _, err = repo.Take(secret); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	mock.
+		ExpectExec("DELETE FROM email_verifications WHERE userId").
+		WithArgs("42").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	if err = repo.RevokeForUser("42"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.
+		ExpectExec("DELETE FROM email_verifications WHERE userId").
+		WillReturnError(fmt.Errorf("db_error"))
+	if _, err = repo.Create("42", "", expires); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,32 @@
+package onetime
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+// Tables of the tokens
+const (
+	PasswordResets     = "password_resets"
+	EmailVerifications = "email_verifications"
+	TwoFactorLogins    = "two_factor_logins"
+)
+
+// ErrNoToken is returned for an unknown, used or expired token
+var ErrNoToken = errors.New("Token not found")
+
+// newSecret makes a random token, it's sent to the user and never stored
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashSecret is what is stored instead of the token itself, the tokens are random so no salt is needed
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
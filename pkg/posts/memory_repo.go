@@ -0,0 +1,205 @@
+package posts
+
+import (
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ids"
+	"sync"
+	"time"
+)
+
+// MemoryRepo keeps all the Posts in memory, it's used to run the app without MongoDB
+type MemoryRepo struct {
+	mu   sync.RWMutex
+	data []*Post
+}
+
+// NewMemoryRepo creates a new in-memory Repository for Posts
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		data: make([]*Post, 0),
+	}
+}
+
+// Get returns a Post item by ID
+func (repo *MemoryRepo) Get(id string) (*Post, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	i := repo.indexOf(id)
+	if i < 0 {
+		return nil, ErrNoPost
+	}
+	return clonePost(repo.data[i]), nil
+}
+
+// getByFilter returns copies of the posts matching the given filter
+func (repo *MemoryRepo) getByFilter(filter func(*Post) bool) ([]*Post, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	posts := []*Post{}
+	for _, post := range repo.data {
+		if filter(post) {
+			posts = append(posts, clonePost(post))
+		}
+	}
+	return posts, nil
+}
+
+// All returns all the existing posts from the Repository
+func (repo *MemoryRepo) All() ([]*Post, error) {
+	return repo.getByFilter(func(*Post) bool {
+		return true
+	})
+}
+
+// ListByCategory returns posts filtered by a respective category
+func (repo *MemoryRepo) ListByCategory(category string) ([]*Post, error) {
+	return repo.getByFilter(func(post *Post) bool {
+		return post.Category == category
+	})
+}
+
+// GetByAuthor returns posts by their author
+func (repo *MemoryRepo) GetByAuthor(login string) ([]*Post, error) {
+	return repo.getByFilter(func(post *Post) bool {
+		return post.Author.Username == login
+	})
+}
+
+// Add adds a new Post item into the Repository
+func (repo *MemoryRepo) Add(post *Post) (*Post, error) {
+	post.ID = ids.GenerateID()
+	post.Votes = []Vote{
+		{
+			User: post.Author.ID,
+			Vote: 1,
+		},
+	}
+
+	post.Score = 1
+	post.Views = 0
+
+	post.Comments = make([]Comment, 0)
+	post.Created = time.Now()
+	post.UpvotePercentage = 100
+
+	repo.mu.Lock()
+	repo.data = append(repo.data, clonePost(post))
+	repo.mu.Unlock()
+
+	return post, nil
+}
+
+// Save stores the Post as it is, replacing the existing one with the same ID
+func (repo *MemoryRepo) Save(post *Post) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.indexOf(post.ID)
+	if i < 0 {
+		repo.data = append(repo.data, clonePost(post))
+		return nil
+	}
+	repo.data[i] = clonePost(post)
+	return nil
+}
+
+// Delete removes an existing Post item from the Repository if the actor may delete it
+func (repo *MemoryRepo) Delete(id string, by Actor) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.indexOf(id)
+	if i < 0 {
+		return ErrNoPost
+	}
+	if !by.mayDelete(repo.data[i].Author.ID) {
+		return ErrNotAuthor
+	}
+	repo.data = append(repo.data[:i], repo.data[i+1:]...)
+	return nil
+}
+
+// Vote adds user's vote with either positive or negative value to a Post by Id
+func (repo *MemoryRepo) Vote(id string, v Vote) (*Post, error) {
+	return repo.update(id, func(post *Post) error {
+		applyVote(post, v)
+		return nil
+	})
+}
+
+// Unvote removes user's vote from a Post by Id
+func (repo *MemoryRepo) Unvote(id string, userID string) (*Post, error) {
+	return repo.Vote(id, Vote{
+		User: userID,
+		Vote: 0,
+	})
+}
+
+// AddComment adds a new comment to a Post
+func (repo *MemoryRepo) AddComment(postID string, comment *Comment) (*Post, error) {
+	comment.ID = ids.GenerateID()
+	return repo.update(postID, func(post *Post) error {
+		post.Comments = append(post.Comments, *comment)
+		return nil
+	})
+}
+
+// DeleteComment removes an existing comment from a Post if the actor may delete it
+func (repo *MemoryRepo) DeleteComment(postID string, commentID string, by Actor) (*Post, error) {
+	return repo.update(postID, func(post *Post) error {
+		err := checkCommentDeletion(post, commentID, by)
+		if err != nil {
+			return err
+		}
+		for i, c := range post.Comments {
+			if c.ID == commentID {
+				post.Comments[i] = post.Comments[len(post.Comments)-1]
+				post.Comments = post.Comments[:len(post.Comments)-1]
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// update applies the change to a stored Post under the write lock and returns its copy,
+// the change must leave the Post intact when it fails
+func (repo *MemoryRepo) update(id string, change func(*Post) error) (*Post, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.indexOf(id)
+	if i < 0 {
+		return nil, ErrNoPost
+	}
+	err := change(repo.data[i])
+	if err != nil {
+		return nil, err
+	}
+	return clonePost(repo.data[i]), nil
+}
+
+// indexOf must be called with the mutex held
+func (repo *MemoryRepo) indexOf(id string) int {
+	for i, post := range repo.data {
+		if post.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// clonePost makes a copy of a Post so that callers can't modify the stored one
+func clonePost(post *Post) *Post {
+	res := *post
+	if post.Votes != nil {
+		res.Votes = make([]Vote, len(post.Votes))
+		copy(res.Votes, post.Votes)
+	}
+	if post.Comments != nil {
+		res.Comments = make([]Comment, len(post.Comments))
+		copy(res.Comments, post.Comments)
+	}
+	return &res
+}
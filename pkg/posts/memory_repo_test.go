@@ -0,0 +1,274 @@
+package posts
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// behaviourRepo lists the methods shared by all the Posts repositories
+type behaviourRepo interface {
+	All() ([]*Post, error)
+	ListByCategory(string) ([]*Post, error)
+	Get(string) (*Post, error)
+	GetByAuthor(string) ([]*Post, error)
+	Add(*Post) (*Post, error)
+	AddComment(string, *Comment) (*Post, error)
+	DeleteComment(string, string, Actor) (*Post, error)
+	Delete(string, Actor) error
+	Vote(string, Vote) (*Post, error)
+	Unvote(string, string) (*Post, error)
+}
+
+// testRepoBehaviour checks the semantics every Posts repository must follow
+func testRepoBehaviour(t *testing.T, repo behaviourRepo) {
+	author := Author{Username: "author", ID: "1"}
+	voter := Author{Username: "voter", ID: "2"}
+
+	first, err := repo.Add(&Post{
+		Title:    "first",
+		Author:   author,
+		Category: "music",
+		Type:     "text",
+		Text:     "text",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error, got %v", err)
+	}
+	if first.ID == "" || first.Score != 1 || first.UpvotePercentage != 100 ||
+		len(first.Votes) != 1 || first.Votes[0].User != author.ID || len(first.Comments) != 0 {
+		t.Fatalf("bad added post %+v", first)
+	}
+
+	_, err = repo.Add(&Post{
+		Title:    "second",
+		Author:   voter,
+		Category: "funny",
+		Type:     "link",
+		Url:      "http://example.com",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error, got %v", err)
+	}
+
+	all, err := repo.All()
+	if err != nil || len(all) != 2 {
+		t.Fatalf("expected 2 posts, got %v, %v", len(all), err)
+	}
+	byCat, err := repo.ListByCategory("music")
+	if err != nil || len(byCat) != 1 || byCat[0].ID != first.ID {
+		t.Fatalf("bad posts by category %v, %v", byCat, err)
+	}
+	byAuthor, err := repo.GetByAuthor("voter")
+	if err != nil || len(byAuthor) != 1 || byAuthor[0].Title != "second" {
+		t.Fatalf("bad posts by author %v, %v", byAuthor, err)
+	}
+
+	got, err := repo.Get(first.ID)
+	if err != nil || got.Title != "first" || got.Author.Username != author.Username {
+		t.Fatalf("bad post %+v, %v", got, err)
+	}
+	if _, err = repo.Get("unknown"); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// a new vote is appended, a repeated one replaces the previous
+	post, err := repo.Vote(first.ID, Vote{User: voter.ID, Vote: -1})
+	if err != nil || post.Score != 0 || post.UpvotePercentage != 50 || len(post.Votes) != 2 {
+		t.Fatalf("bad downvoted post %+v, %v", post, err)
+	}
+	post, err = repo.Vote(first.ID, Vote{User: voter.ID, Vote: 1})
+	if err != nil || post.Score != 2 || post.UpvotePercentage != 100 || len(post.Votes) != 2 {
+		t.Fatalf("bad upvoted post %+v, %v", post, err)
+	}
+	post, err = repo.Unvote(first.ID, voter.ID)
+	if err != nil || post.Score != 1 || post.UpvotePercentage != 50 || len(post.Votes) != 2 {
+		t.Fatalf("bad unvoted post %+v, %v", post, err)
+	}
+	if _, err = repo.Vote("unknown", Vote{User: voter.ID, Vote: 1}); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	comment := &Comment{Author: voter, Body: "comment"}
+	post, err = repo.AddComment(first.ID, comment)
+	if err != nil || len(post.Comments) != 1 || comment.ID == "" ||
+		post.Comments[0].ID != comment.ID || post.Comments[0].Body != "comment" {
+		t.Fatalf("bad commented post %+v, %v", post, err)
+	}
+	if _, err = repo.AddComment("unknown", &Comment{Author: voter, Body: "comment"}); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	// only the comment author or an admin may delete a comment, even the post author may not
+	if _, err = repo.DeleteComment(first.ID, comment.ID, Actor{UserID: author.ID}); err != ErrNotAuthor {
+		t.Fatalf("expected ErrNotAuthor, got %v", err)
+	}
+	if _, err = repo.DeleteComment(first.ID, "unknown", Actor{UserID: voter.ID}); err != ErrNoComment {
+		t.Fatalf("expected ErrNoComment, got %v", err)
+	}
+	if _, err = repo.DeleteComment("unknown", comment.ID, Actor{UserID: voter.ID}); err != ErrNoPost {
+		t.Fatalf("expected ErrNoPost, got %v", err)
+	}
+	post, err = repo.DeleteComment(first.ID, comment.ID, Actor{UserID: voter.ID})
+	if err != nil || len(post.Comments) != 0 {
+		t.Fatalf("bad post after comment deletion %+v, %v", post, err)
+	}
+	if _, err = repo.AddComment(first.ID, comment); err != nil {
+		t.Fatalf("unexpected error, got %v", err)
+	}
+	post, err = repo.DeleteComment(first.ID, comment.ID, Actor{UserID: "admin", Moderator: true})
+	if err != nil || len(post.Comments) != 0 {
+		t.Fatalf("bad post after comment deletion by admin %+v, %v", post, err)
+	}
+
+	if err = repo.Delete(first.ID, Actor{UserID: voter.ID}); err != ErrNotAuthor {
+		t.Fatalf("expected ErrNotAuthor, got %v", err)
+	}
+	if err = repo.Delete(first.ID, Actor{}); err != ErrNotAuthor {
+		t.Fatalf("expected ErrNotAuthor, got %v", err)
+	}
+	if err = repo.Delete("unknown", Actor{UserID: author.ID}); err != ErrNoPost {
+		t.Fatalf("expected ErrNoPost, got %v", err)
+	}
+	err = repo.Delete(first.ID, Actor{UserID: author.ID})
+	if err != nil {
+		t.Fatalf("unexpected error, got %v", err)
+	}
+	if _, err = repo.Get(first.ID); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	all, err = repo.All()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("expected 1 post, got %v, %v", len(all), err)
+	}
+}
+
+// testRepoConcurrentUpdates makes sure that concurrent votes and comments don't get lost
+func testRepoConcurrentUpdates(t *testing.T, repo behaviourRepo) {
+	post, err := repo.Add(&Post{
+		Title:  "popular",
+		Author: Author{Username: "author", ID: "author"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error, got %v", err)
+	}
+
+	// the updates failed under contention are retried like the clients are told to
+	retry := func(update func() error) error {
+		err := update()
+		for err == ErrConcurrentUpdate {
+			err = update()
+		}
+		return err
+	}
+	n := 50
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			err := retry(func() error {
+				_, err := repo.Vote(post.ID, Vote{User: strconv.Itoa(i), Vote: 1})
+				return err
+			})
+			if err != nil {
+				t.Errorf("unexpected error, got %v", err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			err := retry(func() error {
+				_, err := repo.AddComment(post.ID, &Comment{Body: strconv.Itoa(i)})
+				return err
+			})
+			if err != nil {
+				t.Errorf("unexpected error, got %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	res, err := repo.Get(post.ID)
+	if err != nil {
+		t.Fatalf("unexpected error, got %v", err)
+	}
+	if len(res.Votes) != n+1 || res.Score != n+1 {
+		t.Errorf("lost votes: expected %d, got %d votes and score %d", n+1, len(res.Votes), res.Score)
+	}
+	if len(res.Comments) != n {
+		t.Errorf("lost comments: expected %d, got %d", n, len(res.Comments))
+	}
+}
+
+// testRepoSave checks that saved posts are stored as they are and saving is idempotent
+func testRepoSave(t *testing.T, repo interface {
+	behaviourRepo
+	Save(*Post) error
+}) {
+	post := &Post{
+		ID:       "5d0c1b429ba1376cd1f27220",
+		Title:    "imported",
+		Author:   Author{Username: "author", ID: "1"},
+		Category: "news",
+		Score:    2,
+		Views:    100,
+		Votes: []Vote{
+			{User: "1", Vote: 1},
+			{User: "legacy", Vote: 1},
+		},
+		Comments: []Comment{
+			{ID: "c1", Author: Author{Username: "commenter", ID: "2"}, Body: "body", Created: time.Unix(1561076346, 0)},
+		},
+		Created:          time.Unix(1561076000, 0),
+		UpvotePercentage: 100,
+	}
+
+	for i := 0; i < 2; i++ {
+		err := repo.Save(post)
+		if err != nil {
+			t.Fatalf("unexpected error, got %v", err)
+		}
+	}
+
+	all, err := repo.All()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("expected 1 post, got %v, %v", len(all), err)
+	}
+	got := all[0]
+	if got.ID != post.ID || got.Score != 2 || got.Views != 100 || len(got.Votes) != 2 ||
+		len(got.Comments) != 1 || got.Comments[0].ID != "c1" || !got.Created.Equal(post.Created) {
+		t.Fatalf("bad saved post %+v", got)
+	}
+
+	// the saved post works like any other one
+	got, err = repo.Vote(post.ID, Vote{User: "legacy", Vote: -1})
+	if err != nil || got.Score != 0 || got.Views != 100 {
+		t.Fatalf("bad voted post %+v, %v", got, err)
+	}
+}
+
+func TestMemoryRepo(t *testing.T) {
+	testRepoBehaviour(t, NewMemoryRepo())
+}
+
+func TestMemoryRepoSave(t *testing.T) {
+	testRepoSave(t, NewMemoryRepo())
+}
+
+func TestMemoryRepoConcurrentUpdates(t *testing.T) {
+	testRepoConcurrentUpdates(t, NewMemoryRepo())
+}
+
+func TestMemoryRepoReturnsCopies(t *testing.T) {
+	repo := NewMemoryRepo()
+	post, _ := repo.Add(&Post{Title: "title"})
+
+	post.Title = "changed"
+	got, _ := repo.Get(post.ID)
+	got.Votes[0].Vote = -1
+
+	got, _ = repo.Get(post.ID)
+	if got.Title != "title" || got.Votes[0].Vote != 1 {
+		t.Errorf("stored post was modified from outside: %+v", got)
+	}
+}
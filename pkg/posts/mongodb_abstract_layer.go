@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // mockgen command:
@@ -19,6 +20,9 @@ type IMongoCollection interface {
 	InsertOne(ctx context.Context, item interface{}) (IMongoInsertOneResult, error)
 	DeleteOne(ctx context.Context, filter interface{}) (IMongoDeleteResult, error)
 	ReplaceOne(ctx context.Context, filter interface{}, replacement interface{}) (IMongoUpdateResult, error)
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}) (IMongoUpdateResult, error)
+	// FindOneAndUpdate atomically applies the update and returns the updated document
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}) IMongoSingleResult
 }
 
 type IMongoSingleResult interface {
@@ -40,7 +44,7 @@ type IMongoDeleteResult interface {
 }
 
 type IMongoUpdateResult interface {
-	// Decode(v interface{}) error
+	MatchedCount() int64
 }
 
 type MongoCollection struct {
@@ -67,9 +71,12 @@ type MongoUpdateResult struct {
 	ur *mongo.UpdateResult
 }
 
-// func (mu *MongoUpdateResult) Decode(val interface{}) error {
-// 	return mu.ur. //Decode(val)
-// }
+func (mur *MongoUpdateResult) MatchedCount() int64 {
+	if mur.ur == nil {
+		return 0
+	}
+	return mur.ur.MatchedCount
+}
 
 func (msr *MongoSingleResult) Decode(v interface{}) error {
 	return msr.sr.Decode(v)
@@ -115,3 +122,14 @@ func (mc *MongoCollection) ReplaceOne(ctx context.Context, filter interface{}, r
 	updateResult, err := mc.Сoll.ReplaceOne(ctx, filter, replacement)
 	return &MongoUpdateResult{ur: updateResult}, err
 }
+
+func (mc *MongoCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}) (IMongoUpdateResult, error) {
+	updateResult, err := mc.Сoll.UpdateOne(ctx, filter, update)
+	return &MongoUpdateResult{ur: updateResult}, err
+}
+
+func (mc *MongoCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}) IMongoSingleResult {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	singleResult := mc.Сoll.FindOneAndUpdate(ctx, filter, update, opts)
+	return &MongoSingleResult{sr: singleResult}
+}
@@ -144,6 +144,35 @@ func (mr *MockIMongoCollectionMockRecorder) ReplaceOne(ctx, filter, replacement
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceOne", reflect.TypeOf((*MockIMongoCollection)(nil).ReplaceOne), ctx, filter, replacement)
 }
 
+// UpdateOne mocks base method
+func (m *MockIMongoCollection) UpdateOne(ctx context.Context, filter, update interface{}) (IMongoUpdateResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateOne", ctx, filter, update)
+	ret0, _ := ret[0].(IMongoUpdateResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateOne indicates an expected call of UpdateOne
+func (mr *MockIMongoCollectionMockRecorder) UpdateOne(ctx, filter, update interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOne", reflect.TypeOf((*MockIMongoCollection)(nil).UpdateOne), ctx, filter, update)
+}
+
+// FindOneAndUpdate mocks base method
+func (m *MockIMongoCollection) FindOneAndUpdate(ctx context.Context, filter, update interface{}) IMongoSingleResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindOneAndUpdate", ctx, filter, update)
+	ret0, _ := ret[0].(IMongoSingleResult)
+	return ret0
+}
+
+// FindOneAndUpdate indicates an expected call of FindOneAndUpdate
+func (mr *MockIMongoCollectionMockRecorder) FindOneAndUpdate(ctx, filter, update interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindOneAndUpdate", reflect.TypeOf((*MockIMongoCollection)(nil).FindOneAndUpdate), ctx, filter, update)
+}
+
 // MockIMongoSingleResult is a mock of IMongoSingleResult interface
 type MockIMongoSingleResult struct {
 	ctrl     *gomock.Controller
@@ -314,3 +343,17 @@ func NewMockIMongoUpdateResult(ctrl *gomock.Controller) *MockIMongoUpdateResult
 func (m *MockIMongoUpdateResult) EXPECT() *MockIMongoUpdateResultMockRecorder {
 	return m.recorder
 }
+
+// MatchedCount mocks base method
+func (m *MockIMongoUpdateResult) MatchedCount() int64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MatchedCount")
+	ret0, _ := ret[0].(int64)
+	return ret0
+}
+
+// MatchedCount indicates an expected call of MatchedCount
+func (mr *MockIMongoUpdateResultMockRecorder) MatchedCount() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MatchedCount", reflect.TypeOf((*MockIMongoUpdateResult)(nil).MatchedCount))
+}
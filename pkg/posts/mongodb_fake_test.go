@@ -0,0 +1,284 @@
+package posts
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeCollection is an in-memory stand-in for a MongoDB collection. It understands
+// just the filters and update operators used by Repo, applying each call atomically
+type fakeCollection struct {
+	mu   sync.Mutex
+	docs []bson.M
+}
+
+func newFakeCollection() *fakeCollection {
+	return &fakeCollection{}
+}
+
+type fakeSingleResult struct {
+	doc bson.M
+	err error
+}
+
+type fakeCursor struct {
+	docs []bson.M
+	cur  int
+}
+
+type fakeUpdateResult struct {
+	matched int64
+}
+
+func (sr *fakeSingleResult) Decode(v interface{}) error {
+	if sr.err != nil {
+		return sr.err
+	}
+	return decodeDoc(sr.doc, v)
+}
+
+func (c *fakeCursor) Close(context.Context) error {
+	return nil
+}
+
+func (c *fakeCursor) Next(context.Context) bool {
+	c.cur++
+	return c.cur <= len(c.docs)
+}
+
+func (c *fakeCursor) Decode(v interface{}) error {
+	return decodeDoc(c.docs[c.cur-1], v)
+}
+
+func (ur *fakeUpdateResult) MatchedCount() int64 {
+	return ur.matched
+}
+
+func (fc *fakeCollection) Find(ctx context.Context, filter interface{}) (IMongoCursor, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	f := toDoc(filter)
+	docs := []bson.M{}
+	for _, doc := range fc.docs {
+		if matches(doc, f) {
+			docs = append(docs, copyDoc(doc))
+		}
+	}
+	return &fakeCursor{docs: docs}, nil
+}
+
+func (fc *fakeCollection) FindOne(ctx context.Context, filter interface{}) IMongoSingleResult {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	i := fc.indexOf(toDoc(filter))
+	if i < 0 {
+		return &fakeSingleResult{err: mongo.ErrNoDocuments}
+	}
+	return &fakeSingleResult{doc: copyDoc(fc.docs[i])}
+}
+
+func (fc *fakeCollection) InsertOne(ctx context.Context, item interface{}) (IMongoInsertOneResult, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.docs = append(fc.docs, toDoc(item))
+	return nil, nil
+}
+
+func (fc *fakeCollection) DeleteOne(ctx context.Context, filter interface{}) (IMongoDeleteResult, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	i := fc.indexOf(toDoc(filter))
+	if i >= 0 {
+		fc.docs = append(fc.docs[:i], fc.docs[i+1:]...)
+	}
+	return nil, nil
+}
+
+func (fc *fakeCollection) ReplaceOne(ctx context.Context, filter interface{}, replacement interface{}) (IMongoUpdateResult, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	i := fc.indexOf(toDoc(filter))
+	if i < 0 {
+		return &fakeUpdateResult{}, nil
+	}
+	doc := toDoc(replacement)
+	doc["_id"] = fc.docs[i]["_id"]
+	fc.docs[i] = doc
+	return &fakeUpdateResult{matched: 1}, nil
+}
+
+func (fc *fakeCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}) (IMongoUpdateResult, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	i := fc.indexOf(toDoc(filter))
+	if i < 0 {
+		return &fakeUpdateResult{}, nil
+	}
+	applyUpdate(fc.docs[i], toDoc(update))
+	return &fakeUpdateResult{matched: 1}, nil
+}
+
+func (fc *fakeCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}) IMongoSingleResult {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	i := fc.indexOf(toDoc(filter))
+	if i < 0 {
+		return &fakeSingleResult{err: mongo.ErrNoDocuments}
+	}
+	applyUpdate(fc.docs[i], toDoc(update))
+	return &fakeSingleResult{doc: copyDoc(fc.docs[i])}
+}
+
+func (fc *fakeCollection) indexOf(filter bson.M) int {
+	for i, doc := range fc.docs {
+		if matches(doc, filter) {
+			return i
+		}
+	}
+	return -1
+}
+
+// toDoc turns anything the driver accepts as a document into a bson.M
+func toDoc(v interface{}) bson.M {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	doc := bson.M{}
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+func copyDoc(doc bson.M) bson.M {
+	return toDoc(doc)
+}
+
+func decodeDoc(doc bson.M, v interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
+
+// asDoc converts nested documents which are decoded either as M or as D
+func asDoc(v interface{}) (bson.M, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case primitive.D:
+		return d.Map(), true
+	}
+	return nil, false
+}
+
+// lookup finds a value by a dotted path
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		d, ok := asDoc(cur)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = d[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func matches(doc bson.M, filter bson.M) bool {
+	for path, expected := range filter {
+		actual, found := lookup(doc, path)
+		if cond, ok := asDoc(expected); ok {
+			if in, ok := cond["$in"].(primitive.A); ok {
+				if !matchesAny(actual, found, in) {
+					return false
+				}
+				continue
+			}
+		}
+		if !found || !equal(actual, expected) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(actual interface{}, found bool, values primitive.A) bool {
+	for _, v := range values {
+		if v == nil && (!found || actual == nil) {
+			return true
+		}
+		if found && equal(actual, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	an, aok := number(a)
+	bn, bok := number(b)
+	if aok && bok {
+		return an == bn
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func number(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func applyUpdate(doc bson.M, update bson.M) {
+	for op, arg := range update {
+		fields, _ := asDoc(arg)
+		for field, value := range fields {
+			switch op {
+			case "$set":
+				doc[field] = value
+			case "$inc":
+				cur, _ := number(doc[field])
+				inc, _ := number(value)
+				doc[field] = cur + inc
+			case "$push":
+				arr, _ := doc[field].(primitive.A)
+				doc[field] = append(arr, value)
+			case "$pull":
+				cond, _ := asDoc(value)
+				arr, _ := doc[field].(primitive.A)
+				res := primitive.A{}
+				for _, item := range arr {
+					if itemDoc, ok := asDoc(item); ok && matches(itemDoc, cond) {
+						continue
+					}
+					res = append(res, item)
+				}
+				doc[field] = res
+			default:
+				panic("unsupported update operator " + op)
+			}
+		}
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"time"
 )
 
-// Post struct which contains full information about posts
+// Post struct which contains full information about posts.
+// Version is bumped on every update of the stored document to detect concurrent changes
 type Post struct {
 	ID               string    `json:"id,omitempty" bson:"_id,omitempty"`
 	Title            string    `json:"title" bson:"title"`
 	Url              string    `json:"url" bson:"url"`
-	Author           user.User `json:"author" bson:"author"`
+	Author           Author    `json:"author" bson:"author"`
 	Category         string    `json:"category" bson:"category"`
 	Score            int       `json:"score" bson:"score"`
 	Votes            []Vote    `json:"votes" bson:"votes"`
@@ -20,6 +21,18 @@ type Post struct {
 	Type             string    `json:"type" bson:"type"`
 	Text             string    `json:"text" bson:"text"`
 	UpvotePercentage uint8     `json:"upvotePercentage" bson:"upvotePercentage"`
+	Version          int       `json:"-" bson:"version"`
+}
+
+// Author is the public part of the user who wrote a post or a comment, the same in every storage
+type Author struct {
+	Username string `json:"username" bson:"username"`
+	ID       string `json:"id" bson:"id"`
+}
+
+// NewAuthor takes the public part of the user
+func NewAuthor(u *user.User) Author {
+	return Author{Username: u.Username, ID: u.ID}
 }
 
 // Vote counts votes from users
@@ -28,10 +41,21 @@ type Vote struct {
 	Vote int    `json:"vote"`
 }
 
+// Actor is the user deleting a post or a comment, the moderators may delete anyone's
+type Actor struct {
+	UserID    string
+	Moderator bool
+}
+
+// mayDelete reports whether the actor may delete the content of the author
+func (a Actor) mayDelete(authorID string) bool {
+	return a.Moderator || (a.UserID != "" && a.UserID == authorID)
+}
+
 // Comment object
 type Comment struct {
 	ID      string    `json:"id"`
-	Author  user.User `json:"author"`
+	Author  Author    `json:"author"`
 	Body    string    `json:"body"`
 	Created time.Time `json:"created"`
 }
@@ -6,6 +6,7 @@ import (
 	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ids"
 	"time"
 
+	"go.mongodb.org/mongo-driver/mongo"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -17,8 +18,17 @@ type Repo struct {
 var (
 	// ErrNoPost is used to indicate that a post doesn't exist
 	ErrNoPost = errors.New("Post not found")
+	// ErrConcurrentUpdate is returned when a post keeps changing while being updated
+	ErrConcurrentUpdate = errors.New("Post was updated concurrently, try again")
+	// ErrNoComment is used to indicate that a comment doesn't exist
+	ErrNoComment = errors.New("Comment not found")
+	// ErrNotAuthor is returned when a user deletes a post or comment of another user
+	ErrNotAuthor = errors.New("Only the author can delete it")
 )
 
+// maxUpdateRetries limits the attempts to update a post changed concurrently
+const maxUpdateRetries = 10
+
 // NewRepo creates a new Repository for Posts
 func NewRepo(collection IMongoCollection) *Repo {
 	return &Repo{
@@ -101,46 +111,68 @@ func (repo *Repo) Add(post *Post) (*Post, error) {
 	return post, nil
 }
 
-// Delete removes an existing Post item from the Repository
-func (repo *Repo) Delete(id string, userID string) error {
-	filter := bson.M{"id": id}
-
+// Save stores the Post as it is, replacing the existing one with the same ID.
+// It's used to load posts created elsewhere, e.g. by the seed import
+func (repo *Repo) Save(post *Post) error {
 	ctx := context.Background()
-	_, err := repo.Collection.DeleteOne(ctx, filter)
+	res, err := repo.Collection.ReplaceOne(ctx, bson.M{"_id": post.ID}, post)
 	if err != nil {
-		return ErrNoPost
+		return err
+	}
+	if res.MatchedCount() == 0 {
+		_, err = repo.Collection.InsertOne(ctx, post)
 	}
-	return nil
+	return err
 }
 
-// Vote adds user's vote with either positive or negative value to a Post by Id
-func (repo *Repo) Vote(id string, v Vote) (*Post, error) {
-	filter := bson.M{"id": id}
-	ctx := context.Background()
+// Delete removes an existing Post item from the Repository if the actor may delete it
+func (repo *Repo) Delete(id string, by Actor) error {
 	post, err := repo.Get(id)
-	if err != nil {
-		return nil, ErrNoPost
+	if err == mongo.ErrNoDocuments {
+		return ErrNoPost
 	}
-	flag := true
-	for i, vote := range post.Votes {
-		if vote.User == v.User {
-			post.Votes[i].Vote = v.Vote
-			recount(post)
-			flag = false
-			break
-		}
+	if err != nil {
+		return err
 	}
-	if flag {
-		post.Votes = append(post.Votes, v)
-		recount(post)
+	if !by.mayDelete(post.Author.ID) {
+		return ErrNotAuthor
 	}
 
-	_, err = repo.Collection.ReplaceOne(ctx, filter, post)
-	if err != nil {
-		return nil, err
-	}
+	ctx := context.Background()
+	_, err = repo.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
 
-	return post, nil
+// Vote adds user's vote with either positive or negative value to a Post by Id.
+// The votes are updated only if nobody has changed the Post since it was read,
+// otherwise the vote is applied again to the fresh copy
+func (repo *Repo) Vote(id string, v Vote) (*Post, error) {
+	ctx := context.Background()
+	for i := 0; i < maxUpdateRetries; i++ {
+		post, err := repo.Get(id)
+		if err != nil {
+			return nil, ErrNoPost
+		}
+		applyVote(post, v)
+
+		update := bson.M{
+			"$set": bson.M{
+				"votes":            post.Votes,
+				"score":            post.Score,
+				"upvotePercentage": post.UpvotePercentage,
+			},
+			"$inc": bson.M{"version": 1},
+		}
+		res, err := repo.Collection.UpdateOne(ctx, versionFilter(id, post.Version), update)
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount() == 1 {
+			post.Version++
+			return post, nil
+		}
+	}
+	return nil, ErrConcurrentUpdate
 }
 
 // Unvote removes user's vote from a Post by Id
@@ -151,49 +183,86 @@ func (repo *Repo) Unvote(id string, userID string) (*Post, error) {
 	})
 }
 
-// // AddComment adds a new comment to a Post
+// AddComment adds a new comment to a Post
 func (repo *Repo) AddComment(postID string, comment *Comment) (*Post, error) {
 	comment.ID = ids.GenerateID()
-	filter := bson.M{"id": postID}
-	ctx := context.Background()
+	update := bson.M{
+		"$push": bson.M{"comments": comment},
+		"$inc":  bson.M{"version": 1},
+	}
+	return repo.findAndUpdate(postID, update)
+}
 
+// DeleteComment removes an existing comment from a Post if the actor may delete it
+func (repo *Repo) DeleteComment(postID string, commentID string, by Actor) (*Post, error) {
 	post, err := repo.Get(postID)
-	if err != nil {
+	if err == mongo.ErrNoDocuments {
 		return nil, ErrNoPost
 	}
-
-	post.Comments = append(post.Comments, *comment)
-
-	_, err = repo.Collection.ReplaceOne(ctx, filter, post)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCommentDeletion(post, commentID, by)
 	if err != nil {
 		return nil, err
 	}
 
-	return post, nil
+	update := bson.M{
+		"$pull": bson.M{"comments": bson.M{"id": commentID}},
+		"$inc":  bson.M{"version": 1},
+	}
+	return repo.findAndUpdate(postID, update)
 }
 
-// DeleteComment removes an existing comment from a Post
-func (repo *Repo) DeleteComment(postID string, commentID string) (*Post, error) {
-	filter := bson.M{"id": postID}
+// findAndUpdate atomically applies the update to a Post and returns the updated Post
+func (repo *Repo) findAndUpdate(id string, update interface{}) (*Post, error) {
+	post := &Post{}
 	ctx := context.Background()
-	post, err := repo.Get(postID)
-	if err != nil {
+	err := repo.Collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update).Decode(post)
+	if err == mongo.ErrNoDocuments {
 		return nil, ErrNoPost
 	}
-	for i, c := range post.Comments {
-		if c.ID == commentID {
-			post.Comments[i] = post.Comments[len(post.Comments)-1]
-			post.Comments = post.Comments[:len(post.Comments)-1]
-			break
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// checkCommentDeletion checks that the comment exists and the actor may delete it,
+// the authors never change, so it's checked before deleting
+func checkCommentDeletion(post *Post, commentID string, by Actor) error {
+	for _, c := range post.Comments {
+		if c.ID != commentID {
+			continue
 		}
+		if !by.mayDelete(c.Author.ID) {
+			return ErrNotAuthor
+		}
+		return nil
 	}
+	return ErrNoComment
+}
 
-	_, err = repo.Collection.ReplaceOne(ctx, filter, post)
-	if err != nil {
-		return nil, err
+// versionFilter matches a Post only if it still has the given version,
+// the documents created before versioning was introduced have none
+func versionFilter(id string, version int) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "version": bson.M{"$in": []interface{}{0, nil}}}
 	}
+	return bson.M{"_id": id, "version": version}
+}
 
-	return post, nil
+// applyVote sets the user's vote on a Post and recounts its score
+func applyVote(post *Post, v Vote) {
+	for i, vote := range post.Votes {
+		if vote.User == v.User {
+			post.Votes[i].Vote = v.Vote
+			recount(post)
+			return
+		}
+	}
+	post.Votes = append(post.Votes, v)
+	recount(post)
 }
 
 func recount(post *Post) {
@@ -3,13 +3,14 @@ package posts
 import (
 	"context"
 	"errors"
-	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
 	"time"
 
 	"reflect"
 	"testing"
 
 	gomock "github.com/golang/mock/gomock"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/mgo.v2/bson"
 )
 
 // go test -coverprofile=cover.out && go tool cover -html=cover.out -o cover.html
@@ -33,7 +34,7 @@ func TestGet(t *testing.T) {
 		ID:       postID,
 		Type:     "text",
 		Category: "programming",
-		Author: user.User{
+		Author: Author{
 			Username: "userlogin",
 			ID:       "userid",
 		},
@@ -93,7 +94,7 @@ func TestGetByAuthor(t *testing.T) {
 		ID:       postID,
 		Type:     "text",
 		Category: "programming",
-		Author: user.User{
+		Author: Author{
 			Username: username,
 			ID:       "userid",
 		},
@@ -183,7 +184,7 @@ func TestGetAll(t *testing.T) {
 		ID:       postID,
 		Type:     "text",
 		Category: "programming",
-		Author: user.User{
+		Author: Author{
 			Username: "userlogin",
 			ID:       "userid",
 		},
@@ -240,7 +241,7 @@ func TestListByCategory(t *testing.T) {
 		ID:       postID,
 		Type:     "text",
 		Category: category,
-		Author: user.User{
+		Author: Author{
 			Username: "userlogin",
 			ID:       "userid",
 		},
@@ -293,7 +294,7 @@ func TestAdd(t *testing.T) {
 		ID:       postID,
 		Type:     "text",
 		Category: "programming",
-		Author: user.User{
+		Author: Author{
 			Username: "userlogin",
 			ID:       "userid",
 		},
@@ -333,6 +334,7 @@ func TestDelete(t *testing.T) {
 	ctx := context.Background()
 
 	mockCollection := NewMockIMongoCollection(ctrl)
+	mockSingleResult := NewMockIMongoSingleResult(ctrl)
 	mockDeleteResult := NewMockIMongoDeleteResult(ctrl)
 
 	repo := &Repo{
@@ -345,29 +347,68 @@ func TestDelete(t *testing.T) {
 		ID:       postID,
 		Type:     "text",
 		Category: "programming",
-		Author: user.User{
+		Author: Author{
 			Username: "userlogin",
 			ID:       "userid",
 		},
 	}
 
-	// positive outcome (no err on delete operation)
-	mockCollection.EXPECT().
-		DeleteOne(ctx, gomock.Any()).
-		Return(mockDeleteResult, nil)
+	expectFind := func(times int) {
+		mockCollection.EXPECT().
+			FindOne(ctx, bson.M{"_id": postID}).
+			Return(mockSingleResult).
+			Times(times)
+		mockSingleResult.EXPECT().
+			Decode(gomock.AssignableToTypeOf(expectedPost)).
+			SetArg(0, *expectedPost).
+			Return(nil).
+			Times(times)
+	}
 
-	err := repo.Delete(postID, expectedPost.Author.ID)
+	// positive outcome (no err on delete operation), the author and an admin may delete
+	expectFind(2)
+	mockCollection.EXPECT().
+		DeleteOne(ctx, bson.M{"_id": postID}).
+		Return(mockDeleteResult, nil).
+		Times(2)
 
+	err := repo.Delete(postID, Actor{UserID: expectedPost.Author.ID})
 	if err != nil {
 		t.Errorf("unexpected error, got %v", err)
 	}
+	err = repo.Delete(postID, Actor{UserID: "admin", Moderator: true})
+	if err != nil {
+		t.Errorf("unexpected error, got %v", err)
+	}
+
+	// another user
+	expectFind(1)
+
+	err = repo.Delete(postID, Actor{UserID: "otherid"})
+	if err != ErrNotAuthor {
+		t.Errorf("expected ErrNotAuthor, got %v", err)
+	}
+
+	// no post
+	mockCollection.EXPECT().
+		FindOne(ctx, gomock.Any()).
+		Return(mockSingleResult)
+	mockSingleResult.EXPECT().
+		Decode(gomock.Any()).
+		Return(mongo.ErrNoDocuments)
+
+	err = repo.Delete(postID, Actor{UserID: expectedPost.Author.ID})
+	if err != ErrNoPost {
+		t.Errorf("expected ErrNoPost, got %v", err)
+	}
 
 	// delete error
+	expectFind(1)
 	mockCollection.EXPECT().
 		DeleteOne(ctx, gomock.Any()).
 		Return(nil, errors.New("mocked-error"))
 
-	err = repo.Delete(postID, expectedPost.Author.ID)
+	err = repo.Delete(postID, Actor{UserID: expectedPost.Author.ID})
 
 	if err == nil {
 		t.Errorf("expected error, got nil")
@@ -396,11 +437,27 @@ func TestVote(t *testing.T) {
 		Vote: 1,
 	}
 
+	inputPost := &Post{
+		ID:       postID,
+		Type:     "text",
+		Category: "programming",
+		Author: Author{
+			Username: username,
+			ID:       "userid",
+		},
+		Votes: []Vote{
+			vote,
+		},
+		Score:            1,
+		UpvotePercentage: 100,
+		Version:          3,
+	}
+
 	expectedPost := &Post{
 		ID:       postID,
 		Type:     "text",
 		Category: "programming",
-		Author: user.User{
+		Author: Author{
 			Username: username,
 			ID:       "userid",
 		},
@@ -409,6 +466,7 @@ func TestVote(t *testing.T) {
 		},
 		Score:            1,
 		UpvotePercentage: 100,
+		Version:          4,
 	}
 
 	// positive outcome
@@ -417,11 +475,14 @@ func TestVote(t *testing.T) {
 		Return(mockSingleResult)
 	mockSingleResult.EXPECT().
 		Decode(gomock.AssignableToTypeOf(expectedPost)).
-		SetArg(0, *expectedPost).
+		SetArg(0, *inputPost).
 		Return(nil)
 	mockCollection.EXPECT().
-		ReplaceOne(ctx, gomock.Any(), gomock.Any()).
+		UpdateOne(ctx, versionFilter(postID, 3), gomock.Any()).
 		Return(mockUpdateResult, nil)
+	mockUpdateResult.EXPECT().
+		MatchedCount().
+		Return(int64(1))
 
 	res, err := repo.Vote(postID, vote)
 
@@ -443,11 +504,14 @@ func TestVote(t *testing.T) {
 		Return(mockSingleResult)
 	mockSingleResult.EXPECT().
 		Decode(gomock.AssignableToTypeOf(expectedPost)).
-		SetArg(0, *expectedPost).
+		SetArg(0, *inputPost).
 		Return(nil)
 	mockCollection.EXPECT().
-		ReplaceOne(ctx, gomock.Any(), gomock.Any()).
+		UpdateOne(ctx, gomock.Any(), gomock.Any()).
 		Return(mockUpdateResult, nil)
+	mockUpdateResult.EXPECT().
+		MatchedCount().
+		Return(int64(1))
 
 	res, err = repo.Vote(postID, vote2)
 
@@ -461,6 +525,73 @@ func TestVote(t *testing.T) {
 		t.Errorf("unexpected error, got %v", err)
 	}
 
+	// the post was changed concurrently, the vote is applied to its fresh copy
+	concurrentPost := *inputPost
+	concurrentPost.Votes = []Vote{vote, {User: "userlogin3", Vote: -1}}
+	concurrentPost.Score = 0
+	concurrentPost.Version = 4
+
+	mockCollection.EXPECT().
+		FindOne(ctx, gomock.Any()).
+		Return(mockSingleResult)
+	mockSingleResult.EXPECT().
+		Decode(gomock.AssignableToTypeOf(expectedPost)).
+		SetArg(0, *inputPost).
+		Return(nil)
+	mockCollection.EXPECT().
+		UpdateOne(ctx, versionFilter(postID, 3), gomock.Any()).
+		Return(mockUpdateResult, nil)
+	mockUpdateResult.EXPECT().
+		MatchedCount().
+		Return(int64(0))
+	mockCollection.EXPECT().
+		FindOne(ctx, gomock.Any()).
+		Return(mockSingleResult)
+	mockSingleResult.EXPECT().
+		Decode(gomock.AssignableToTypeOf(expectedPost)).
+		SetArg(0, concurrentPost).
+		Return(nil)
+	mockCollection.EXPECT().
+		UpdateOne(ctx, versionFilter(postID, 4), gomock.Any()).
+		Return(mockUpdateResult, nil)
+	mockUpdateResult.EXPECT().
+		MatchedCount().
+		Return(int64(1))
+
+	res, err = repo.Vote(postID, vote2)
+
+	if err != nil {
+		t.Errorf("unexpected error, got %v", err)
+	}
+	if len(res.Votes) != 3 || res.Score != 1 || res.Version != 5 {
+		t.Errorf("bad result, got %v", res)
+	}
+
+	// the post keeps changing
+	mockCollection.EXPECT().
+		FindOne(ctx, gomock.Any()).
+		Return(mockSingleResult).
+		Times(maxUpdateRetries)
+	mockSingleResult.EXPECT().
+		Decode(gomock.AssignableToTypeOf(expectedPost)).
+		SetArg(0, *inputPost).
+		Return(nil).
+		Times(maxUpdateRetries)
+	mockCollection.EXPECT().
+		UpdateOne(ctx, gomock.Any(), gomock.Any()).
+		Return(mockUpdateResult, nil).
+		Times(maxUpdateRetries)
+	mockUpdateResult.EXPECT().
+		MatchedCount().
+		Return(int64(0)).
+		Times(maxUpdateRetries)
+
+	_, err = repo.Vote(postID, vote)
+
+	if err != ErrConcurrentUpdate {
+		t.Errorf("expected %v, got %v", ErrConcurrentUpdate, err)
+	}
+
 	// repo.Get error inside the method
 	mockCollection.EXPECT().
 		FindOne(ctx, gomock.Any()).
@@ -476,16 +607,16 @@ func TestVote(t *testing.T) {
 		return
 	}
 
-	// repo.ReplaceOne error inside the method
+	// repo.UpdateOne error inside the method
 	mockCollection.EXPECT().
 		FindOne(ctx, gomock.Any()).
 		Return(mockSingleResult)
 	mockSingleResult.EXPECT().
 		Decode(gomock.AssignableToTypeOf(expectedPost)).
-		SetArg(0, *expectedPost).
+		SetArg(0, *inputPost).
 		Return(nil)
 	mockCollection.EXPECT().
-		ReplaceOne(ctx, gomock.Any(), gomock.Any()).
+		UpdateOne(ctx, gomock.Any(), gomock.Any()).
 		Return(nil, errors.New("mocked-error"))
 
 	_, err = repo.Vote(postID, vote)
@@ -532,7 +663,7 @@ func TestUnvote(t *testing.T) {
 		ID:       postID,
 		Type:     "text",
 		Category: "programming",
-		Author: user.User{
+		Author: Author{
 			Username: username,
 			ID:       "userid",
 		},
@@ -542,13 +673,14 @@ func TestUnvote(t *testing.T) {
 		},
 		Score:            1,
 		UpvotePercentage: 50,
+		Version:          1,
 	}
 
 	inputPost := &Post{
 		ID:       postID,
 		Type:     "text",
 		Category: "programming",
-		Author: user.User{
+		Author: Author{
 			Username: username,
 			ID:       "userid",
 		},
@@ -569,8 +701,11 @@ func TestUnvote(t *testing.T) {
 		SetArg(0, *inputPost).
 		Return(nil)
 	mockCollection.EXPECT().
-		ReplaceOne(ctx, gomock.Any(), gomock.Any()).
+		UpdateOne(ctx, versionFilter(postID, 0), gomock.Any()).
 		Return(mockUpdateResult, nil)
+	mockUpdateResult.EXPECT().
+		MatchedCount().
+		Return(int64(1))
 
 	res, err := repo.Unvote(postID, username2)
 
@@ -590,7 +725,6 @@ func TestAddComment(t *testing.T) {
 
 	mockCollection := NewMockIMongoCollection(ctrl)
 	mockSingleResult := NewMockIMongoSingleResult(ctrl)
-	mockUpdateResult := NewMockIMongoUpdateResult(ctrl)
 
 	repo := &Repo{
 		Collection: mockCollection,
@@ -600,7 +734,7 @@ func TestAddComment(t *testing.T) {
 	commentID := "1234"
 	username := "userlogin"
 
-	user := user.User{
+	user := Author{
 		Username: username,
 		ID:       "userid",
 	}
@@ -612,14 +746,6 @@ func TestAddComment(t *testing.T) {
 		Created: time.Now(),
 	}
 
-	inputPost := &Post{
-		ID:       postID,
-		Type:     "text",
-		Category: "programming",
-		Author:   user,
-		Comments: []Comment{},
-	}
-
 	expectedPost := &Post{
 		ID:       postID,
 		Type:     "text",
@@ -632,15 +758,12 @@ func TestAddComment(t *testing.T) {
 
 	// positive outcome
 	mockCollection.EXPECT().
-		FindOne(ctx, gomock.Any()).
+		FindOneAndUpdate(ctx, bson.M{"_id": postID}, gomock.Any()).
 		Return(mockSingleResult)
 	mockSingleResult.EXPECT().
-		Decode(gomock.AssignableToTypeOf(inputPost)).
-		SetArg(0, *inputPost).
+		Decode(gomock.AssignableToTypeOf(expectedPost)).
+		SetArg(0, *expectedPost).
 		Return(nil)
-	mockCollection.EXPECT().
-		ReplaceOne(ctx, gomock.Any(), gomock.Any()).
-		Return(mockUpdateResult, nil)
 
 	res, err := repo.AddComment(postID, &comment)
 
@@ -654,32 +777,28 @@ func TestAddComment(t *testing.T) {
 		t.Errorf("unexpected error, got %v", err)
 	}
 
-	// repo.Get error inside the method
+	// no such post
 	mockCollection.EXPECT().
-		FindOne(ctx, gomock.Any()).
+		FindOneAndUpdate(ctx, gomock.Any(), gomock.Any()).
 		Return(mockSingleResult)
 	mockSingleResult.EXPECT().
 		Decode(gomock.Any()).
-		Return(errors.New("mocked-error"))
+		Return(mongo.ErrNoDocuments)
 
 	_, err = repo.AddComment(postID, &comment)
 
-	if err == nil {
-		t.Errorf("expected error, got nil")
+	if err != ErrNoPost {
+		t.Errorf("expected %v, got %v", ErrNoPost, err)
 		return
 	}
 
-	// repo.ReplaceOne error inside the method
+	// update error
 	mockCollection.EXPECT().
-		FindOne(ctx, gomock.Any()).
+		FindOneAndUpdate(ctx, gomock.Any(), gomock.Any()).
 		Return(mockSingleResult)
 	mockSingleResult.EXPECT().
-		Decode(gomock.AssignableToTypeOf(expectedPost)).
-		SetArg(0, *expectedPost).
-		Return(nil)
-	mockCollection.EXPECT().
-		ReplaceOne(ctx, gomock.Any(), gomock.Any()).
-		Return(nil, errors.New("mocked-error"))
+		Decode(gomock.Any()).
+		Return(errors.New("mocked-error"))
 
 	_, err = repo.AddComment(postID, &comment)
 
@@ -697,7 +816,6 @@ func TestDeleteComment(t *testing.T) {
 
 	mockCollection := NewMockIMongoCollection(ctrl)
 	mockSingleResult := NewMockIMongoSingleResult(ctrl)
-	mockUpdateResult := NewMockIMongoUpdateResult(ctrl)
 
 	repo := &Repo{
 		Collection: mockCollection,
@@ -707,18 +825,11 @@ func TestDeleteComment(t *testing.T) {
 	commentID := "1234"
 	username := "userlogin"
 
-	user := user.User{
+	user := Author{
 		Username: username,
 		ID:       "userid",
 	}
 
-	comment := Comment{
-		ID:      commentID,
-		Author:  user,
-		Body:    "comment",
-		Created: time.Now(),
-	}
-
 	expectedPost := &Post{
 		ID:       postID,
 		Type:     "text",
@@ -726,30 +837,30 @@ func TestDeleteComment(t *testing.T) {
 		Author:   user,
 		Comments: []Comment{},
 	}
+	commentedPost := *expectedPost
+	commentedPost.Comments = []Comment{{ID: commentID, Author: user, Body: "comment"}}
 
-	inputPost := &Post{
-		ID:       postID,
-		Type:     "text",
-		Category: "programming",
-		Author:   user,
-		Comments: []Comment{
-			comment,
-		},
+	expectFind := func() {
+		mockCollection.EXPECT().
+			FindOne(ctx, bson.M{"_id": postID}).
+			Return(mockSingleResult)
+		mockSingleResult.EXPECT().
+			Decode(gomock.AssignableToTypeOf(expectedPost)).
+			SetArg(0, commentedPost).
+			Return(nil)
 	}
 
 	// positive outcome
+	expectFind()
 	mockCollection.EXPECT().
-		FindOne(ctx, gomock.Any()).
+		FindOneAndUpdate(ctx, bson.M{"_id": postID}, gomock.Any()).
 		Return(mockSingleResult)
 	mockSingleResult.EXPECT().
-		Decode(gomock.AssignableToTypeOf(inputPost)).
-		SetArg(0, *inputPost).
+		Decode(gomock.AssignableToTypeOf(expectedPost)).
+		SetArg(0, *expectedPost).
 		Return(nil)
-	mockCollection.EXPECT().
-		ReplaceOne(ctx, gomock.Any(), gomock.Any()).
-		Return(mockUpdateResult, nil)
 
-	res, err := repo.DeleteComment(postID, commentID)
+	res, err := repo.DeleteComment(postID, commentID, Actor{UserID: user.ID})
 
 	if !reflect.DeepEqual(res, expectedPost) {
 		t.Errorf("bad result, expected %v, got %v", expectedPost, res)
@@ -758,37 +869,47 @@ func TestDeleteComment(t *testing.T) {
 		t.Errorf("unexpected error, got %v", err)
 	}
 
-	// repo.Get error inside the method
+	// another user
+	expectFind()
+
+	_, err = repo.DeleteComment(postID, commentID, Actor{UserID: "otherid"})
+	if err != ErrNotAuthor {
+		t.Errorf("expected ErrNotAuthor, got %v", err)
+	}
+
+	// no comment
+	expectFind()
+
+	_, err = repo.DeleteComment(postID, "unknown", Actor{UserID: user.ID})
+	if err != ErrNoComment {
+		t.Errorf("expected ErrNoComment, got %v", err)
+	}
+
+	// update error
+	expectFind()
 	mockCollection.EXPECT().
-		FindOne(ctx, gomock.Any()).
+		FindOneAndUpdate(ctx, gomock.Any(), gomock.Any()).
 		Return(mockSingleResult)
 	mockSingleResult.EXPECT().
 		Decode(gomock.Any()).
 		Return(errors.New("mocked-error"))
 
-	_, err = repo.DeleteComment(postID, commentID)
+	_, err = repo.DeleteComment(postID, commentID, Actor{UserID: user.ID})
 
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
 	}
+}
 
-	// repo.ReplaceOne error inside the method
-	mockCollection.EXPECT().
-		FindOne(ctx, gomock.Any()).
-		Return(mockSingleResult)
-	mockSingleResult.EXPECT().
-		Decode(gomock.AssignableToTypeOf(expectedPost)).
-		SetArg(0, *expectedPost).
-		Return(nil)
-	mockCollection.EXPECT().
-		ReplaceOne(ctx, gomock.Any(), gomock.Any()).
-		Return(nil, errors.New("mocked-error"))
+func TestRepoBehaviour(t *testing.T) {
+	testRepoBehaviour(t, NewRepo(newFakeCollection()))
+}
 
-	_, err = repo.DeleteComment(postID, commentID)
+func TestRepoConcurrentUpdates(t *testing.T) {
+	testRepoConcurrentUpdates(t, NewRepo(newFakeCollection()))
+}
 
-	if err == nil {
-		t.Errorf("expected error, got nil")
-		return
-	}
+func TestRepoSave(t *testing.T) {
+	testRepoSave(t, NewRepo(newFakeCollection()))
 }
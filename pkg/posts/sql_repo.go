@@ -0,0 +1,375 @@
+package posts
+
+import (
+	"database/sql"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ids"
+	"strings"
+	"time"
+)
+
+// SQLRepo keeps the Posts in MySQL, the votes and comments live in their own tables
+// (see the migrate package)
+type SQLRepo struct {
+	DB *sql.DB
+}
+
+// NewSQLRepo creates a new MySQL Repository for Posts
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{DB: db}
+}
+
+const selectPosts = "SELECT id, title, url, authorId, authorUsername, category, score, views, type, text, upvotePercentage, created FROM posts"
+
+// Get returns a Post item by ID
+func (repo *SQLRepo) Get(id string) (*Post, error) {
+	posts, err := repo.getByQuery(selectPosts+" WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, ErrNoPost
+	}
+	return posts[0], nil
+}
+
+// getByQuery returns the posts selected by the query together with their votes and comments
+func (repo *SQLRepo) getByQuery(query string, args ...interface{}) ([]*Post, error) {
+	rows, err := repo.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []*Post{}
+	byID := make(map[string]*Post)
+	for rows.Next() {
+		post := &Post{
+			Votes:    []Vote{},
+			Comments: []Comment{},
+		}
+		var created int64
+		err = rows.Scan(&post.ID, &post.Title, &post.Url, &post.Author.ID, &post.Author.Username,
+			&post.Category, &post.Score, &post.Views, &post.Type, &post.Text, &post.UpvotePercentage, &created)
+		if err != nil {
+			return nil, err
+		}
+		post.Created = fromUnixNano(created)
+		posts = append(posts, post)
+		byID[post.ID] = post
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return posts, nil
+	}
+
+	err = repo.loadVotes(posts, byID)
+	if err != nil {
+		return nil, err
+	}
+	err = repo.loadComments(posts, byID)
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// loadVotes fills in the votes of the given posts
+func (repo *SQLRepo) loadVotes(posts []*Post, byID map[string]*Post) error {
+	inClause, args := postIDs(posts)
+	rows, err := repo.DB.Query("SELECT postId, userId, vote FROM post_votes WHERE postId IN ("+inClause+") ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID string
+		v := Vote{}
+		err = rows.Scan(&postID, &v.User, &v.Vote)
+		if err != nil {
+			return err
+		}
+		if post, ok := byID[postID]; ok {
+			post.Votes = append(post.Votes, v)
+		}
+	}
+	return rows.Err()
+}
+
+// loadComments fills in the comments of the given posts
+func (repo *SQLRepo) loadComments(posts []*Post, byID map[string]*Post) error {
+	inClause, args := postIDs(posts)
+	rows, err := repo.DB.Query("SELECT id, postId, authorId, authorUsername, body, created FROM post_comments WHERE postId IN ("+inClause+") ORDER BY seq", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID string
+		var created int64
+		c := Comment{}
+		err = rows.Scan(&c.ID, &postID, &c.Author.ID, &c.Author.Username, &c.Body, &created)
+		if err != nil {
+			return err
+		}
+		c.Created = fromUnixNano(created)
+		if post, ok := byID[postID]; ok {
+			post.Comments = append(post.Comments, c)
+		}
+	}
+	return rows.Err()
+}
+
+// All returns all the existing posts from the Repository
+func (repo *SQLRepo) All() ([]*Post, error) {
+	return repo.getByQuery(selectPosts + " ORDER BY created")
+}
+
+// ListByCategory returns posts filtered by a respective category
+func (repo *SQLRepo) ListByCategory(category string) ([]*Post, error) {
+	return repo.getByQuery(selectPosts+" WHERE category = ? ORDER BY created", category)
+}
+
+// GetByAuthor returns posts by their author
+func (repo *SQLRepo) GetByAuthor(login string) ([]*Post, error) {
+	return repo.getByQuery(selectPosts+" WHERE authorUsername = ? ORDER BY created", login)
+}
+
+// Add adds a new Post item into the Repository
+func (repo *SQLRepo) Add(post *Post) (*Post, error) {
+	post.ID = ids.GenerateID()
+	post.Votes = []Vote{
+		{
+			User: post.Author.ID,
+			Vote: 1,
+		},
+	}
+
+	post.Score = 1
+	post.Views = 0
+
+	post.Comments = make([]Comment, 0)
+	post.Created = time.Now()
+	post.UpvotePercentage = 100
+
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	err = insertPost(tx, post)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return post, nil
+}
+
+// Save stores the Post as it is together with its votes and comments,
+// replacing the existing one with the same ID
+func (repo *SQLRepo) Save(post *Post) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM posts WHERE id = ?", post.ID)
+	if err == nil {
+		err = insertPost(tx, post)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertPost inserts a Post with its votes and comments
+func insertPost(tx *sql.Tx, post *Post) error {
+	_, err := tx.Exec(
+		"INSERT INTO posts (`id`, `title`, `url`, `authorId`, `authorUsername`, `category`, `score`, `views`, `type`, `text`, `upvotePercentage`, `created`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		post.ID,
+		post.Title,
+		post.Url,
+		post.Author.ID,
+		post.Author.Username,
+		post.Category,
+		post.Score,
+		post.Views,
+		post.Type,
+		post.Text,
+		post.UpvotePercentage,
+		toUnixNano(post.Created),
+	)
+	if err != nil {
+		return err
+	}
+	for _, v := range post.Votes {
+		_, err = tx.Exec(
+			"INSERT INTO post_votes (`postId`, `userId`, `vote`) VALUES (?, ?, ?)",
+			post.ID,
+			v.User,
+			v.Vote,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range post.Comments {
+		_, err = tx.Exec(
+			"INSERT INTO post_comments (`id`, `postId`, `authorId`, `authorUsername`, `body`, `created`) VALUES (?, ?, ?, ?, ?, ?)",
+			c.ID,
+			post.ID,
+			c.Author.ID,
+			c.Author.Username,
+			c.Body,
+			toUnixNano(c.Created),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes an existing Post item from the Repository if the actor may delete it,
+// its votes and comments go with it
+func (repo *SQLRepo) Delete(id string, by Actor) error {
+	var authorID string
+	err := repo.DB.QueryRow("SELECT authorId FROM posts WHERE id = ?", id).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		return ErrNoPost
+	}
+	if err != nil {
+		return err
+	}
+	if !by.mayDelete(authorID) {
+		return ErrNotAuthor
+	}
+
+	result, err := repo.DB.Exec("DELETE FROM posts WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoPost
+	}
+	return nil
+}
+
+// Vote adds user's vote with either positive or negative value to a Post by Id.
+// The score is recounted by the database itself so concurrent votes can't get lost
+func (repo *SQLRepo) Vote(id string, v Vote) (*Post, error) {
+	_, err := repo.DB.Exec(
+		"INSERT INTO post_votes (`postId`, `userId`, `vote`) SELECT id, ?, ? FROM posts WHERE id = ? "+
+			"ON DUPLICATE KEY UPDATE `vote` = VALUES(`vote`)",
+		v.User,
+		v.Vote,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = repo.DB.Exec(
+		"UPDATE posts SET "+
+			"`score` = (SELECT COALESCE(SUM(vote), 0) FROM post_votes WHERE postId = ?), "+
+			"`upvotePercentage` = (SELECT COALESCE(FLOOR(100 * SUM(vote = 1) / COUNT(*)), 0) FROM post_votes WHERE postId = ?) "+
+			"WHERE id = ?",
+		id,
+		id,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Get(id)
+}
+
+// Unvote removes user's vote from a Post by Id
+func (repo *SQLRepo) Unvote(id string, userID string) (*Post, error) {
+	return repo.Vote(id, Vote{
+		User: userID,
+		Vote: 0,
+	})
+}
+
+// AddComment adds a new comment to a Post
+func (repo *SQLRepo) AddComment(postID string, comment *Comment) (*Post, error) {
+	comment.ID = ids.GenerateID()
+
+	result, err := repo.DB.Exec(
+		"INSERT INTO post_comments (`id`, `postId`, `authorId`, `authorUsername`, `body`, `created`) SELECT ?, id, ?, ?, ?, ? FROM posts WHERE id = ?",
+		comment.ID,
+		comment.Author.ID,
+		comment.Author.Username,
+		comment.Body,
+		toUnixNano(comment.Created),
+		postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrNoPost
+	}
+	return repo.Get(postID)
+}
+
+// DeleteComment removes an existing comment from a Post if the actor may delete it
+func (repo *SQLRepo) DeleteComment(postID string, commentID string, by Actor) (*Post, error) {
+	post, err := repo.Get(postID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCommentDeletion(post, commentID, by)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.DB.Exec("DELETE FROM post_comments WHERE id = ? AND postId = ?", commentID, postID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Get(postID)
+}
+
+// postIDs builds the placeholders and arguments for an IN clause
+func postIDs(posts []*Post) (string, []interface{}) {
+	placeholders := make([]string, 0, len(posts))
+	args := make([]interface{}, 0, len(posts))
+	for _, post := range posts {
+		placeholders = append(placeholders, "?")
+		args = append(args, post.ID)
+	}
+	return strings.Join(placeholders, ", "), args
+}
+
+// toUnixNano converts time for storing, the zero time is kept as 0
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano is the reverse of toUnixNano
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,686 @@
+package posts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/migrate"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+var postColumns = []string{"id", "title", "url", "authorId", "authorUsername", "category", "score", "views", "type", "text", "upvotePercentage", "created"}
+
+func TestSQLGet(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewSQLRepo(db)
+	created := time.Unix(0, time.Now().UnixNano())
+
+	expectedPost := &Post{
+		ID:       "12345",
+		Title:    "title",
+		Type:     "text",
+		Text:     "text",
+		Category: "programming",
+		Score:    1,
+		Views:    3,
+		Created:  created,
+		Votes: []Vote{
+			{User: "1", Vote: 1},
+			{User: "2", Vote: 0},
+		},
+		Comments: []Comment{
+			{ID: "c1", Body: "body", Created: created},
+		},
+		UpvotePercentage: 50,
+	}
+	expectedPost.Author.ID = "1"
+	expectedPost.Author.Username = "userlogin"
+	expectedPost.Comments[0].Author.ID = "2"
+	expectedPost.Comments[0].Author.Username = "commenter"
+
+	// good query
+	mock.
+		ExpectQuery("SELECT id, title, url, authorId, authorUsername, category, score, views, type, text, upvotePercentage, created FROM posts WHERE id = ?").
+		WithArgs(expectedPost.ID).
+		WillReturnRows(sqlmock.NewRows(postColumns).
+			AddRow("12345", "title", "", "1", "userlogin", "programming", 1, 3, "text", "text", 50, created.UnixNano()))
+	mock.
+		ExpectQuery("SELECT postId, userId, vote FROM post_votes WHERE postId IN").
+		WithArgs(expectedPost.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"postId", "userId", "vote"}).
+			AddRow("12345", "1", 1).
+			AddRow("12345", "2", 0))
+	mock.
+		ExpectQuery("SELECT id, postId, authorId, authorUsername, body, created FROM post_comments WHERE postId IN").
+		WithArgs(expectedPost.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "postId", "authorId", "authorUsername", "body", "created"}).
+			AddRow("c1", "12345", "2", "commenter", "body", created.UnixNano()))
+
+	post, err := repo.Get(expectedPost.ID)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(post, expectedPost) {
+		t.Errorf("results not match, want %v, have %v", expectedPost, post)
+		return
+	}
+
+	// no such post
+	mock.
+		ExpectQuery("SELECT id, title, url").
+		WithArgs(expectedPost.ID).
+		WillReturnRows(sqlmock.NewRows(postColumns))
+
+	_, err = repo.Get(expectedPost.ID)
+	if err != ErrNoPost {
+		t.Errorf("expected %v, got %v", ErrNoPost, err)
+		return
+	}
+
+	// query db error
+	mock.
+		ExpectQuery("SELECT id, title, url").
+		WithArgs(expectedPost.ID).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, err = repo.Get(expectedPost.ID)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	// votes query error
+	mock.
+		ExpectQuery("SELECT id, title, url").
+		WithArgs(expectedPost.ID).
+		WillReturnRows(sqlmock.NewRows(postColumns).
+			AddRow("12345", "title", "", "1", "userlogin", "programming", 1, 3, "text", "text", 50, created.UnixNano()))
+	mock.
+		ExpectQuery("SELECT postId, userId, vote FROM post_votes").
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, err = repo.Get(expectedPost.ID)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
+
+func TestSQLListByCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewSQLRepo(db)
+
+	mock.
+		ExpectQuery("FROM posts WHERE category = ?").
+		WithArgs("music").
+		WillReturnRows(sqlmock.NewRows(postColumns).
+			AddRow("1", "first", "", "1", "userlogin", "music", 1, 0, "text", "text", 100, 1).
+			AddRow("2", "second", "", "1", "userlogin", "music", 1, 0, "text", "text", 100, 2))
+	mock.
+		ExpectQuery("SELECT postId, userId, vote FROM post_votes WHERE postId IN \\(\\?, \\?\\)").
+		WithArgs("1", "2").
+		WillReturnRows(sqlmock.NewRows([]string{"postId", "userId", "vote"}).
+			AddRow("1", "1", 1).
+			AddRow("2", "1", 1))
+	mock.
+		ExpectQuery("SELECT id, postId, authorId, authorUsername, body, created FROM post_comments WHERE postId IN \\(\\?, \\?\\)").
+		WithArgs("1", "2").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "postId", "authorId", "authorUsername", "body", "created"}))
+
+	posts, err := repo.ListByCategory("music")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+	if len(posts) != 2 || len(posts[0].Votes) != 1 || len(posts[1].Votes) != 1 || len(posts[1].Comments) != 0 {
+		t.Errorf("bad result %v", posts)
+		return
+	}
+
+	// empty result doesn't query votes and comments
+	mock.
+		ExpectQuery("FROM posts WHERE authorUsername = ?").
+		WithArgs("nobody").
+		WillReturnRows(sqlmock.NewRows(postColumns))
+
+	posts, err = repo.GetByAuthor("nobody")
+	if err != nil || len(posts) != 0 {
+		t.Errorf("bad result %v, %v", posts, err)
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
+
+func TestSQLAdd(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewSQLRepo(db)
+	post := &Post{Title: "title", Type: "text", Text: "text", Category: "music"}
+	post.Author.ID = "1"
+	post.Author.Username = "userlogin"
+
+	// good query
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("INSERT INTO posts").
+		WithArgs(sqlmock.AnyArg(), "title", "", "1", "userlogin", "music", 1, 0, "text", "text", 100, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO post_votes").
+		WithArgs(sqlmock.AnyArg(), "1", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	res, err := repo.Add(post)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+	if res.ID == "" || res.Score != 1 || len(res.Votes) != 1 || res.Created.IsZero() {
+		t.Errorf("bad result %v", res)
+		return
+	}
+
+	// insert error rolls the transaction back
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("INSERT INTO posts").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO post_votes").
+		WillReturnError(fmt.Errorf("db_error"))
+	mock.ExpectRollback()
+
+	_, err = repo.Add(post)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
+
+func TestSQLSave(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewSQLRepo(db)
+	post := &Post{
+		ID:       "12345",
+		Title:    "title",
+		Score:    2,
+		Views:    10,
+		Votes:    []Vote{{User: "1", Vote: 1}, {User: "2", Vote: 1}},
+		Comments: []Comment{{ID: "c1", Body: "body"}},
+	}
+
+	// good query
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("DELETE FROM posts WHERE id = ?").
+		WithArgs("12345").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO posts").
+		WithArgs("12345", "title", "", "", "", "", 2, 10, "", "", 0, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO post_votes").
+		WithArgs("12345", "1", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("INSERT INTO post_votes").
+		WithArgs("12345", "2", 1).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.
+		ExpectExec("INSERT INTO post_comments").
+		WithArgs("c1", "12345", "", "", "body", 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = repo.Save(post)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// delete error
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("DELETE FROM posts WHERE id = ?").
+		WillReturnError(fmt.Errorf("db_error"))
+	mock.ExpectRollback()
+
+	err = repo.Save(post)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
+
+func TestSQLDelete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewSQLRepo(db)
+	expectAuthor := func() {
+		mock.
+			ExpectQuery("SELECT authorId FROM posts WHERE id = ?").
+			WithArgs("12345").
+			WillReturnRows(sqlmock.NewRows([]string{"authorId"}).AddRow("1"))
+	}
+
+	expectAuthor()
+	mock.
+		ExpectExec("DELETE FROM posts WHERE id = ?").
+		WithArgs("12345").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	err = repo.Delete("12345", Actor{UserID: "1"})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// an admin deletes anyone's post
+	expectAuthor()
+	mock.
+		ExpectExec("DELETE FROM posts WHERE id = ?").
+		WithArgs("12345").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	err = repo.Delete("12345", Actor{UserID: "2", Moderator: true})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// another user
+	expectAuthor()
+	err = repo.Delete("12345", Actor{UserID: "2"})
+	if err != ErrNotAuthor {
+		t.Errorf("expected %v, got %v", ErrNotAuthor, err)
+		return
+	}
+
+	// no post
+	mock.
+		ExpectQuery("SELECT authorId FROM posts WHERE id = ?").
+		WithArgs("12345").
+		WillReturnRows(sqlmock.NewRows([]string{"authorId"}))
+	err = repo.Delete("12345", Actor{UserID: "1"})
+	if err != ErrNoPost {
+		t.Errorf("expected %v, got %v", ErrNoPost, err)
+		return
+	}
+
+	// deleted concurrently
+	expectAuthor()
+	mock.
+		ExpectExec("DELETE FROM posts WHERE id = ?").
+		WithArgs("12345").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	err = repo.Delete("12345", Actor{UserID: "1"})
+	if err != ErrNoPost {
+		t.Errorf("expected %v, got %v", ErrNoPost, err)
+		return
+	}
+
+	expectAuthor()
+	mock.
+		ExpectExec("DELETE FROM posts WHERE id = ?").
+		WithArgs("12345").
+		WillReturnError(fmt.Errorf("db_error"))
+	err = repo.Delete("12345", Actor{UserID: "1"})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
+
+func TestSQLVote(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewSQLRepo(db)
+
+	// good query
+	mock.
+		ExpectExec("INSERT INTO post_votes .* ON DUPLICATE KEY UPDATE").
+		WithArgs("2", 0, "12345").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.
+		ExpectExec("UPDATE posts SET").
+		WithArgs("12345", "12345", "12345").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectQuery("FROM posts WHERE id = ?").
+		WithArgs("12345").
+		WillReturnRows(sqlmock.NewRows(postColumns).
+			AddRow("12345", "title", "", "1", "userlogin", "music", 1, 0, "text", "text", 50, 1))
+	mock.
+		ExpectQuery("FROM post_votes").
+		WillReturnRows(sqlmock.NewRows([]string{"postId", "userId", "vote"}).
+			AddRow("12345", "1", 1).
+			AddRow("12345", "2", 0))
+	mock.
+		ExpectQuery("FROM post_comments").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "postId", "authorId", "authorUsername", "body", "created"}))
+
+	post, err := repo.Unvote("12345", "2")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if post.Score != 1 || post.UpvotePercentage != 50 || len(post.Votes) != 2 {
+		t.Errorf("bad result %v", post)
+		return
+	}
+
+	// insert error
+	mock.
+		ExpectExec("INSERT INTO post_votes").
+		WillReturnError(fmt.Errorf("db_error"))
+	_, err = repo.Vote("12345", Vote{User: "2", Vote: 1})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	// recount error
+	mock.
+		ExpectExec("INSERT INTO post_votes").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("UPDATE posts SET").
+		WillReturnError(fmt.Errorf("db_error"))
+	_, err = repo.Vote("12345", Vote{User: "2", Vote: 1})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
+
+func TestSQLComments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewSQLRepo(db)
+	comment := &Comment{Body: "body"}
+	comment.Author.ID = "2"
+	comment.Author.Username = "commenter"
+
+	// good add
+	mock.
+		ExpectExec("INSERT INTO post_comments").
+		WithArgs(sqlmock.AnyArg(), "2", "commenter", "body", 0, "12345").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectQuery("FROM posts WHERE id = ?").
+		WithArgs("12345").
+		WillReturnRows(sqlmock.NewRows(postColumns).
+			AddRow("12345", "title", "", "1", "userlogin", "music", 1, 0, "text", "text", 100, 1))
+	mock.
+		ExpectQuery("FROM post_votes").
+		WillReturnRows(sqlmock.NewRows([]string{"postId", "userId", "vote"}).
+			AddRow("12345", "1", 1))
+	mock.
+		ExpectQuery("FROM post_comments").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "postId", "authorId", "authorUsername", "body", "created"}).
+			AddRow("c1", "12345", "2", "commenter", "body", 0))
+
+	post, err := repo.AddComment("12345", comment)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if comment.ID == "" || len(post.Comments) != 1 || !post.Comments[0].Created.IsZero() {
+		t.Errorf("bad result %v", post)
+		return
+	}
+
+	// no such post
+	mock.
+		ExpectExec("INSERT INTO post_comments").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err = repo.AddComment("12345", comment)
+	if err != ErrNoPost {
+		t.Errorf("expected %v, got %v", ErrNoPost, err)
+		return
+	}
+
+	expectPost := func() {
+		mock.
+			ExpectQuery("FROM posts WHERE id = ?").
+			WithArgs("12345").
+			WillReturnRows(sqlmock.NewRows(postColumns).
+				AddRow("12345", "title", "", "1", "userlogin", "music", 1, 0, "text", "text", 100, 1))
+		mock.
+			ExpectQuery("FROM post_votes").
+			WillReturnRows(sqlmock.NewRows([]string{"postId", "userId", "vote"}))
+		mock.
+			ExpectQuery("FROM post_comments").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "postId", "authorId", "authorUsername", "body", "created"}).
+				AddRow("c1", "12345", "2", "commenter", "body", 0))
+	}
+
+	// the post author can't delete the comment of another user
+	expectPost()
+	_, err = repo.DeleteComment("12345", "c1", Actor{UserID: "1"})
+	if err != ErrNotAuthor {
+		t.Errorf("expected %v, got %v", ErrNotAuthor, err)
+		return
+	}
+
+	// no comment
+	expectPost()
+	_, err = repo.DeleteComment("12345", "c2", Actor{UserID: "2"})
+	if err != ErrNoComment {
+		t.Errorf("expected %v, got %v", ErrNoComment, err)
+		return
+	}
+
+	// delete error
+	expectPost()
+	mock.
+		ExpectExec("DELETE FROM post_comments WHERE id = \\? AND postId = \\?").
+		WithArgs("c1", "12345").
+		WillReturnError(fmt.Errorf("db_error"))
+	_, err = repo.DeleteComment("12345", "c1", Actor{UserID: "2"})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+}
+
+// TestReposSameJSON checks that a post stored in any repository is given to the clients the same way,
+// the MySQL one keeps only the id and the username of the authors so the others mustn't have more
+func TestReposSameJSON(t *testing.T) {
+	post := &Post{
+		ID:       "5d0c1b429ba1376cd1f27220",
+		Title:    "title",
+		Author:   Author{Username: "author", ID: "1"},
+		Category: "news",
+		Score:    1,
+		Views:    3,
+		Type:     "text",
+		Text:     "text",
+		Votes: []Vote{
+			{User: "1", Vote: 1},
+		},
+		Comments: []Comment{
+			{ID: "c1", Author: Author{Username: "commenter", ID: "2"}, Body: "body", Created: time.Unix(1561076346, 0)},
+		},
+		Created:          time.Unix(1561076000, 0),
+		UpvotePercentage: 100,
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+	mock.
+		ExpectQuery("SELECT id, title, url, authorId, authorUsername, category, score, views, type, text, upvotePercentage, created FROM posts ORDER BY created").
+		WillReturnRows(sqlmock.NewRows(postColumns).
+			AddRow(post.ID, "title", "", "1", "author", "news", 1, 3, "text", "text", 100, post.Created.UnixNano()))
+	mock.
+		ExpectQuery("SELECT postId, userId, vote FROM post_votes WHERE postId IN").
+		WillReturnRows(sqlmock.NewRows([]string{"postId", "userId", "vote"}).
+			AddRow(post.ID, "1", 1))
+	mock.
+		ExpectQuery("SELECT id, postId, authorId, authorUsername, body, created FROM post_comments WHERE postId IN").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "postId", "authorId", "authorUsername", "body", "created"}).
+			AddRow("c1", post.ID, "2", "commenter", "body", post.Comments[0].Created.UnixNano()))
+
+	expected, err := json.Marshal(post)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	repos := map[string]interface {
+		All() ([]*Post, error)
+		Save(*Post) error
+	}{
+		"memory":  NewMemoryRepo(),
+		"mongodb": NewRepo(newFakeCollection()),
+		"mysql":   NewSQLRepo(db),
+	}
+	for name, repo := range repos {
+		if name != "mysql" {
+			if err = repo.Save(post); err != nil {
+				t.Fatalf("%s: unexpected err: %s", name, err)
+			}
+		}
+		all, err := repo.All()
+		if err != nil || len(all) != 1 {
+			t.Fatalf("%s: expected 1 post, got %v, %v", name, all, err)
+		}
+		// MongoDB gives the times in UTC, only the instants are compared
+		got := all[0]
+		got.Created = got.Created.Local()
+		for i := range got.Comments {
+			got.Comments[i].Created = got.Comments[i].Created.Local()
+		}
+		body, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("%s: unexpected err: %s", name, err)
+		}
+		if string(body) != string(expected) {
+			t.Errorf("%s: results not match, want %s, have %s", name, expected, body)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// openTestMySQL connects to the database given by REDDITCLONE_TEST_MYSQL_DSN
+// and recreates the schema there, the test is skipped without it
+func openTestMySQL(t *testing.T) *sql.DB {
+	dsn := os.Getenv("REDDITCLONE_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("REDDITCLONE_TEST_MYSQL_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("can't connect to mysql: %s", err)
+	}
+
+	migrator, err := migrate.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("can't load migrations: %s", err)
+	}
+	if _, err = migrator.Down(len(migrator.Migrations)); err != nil {
+		t.Fatalf("can't drop schema: %s", err)
+	}
+	if _, err = migrator.Up(); err != nil {
+		t.Fatalf("can't create schema: %s", err)
+	}
+	return db
+}
+
+func TestSQLRepoBehaviour(t *testing.T) {
+	db := openTestMySQL(t)
+	defer db.Close()
+	testRepoBehaviour(t, NewSQLRepo(db))
+}
+
+func TestSQLRepoSave(t *testing.T) {
+	db := openTestMySQL(t)
+	defer db.Close()
+	testRepoSave(t, NewSQLRepo(db))
+}
+
+func TestSQLRepoConcurrentUpdates(t *testing.T) {
+	db := openTestMySQL(t)
+	defer db.Close()
+	testRepoConcurrentUpdates(t, NewSQLRepo(db))
+}
@@ -0,0 +1,332 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNil is returned by the reply helpers for a missing key
+	ErrNil = errors.New("Nil redis reply")
+	// ErrClosed is returned by the commands sent after Close
+	ErrClosed = errors.New("Redis client is closed")
+	// ErrTxAborted is returned when a transaction isn't executed
+	ErrTxAborted = errors.New("Redis transaction aborted")
+)
+
+// DefaultTimeout limits dialing and every command unless the client sets its own
+const DefaultTimeout = 3 * time.Second
+
+// DefaultMaxIdle is the number of connections kept open between the commands
+const DefaultMaxIdle = 8
+
+// Client is a minimal client of the servers speaking the Redis protocol (RESP), it covers the commands,
+// pipelines and MULTI/EXEC transactions the app needs. The commands are sent over a pool of connections,
+// it's safe for concurrent use
+type Client struct {
+	Addr string
+	// Username and Password are sent with AUTH when the password is set, the username is for the ACL users
+	Username string
+	Password string
+	// DB is the logical database selected on connect
+	DB int
+	// Timeout limits dialing and every command
+	Timeout time.Duration
+	// MaxIdle connections are kept open between the commands
+	MaxIdle int
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// NewClient creates a client of the server given as redis://[[username]:password@]host[:port][/db]
+func NewClient(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis url scheme %q", u.Scheme)
+	}
+	c := &Client{
+		Addr:    u.Host,
+		Timeout: DefaultTimeout,
+		MaxIdle: DefaultMaxIdle,
+	}
+	if u.Port() == "" {
+		c.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.Username = u.User.Username()
+		c.Password, _ = u.User.Password()
+	}
+	if db := u.Path; len(db) > 1 {
+		c.DB, err = strconv.Atoi(db[1:])
+		if err != nil || c.DB < 0 {
+			return nil, fmt.Errorf("bad redis database %q", db[1:])
+		}
+	}
+	return c, nil
+}
+
+// Do sends the command and returns its reply, an error reply is returned as an Error
+func (c *Client) Do(args ...string) (interface{}, error) {
+	replies, err := c.roundTrip(time.Now().Add(c.timeout()), [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], replyError(replies[0])
+}
+
+// Pipeline sends the commands at once and returns their replies in order,
+// the error replies are left in the replies
+func (c *Client) Pipeline(cmds ...[]string) ([]interface{}, error) {
+	return c.roundTrip(time.Now().Add(c.timeout()), cmds)
+}
+
+// Tx runs the commands in a MULTI/EXEC transaction, so no other client sees them half done,
+// and returns their replies. The first error reply is returned as an Error
+func (c *Client) Tx(cmds ...[]string) ([]interface{}, error) {
+	all := make([][]string, 0, len(cmds)+2)
+	all = append(all, []string{"MULTI"})
+	all = append(all, cmds...)
+	all = append(all, []string{"EXEC"})
+	replies, err := c.roundTrip(time.Now().Add(c.timeout()), all)
+	if err != nil {
+		return nil, err
+	}
+	// a command failing to queue aborts the transaction with EXECABORT
+	for _, reply := range replies {
+		if err := replyError(reply); err != nil {
+			return nil, err
+		}
+	}
+	results, ok := replies[len(replies)-1].([]interface{})
+	if !ok {
+		return nil, ErrTxAborted
+	}
+	for _, result := range results {
+		if err := replyError(result); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// Ping checks the server is available, it's used by the readiness probe
+func (c *Client) Ping(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.timeout())
+	}
+	replies, err := c.roundTrip(deadline, [][]string{{"PING"}})
+	if err != nil {
+		return err
+	}
+	return replyError(replies[0])
+}
+
+// Close closes the idle connections, the ones in use are closed once they are released
+func (c *Client) Close() error {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.closed = true
+	c.mu.Unlock()
+	var err error
+	for _, cn := range idle {
+		if closeErr := cn.nc.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// roundTrip sends the commands over a pooled connection and reads a reply for each,
+// the connection is dropped after a network or protocol error as its state is unknown
+func (c *Client) roundTrip(deadline time.Time, cmds [][]string) ([]interface{}, error) {
+	cn, err := c.get(deadline)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := cn.roundTrip(deadline, cmds)
+	if err != nil {
+		cn.nc.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return replies, nil
+}
+
+// get takes an idle connection or dials a new one
+func (c *Client) get(deadline time.Time) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+	return c.dial(deadline)
+}
+
+// put returns the connection to the pool unless it's full
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	if !c.closed && len(c.idle) < c.MaxIdle {
+		c.idle = append(c.idle, cn)
+		cn = nil
+	}
+	c.mu.Unlock()
+	if cn != nil {
+		cn.nc.Close()
+	}
+}
+
+// dial connects and authenticates a new connection
+func (c *Client) dial(deadline time.Time) (*conn, error) {
+	dialer := net.Dialer{Deadline: deadline}
+	nc, err := dialer.Dial("tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{
+		nc: nc,
+		r:  bufio.NewReader(nc),
+		w:  bufio.NewWriter(nc),
+	}
+	setup := [][]string{}
+	if c.Password != "" {
+		auth := []string{"AUTH", c.Password}
+		if c.Username != "" {
+			auth = []string{"AUTH", c.Username, c.Password}
+		}
+		setup = append(setup, auth)
+	}
+	if c.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.DB)})
+	}
+	if len(setup) == 0 {
+		return cn, nil
+	}
+	replies, err := cn.roundTrip(deadline, setup)
+	if err == nil {
+		for _, reply := range replies {
+			if err = replyError(reply); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
+
+// conn is a connection to the server
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+// roundTrip writes the commands at once and reads their replies
+func (cn *conn) roundTrip(deadline time.Time, cmds [][]string) ([]interface{}, error) {
+	err := cn.nc.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	for _, args := range cmds {
+		writeCommand(cn.w, args)
+	}
+	err = cn.w.Flush()
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	for i := range replies {
+		replies[i], err = readReply(cn.r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// replyError returns the error reply as an error
+func replyError(reply interface{}) error {
+	if err, ok := reply.(Error); ok {
+		return err
+	}
+	return nil
+}
+
+// String converts a string reply, a nil one is ErrNil
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch reply := reply.(type) {
+	case string:
+		return reply, nil
+	case nil:
+		return "", ErrNil
+	}
+	return "", fmt.Errorf("unexpected redis reply %T", reply)
+}
+
+// Int64 converts an integer reply
+func Int64(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected redis reply %T", reply)
+	}
+	return n, nil
+}
+
+// Strings converts an array reply, the nil items become empty strings
+func Strings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected redis reply %T", reply)
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		value, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected redis reply %T", item)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
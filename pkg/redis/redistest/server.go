@@ -0,0 +1,726 @@
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory stand-in of a Redis server for the tests, it speaks RESP over a local
+// TCP listener and supports the string, hash and set commands the app uses, key expiry,
+// SCAN and MULTI/EXEC. The keys expire by the clock moved with Advance
+type Server struct {
+	Addr string
+	// Password is required with AUTH when it's set
+	Password string
+
+	ln    net.Listener
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	dbs   map[int]map[string]*entry
+	conns map[net.Conn]bool
+	// offset is added to the wall clock by Advance
+	offset time.Duration
+	// cursors of SCAN map to the last keys returned
+	cursors    map[uint64]string
+	lastCursor uint64
+}
+
+// NewServer starts a server on a random local port, it panics when it can't listen like httptest does
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer listens on a random local port, the server is configured and then started with Start
+func NewUnstartedServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("redistest: failed to listen on a port: %v", err))
+	}
+	return &Server{
+		Addr:    ln.Addr().String(),
+		ln:      ln,
+		dbs:     map[int]map[string]*entry{},
+		conns:   map[net.Conn]bool{},
+		cursors: map[uint64]string{},
+	}
+}
+
+// Start starts serving the clients
+func (s *Server) Start() {
+	s.wg.Add(1)
+	go s.serve()
+}
+
+// URL returns the redis:// URL of the server
+func (s *Server) URL() string {
+	if s.Password != "" {
+		return "redis://:" + s.Password + "@" + s.Addr
+	}
+	return "redis://" + s.Addr
+}
+
+// Advance moves the clock of the server, so the keys expire without waiting
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	s.offset += d
+	s.mu.Unlock()
+}
+
+// Keys returns the live keys of the database sorted
+func (s *Server) Keys(db int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys(db, "*")
+}
+
+// Close stops the server and closes the client connections
+func (s *Server) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// entry is a value of one of the supported types
+type entry struct {
+	str  string
+	hash map[string]string
+	set  map[string]bool
+	// expires is zero for the persistent keys
+	expires time.Time
+}
+
+func (e *entry) kind() string {
+	switch {
+	case e.hash != nil:
+		return "hash"
+	case e.set != nil:
+		return "set"
+	}
+	return "string"
+}
+
+// the replies are written as RESP by writeReply
+type (
+	simple   string
+	errReply string
+)
+
+const (
+	ok         = simple("OK")
+	errWrongTy = errReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errSyntax  = errReply("ERR syntax error")
+	errInt     = errReply("ERR value is not an integer or out of range")
+)
+
+// connState is the state of a client connection
+type connState struct {
+	authed bool
+	db     int
+	multi  bool
+	queued [][]string
+	// dirty aborts the transaction as a command failed to queue
+	dirty bool
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	st := &connState{authed: s.Password == ""}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if err != io.EOF {
+				writeReply(w, errReply("ERR Protocol error: "+err.Error()))
+				w.Flush()
+			}
+			return
+		}
+		writeReply(w, s.command(st, args))
+		// the pipelined commands are answered at once
+		if r.Buffered() == 0 {
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// command handles the connection and transaction commands and runs the others
+func (s *Server) command(st *connState, args []string) interface{} {
+	if len(args) == 0 {
+		return errReply("ERR empty command")
+	}
+	name := strings.ToUpper(args[0])
+	if name == "AUTH" {
+		if len(args) < 2 || len(args) > 3 {
+			return wrongArgs(name)
+		}
+		if s.Password == "" {
+			return errReply("ERR AUTH called without any password configured for the default user")
+		}
+		if args[len(args)-1] != s.Password {
+			return errReply("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		st.authed = true
+		return ok
+	}
+	if !st.authed {
+		return errReply("NOAUTH Authentication required.")
+	}
+
+	switch name {
+	case "MULTI":
+		if st.multi {
+			return errReply("ERR MULTI calls can not be nested")
+		}
+		st.multi = true
+		return ok
+	case "DISCARD":
+		if !st.multi {
+			return errReply("ERR DISCARD without MULTI")
+		}
+		st.multi, st.queued, st.dirty = false, nil, false
+		return ok
+	case "EXEC":
+		if !st.multi {
+			return errReply("ERR EXEC without MULTI")
+		}
+		queued, dirty := st.queued, st.dirty
+		st.multi, st.queued, st.dirty = false, nil, false
+		if dirty {
+			return errReply("EXECABORT Transaction discarded because of previous errors.")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		results := make([]interface{}, len(queued))
+		for i, cmd := range queued {
+			results[i] = s.run(st, cmd)
+		}
+		return results
+	case "SELECT":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		db, err := strconv.Atoi(args[1])
+		if err != nil || db < 0 || db > 15 {
+			return errReply("ERR DB index is out of range")
+		}
+		st.db = db
+		return ok
+	}
+
+	if st.multi {
+		min, known := commands[name]
+		if !known {
+			st.dirty = true
+			return unknownCommand(args[0])
+		}
+		if len(args) < min {
+			st.dirty = true
+			return wrongArgs(name)
+		}
+		st.queued = append(st.queued, args)
+		return simple("QUEUED")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run(st, args)
+}
+
+// commands are the data commands with their minimal number of arguments including the name
+var commands = map[string]int{
+	"PING": 1, "ECHO": 2, "FLUSHDB": 1, "DBSIZE": 1,
+	"GET": 2, "SET": 3, "MGET": 2, "DEL": 2, "EXISTS": 2,
+	"PEXPIRE": 3, "PEXPIREAT": 3, "PTTL": 2,
+	"HSET": 4, "HGET": 3, "HSETNX": 4, "HGETALL": 2, "HDEL": 3,
+	"SADD": 3, "SREM": 3, "SMEMBERS": 2, "SCARD": 2,
+	"SCAN": 2,
+}
+
+// run executes a data command, the lock must be held
+func (s *Server) run(st *connState, args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	min, known := commands[name]
+	if !known {
+		return unknownCommand(args[0])
+	}
+	if len(args) < min {
+		return wrongArgs(name)
+	}
+	now := time.Now().Add(s.offset)
+	db := s.db(st.db)
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			return args[1]
+		}
+		return simple("PONG")
+	case "ECHO":
+		return args[1]
+	case "FLUSHDB":
+		s.dbs[st.db] = map[string]*entry{}
+		return ok
+	case "DBSIZE":
+		return int64(len(s.keys(st.db, "*")))
+
+	case "GET":
+		e := s.lookup(db, key, now)
+		if e == nil {
+			return nil
+		}
+		if e.kind() != "string" {
+			return errWrongTy
+		}
+		return e.str
+	case "SET":
+		return s.set(db, args, now)
+	case "MGET":
+		values := make([]interface{}, len(args)-1)
+		for i, k := range args[1:] {
+			if e := s.lookup(db, k, now); e != nil && e.kind() == "string" {
+				values[i] = e.str
+			}
+		}
+		return values
+	case "DEL", "EXISTS":
+		var n int64
+		for _, k := range args[1:] {
+			if s.lookup(db, k, now) != nil {
+				n++
+				if name == "DEL" {
+					delete(db, k)
+				}
+			}
+		}
+		return n
+	case "PEXPIRE", "PEXPIREAT":
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errInt
+		}
+		e := s.lookup(db, key, now)
+		if e == nil {
+			return int64(0)
+		}
+		at := time.Unix(0, ms*int64(time.Millisecond))
+		if name == "PEXPIRE" {
+			at = now.Add(time.Duration(ms) * time.Millisecond)
+		}
+		if !at.After(now) {
+			delete(db, key)
+			return int64(1)
+		}
+		e.expires = at
+		return int64(1)
+	case "PTTL":
+		e := s.lookup(db, key, now)
+		if e == nil {
+			return int64(-2)
+		}
+		if e.expires.IsZero() {
+			return int64(-1)
+		}
+		return int64(e.expires.Sub(now) / time.Millisecond)
+
+	case "HSET", "HSETNX":
+		if len(args)%2 != 0 || name == "HSETNX" && len(args) != 4 {
+			return wrongArgs(name)
+		}
+		e, reply := s.lookupType(db, key, now, "hash")
+		if reply != nil {
+			return reply
+		}
+		if e == nil {
+			e = &entry{hash: map[string]string{}}
+			db[key] = e
+		}
+		var added int64
+		for i := 2; i < len(args); i += 2 {
+			if _, exists := e.hash[args[i]]; exists {
+				if name == "HSETNX" {
+					continue
+				}
+			} else {
+				added++
+			}
+			e.hash[args[i]] = args[i+1]
+		}
+		return added
+	case "HGET":
+		e, reply := s.lookupType(db, key, now, "hash")
+		if reply != nil {
+			return reply
+		}
+		if e == nil {
+			return nil
+		}
+		value, exists := e.hash[args[2]]
+		if !exists {
+			return nil
+		}
+		return value
+	case "HGETALL":
+		e, reply := s.lookupType(db, key, now, "hash")
+		if reply != nil {
+			return reply
+		}
+		items := []interface{}{}
+		if e != nil {
+			for _, field := range sortedKeys(e.hash) {
+				items = append(items, field, e.hash[field])
+			}
+		}
+		return items
+	case "HDEL":
+		e, reply := s.lookupType(db, key, now, "hash")
+		if reply != nil || e == nil {
+			return orZero(reply)
+		}
+		var n int64
+		for _, field := range args[2:] {
+			if _, exists := e.hash[field]; exists {
+				delete(e.hash, field)
+				n++
+			}
+		}
+		if len(e.hash) == 0 {
+			delete(db, key)
+		}
+		return n
+
+	case "SADD":
+		e, reply := s.lookupType(db, key, now, "set")
+		if reply != nil {
+			return reply
+		}
+		if e == nil {
+			e = &entry{set: map[string]bool{}}
+			db[key] = e
+		}
+		var n int64
+		for _, member := range args[2:] {
+			if !e.set[member] {
+				e.set[member] = true
+				n++
+			}
+		}
+		return n
+	case "SREM":
+		e, reply := s.lookupType(db, key, now, "set")
+		if reply != nil || e == nil {
+			return orZero(reply)
+		}
+		var n int64
+		for _, member := range args[2:] {
+			if e.set[member] {
+				delete(e.set, member)
+				n++
+			}
+		}
+		if len(e.set) == 0 {
+			delete(db, key)
+		}
+		return n
+	case "SMEMBERS":
+		e, reply := s.lookupType(db, key, now, "set")
+		if reply != nil {
+			return reply
+		}
+		members := []interface{}{}
+		if e != nil {
+			for _, member := range sortedKeys(e.set) {
+				members = append(members, member)
+			}
+		}
+		return members
+	case "SCARD":
+		e, reply := s.lookupType(db, key, now, "set")
+		if reply != nil || e == nil {
+			return orZero(reply)
+		}
+		return int64(len(e.set))
+
+	case "SCAN":
+		return s.scan(st.db, args)
+	}
+	return unknownCommand(args[0])
+}
+
+// set handles SET key value [NX|XX] [EX seconds|PX milliseconds]
+func (s *Server) set(db map[string]*entry, args []string, now time.Time) interface{} {
+	key, value := args[1], args[2]
+	var nx, xx bool
+	var expires time.Time
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 == len(args) || !expires.IsZero() {
+				return errSyntax
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return errInt
+			}
+			if n <= 0 {
+				return errReply("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Millisecond
+			if opt == "EX" {
+				unit = time.Second
+			}
+			expires = now.Add(time.Duration(n) * unit)
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+	exists := s.lookup(db, key, now) != nil
+	if nx && exists || xx && !exists {
+		return nil
+	}
+	db[key] = &entry{str: value, expires: expires}
+	return ok
+}
+
+// scan handles SCAN cursor [MATCH pattern] [COUNT count]. A cursor remembers the last key returned,
+// so the keys present during the whole iteration are returned even if others are deleted meanwhile
+func (s *Server) scan(dbIndex int, args []string) interface{} {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	after, known := s.cursors[cursor]
+	if err != nil || cursor != 0 && !known {
+		return errReply("ERR invalid cursor")
+	}
+	delete(s.cursors, cursor)
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+	// the keys are matched after the page is taken like the real server does, so a page may be empty
+	all := s.keys(dbIndex, "*")
+	start := sort.SearchStrings(all, after)
+	if start < len(all) && all[start] == after && cursor != 0 {
+		start++
+	}
+	end := start + count
+	if end >= len(all) {
+		end = len(all)
+	}
+	keys := []interface{}{}
+	for _, key := range all[start:end] {
+		if matched, _ := path.Match(pattern, key); matched {
+			keys = append(keys, key)
+		}
+	}
+	if end == len(all) {
+		return []interface{}{"0", keys}
+	}
+	s.lastCursor++
+	s.cursors[s.lastCursor] = all[end-1]
+	return []interface{}{strconv.FormatUint(s.lastCursor, 10), keys}
+}
+
+// db returns the database by its index creating it on the first use
+func (s *Server) db(index int) map[string]*entry {
+	db, exists := s.dbs[index]
+	if !exists {
+		db = map[string]*entry{}
+		s.dbs[index] = db
+	}
+	return db
+}
+
+// keys returns the live keys matching the pattern sorted, the lock must be held
+func (s *Server) keys(dbIndex int, pattern string) []string {
+	now := time.Now().Add(s.offset)
+	db := s.db(dbIndex)
+	keys := []string{}
+	for key := range db {
+		if s.lookup(db, key, now) == nil {
+			continue
+		}
+		if matched, _ := path.Match(pattern, key); matched {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lookup returns the live entry deleting the expired one
+func (s *Server) lookup(db map[string]*entry, key string, now time.Time) *entry {
+	e, exists := db[key]
+	if !exists {
+		return nil
+	}
+	if !e.expires.IsZero() && !now.Before(e.expires) {
+		delete(db, key)
+		return nil
+	}
+	return e
+}
+
+// lookupType returns the live entry of the type or the WRONGTYPE error reply
+func (s *Server) lookupType(db map[string]*entry, key string, now time.Time, kind string) (*entry, interface{}) {
+	e := s.lookup(db, key, now)
+	if e != nil && e.kind() != kind {
+		return nil, errWrongTy
+	}
+	return e, nil
+}
+
+func orZero(reply interface{}) interface{} {
+	if reply != nil {
+		return reply
+	}
+	return int64(0)
+}
+
+func wrongArgs(name string) errReply {
+	return errReply("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+}
+
+func unknownCommand(name string) errReply {
+	return errReply("ERR unknown command '" + name + "'")
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch m := m.(type) {
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]bool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[0] != '*' {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad array length %q", line[1:])
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 2 || line[0] != '$' {
+			return nil, fmt.Errorf("expected a bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("bad bulk length %q", line[1:])
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line[:len(line)-1], "\r"), nil
+}
+
+// writeReply writes the reply: a simple string, an error, an int64, a bulk string, nil or an array of them
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch reply := reply.(type) {
+	case simple:
+		fmt.Fprintf(w, "+%s\r\n", reply)
+	case errReply:
+		fmt.Fprintf(w, "-%s\r\n", reply)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", reply)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(reply), reply)
+	case nil:
+		w.WriteString("$-1\r\n")
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(reply))
+		for _, item := range reply {
+			writeReply(w, item)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package seed
+
+import (
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/ids"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/posts"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"io"
+)
+
+// UsersRepoInterface describes the Users Repo methods needed to create the authors
+type UsersRepoInterface interface {
+	GetByUserName(string) (*user.User, error)
+	Register(*user.User) (*user.User, error)
+}
+
+// PostsRepoInterface describes the Posts Repo methods needed to store the posts
+type PostsRepoInterface interface {
+	Save(*posts.Post) error
+}
+
+// Importer loads posts in the asperitas format (see assets/posts.json).
+// Authors missing in the Users Repo are created with a random password and
+// their legacy ids are replaced with the new ones in posts, comments and votes.
+// Posts keep their legacy ids so importing the same file again updates them
+type Importer struct {
+	UsersRepo UsersRepoInterface
+	PostsRepo PostsRepoInterface
+}
+
+// Stats sums up an import run
+type Stats struct {
+	Posts        int
+	UsersCreated int
+}
+
+// Import reads a JSON array of posts and stores them
+func (im *Importer) Import(r io.Reader) (*Stats, error) {
+	items := []*posts.Post{}
+	err := json.NewDecoder(r).Decode(&items)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{}
+	newIDs := make(map[string]string)
+	for _, post := range items {
+		err = im.mapAuthor(&post.Author, newIDs, stats)
+		if err != nil {
+			return stats, err
+		}
+		for i := range post.Comments {
+			err = im.mapAuthor(&post.Comments[i].Author, newIDs, stats)
+			if err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	for _, post := range items {
+		if post.Votes == nil {
+			post.Votes = []posts.Vote{}
+		}
+		if post.Comments == nil {
+			post.Comments = []posts.Comment{}
+		}
+		// voters who never posted anything keep their legacy ids
+		for i, v := range post.Votes {
+			if id, ok := newIDs[v.User]; ok {
+				post.Votes[i].User = id
+			}
+		}
+		err = im.PostsRepo.Save(post)
+		if err != nil {
+			return stats, err
+		}
+		stats.Posts++
+	}
+	return stats, nil
+}
+
+// mapAuthor replaces the legacy author with the existing or newly created user
+func (im *Importer) mapAuthor(author *posts.Author, newIDs map[string]string, stats *Stats) error {
+	legacyID := author.ID
+	if id, ok := newIDs[legacyID]; ok {
+		author.ID = id
+		return nil
+	}
+
+	u, err := im.UsersRepo.GetByUserName(author.Username)
+	if err == user.ErrNoUser {
+		u, err = im.UsersRepo.Register(&user.User{
+			Username: author.Username,
+			Password: ids.GenerateID(),
+		})
+		if err != nil {
+			return err
+		}
+		stats.UsersCreated++
+	}
+	if err != nil {
+		return err
+	}
+
+	newIDs[legacyID] = u.ID
+	*author = posts.NewAuthor(u)
+	return nil
+}
@@ -0,0 +1,160 @@
+package seed
+
+import (
+	"errors"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/posts"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/user"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const legacyPosts = `[{
+	"score": 1,
+	"views": 10,
+	"type": "text",
+	"title": "first",
+	"author": {"username": "alice", "id": "5d0c19fe9ba1373573f27219"},
+	"category": "programming",
+	"text": "text",
+	"votes": [
+		{"user": "5d0c19fe9ba1373573f27219", "vote": 1},
+		{"user": "5d0c1a609ba13771b7f2721b", "vote": 1},
+		{"user": "5d0c1a839ba1375b74f2721c", "vote": -1}
+	],
+	"comments": [{
+		"created": "2019-06-21T00:19:06.357Z",
+		"author": {"username": "bob", "id": "5d0c1a609ba13771b7f2721b"},
+		"body": "comment",
+		"id": "5d0c227a9ba137eff0f27252"
+	}],
+	"created": "2019-06-20T23:46:06.357Z",
+	"upvotePercentage": 66,
+	"id": "5d0c1b429ba1376cd1f27220"
+}, {
+	"score": 1,
+	"views": 3,
+	"type": "link",
+	"title": "second",
+	"url": "http://example.com",
+	"author": {"username": "bob", "id": "5d0c1a609ba13771b7f2721b"},
+	"category": "news",
+	"votes": [{"user": "5d0c1a609ba13771b7f2721b", "vote": 1}],
+	"comments": [],
+	"created": "2019-06-21T23:46:06.357Z",
+	"upvotePercentage": 100,
+	"id": "5d0c1b429ba1376cd1f27221"
+}]`
+
+func TestImport(t *testing.T) {
+	usersRepo := newUsersRepo()
+	postsRepo := posts.NewMemoryRepo()
+	im := &Importer{
+		UsersRepo: usersRepo,
+		PostsRepo: postsRepo,
+	}
+
+	// alice already exists and keeps her id
+	alice, _ := usersRepo.Register(&user.User{Username: "alice", Password: "password"})
+
+	stats, err := im.Import(strings.NewReader(legacyPosts))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if stats.Posts != 2 || stats.UsersCreated != 1 {
+		t.Errorf("bad stats %+v", stats)
+	}
+
+	bob, err := usersRepo.GetByUserName("bob")
+	if err != nil {
+		t.Fatalf("bob wasn't created: %s", err)
+	}
+
+	first, err := postsRepo.Get("5d0c1b429ba1376cd1f27220")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if first.Author.ID != alice.ID || first.Author.Username != "alice" ||
+		first.Score != 1 || first.Views != 10 || first.UpvotePercentage != 66 {
+		t.Errorf("bad imported post %+v", first)
+	}
+	expectedVoters := []string{alice.ID, bob.ID, "5d0c1a839ba1375b74f2721c"}
+	for i, v := range first.Votes {
+		if v.User != expectedVoters[i] {
+			t.Errorf("bad voter %d: want %s, have %s", i, expectedVoters[i], v.User)
+		}
+	}
+	if len(first.Comments) != 1 || first.Comments[0].Author.ID != bob.ID ||
+		first.Comments[0].ID != "5d0c227a9ba137eff0f27252" || first.Comments[0].Created.IsZero() {
+		t.Errorf("bad imported comments %+v", first.Comments)
+	}
+
+	// importing again changes nothing
+	stats, err = im.Import(strings.NewReader(legacyPosts))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if stats.Posts != 2 || stats.UsersCreated != 0 {
+		t.Errorf("bad stats %+v", stats)
+	}
+	all, _ := postsRepo.All()
+	if len(all) != 2 {
+		t.Errorf("expected 2 posts, got %d", len(all))
+	}
+}
+
+type failingUsersRepo struct {
+	*user.MemoryRepo
+}
+
+func (r failingUsersRepo) GetByUserName(string) (*user.User, error) {
+	return nil, errors.New("db_error")
+}
+
+func TestImportErrors(t *testing.T) {
+	im := &Importer{
+		UsersRepo: newUsersRepo(),
+		PostsRepo: posts.NewMemoryRepo(),
+	}
+	_, err := im.Import(strings.NewReader(`{"not": "an array"}`))
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	im.UsersRepo = failingUsersRepo{newUsersRepo()}
+	_, err = im.Import(strings.NewReader(legacyPosts))
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestImportAssets(t *testing.T) {
+	f, err := os.Open("../../assets/posts.json")
+	if err != nil {
+		t.Skipf("no assets: %s", err)
+	}
+	defer f.Close()
+
+	postsRepo := posts.NewMemoryRepo()
+	im := &Importer{
+		UsersRepo: newUsersRepo(),
+		PostsRepo: postsRepo,
+	}
+	stats, err := im.Import(f)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	all, _ := postsRepo.All()
+	if stats.Posts == 0 || len(all) != stats.Posts {
+		t.Errorf("bad stats %+v for %d posts", stats, len(all))
+	}
+}
+
+// newUsersRepo creates a Users Repo with fast password hashing
+func newUsersRepo() *user.MemoryRepo {
+	repo := user.NewMemoryRepo()
+	repo.Hasher = &user.BcryptHasher{Cost: bcrypt.MinCost}
+	return repo
+}
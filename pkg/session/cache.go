@@ -0,0 +1,120 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds the memory of the cache, the expired entries are dropped when it's full
+const maxCacheEntries = 100000
+
+// Cache keeps the checked sessions in the process for a short TTL, so that the store isn't hit
+// on every request. The sessions revoked through the manager are dropped at once, the ones revoked
+// by another instance sharing the store are accepted here until the cached entry expires.
+// The nil Cache caches nothing
+type Cache struct {
+	// TTL is how long a checked session is accepted without the store,
+	// it should be shorter than LastSeenInterval so the last seen times stay accurate
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// gen is bumped on every removal, so a session read from the store before it isn't cached
+	gen uint64
+}
+
+type cacheEntry struct {
+	sess   Session
+	cached time.Time
+}
+
+// NewCache creates a cache keeping the sessions for the ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		TTL:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// get returns a copy of the cached session unless the entry or the session has expired
+func (c *Cache) get(sessionID string, now time.Time) (*Session, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[sessionID]
+	if !ok {
+		return nil, false
+	}
+	if now.Sub(entry.cached) >= c.TTL || entry.sess.expired(now) {
+		delete(c.entries, sessionID)
+		return nil, false
+	}
+	sess := entry.sess
+	return &sess, true
+}
+
+// generation is taken before reading a session from the store to be passed to put
+func (c *Cache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches a copy of the session unless a session was removed since the generation was taken
+func (c *Cache) put(sess *Session, generation uint64, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.gen {
+		return
+	}
+	if len(c.entries) >= maxCacheEntries {
+		c.evict(now)
+	}
+	c.entries[sess.ID] = cacheEntry{sess: *sess, cached: now}
+}
+
+// evict drops the expired entries or all of them if none has expired, the lock must be held
+func (c *Cache) evict(now time.Time) {
+	for id, entry := range c.entries {
+		if now.Sub(entry.cached) >= c.TTL {
+			delete(c.entries, id)
+		}
+	}
+	if len(c.entries) >= maxCacheEntries {
+		c.entries = make(map[string]cacheEntry)
+	}
+}
+
+// remove drops the session
+func (c *Cache) remove(sessionID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, sessionID)
+	c.gen++
+	c.mu.Unlock()
+}
+
+// removeUser drops all the sessions of the user
+func (c *Cache) removeUser(userID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	for id, entry := range c.entries {
+		if entry.sess.UserID == userID {
+			delete(c.entries, id)
+		}
+	}
+	c.gen++
+	c.mu.Unlock()
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the reads of the sessions
+type countingStore struct {
+	*MemoryStore
+	gets int32
+}
+
+func (s *countingStore) Get(sessionID string) (*Session, error) {
+	atomic.AddInt32(&s.gets, 1)
+	return s.MemoryStore.Get(sessionID)
+}
+
+func TestCachedCheck(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	sm := NewSessionsManager(store, time.Hour)
+	sm.Cache = NewCache(time.Minute)
+
+	first, _ := sm.Create("42", Client{})
+	second, _ := sm.Create("42", Client{})
+	other, _ := sm.Create("43", Client{})
+	for i := 0; i < 3; i++ {
+		sess, err := sm.Check(first.ID)
+		if err != nil || sess.ID != first.ID {
+			t.Fatalf("bad check %+v, %v", sess, err)
+		}
+		// the cached session can't be changed by the caller
+		sess.UserID = "43"
+	}
+	if gets := atomic.LoadInt32(&store.gets); gets != 1 {
+		t.Errorf("the store was read %d times", gets)
+	}
+
+	// the revoked sessions aren't accepted from the cache
+	sm.Check(second.ID)
+	sm.Check(other.ID)
+	sm.Destroy(first.ID)
+	if _, err := sm.Check(first.ID); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+	sm.DestroyAllForUser("42")
+	if _, err := sm.Check(second.ID); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+	if _, err := sm.Check(other.ID); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	// a reused refresh token revokes the cached session as well
+	sess, _ := sm.Create("42", Client{})
+	token, _ := sm.NewRefreshToken(sess.ID)
+	sm.Refresh(token)
+	sm.Check(sess.ID)
+	if _, _, err := sm.Refresh(token); err != ErrRefreshReused {
+		t.Errorf("expected %v, got %v", ErrRefreshReused, err)
+	}
+	if _, err := sm.Check(sess.ID); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(time.Second)
+	now := time.Now()
+	sess := &Session{ID: "sess_id", UserID: "42", Expires: now.Add(time.Hour)}
+
+	c.put(sess, c.generation(), now)
+	if cached, ok := c.get("sess_id", now.Add(999*time.Millisecond)); !ok || cached.UserID != "42" {
+		t.Errorf("bad cached session %+v", cached)
+	}
+	if _, ok := c.get("sess_id", now.Add(time.Second)); ok {
+		t.Errorf("the entry hasn't expired")
+	}
+
+	// the session expiring before the entry isn't accepted
+	long := NewCache(time.Minute)
+	long.put(&Session{ID: "short", Expires: now.Add(time.Second)}, long.generation(), now)
+	if _, ok := long.get("short", now.Add(2*time.Second)); ok {
+		t.Errorf("the expired session was accepted")
+	}
+
+	// a session read before a removal isn't cached
+	generation := c.generation()
+	c.remove("sess_id")
+	c.put(sess, generation, now)
+	if _, ok := c.get("sess_id", now); ok {
+		t.Errorf("the session read before the removal was cached")
+	}
+
+	var disabled *Cache
+	disabled.put(sess, disabled.generation(), now)
+	if _, ok := disabled.get("sess_id", now); ok {
+		t.Errorf("the nil cache cached the session")
+	}
+	disabled.remove("sess_id")
+	disabled.removeUser("42")
+}
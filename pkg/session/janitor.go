@@ -0,0 +1,42 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PurgerInterface deletes the expired sessions
+type PurgerInterface interface {
+	PurgeExpired() (int64, error)
+}
+
+// Janitor purges the expired sessions in the background
+type Janitor struct {
+	Sessions PurgerInterface
+	// Interval is the time between the purges
+	Interval time.Duration
+	Logger   *zap.SugaredLogger
+}
+
+// Run purges the expired sessions every interval until the context is done
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := j.Sessions.PurgeExpired()
+			if err != nil {
+				j.Logger.Errorw("Can't purge expired sessions", "err", err)
+				continue
+			}
+			if purged > 0 {
+				j.Logger.Infow("Purged expired sessions", "sessions", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type countingPurger struct {
+	calls int32
+}
+
+func (p *countingPurger) PurgeExpired() (int64, error) {
+	if atomic.AddInt32(&p.calls, 1)%2 == 0 {
+		return 0, errors.New("db_error")
+	}
+	return 1, nil
+}
+
+func TestJanitor(t *testing.T) {
+	purger := &countingPurger{}
+	janitor := &Janitor{
+		Sessions: purger,
+		Interval: time.Millisecond,
+		Logger:   zap.NewNop().Sugar(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		janitor.Run(ctx)
+		close(done)
+	}()
+
+	for atomic.LoadInt32(&purger.calls) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("janitor didn't stop")
+	}
+}
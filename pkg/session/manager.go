@@ -1,57 +1,169 @@
 package session
 
 import (
-	"database/sql"
 	"time"
 )
 
+// SessionStore keeps the sessions with their refresh tokens, it's MySQL, memory or Redis
+type SessionStore interface {
+	// Get returns the session, ErrNoAuth when there is none. The expired sessions may be returned
+	Get(sessionID string) (*Session, error)
+	// Save stores the session as it is, the existing session with the same id is replaced
+	Save(sess *Session) error
+	// Touch writes the last seen time and expiry of the session, a deleted session isn't stored again
+	Touch(sess *Session) error
+	// Delete deletes the session with all its refresh tokens
+	Delete(sessionID string) error
+	// DeleteForUser deletes all the sessions of the user with their refresh tokens
+	DeleteForUser(userID string) error
+	// ListForUser returns the sessions of the user active at now, the recently seen first
+	ListForUser(userID string, now time.Time) ([]*Session, error)
+	// All returns all the stored sessions, the earliest expiring first
+	All() ([]*Session, error)
+	// PurgeExpired deletes the sessions expired at now with their refresh tokens and returns their number
+	PurgeExpired(now time.Time) (int64, error)
+	// AddRefreshToken stores the hash of a new refresh token of the session,
+	// the token isn't needed after expires as the session can't live longer
+	AddRefreshToken(hash, sessionID string, expires time.Time) error
+	// UseRefreshToken marks the token used and returns its session, reused is set when
+	// the token has been used before. It returns ErrNoAuth for an unknown token
+	UseRefreshToken(hash string) (sessionID string, reused bool, err error)
+}
+
 // SessionsManager manages sessions
 type SessionsManager struct {
-	// data map[string]*Session
-	DB *sql.DB
+	Store SessionStore
+	// TTL is the absolute lifetime of the sessions
+	TTL time.Duration
+	// IdleTimeout expires the sessions unused for that long, it's disabled when zero
+	IdleTimeout time.Duration
+	// Cache keeps the checked sessions for a short time, the store is hit on every check when it's nil
+	Cache *Cache
 }
 
-// NewSessionsManager constructs a new Sess Man
-func NewSessionsManager(db *sql.DB) *SessionsManager {
+// NewSessionsManager constructs a new Sess Man keeping the sessions in the store
+func NewSessionsManager(store SessionStore, ttl time.Duration) *SessionsManager {
 	return &SessionsManager{
-		DB: db,
+		Store: store,
+		TTL:   ttl,
 	}
 }
 
 // Check validates a session by its id
 func (sm *SessionsManager) Check(sessionID string) (*Session, error) {
-	sess := &Session{}
-	var expiresUnix int64
-	err := sm.DB.
-		QueryRow("SELECT id, userId, expires FROM sessions WHERE id = ?", sessionID).
-		Scan(&sess.ID, &sess.UserID, &expiresUnix)
+	now := time.Now()
+	if sess, ok := sm.Cache.get(sessionID, now); ok {
+		return sess, nil
+	}
+	// a session revoked while it's read isn't cached
+	generation := sm.Cache.generation()
+
+	sess, err := sm.Store.Get(sessionID)
 	if err != nil {
 		return nil, ErrNoAuth
 	}
-
-	sess.Expires = time.Unix(expiresUnix, 0)
-	if sess.Expires.Unix() < time.Now().Unix() {
+	if sess.expired(now) {
 		return nil, ErrNoAuth
 	}
+	if now.Sub(sess.LastSeen) >= LastSeenInterval {
+		// the updates are throttled, so a failed one is retried on the next request
+		touched := *sess
+		touched.touch(now, sm.TTL, sm.IdleTimeout)
+		err = sm.Store.Touch(&touched)
+		if err == nil {
+			sess = &touched
+		}
+	}
 
+	sm.Cache.put(sess, generation, now)
 	return sess, nil
 }
 
 // Create creates a new session for the passed userID
-func (sm *SessionsManager) Create(userID string) (*Session, error) {
-	sess, err := NewSession(userID)
+func (sm *SessionsManager) Create(userID string, client Client) (*Session, error) {
+	sess, err := newClientSession(userID, sm.TTL, sm.IdleTimeout, client)
 	if err != nil {
 		return nil, err
 	}
-	_, err = sm.DB.Exec(
-		"INSERT INTO sessions (`id`, `userId`, `expires`) VALUES (?, ?, ?)",
-		sess.ID,
-		sess.UserID,
-		sess.Expires.Unix(),
-	)
+	err = sm.Store.Save(sess)
 	if err != nil {
 		return nil, err
 	}
-
 	return sess, nil
 }
+
+// NewRefreshToken issues the first refresh token of the session
+func (sm *SessionsManager) NewRefreshToken(sessionID string) (string, error) {
+	token, err := generateRandomString(IDLength)
+	if err != nil {
+		return "", err
+	}
+	err = sm.Store.AddRefreshToken(hashRefreshToken(token), sessionID, time.Now().Add(sm.TTL))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Refresh exchanges the refresh token for the next one of the same session,
+// a token presented twice revokes the session with all its tokens
+func (sm *SessionsManager) Refresh(token string) (*Session, string, error) {
+	sessionID, reused, err := sm.Store.UseRefreshToken(hashRefreshToken(token))
+	if err != nil {
+		return nil, "", err
+	}
+	if reused {
+		err = sm.Destroy(sessionID)
+		if err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshReused
+	}
+
+	sess, err := sm.Check(sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	next, err := sm.NewRefreshToken(sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	return sess, next, nil
+}
+
+// Destroy deletes the session with all its refresh tokens
+func (sm *SessionsManager) Destroy(sessionID string) error {
+	err := sm.Store.Delete(sessionID)
+	// the cache is cleared after the store, so a check reading the session meanwhile doesn't cache it again
+	sm.Cache.remove(sessionID)
+	return err
+}
+
+// DestroyAllForUser deletes all the sessions of the user with their refresh tokens
+func (sm *SessionsManager) DestroyAllForUser(userID string) error {
+	err := sm.Store.DeleteForUser(userID)
+	sm.Cache.removeUser(userID)
+	return err
+}
+
+// PurgeExpired deletes the expired sessions with their refresh tokens and returns the number of sessions deleted
+func (sm *SessionsManager) PurgeExpired() (int64, error) {
+	return sm.Store.PurgeExpired(time.Now())
+}
+
+// ListForUser retrieves the active sessions of the user, the recently seen first
+func (sm *SessionsManager) ListForUser(userID string) ([]*Session, error) {
+	return sm.Store.ListForUser(userID, time.Now())
+}
+
+// All retrieves all the stored sessions including the expired ones
+func (sm *SessionsManager) All() ([]*Session, error) {
+	return sm.Store.All()
+}
+
+// Save stores the session as it is, the existing session with the same id is replaced
+func (sm *SessionsManager) Save(sess *Session) error {
+	err := sm.Store.Save(sess)
+	sm.Cache.remove(sess.ID)
+	return err
+}
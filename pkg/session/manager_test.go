@@ -0,0 +1,334 @@
+package session
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRefresh(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	sm := NewSessionsManager(NewSQLStore(db), time.Hour)
+	token := "refresh_token"
+	hash := hashRefreshToken(token)
+	expires := time.Now().Add(time.Hour).Unix()
+
+	// good rotation
+	mock.
+		ExpectQuery("SELECT sessionId, used FROM refresh_tokens").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"sessionId", "used"}).AddRow("sess_id", false))
+	mock.
+		ExpectExec("UPDATE refresh_tokens SET used = 1").
+		WithArgs(hash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectQuery("SELECT (.+) FROM sessions WHERE id").
+		WithArgs("sess_id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "userId", "expires", "created", "lastSeen", "userAgent", "ip"}).
+			AddRow("sess_id", "42", expires, time.Now().Unix(), time.Now().Unix(), "curl", "127.0.0.1"))
+	mock.
+		ExpectExec("INSERT INTO refresh_tokens").
+		WithArgs(sqlmock.AnyArg(), "sess_id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sess, next, err := sm.Refresh(token)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if sess.ID != "sess_id" || sess.UserID != "42" || next == "" || next == token {
+		t.Errorf("bad refresh %+v, %q", sess, next)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// unknown token
+	mock.
+		ExpectQuery("SELECT sessionId, used FROM refresh_tokens").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"sessionId", "used"}))
+
+	_, _, err = sm.Refresh(token)
+	if err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// reused token revokes the session
+	mock.
+		ExpectQuery("SELECT sessionId, used FROM refresh_tokens").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"sessionId", "used"}).AddRow("sess_id", true))
+	mock.
+		ExpectExec("DELETE FROM refresh_tokens").
+		WithArgs("sess_id").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.
+		ExpectExec("DELETE FROM sessions").
+		WithArgs("sess_id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, _, err = sm.Refresh(token)
+	if err != ErrRefreshReused {
+		t.Errorf("expected %v, got %v", ErrRefreshReused, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// concurrent refresh with the same token
+	mock.
+		ExpectQuery("SELECT sessionId, used FROM refresh_tokens").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"sessionId", "used"}).AddRow("sess_id", false))
+	mock.
+		ExpectExec("UPDATE refresh_tokens SET used = 1").
+		WithArgs(hash).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectExec("DELETE FROM refresh_tokens").
+		WithArgs("sess_id").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.
+		ExpectExec("DELETE FROM sessions").
+		WithArgs("sess_id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, _, err = sm.Refresh(token)
+	if err != ErrRefreshReused {
+		t.Errorf("expected %v, got %v", ErrRefreshReused, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// db error
+	mock.
+		ExpectQuery("SELECT sessionId, used FROM refresh_tokens").
+		WithArgs(hash).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, _, err = sm.Refresh(token)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckUpdatesLastSeen(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	sm := NewSessionsManager(NewSQLStore(db), time.Hour)
+	now := time.Now()
+	columns := []string{"id", "userId", "expires", "created", "lastSeen", "userAgent", "ip"}
+
+	// recently seen session isn't updated
+	mock.
+		ExpectQuery("SELECT (.+) FROM sessions WHERE id").
+		WithArgs("sess_id").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("sess_id", "42", now.Add(time.Hour).Unix(), now.Unix(), now.Unix(), "curl", "127.0.0.1"))
+
+	sess, err := sm.Check("sess_id")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if sess.UserAgent != "curl" || sess.IP != "127.0.0.1" || sess.Created.Unix() != now.Unix() {
+		t.Errorf("bad session %+v", sess)
+	}
+
+	// stale last seen time is updated
+	mock.
+		ExpectQuery("SELECT (.+) FROM sessions WHERE id").
+		WithArgs("sess_id").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("sess_id", "42", now.Add(time.Hour).Unix(), 0, now.Add(-time.Hour).Unix(), "", ""))
+	mock.
+		ExpectExec("UPDATE sessions SET lastSeen = \\?, expires = \\?").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "sess_id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sess, err = sm.Check("sess_id")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if !sess.Created.IsZero() || now.Sub(sess.LastSeen) > time.Minute {
+		t.Errorf("bad session %+v", sess)
+	}
+
+	// the idle timeout slides the expiry, but not past the absolute ttl
+	sm.IdleTimeout = 30 * time.Minute
+	mock.
+		ExpectQuery("SELECT (.+) FROM sessions WHERE id").
+		WithArgs("sess_id").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("sess_id", "42", now.Add(time.Minute).Unix(), now.Add(-10*time.Minute).Unix(), now.Add(-5*time.Minute).Unix(), "", ""))
+	mock.
+		ExpectExec("UPDATE sessions SET lastSeen = \\?, expires = \\?").
+		WithArgs(sqlmock.AnyArg(), aroundUnix{now.Add(30 * time.Minute)}, "sess_id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectQuery("SELECT (.+) FROM sessions WHERE id").
+		WithArgs("sess_id").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("sess_id", "42", now.Add(time.Minute).Unix(), now.Add(-50*time.Minute).Unix(), now.Add(-5*time.Minute).Unix(), "", ""))
+	mock.
+		ExpectExec("UPDATE sessions SET lastSeen = \\?, expires = \\?").
+		WithArgs(sqlmock.AnyArg(), now.Add(10*time.Minute).Unix(), "sess_id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sess, err = sm.Check("sess_id")
+	if err != nil || sess.Expires.Sub(now.Add(30*time.Minute)) > time.Second {
+		t.Errorf("bad session %+v, %v", sess, err)
+	}
+	sess, err = sm.Check("sess_id")
+	if err != nil || sess.Expires.Unix() != now.Add(10*time.Minute).Unix() {
+		t.Errorf("bad session %+v, %v", sess, err)
+	}
+
+	// expired session
+	mock.
+		ExpectQuery("SELECT (.+) FROM sessions WHERE id").
+		WithArgs("sess_id").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("sess_id", "42", now.Add(-time.Hour).Unix(), 0, 0, "", ""))
+
+	_, err = sm.Check("sess_id")
+	if err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDestroyAndList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	sm := NewSessionsManager(NewSQLStore(db), time.Hour)
+	now := time.Now()
+
+	mock.
+		ExpectQuery("SELECT (.+) FROM sessions WHERE userId").
+		WithArgs("42", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "userId", "expires", "created", "lastSeen", "userAgent", "ip"}).
+			AddRow("first", "42", now.Add(time.Hour).Unix(), now.Unix(), now.Unix(), "curl", "127.0.0.1").
+			AddRow("second", "42", now.Add(time.Hour).Unix(), now.Unix(), now.Add(-time.Hour).Unix(), "Firefox", "::1"))
+
+	sessions, err := sm.ListForUser("42")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "first" || sessions[1].IP != "::1" {
+		t.Errorf("bad sessions %+v", sessions)
+	}
+
+	mock.
+		ExpectExec("DELETE FROM refresh_tokens").
+		WithArgs("first").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("DELETE FROM sessions").
+		WithArgs("first").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = sm.Destroy("first")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.
+		ExpectExec("DELETE refresh_tokens FROM refresh_tokens").
+		WithArgs("42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("DELETE FROM sessions WHERE userId").
+		WithArgs("42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = sm.DestroyAllForUser("42")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.
+		ExpectExec("DELETE refresh_tokens FROM refresh_tokens").
+		WithArgs("42").
+		WillReturnError(fmt.Errorf("db_error"))
+
+	err = sm.DestroyAllForUser("42")
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	sm := NewSessionsManager(NewSQLStore(db), time.Hour)
+
+	mock.
+		ExpectExec("DELETE refresh_tokens FROM refresh_tokens LEFT JOIN sessions").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.
+		ExpectExec("DELETE FROM sessions WHERE expires").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	purged, err := sm.PurgeExpired()
+	if err != nil || purged != 2 {
+		t.Errorf("bad purge %d, %v", purged, err)
+	}
+
+	mock.
+		ExpectExec("DELETE refresh_tokens FROM refresh_tokens LEFT JOIN sessions").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, err = sm.PurgeExpired()
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// aroundUnix matches the unix time arguments within a second
+type aroundUnix struct {
+	t time.Time
+}
+
+func (a aroundUnix) Match(v driver.Value) bool {
+	sec, ok := v.(int64)
+	return ok && sec >= a.t.Unix() && sec <= a.t.Unix()+1
+}
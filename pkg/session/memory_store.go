@@ -0,0 +1,164 @@
+package session
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in memory, it's used to run the app without MySQL
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data map[string]*Session
+	// refresh maps the refresh token hashes to their state
+	refresh map[string]*refreshToken
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data:    make(map[string]*Session),
+		refresh: make(map[string]*refreshToken),
+	}
+}
+
+// Get returns a copy of the session
+func (s *MemoryStore) Get(sessionID string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, ok := s.data[sessionID]
+	if !ok {
+		return nil, ErrNoAuth
+	}
+	sess := *stored
+	return &sess, nil
+}
+
+// Save stores the session as it is, the existing session with the same id is replaced
+func (s *MemoryStore) Save(sess *Session) error {
+	stored := *sess
+	s.mu.Lock()
+	s.data[sess.ID] = &stored
+	s.mu.Unlock()
+	return nil
+}
+
+// Touch writes the last seen time and expiry of the session
+func (s *MemoryStore) Touch(sess *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.data[sess.ID]; ok {
+		stored.LastSeen = sess.LastSeen
+		stored.Expires = sess.Expires
+	}
+	return nil
+}
+
+// Delete deletes the session with all its refresh tokens
+func (s *MemoryStore) Delete(sessionID string) error {
+	s.mu.Lock()
+	s.delete(sessionID)
+	s.mu.Unlock()
+	return nil
+}
+
+// DeleteForUser deletes all the sessions of the user with their refresh tokens
+func (s *MemoryStore) DeleteForUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, stored := range s.data {
+		if stored.UserID == userID {
+			s.delete(id)
+		}
+	}
+	return nil
+}
+
+// delete deletes the session with all its refresh tokens, the lock must be held
+func (s *MemoryStore) delete(sessionID string) {
+	delete(s.data, sessionID)
+	for hash, rt := range s.refresh {
+		if rt.sessionID == sessionID {
+			delete(s.refresh, hash)
+		}
+	}
+}
+
+// ListForUser returns the sessions of the user active at now, the recently seen first
+func (s *MemoryStore) ListForUser(userID string, now time.Time) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := []*Session{}
+	for _, stored := range s.data {
+		if stored.UserID == userID && !stored.expired(now) {
+			sess := *stored
+			sessions = append(sessions, &sess)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// All returns all the stored sessions including the expired ones
+func (s *MemoryStore) All() ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := make([]*Session, 0, len(s.data))
+	for _, stored := range s.data {
+		sess := *stored
+		sessions = append(sessions, &sess)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Expires.Before(sessions[j].Expires)
+	})
+	return sessions, nil
+}
+
+// PurgeExpired deletes the expired sessions with their refresh tokens and returns the number of sessions deleted
+func (s *MemoryStore) PurgeExpired(now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for id, stored := range s.data {
+		if stored.expired(now) {
+			delete(s.data, id)
+			purged++
+		}
+	}
+	for hash, rt := range s.refresh {
+		if _, ok := s.data[rt.sessionID]; !ok {
+			delete(s.refresh, hash)
+		}
+	}
+	return purged, nil
+}
+
+// AddRefreshToken stores the hash of a new refresh token, it's purged with the session
+func (s *MemoryStore) AddRefreshToken(hash, sessionID string, expires time.Time) error {
+	s.mu.Lock()
+	s.refresh[hash] = &refreshToken{sessionID: sessionID}
+	s.mu.Unlock()
+	return nil
+}
+
+// UseRefreshToken marks the token used and returns its session
+func (s *MemoryStore) UseRefreshToken(hash string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.refresh[hash]
+	if !ok {
+		return "", false, ErrNoAuth
+	}
+	reused := rt.used
+	rt.used = true
+	return rt.sessionID, reused, nil
+}
+
+// refreshToken is the state of an issued refresh token
+type refreshToken struct {
+	sessionID string
+	// used is set once the token is exchanged for the next one
+	used bool
+}
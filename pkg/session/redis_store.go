@@ -0,0 +1,417 @@
+package session
+
+import (
+	"encoding/json"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/redis"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// RedisClient sends the commands to a server speaking the Redis protocol
+type RedisClient interface {
+	Do(args ...string) (interface{}, error)
+	Pipeline(cmds ...[]string) ([]interface{}, error)
+	Tx(cmds ...[]string) ([]interface{}, error)
+}
+
+// DefaultRedisPrefix starts the keys of the sessions, so the server can be shared with other apps
+const DefaultRedisPrefix = "redditclone:"
+
+// redisScanCount is the number of keys SCAN looks at in one call
+const redisScanCount = "100"
+
+// RedisStore keeps the sessions in Redis, they expire with their keys. The keys are:
+//
+//	session:<id>          the session as JSON
+//	user-sessions:<user>  the set of the session ids of the user
+//	session-refresh:<id>  the set of the refresh token hashes of the session
+//	refresh:<hash>        the hash of the refresh token with the session field and the used flag
+//
+// The sets are cleaned from the expired sessions by PurgeExpired
+type RedisStore struct {
+	Client RedisClient
+	Prefix string
+}
+
+// NewRedisStore creates a store keeping the keys under DefaultRedisPrefix
+func NewRedisStore(client RedisClient) *RedisStore {
+	return &RedisStore{
+		Client: client,
+		Prefix: DefaultRedisPrefix,
+	}
+}
+
+// redisSession is the JSON stored for a session, the times are unix seconds like in MySQL
+type redisSession struct {
+	UserID    string `json:"userId"`
+	Expires   int64  `json:"expires"`
+	Created   int64  `json:"created,omitempty"`
+	LastSeen  int64  `json:"lastSeen,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	IP        string `json:"ip,omitempty"`
+}
+
+func (s *RedisStore) sessionKey(sessionID string) string {
+	return s.Prefix + "session:" + sessionID
+}
+
+func (s *RedisStore) userKey(userID string) string {
+	return s.Prefix + "user-sessions:" + userID
+}
+
+func (s *RedisStore) sessionRefreshKey(sessionID string) string {
+	return s.Prefix + "session-refresh:" + sessionID
+}
+
+func (s *RedisStore) refreshKey(hash string) string {
+	return s.Prefix + "refresh:" + hash
+}
+
+// Get returns the session
+func (s *RedisStore) Get(sessionID string) (*Session, error) {
+	data, err := redis.String(s.Client.Do("GET", s.sessionKey(sessionID)))
+	if err == redis.ErrNil {
+		return nil, ErrNoAuth
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeRedisSession(sessionID, data)
+}
+
+// Save stores the session as it is, the existing session with the same id is replaced.
+// The expired session isn't stored as its key would expire at once
+func (s *RedisStore) Save(sess *Session) error {
+	ttl, ok := redisTTL(sess.Expires)
+	if !ok {
+		return s.Delete(sess.ID)
+	}
+	data, err := encodeRedisSession(sess)
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.Tx(
+		[]string{"SET", s.sessionKey(sess.ID), data, "PX", ttl},
+		[]string{"SADD", s.userKey(sess.UserID), sess.ID},
+	)
+	return err
+}
+
+// Touch writes the last seen time and expiry of the session, it's written only if the key still exists
+func (s *RedisStore) Touch(sess *Session) error {
+	ttl, ok := redisTTL(sess.Expires)
+	if !ok {
+		return nil
+	}
+	data, err := encodeRedisSession(sess)
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.Do("SET", s.sessionKey(sess.ID), data, "XX", "PX", ttl)
+	return err
+}
+
+// Delete deletes the session with all its refresh tokens
+func (s *RedisStore) Delete(sessionID string) error {
+	sess, err := s.Get(sessionID)
+	if err != nil && err != ErrNoAuth {
+		return err
+	}
+	// the user index of an expired session is cleaned by PurgeExpired
+	userID := ""
+	if sess != nil {
+		userID = sess.UserID
+	}
+	return s.deleteSessions(userID, []string{sessionID})
+}
+
+// DeleteForUser deletes all the sessions of the user with their refresh tokens
+func (s *RedisStore) DeleteForUser(userID string) error {
+	sessions, ids, err := s.userSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, sess := range sessions {
+		ids = append(ids, sess.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.deleteSessions(userID, ids)
+}
+
+// deleteSessions deletes the sessions with their refresh tokens and removes them from the user index
+func (s *RedisStore) deleteSessions(userID string, ids []string) error {
+	cmds := make([][]string, len(ids))
+	for i, id := range ids {
+		cmds[i] = []string{"SMEMBERS", s.sessionRefreshKey(id)}
+	}
+	replies, err := s.Client.Pipeline(cmds...)
+	if err != nil {
+		return err
+	}
+
+	del := []string{"DEL"}
+	for i, id := range ids {
+		hashes, err := redis.Strings(replies[i], nil)
+		if err != nil {
+			return err
+		}
+		del = append(del, s.sessionKey(id), s.sessionRefreshKey(id))
+		for _, hash := range hashes {
+			del = append(del, s.refreshKey(hash))
+		}
+	}
+	cmds = [][]string{del}
+	if userID != "" {
+		cmds = append(cmds, append([]string{"SREM", s.userKey(userID)}, ids...))
+	}
+	_, err = s.Client.Tx(cmds...)
+	return err
+}
+
+// ListForUser returns the sessions of the user active at now, the recently seen first
+func (s *RedisStore) ListForUser(userID string, now time.Time) ([]*Session, error) {
+	all, _, err := s.userSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []*Session{}
+	for _, sess := range all {
+		if !sess.expired(now) {
+			sessions = append(sessions, sess)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// userSessions returns the stored sessions of the user and the ids in the user index with no session
+func (s *RedisStore) userSessions(userID string) ([]*Session, []string, error) {
+	ids, err := redis.Strings(s.Client.Do("SMEMBERS", s.userKey(userID)))
+	if err != nil || len(ids) == 0 {
+		return nil, nil, err
+	}
+	sessions, err := s.mget(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	found := []*Session{}
+	missing := []string{}
+	for i, sess := range sessions {
+		switch {
+		case sess == nil:
+			missing = append(missing, ids[i])
+		// a session saved again for another user is left in the index of the first one
+		case sess.UserID == userID:
+			found = append(found, sess)
+		}
+	}
+	return found, missing, nil
+}
+
+// All returns all the stored sessions, the expired ones are gone with their keys
+func (s *RedisStore) All() ([]*Session, error) {
+	sessions := []*Session{}
+	prefix := s.sessionKey("")
+	err := s.scan(prefix+"*", func(keys []string) error {
+		ids := make([]string, len(keys))
+		for i, key := range keys {
+			ids[i] = key[len(prefix):]
+		}
+		found, err := s.mget(ids)
+		if err != nil {
+			return err
+		}
+		for _, sess := range found {
+			if sess != nil {
+				sessions = append(sessions, sess)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Expires.Before(sessions[j].Expires)
+	})
+	return sessions, nil
+}
+
+// PurgeExpired cleans the user indexes and the refresh tokens of the sessions whose keys have expired,
+// it returns the number of the sessions cleaned
+func (s *RedisStore) PurgeExpired(now time.Time) (int64, error) {
+	var purged int64
+	prefix := s.userKey("")
+	err := s.scan(prefix+"*", func(keys []string) error {
+		for _, key := range keys {
+			userID := key[len(prefix):]
+			sessions, missing, err := s.userSessions(userID)
+			if err != nil {
+				return err
+			}
+			// the keys may outlive the sessions by a second as the expiry is stored in seconds
+			for _, sess := range sessions {
+				if sess.expired(now) {
+					missing = append(missing, sess.ID)
+				}
+			}
+			if len(missing) == 0 {
+				continue
+			}
+			err = s.deleteSessions(userID, missing)
+			if err != nil {
+				return err
+			}
+			purged += int64(len(missing))
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// AddRefreshToken stores the hash of a new refresh token, its key expires by the expiry
+func (s *RedisStore) AddRefreshToken(hash, sessionID string, expires time.Time) error {
+	key := s.refreshKey(hash)
+	_, err := s.Client.Tx(
+		[]string{"HSET", key, "session", sessionID},
+		[]string{"PEXPIREAT", key, strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10)},
+		[]string{"SADD", s.sessionRefreshKey(sessionID), hash},
+	)
+	return err
+}
+
+// UseRefreshToken marks the token used and returns its session, HSETNX tells whether it has been used before
+func (s *RedisStore) UseRefreshToken(hash string) (string, bool, error) {
+	key := s.refreshKey(hash)
+	results, err := s.Client.Tx(
+		[]string{"HGET", key, "session"},
+		[]string{"HSETNX", key, "used", "1"},
+	)
+	if err != nil {
+		return "", false, err
+	}
+	sessionID, err := redis.String(results[0], nil)
+	if err == redis.ErrNil {
+		// HSETNX has created the key of the unknown token
+		_, err = s.Client.Do("DEL", key)
+		if err != nil {
+			return "", false, err
+		}
+		return "", false, ErrNoAuth
+	}
+	if err != nil {
+		return "", false, err
+	}
+	set, err := redis.Int64(results[1], nil)
+	if err != nil {
+		return "", false, err
+	}
+	return sessionID, set == 0, nil
+}
+
+// mget returns the sessions by their ids, nil for the missing ones
+func (s *RedisStore) mget(ids []string) ([]*Session, error) {
+	args := make([]string, 0, len(ids)+1)
+	args = append(args, "MGET")
+	for _, id := range ids {
+		args = append(args, s.sessionKey(id))
+	}
+	reply, err := s.Client.Do(args...)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != len(ids) {
+		return nil, redis.ErrProtocol
+	}
+	sessions := make([]*Session, len(ids))
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		data, err := redis.String(item, nil)
+		if err != nil {
+			return nil, err
+		}
+		sessions[i], err = decodeRedisSession(ids[i], data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
+
+// scan passes the keys matching the pattern to fn a page at a time
+func (s *RedisStore) scan(pattern string, fn func(keys []string) error) error {
+	cursor := "0"
+	for {
+		reply, err := s.Client.Do("SCAN", cursor, "MATCH", pattern, "COUNT", redisScanCount)
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return redis.ErrProtocol
+		}
+		cursor, err = redis.String(page[0], nil)
+		if err != nil {
+			return err
+		}
+		keys, err := redis.Strings(page[1], nil)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			err = fn(keys)
+			if err != nil {
+				return err
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// redisTTL returns the milliseconds left until the expiry for PX, ok is false for the expired sessions
+func redisTTL(expires time.Time) (string, bool) {
+	ms := time.Until(expires).Milliseconds()
+	if ms <= 0 {
+		return "", false
+	}
+	return strconv.FormatInt(ms, 10), true
+}
+
+func encodeRedisSession(sess *Session) (string, error) {
+	data, err := json.Marshal(redisSession{
+		UserID:    sess.UserID,
+		Expires:   sess.Expires.Unix(),
+		Created:   toUnix(sess.Created),
+		LastSeen:  toUnix(sess.LastSeen),
+		UserAgent: sess.UserAgent,
+		IP:        sess.IP,
+	})
+	return string(data), err
+}
+
+func decodeRedisSession(sessionID, data string) (*Session, error) {
+	stored := redisSession{}
+	err := json.Unmarshal([]byte(data), &stored)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:        sessionID,
+		UserID:    stored.UserID,
+		Expires:   time.Unix(stored.Expires, 0),
+		Created:   fromUnix(stored.Created),
+		LastSeen:  fromUnix(stored.LastSeen),
+		UserAgent: stored.UserAgent,
+		IP:        stored.IP,
+	}, nil
+}
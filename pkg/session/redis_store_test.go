@@ -0,0 +1,145 @@
+package session
+
+import (
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/redis"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/redis/redistest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newRedisManager(t *testing.T) (*SessionsManager, *redistest.Server, func()) {
+	srv := redistest.NewServer()
+	client, err := redis.NewClient(srv.URL())
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	sm := NewSessionsManager(NewRedisStore(client), time.Hour)
+	return sm, srv, func() {
+		client.Close()
+		srv.Close()
+	}
+}
+
+func TestRedisStoreKeys(t *testing.T) {
+	sm, srv, done := newRedisManager(t)
+	defer done()
+
+	sess, _ := sm.Create("42", Client{UserAgent: "curl", IP: "127.0.0.1"})
+	token, _ := sm.NewRefreshToken(sess.ID)
+	other, _ := sm.Create("43", Client{})
+	expected := []string{
+		"redditclone:refresh:" + hashRefreshToken(token),
+		"redditclone:session-refresh:" + sess.ID,
+		"redditclone:session:" + sess.ID,
+		"redditclone:session:" + other.ID,
+		"redditclone:user-sessions:42",
+		"redditclone:user-sessions:43",
+	}
+	// the session ids are random, so their keys sort in any order
+	sort.Strings(expected)
+	if keys := srv.Keys(0); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("results not match, want %v, have %v", expected, keys)
+	}
+
+	// the unknown tokens leave no keys behind
+	if _, _, err := sm.Refresh("unknown"); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+	if keys := srv.Keys(0); len(keys) != len(expected) {
+		t.Errorf("bad keys %v", keys)
+	}
+
+	all, err := sm.All()
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	// both sessions expire in the same second, so they come in any order
+	if len(all) == 2 && all[0].ID == other.ID {
+		all[0], all[1] = all[1], all[0]
+	}
+	if len(all) != 2 || all[0].ID != sess.ID || all[0].UserAgent != "curl" || all[1].ID != other.ID {
+		t.Errorf("bad sessions %+v", all)
+	}
+
+	err = sm.DestroyAllForUser("42")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	expected = []string{
+		"redditclone:session:" + other.ID,
+		"redditclone:user-sessions:43",
+	}
+	if keys := srv.Keys(0); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("results not match, want %v, have %v", expected, keys)
+	}
+}
+
+func TestRedisStorePurgeExpired(t *testing.T) {
+	sm, srv, done := newRedisManager(t)
+	defer done()
+	sm.IdleTimeout = 10 * time.Minute
+
+	idle, _ := sm.Create("42", Client{})
+	sm.NewRefreshToken(idle.ID)
+	active, _ := sm.Create("42", Client{})
+	activeToken, _ := sm.NewRefreshToken(active.ID)
+
+	// the idle session's key expires by itself, its index entry and tokens are purged
+	srv.Advance(5 * time.Minute)
+	stored, _ := sm.Store.Get(active.ID)
+	stored.Expires = time.Now().Add(time.Hour)
+	sm.Save(stored)
+	srv.Advance(6 * time.Minute)
+	if _, err := sm.Check(idle.ID); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+
+	purged, err := sm.PurgeExpired()
+	if err != nil || purged != 1 {
+		t.Errorf("bad purge %d, %v", purged, err)
+	}
+	expected := []string{
+		"redditclone:refresh:" + hashRefreshToken(activeToken),
+		"redditclone:session-refresh:" + active.ID,
+		"redditclone:session:" + active.ID,
+		"redditclone:user-sessions:42",
+	}
+	if keys := srv.Keys(0); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("results not match, want %v, have %v", expected, keys)
+	}
+	if sessions, _ := sm.ListForUser("42"); len(sessions) != 1 || sessions[0].ID != active.ID {
+		t.Errorf("bad sessions %+v", sessions)
+	}
+
+	// the refresh tokens don't outlive the ttl
+	srv.Advance(time.Hour)
+	if keys := srv.Keys(0); len(keys) != 2 {
+		t.Errorf("bad keys %v", keys)
+	}
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	sm, srv, done := newRedisManager(t)
+	defer done()
+	sess, _ := sm.Create("42", Client{})
+	token, _ := sm.NewRefreshToken(sess.ID)
+	srv.Close()
+
+	if _, err := sm.Check(sess.ID); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+	if _, err := sm.Create("42", Client{}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if _, _, err := sm.Refresh(token); err == nil || err == ErrNoAuth {
+		t.Errorf("expected a network error, got %v", err)
+	}
+	if err := sm.Destroy(sess.ID); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if _, err := sm.PurgeExpired(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
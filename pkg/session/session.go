@@ -3,7 +3,9 @@ package session
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"time"
 )
@@ -12,25 +14,92 @@ type Session struct {
 	ID      string
 	UserID  string
 	Expires time.Time
+	// Created, LastSeen and the client are shown to the user reviewing their sessions
+	Created   time.Time
+	LastSeen  time.Time
+	UserAgent string
+	IP        string
+}
+
+// Client describes where a session is created from
+type Client struct {
+	UserAgent string
+	IP        string
 }
 
 const IDLength int = 32
 
-func NewSession(userID string) (*Session, error) {
+// maxUserAgentLength limits the stored user agents
+const maxUserAgentLength = 255
+
+// LastSeenInterval is how often the last seen time of an active session is updated
+const LastSeenInterval = time.Minute
+
+func NewSession(userID string, ttl time.Duration) (*Session, error) {
 	id, err := generateRandomString(IDLength)
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
 	return &Session{
-		ID:      id,
-		UserID:  userID,
-		Expires: time.Now().Add(time.Hour),
+		ID:       id,
+		UserID:   userID,
+		Expires:  now.Add(ttl),
+		Created:  now,
+		LastSeen: now,
 	}, nil
 }
 
+// newClientSession creates a session of the client expiring after the idle timeout unless it's used
+func newClientSession(userID string, ttl, idleTimeout time.Duration, client Client) (*Session, error) {
+	sess, err := NewSession(userID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	sess.touch(sess.Created, ttl, idleTimeout)
+	sess.UserAgent = client.UserAgent
+	if len(sess.UserAgent) > maxUserAgentLength {
+		sess.UserAgent = sess.UserAgent[:maxUserAgentLength]
+	}
+	sess.IP = client.IP
+	return sess, nil
+}
+
+// PublicID identifies the session to its user without revealing the session id, which is a credential
+func (s *Session) PublicID() string {
+	sum := sha256.Sum256([]byte(s.ID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// expired reports whether the session can't be used anymore
+func (s *Session) expired(now time.Time) bool {
+	return s.Expires.Unix() < now.Unix()
+}
+
+// touch marks the session seen and slides its expiry by the idle timeout,
+// but never past the absolute ttl since the creation. Without the idle timeout the expiry stays
+func (s *Session) touch(now time.Time, ttl, idleTimeout time.Duration) {
+	s.LastSeen = now
+	if idleTimeout <= 0 {
+		return
+	}
+	// the creation time of the sessions made before it was tracked is unknown
+	limit := s.Expires
+	if !s.Created.IsZero() {
+		limit = s.Created.Add(ttl)
+	}
+	s.Expires = now.Add(idleTimeout)
+	if s.Expires.After(limit) {
+		s.Expires = limit
+	}
+}
+
 var (
 	ErrNoAuth = errors.New("No session found")
+	// ErrRefreshReused is returned when an already rotated refresh token is presented again,
+	// the whole session is revoked then as the token has likely been stolen
+	ErrRefreshReused = errors.New("Refresh token reuse detected")
 )
 
 type sessKey string
@@ -45,6 +114,28 @@ func SessionFromContext(ctx context.Context) (*Session, error) {
 	return sess, nil
 }
 
+// hashRefreshToken is what is stored instead of the refresh token itself
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// fromUnix converts the stored unix seconds, 0 stands for an unknown time
+func fromUnix(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// toUnix converts the time into the stored unix seconds, 0 stands for an unknown time
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
 func generateRandomString(length int) (string, error) {
 	bytes := make([]byte, length)
 	_, err := rand.Read(bytes)
@@ -0,0 +1,185 @@
+package session
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SQLStore keeps the sessions in the MySQL sessions and refresh_tokens tables
+type SQLStore struct {
+	DB *sql.DB
+}
+
+// NewSQLStore creates a store on the database
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{DB: db}
+}
+
+// sessionColumns are scanned by scanSession
+const sessionColumns = "id, userId, expires, created, lastSeen, userAgent, ip"
+
+// Get returns the session
+func (s *SQLStore) Get(sessionID string) (*Session, error) {
+	sess, err := scanSession(s.DB.
+		QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", sessionID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoAuth
+	}
+	return sess, err
+}
+
+// Save stores the session as it is, the existing session with the same id is replaced
+func (s *SQLStore) Save(sess *Session) error {
+	_, err := s.DB.Exec(
+		"INSERT INTO sessions (`id`, `userId`, `expires`, `created`, `lastSeen`, `userAgent`, `ip`) VALUES (?, ?, ?, ?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE `userId` = VALUES(`userId`), `expires` = VALUES(`expires`), "+
+			"`created` = VALUES(`created`), `lastSeen` = VALUES(`lastSeen`), "+
+			"`userAgent` = VALUES(`userAgent`), `ip` = VALUES(`ip`)",
+		sess.ID,
+		sess.UserID,
+		sess.Expires.Unix(),
+		toUnix(sess.Created),
+		toUnix(sess.LastSeen),
+		sess.UserAgent,
+		sess.IP,
+	)
+	return err
+}
+
+// Touch writes the last seen time and expiry of the session
+func (s *SQLStore) Touch(sess *Session) error {
+	_, err := s.DB.Exec(
+		"UPDATE sessions SET lastSeen = ?, expires = ? WHERE id = ?",
+		sess.LastSeen.Unix(),
+		sess.Expires.Unix(),
+		sess.ID,
+	)
+	return err
+}
+
+// Delete deletes the session with all its refresh tokens
+func (s *SQLStore) Delete(sessionID string) error {
+	_, err := s.DB.Exec("DELETE FROM refresh_tokens WHERE sessionId = ?", sessionID)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
+	return err
+}
+
+// DeleteForUser deletes all the sessions of the user with their refresh tokens
+func (s *SQLStore) DeleteForUser(userID string) error {
+	_, err := s.DB.Exec(
+		"DELETE refresh_tokens FROM refresh_tokens JOIN sessions ON sessions.id = refresh_tokens.sessionId WHERE sessions.userId = ?",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec("DELETE FROM sessions WHERE userId = ?", userID)
+	return err
+}
+
+// ListForUser returns the sessions of the user active at now, the recently seen first
+func (s *SQLStore) ListForUser(userID string, now time.Time) ([]*Session, error) {
+	return s.query(
+		"SELECT "+sessionColumns+" FROM sessions WHERE userId = ? AND expires >= ? ORDER BY lastSeen DESC",
+		userID,
+		now.Unix(),
+	)
+}
+
+// All returns all the stored sessions including the expired ones
+func (s *SQLStore) All() ([]*Session, error) {
+	return s.query("SELECT " + sessionColumns + " FROM sessions ORDER BY expires")
+}
+
+// PurgeExpired deletes the expired sessions with their refresh tokens and returns the number of sessions deleted
+func (s *SQLStore) PurgeExpired(now time.Time) (int64, error) {
+	// the refresh tokens of the sessions deleted some other way are purged as well
+	_, err := s.DB.Exec(
+		"DELETE refresh_tokens FROM refresh_tokens LEFT JOIN sessions ON sessions.id = refresh_tokens.sessionId "+
+			"WHERE sessions.id IS NULL OR sessions.expires < ?",
+		now.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.DB.Exec("DELETE FROM sessions WHERE expires < ?", now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AddRefreshToken stores the hash of a new refresh token, it's purged with the session
+func (s *SQLStore) AddRefreshToken(hash, sessionID string, expires time.Time) error {
+	_, err := s.DB.Exec(
+		"INSERT INTO refresh_tokens (`tokenHash`, `sessionId`) VALUES (?, ?)",
+		hash,
+		sessionID,
+	)
+	return err
+}
+
+// UseRefreshToken marks the token used and returns its session
+func (s *SQLStore) UseRefreshToken(hash string) (string, bool, error) {
+	var sessionID string
+	var used bool
+	err := s.DB.
+		QueryRow("SELECT sessionId, used FROM refresh_tokens WHERE tokenHash = ?", hash).
+		Scan(&sessionID, &used)
+	if err == sql.ErrNoRows {
+		return "", false, ErrNoAuth
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if used {
+		return sessionID, true, nil
+	}
+
+	result, err := s.DB.Exec("UPDATE refresh_tokens SET used = 1 WHERE tokenHash = ? AND used = 0", hash)
+	if err != nil {
+		return "", false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return "", false, err
+	}
+	// a concurrent refresh with the same token has won
+	return sessionID, affected == 0, nil
+}
+
+// query retrieves the sessions selected by the query
+func (s *SQLStore) query(query string, args ...interface{}) ([]*Session, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// scanSession reads the sessionColumns of a row
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
+	sess := &Session{}
+	var expires, created, lastSeen int64
+	err := row.Scan(&sess.ID, &sess.UserID, &expires, &created, &lastSeen, &sess.UserAgent, &sess.IP)
+	if err != nil {
+		return nil, err
+	}
+	sess.Expires = time.Unix(expires, 0)
+	sess.Created = fromUnix(created)
+	sess.LastSeen = fromUnix(lastSeen)
+	return sess, nil
+}
@@ -0,0 +1,172 @@
+package session
+
+import (
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/redis"
+	"golang-stepik-2020q2/6/99_hw/redditclone/pkg/redis/redistest"
+	"testing"
+	"time"
+)
+
+// testStores runs the test against the memory store and the Redis one on a stand-in server,
+// the MySQL one is tested with sqlmock
+func testStores(t *testing.T, test func(t *testing.T, sm *SessionsManager)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewSessionsManager(NewMemoryStore(), time.Hour))
+	})
+	t.Run("redis", func(t *testing.T) {
+		srv := redistest.NewServer()
+		defer srv.Close()
+		client, err := redis.NewClient(srv.URL())
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+		defer client.Close()
+		test(t, NewSessionsManager(NewRedisStore(client), time.Hour))
+	})
+}
+
+func TestStoreRefresh(t *testing.T) {
+	testStores(t, testStoreRefresh)
+}
+
+func testStoreRefresh(t *testing.T, sm *SessionsManager) {
+	sess, err := sm.Create("42", Client{})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	first, err := sm.NewRefreshToken(sess.ID)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	refreshed, second, err := sm.Refresh(first)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if refreshed.ID != sess.ID || refreshed.UserID != "42" || second == "" || second == first {
+		t.Errorf("bad refresh %+v, %q", refreshed, second)
+	}
+	_, third, err := sm.Refresh(second)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if _, _, err = sm.Refresh("unknown"); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+
+	// replaying a rotated token revokes the session and the latest token
+	if _, _, err = sm.Refresh(first); err != ErrRefreshReused {
+		t.Errorf("expected %v, got %v", ErrRefreshReused, err)
+	}
+	if _, err = sm.Check(sess.ID); err != ErrNoAuth {
+		t.Errorf("session wasn't revoked: %v", err)
+	}
+	if _, _, err = sm.Refresh(third); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+
+	// the refresh tokens don't outlive the session
+	expired, _ := sm.Create("42", Client{})
+	token, _ := sm.NewRefreshToken(expired.ID)
+	expired.Expires = time.Now().Add(-time.Minute)
+	sm.Save(expired)
+	if _, _, err = sm.Refresh(token); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+}
+
+func TestStoreDestroy(t *testing.T) {
+	testStores(t, testStoreDestroy)
+}
+
+func testStoreDestroy(t *testing.T, sm *SessionsManager) {
+	first, _ := sm.Create("42", Client{UserAgent: "curl", IP: "127.0.0.1"})
+	second, _ := sm.Create("42", Client{UserAgent: "Firefox", IP: "::1"})
+	other, _ := sm.Create("43", Client{})
+	token, _ := sm.NewRefreshToken(first.ID)
+
+	// the recently seen sessions go first
+	stored, _ := sm.Check(first.ID)
+	stored.LastSeen = time.Now().Add(-time.Hour)
+	sm.Save(stored)
+	sessions, err := sm.ListForUser("42")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != second.ID || sessions[1].UserAgent != "curl" {
+		t.Errorf("bad sessions %+v", sessions)
+	}
+
+	sm.Destroy(first.ID)
+	if _, err = sm.Check(first.ID); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+	if _, _, err = sm.Refresh(token); err != ErrNoAuth {
+		t.Errorf("refresh token outlived the session: %v", err)
+	}
+
+	sm.DestroyAllForUser("42")
+	if sessions, _ = sm.ListForUser("42"); len(sessions) != 0 {
+		t.Errorf("sessions weren't destroyed %+v", sessions)
+	}
+	if _, err = sm.Check(other.ID); err != nil {
+		t.Errorf("other user's session was destroyed: %v", err)
+	}
+}
+
+func TestStoreIdleTimeout(t *testing.T) {
+	testStores(t, testStoreIdleTimeout)
+}
+
+func testStoreIdleTimeout(t *testing.T, sm *SessionsManager) {
+	sm.IdleTimeout = 10 * time.Minute
+	sess, _ := sm.Create("42", Client{})
+	if sess.Expires.Sub(sess.Created) != 10*time.Minute {
+		t.Errorf("bad expiry %v", sess.Expires.Sub(sess.Created))
+	}
+
+	// using the session slides the expiry up to the absolute ttl
+	stored, _ := sm.Check(sess.ID)
+	stored.Created = time.Now().Add(-55 * time.Minute)
+	stored.LastSeen = time.Now().Add(-5 * time.Minute)
+	sm.Save(stored)
+	checked, err := sm.Check(sess.ID)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	// the times are kept in seconds by all the stores but the memory one
+	if checked.Expires.Unix() != stored.Created.Add(time.Hour).Unix() {
+		t.Errorf("expiry slid past the absolute ttl: %v", checked.Expires)
+	}
+
+	// the idle session expires
+	stored.Expires = time.Now().Add(-time.Second)
+	sm.Save(stored)
+	if _, err = sm.Check(sess.ID); err != ErrNoAuth {
+		t.Errorf("expected %v, got %v", ErrNoAuth, err)
+	}
+}
+
+func TestMemoryStorePurgeExpired(t *testing.T) {
+	store := NewMemoryStore()
+	sm := NewSessionsManager(store, time.Hour)
+	active, _ := sm.Create("42", Client{})
+	activeToken, _ := sm.NewRefreshToken(active.ID)
+	expired, _ := sm.Create("42", Client{})
+	sm.NewRefreshToken(expired.ID)
+	expired.Expires = time.Now().Add(-time.Minute)
+	sm.Save(expired)
+
+	purged, err := sm.PurgeExpired()
+	if err != nil || purged != 1 {
+		t.Errorf("bad purge %d, %v", purged, err)
+	}
+	all, _ := sm.All()
+	if len(all) != 1 || all[0].ID != active.ID || len(store.refresh) != 1 {
+		t.Errorf("bad sessions left %+v, %d refresh tokens", all, len(store.refresh))
+	}
+	if _, _, err = sm.Refresh(activeToken); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+}
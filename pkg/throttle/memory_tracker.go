@@ -0,0 +1,132 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// minSweep is the number of the tracked keys the first sweep of the forgotten ones starts at
+const minSweep = 1024
+
+// MemoryTracker keeps the failed attempts in memory, so they are tracked per instance
+type MemoryTracker struct {
+	mu   sync.Mutex
+	data map[string]*attempts
+	// nextSweep is the number of the tracked keys the next sweep starts at
+	nextSweep int
+	now       func() time.Time
+	Policy    Policy
+}
+
+type attempts struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// NewMemoryTracker creates a new in-memory tracker of the failed attempts
+func NewMemoryTracker(policy Policy) *MemoryTracker {
+	return &MemoryTracker{
+		data:      make(map[string]*attempts),
+		nextSweep: minSweep,
+		now:       time.Now,
+		Policy:    policy,
+	}
+}
+
+// Wait returns how long the key waits before the next attempt, it's zero when the attempt is allowed
+func (t *MemoryTracker) Wait(key string) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stored, ok := t.data[key]
+	if !ok {
+		return 0, nil
+	}
+	now := t.now()
+	if now.Before(stored.until) {
+		return stored.until.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Fail records a failed attempt and returns the wait before the next one
+func (t *MemoryTracker) Fail(key string) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fail(key, t.now()), nil
+}
+
+// Try counts an attempt as failed before it's checked and returns the wait when it isn't allowed yet,
+// the attempt waiting isn't counted. Checking and counting at once keeps the parallel attempts
+// from all passing the check, the attempt found right is taken back with Undo or Reset
+func (t *MemoryTracker) Try(key string) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if stored, ok := t.data[key]; ok && now.Before(stored.until) {
+		return stored.until.Sub(now), nil
+	}
+	t.fail(key, now)
+	return 0, nil
+}
+
+// Undo takes back an attempt counted by Try, the wait is set as if it was never made
+func (t *MemoryTracker) Undo(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stored, ok := t.data[key]
+	if !ok {
+		return nil
+	}
+	stored.failures--
+	if stored.failures <= 0 {
+		delete(t.data, key)
+		return nil
+	}
+	stored.until = stored.last.Add(t.Policy.Wait(stored.failures))
+	return nil
+}
+
+// fail counts a failure of the key and returns the wait before the next attempt, the lock must be held
+func (t *MemoryTracker) fail(key string, now time.Time) time.Duration {
+	stored, ok := t.data[key]
+	if !ok || t.forgotten(stored, now) {
+		stored = &attempts{}
+		t.data[key] = stored
+	}
+	stored.failures++
+	stored.last = now
+	wait := t.Policy.Wait(stored.failures)
+	stored.until = now.Add(wait)
+
+	if len(t.data) >= t.nextSweep {
+		t.sweep(now)
+	}
+	return wait
+}
+
+// Reset forgets the failed attempts of the key, e.g. after a successful one
+func (t *MemoryTracker) Reset(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.data, key)
+	return nil
+}
+
+// forgotten reports whether the lockout duration has passed since the last failure and its wait
+func (t *MemoryTracker) forgotten(stored *attempts, now time.Time) bool {
+	return !now.Before(stored.until) && now.Sub(stored.last) >= t.Policy.LockoutDuration
+}
+
+// sweep drops the forgotten keys, so the keys of a spraying attack don't pile up, the lock must be held
+func (t *MemoryTracker) sweep(now time.Time) {
+	for key, stored := range t.data {
+		if t.forgotten(stored, now) {
+			delete(t.data, key)
+		}
+	}
+	t.nextSweep = 2 * len(t.data)
+	if t.nextSweep < minSweep {
+		t.nextSweep = minSweep
+	}
+}
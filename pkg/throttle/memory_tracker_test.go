@@ -0,0 +1,146 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	LockoutAttempts: 6,
+	LockoutDuration: 15 * time.Minute,
+}
+
+func TestPolicyWait(t *testing.T) {
+	expected := []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second, 15 * time.Minute, 15 * time.Minute}
+	for failures, wait := range expected {
+		if have := testPolicy.Wait(failures); have != wait {
+			t.Errorf("bad wait after %d failures: want %s, have %s", failures, wait, have)
+		}
+	}
+
+	// the backoff is capped by the lockout duration and never locks out without the lockout attempts
+	policy := Policy{BaseDelay: time.Minute, LockoutDuration: 10 * time.Minute}
+	if have := policy.Wait(100); have != 10*time.Minute {
+		t.Errorf("bad capped wait %s", have)
+	}
+}
+
+func TestMemoryTracker(t *testing.T) {
+	now := time.Now()
+	tracker := NewMemoryTracker(testPolicy)
+	tracker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		wait, err := tracker.Fail("alice")
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+		if wait != 0 {
+			t.Errorf("free attempt %d waits %s", i, wait)
+		}
+	}
+	wait, _ := tracker.Fail("alice")
+	if wait != time.Second {
+		t.Errorf("bad wait %s", wait)
+	}
+	now = now.Add(400 * time.Millisecond)
+	wait, err := tracker.Wait("alice")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if wait != 600*time.Millisecond {
+		t.Errorf("bad remaining wait %s", wait)
+	}
+	if wait, _ = tracker.Wait("bob"); wait != 0 {
+		t.Errorf("other keys aren't throttled, bob waits %s", wait)
+	}
+
+	// locked out after the lockout attempts
+	for i := 0; i < 3; i++ {
+		wait, _ = tracker.Fail("alice")
+	}
+	if wait != 15*time.Minute {
+		t.Errorf("expected lockout, got %s", wait)
+	}
+
+	// the failures are forgotten once the lockout duration passes without new ones
+	now = now.Add(15 * time.Minute)
+	if wait, _ = tracker.Wait("alice"); wait != 0 {
+		t.Errorf("lockout didn't end, waits %s", wait)
+	}
+	if wait, _ = tracker.Fail("alice"); wait != 0 {
+		t.Errorf("failures weren't forgotten, waits %s", wait)
+	}
+
+	// a successful attempt resets the key
+	tracker.Fail("alice")
+	tracker.Fail("alice")
+	tracker.Reset("alice")
+	if wait, _ = tracker.Wait("alice"); wait != 0 {
+		t.Errorf("reset key waits %s", wait)
+	}
+	if wait, _ = tracker.Fail("alice"); wait != 0 {
+		t.Errorf("reset key wasn't forgotten, waits %s", wait)
+	}
+}
+
+func TestMemoryTrackerTry(t *testing.T) {
+	now := time.Now()
+	tracker := NewMemoryTracker(testPolicy)
+	tracker.now = func() time.Time { return now }
+
+	// the attempts are counted before they are checked, so the third one waits before the next
+	for i := 0; i < 3; i++ {
+		wait, err := tracker.Try("alice")
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+		if wait != 0 {
+			t.Errorf("attempt %d waits %s", i, wait)
+		}
+	}
+	wait, _ := tracker.Try("alice")
+	if wait != time.Second {
+		t.Errorf("bad wait %s", wait)
+	}
+	// the attempt waiting isn't counted
+	if tracker.data["alice"].failures != 3 {
+		t.Errorf("bad failures %d", tracker.data["alice"].failures)
+	}
+
+	// the right attempt is taken back
+	if err := tracker.Undo("alice"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if wait, _ = tracker.Wait("alice"); wait != 0 {
+		t.Errorf("undone attempt waits %s", wait)
+	}
+	tracker.Undo("alice")
+	tracker.Undo("alice")
+	if _, ok := tracker.data["alice"]; ok {
+		t.Errorf("the key with no attempts left is kept")
+	}
+	if err := tracker.Undo("bob"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+}
+
+func TestMemoryTrackerSweep(t *testing.T) {
+	now := time.Now()
+	tracker := NewMemoryTracker(testPolicy)
+	tracker.now = func() time.Time { return now }
+
+	for i := 0; i < minSweep-1; i++ {
+		tracker.Fail(time.Duration(i).String())
+	}
+	now = now.Add(testPolicy.LockoutDuration)
+	tracker.Fail("alice")
+	if len(tracker.data) != 1 {
+		t.Errorf("forgotten keys weren't swept, %d left", len(tracker.data))
+	}
+	if tracker.nextSweep != minSweep {
+		t.Errorf("bad next sweep %d", tracker.nextSweep)
+	}
+}
@@ -0,0 +1,36 @@
+package throttle
+
+import "time"
+
+// Policy tells how long a key waits after its failed attempts:
+// the free attempts fail without a wait, then the wait doubles with every failure
+// until the key is locked out
+type Policy struct {
+	// FreeAttempts fail without any wait
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure over the free ones
+	BaseDelay time.Duration
+	// LockoutAttempts failures lock the key out, it's never locked out when zero
+	LockoutAttempts int
+	// LockoutDuration is the lockout time and the longest wait,
+	// the failures are forgotten when it passes without new ones
+	LockoutDuration time.Duration
+}
+
+// Wait returns the wait after the given number of failures in a row
+func (p Policy) Wait(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	if p.LockoutAttempts > 0 && failures >= p.LockoutAttempts {
+		return p.LockoutDuration
+	}
+	wait := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && wait < p.LockoutDuration; i++ {
+		wait *= 2
+	}
+	if wait > p.LockoutDuration {
+		return p.LockoutDuration
+	}
+	return wait
+}
@@ -0,0 +1,124 @@
+package twofactor
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryRepo keeps the two-factor settings in memory, it's used to run the app without MySQL
+type MemoryRepo struct {
+	mu       sync.Mutex
+	settings map[string]*Settings
+	// recovery maps the user ids to the hashes of their recovery codes
+	recovery map[string]map[string]bool
+}
+
+// NewMemoryRepo creates a new in-memory repository of the two-factor settings
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		settings: make(map[string]*Settings),
+		recovery: make(map[string]map[string]bool),
+	}
+}
+
+// Get retrieves the two-factor settings of the user
+func (repo *MemoryRepo) Get(userID string) (*Settings, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored, ok := repo.settings[userID]
+	if !ok {
+		return nil, ErrNotEnrolled
+	}
+	settings := *stored
+	return &settings, nil
+}
+
+// Enroll stores a new secret of the user to be enabled with its first code, it replaces the one not enabled yet
+func (repo *MemoryRepo) Enroll(userID, secret string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if stored, ok := repo.settings[userID]; ok && stored.Enabled {
+		return ErrEnabled
+	}
+	repo.settings[userID] = &Settings{UserID: userID, Secret: secret}
+	return nil
+}
+
+// Enable turns on the enrolled secret verified by the code of the counter period and replaces the recovery codes,
+// it fails with ErrBadCode when the code is used or the secret is enabled already
+func (repo *MemoryRepo) Enable(userID string, counter int64, recoveryCodes []string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored, ok := repo.settings[userID]
+	if !ok || stored.Enabled || stored.LastCounter >= counter {
+		return ErrBadCode
+	}
+	stored.Enabled = true
+	stored.LastCounter = counter
+	hashes := make(map[string]bool, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes[hashRecoveryCode(code)] = true
+	}
+	repo.recovery[userID] = hashes
+	return nil
+}
+
+// UseCode records the code of the counter period as used, it fails with ErrBadCode
+// when a code of the period or a later one was used already
+func (repo *MemoryRepo) UseCode(userID string, counter int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored, ok := repo.settings[userID]
+	if !ok || !stored.Enabled || stored.LastCounter >= counter {
+		return ErrBadCode
+	}
+	stored.LastCounter = counter
+	return nil
+}
+
+// UseRecoveryCode deletes the recovery code of the user, a code works once
+func (repo *MemoryRepo) UseRecoveryCode(userID, code string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	hash := hashRecoveryCode(code)
+	if !repo.recovery[userID][hash] {
+		return ErrBadCode
+	}
+	delete(repo.recovery[userID], hash)
+	return nil
+}
+
+// Disable deletes the two-factor settings and the recovery codes of the user
+func (repo *MemoryRepo) Disable(userID string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	delete(repo.settings, userID)
+	delete(repo.recovery, userID)
+	return nil
+}
+
+// RecoveryCodeHashes lists the hashes of the recovery codes the user has left, it's used for the backups
+func (repo *MemoryRepo) RecoveryCodeHashes(userID string) ([]string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	hashes := make([]string, 0, len(repo.recovery[userID]))
+	for hash := range repo.recovery[userID] {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// Restore replaces the two-factor settings and the recovery codes of the user with the ones from a backup
+func (repo *MemoryRepo) Restore(settings *Settings, recoveryCodeHashes []string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored := *settings
+	repo.settings[settings.UserID] = &stored
+	hashes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		hashes[hash] = true
+	}
+	repo.recovery[settings.UserID] = hashes
+	return nil
+}
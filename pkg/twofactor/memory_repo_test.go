@@ -0,0 +1,85 @@
+package twofactor
+
+import (
+	"testing"
+)
+
+func TestMemoryRepo(t *testing.T) {
+	repo := NewMemoryRepo()
+
+	if _, err := repo.Get("42"); err != ErrNotEnrolled {
+		t.Errorf("expected %v, got %v", ErrNotEnrolled, err)
+	}
+	if err := repo.Enroll("42", "FIRST"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	// a secret not enabled yet is replaced
+	if err := repo.Enroll("42", "SECRET"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	settings, err := repo.Get("42")
+	if err != nil || settings.Secret != "SECRET" || settings.Enabled {
+		t.Errorf("bad settings %+v, %v", settings, err)
+	}
+	if err = repo.UseCode("42", 100); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+
+	codes := []string{"aaaa-bbbb-cccc-dddd", "eeee-ffff-gggg-hhhh"}
+	if err = repo.Enable("42", 100, codes); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if err = repo.Enable("42", 101, codes); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+	if err = repo.Enroll("42", "OTHER"); err != ErrEnabled {
+		t.Errorf("expected %v, got %v", ErrEnabled, err)
+	}
+
+	// a code works once and the earlier ones don't work anymore
+	if err = repo.UseCode("42", 100); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+	if err = repo.UseCode("42", 102); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	if err = repo.UseCode("42", 101); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+
+	if err = repo.UseRecoveryCode("42", "AAAABBBBCCCCDDDD"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	if err = repo.UseRecoveryCode("42", "aaaa-bbbb-cccc-dddd"); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+	if err = repo.UseRecoveryCode("43", "eeee-ffff-gggg-hhhh"); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+
+	// the backups keep the hashes of the codes left
+	hashes, err := repo.RecoveryCodeHashes("42")
+	if err != nil || len(hashes) != 1 || hashes[0] != hashRecoveryCode("eeee-ffff-gggg-hhhh") {
+		t.Errorf("bad hashes %v, %v", hashes, err)
+	}
+	err = repo.Restore(&Settings{UserID: "43", Secret: "RESTORED", Enabled: true, LastCounter: 200}, hashes)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if settings, err = repo.Get("43"); err != nil || settings.Secret != "RESTORED" || settings.LastCounter != 200 {
+		t.Errorf("bad settings %+v, %v", settings, err)
+	}
+	if err = repo.UseRecoveryCode("43", "eeee-ffff-gggg-hhhh"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	if err = repo.Disable("42"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if _, err = repo.Get("42"); err != ErrNotEnrolled {
+		t.Errorf("expected %v, got %v", ErrNotEnrolled, err)
+	}
+	if err = repo.UseRecoveryCode("42", "eeee-ffff-gggg-hhhh"); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+}
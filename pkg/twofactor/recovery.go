@@ -0,0 +1,42 @@
+package twofactor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodes is the number of the recovery codes given on enabling the two-factor authentication
+const RecoveryCodes = 10
+
+// recoveryAlphabet is the lower case base32 one, every character carries 5 bits
+const recoveryAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// NewRecoveryCodes makes the random recovery codes like "abcd-efgh-jkmn-pqrs", 80 bits each
+func NewRecoveryCodes() []string {
+	codes := make([]string, RecoveryCodes)
+	for i := range codes {
+		b := make([]byte, 16)
+		rand.Read(b)
+		var sb strings.Builder
+		for j, c := range b {
+			if j > 0 && j%4 == 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(recoveryAlphabet[c%32])
+		}
+		codes[i] = sb.String()
+	}
+	return codes
+}
+
+// hashRecoveryCode is what is stored instead of the code, the dashes, spaces and case don't matter.
+// The codes are random enough, so no salt is needed
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,238 @@
+package twofactor
+
+import (
+	"database/sql"
+)
+
+// Repo keeps the two-factor settings and the recovery codes in MySQL
+type Repo struct {
+	DB *sql.DB
+}
+
+// NewRepo creates a new repository of the two-factor settings
+func NewRepo(db *sql.DB) *Repo {
+	return &Repo{DB: db}
+}
+
+// Get retrieves the two-factor settings of the user
+func (repo *Repo) Get(userID string) (*Settings, error) {
+	settings := &Settings{UserID: userID}
+	err := repo.DB.
+		QueryRow("SELECT secret, enabled, lastCounter FROM two_factor WHERE userId = ?", userID).
+		Scan(&settings.Secret, &settings.Enabled, &settings.LastCounter)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// Enroll stores a new secret of the user to be enabled with its first code, it replaces the one not enabled yet
+func (repo *Repo) Enroll(userID, secret string) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	err = repo.enroll(tx, userID, secret)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// enroll locks the settings of the user, so an enabled secret isn't replaced concurrently
+func (repo *Repo) enroll(tx *sql.Tx, userID, secret string) error {
+	var enabled bool
+	err := tx.
+		QueryRow("SELECT enabled FROM two_factor WHERE userId = ? FOR UPDATE", userID).
+		Scan(&enabled)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec(
+			"INSERT INTO two_factor (`userId`, `secret`, `enabled`, `lastCounter`) VALUES (?, ?, 0, 0)",
+			userID,
+			secret,
+		)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if enabled {
+		return ErrEnabled
+	}
+	_, err = tx.Exec("UPDATE two_factor SET secret = ?, lastCounter = 0 WHERE userId = ?", secret, userID)
+	return err
+}
+
+// Enable turns on the enrolled secret verified by the code of the counter period and replaces the recovery codes,
+// it fails with ErrBadCode when the code is used or the secret is enabled already
+func (repo *Repo) Enable(userID string, counter int64, recoveryCodes []string) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	err = repo.enable(tx, userID, counter, recoveryCodes)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (repo *Repo) enable(tx *sql.Tx, userID string, counter int64, recoveryCodes []string) error {
+	res, err := tx.Exec(
+		"UPDATE two_factor SET enabled = 1, lastCounter = ? WHERE userId = ? AND enabled = 0 AND lastCounter < ?",
+		counter,
+		userID,
+		counter,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrBadCode
+	}
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE userId = ?", userID)
+	if err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(
+			"INSERT INTO recovery_codes (`userId`, `codeHash`) VALUES (?, ?)",
+			userID,
+			hashRecoveryCode(code),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseCode records the code of the counter period as used, it fails with ErrBadCode
+// when a code of the period or a later one was used already
+func (repo *Repo) UseCode(userID string, counter int64) error {
+	res, err := repo.DB.Exec(
+		"UPDATE two_factor SET lastCounter = ? WHERE userId = ? AND enabled = 1 AND lastCounter < ?",
+		counter,
+		userID,
+		counter,
+	)
+	if err != nil {
+		return err
+	}
+	return badCodeIfNone(res)
+}
+
+// UseRecoveryCode deletes the recovery code of the user, a code works once
+func (repo *Repo) UseRecoveryCode(userID, code string) error {
+	res, err := repo.DB.Exec(
+		"DELETE FROM recovery_codes WHERE userId = ? AND codeHash = ?",
+		userID,
+		hashRecoveryCode(code),
+	)
+	if err != nil {
+		return err
+	}
+	return badCodeIfNone(res)
+}
+
+// Disable deletes the two-factor settings and the recovery codes of the user
+func (repo *Repo) Disable(userID string) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE userId = ?", userID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM two_factor WHERE userId = ?", userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecoveryCodeHashes lists the hashes of the recovery codes the user has left, it's used for the backups
+func (repo *Repo) RecoveryCodeHashes(userID string) ([]string, error) {
+	rows, err := repo.DB.Query("SELECT codeHash FROM recovery_codes WHERE userId = ? ORDER BY codeHash", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// Restore replaces the two-factor settings and the recovery codes of the user with the ones from a backup
+func (repo *Repo) Restore(settings *Settings, recoveryCodeHashes []string) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	err = repo.restore(tx, settings, recoveryCodeHashes)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (repo *Repo) restore(tx *sql.Tx, settings *Settings, recoveryCodeHashes []string) error {
+	_, err := tx.Exec("DELETE FROM recovery_codes WHERE userId = ?", settings.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM two_factor WHERE userId = ?", settings.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO two_factor (`userId`, `secret`, `enabled`, `lastCounter`) VALUES (?, ?, ?, ?)",
+		settings.UserID,
+		settings.Secret,
+		settings.Enabled,
+		settings.LastCounter,
+	)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(
+			"INSERT INTO recovery_codes (`userId`, `codeHash`) VALUES (?, ?)",
+			settings.UserID,
+			hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func badCodeIfNone(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrBadCode
+	}
+	return nil
+}
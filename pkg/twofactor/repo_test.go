@@ -0,0 +1,204 @@
+package twofactor
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRepo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepo(db)
+
+	mock.
+		ExpectQuery("SELECT secret, enabled, lastCounter FROM two_factor WHERE userId").
+		WithArgs("42").
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled", "lastCounter"}).AddRow("SECRET", true, 100))
+	settings, err := repo.Get("42")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if settings.UserID != "42" || settings.Secret != "SECRET" || !settings.Enabled || settings.LastCounter != 100 {
+		t.Errorf("bad settings %+v", settings)
+	}
+
+	mock.
+		ExpectQuery("SELECT secret, enabled, lastCounter FROM two_factor WHERE userId").
+		WithArgs("43").
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled", "lastCounter"}))
+	if _, err = repo.Get("43"); err != ErrNotEnrolled {
+		t.Errorf("expected %v, got %v", ErrNotEnrolled, err)
+	}
+
+	// the first secret is inserted, the pending one is replaced and the enabled one is kept
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT enabled FROM two_factor WHERE userId = (.+) FOR UPDATE").
+		WithArgs("42").
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}))
+	mock.
+		ExpectExec("INSERT INTO two_factor").
+		WithArgs("42", "SECRET").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err = repo.Enroll("42", "SECRET"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT enabled FROM two_factor").
+		WithArgs("42").
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(false))
+	mock.
+		ExpectExec("UPDATE two_factor SET secret = (.+), lastCounter = 0 WHERE userId").
+		WithArgs("OTHER", "42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err = repo.Enroll("42", "OTHER"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT enabled FROM two_factor").
+		WithArgs("42").
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(true))
+	mock.ExpectRollback()
+	if err = repo.Enroll("42", "OTHER"); err != ErrEnabled {
+		t.Errorf("expected %v, got %v", ErrEnabled, err)
+	}
+
+	// only the hashes of the recovery codes are stored
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("UPDATE two_factor SET enabled = 1, lastCounter = (.+) WHERE userId = (.+) AND enabled = 0 AND lastCounter").
+		WithArgs(int64(100), "42", int64(100)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("DELETE FROM recovery_codes WHERE userId").
+		WithArgs("42").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectExec("INSERT INTO recovery_codes").
+		WithArgs("42", hashRecoveryCode("aaaa-bbbb-cccc-dddd")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err = repo.Enable("42", 100, []string{"aaaa-bbbb-cccc-dddd"}); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("UPDATE two_factor SET enabled = 1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err = repo.Enable("42", 100, []string{"aaaa-bbbb-cccc-dddd"}); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+
+	// a code of a period works once
+	mock.
+		ExpectExec("UPDATE two_factor SET lastCounter = (.+) WHERE userId = (.+) AND enabled = 1 AND lastCounter").
+		WithArgs(int64(101), "42", int64(101)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("UPDATE two_factor SET lastCounter").
+		WithArgs(int64(101), "42", int64(101)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err = repo.UseCode("42", 101); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	if err = repo.UseCode("42", 101); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+
+	mock.
+		ExpectExec("DELETE FROM recovery_codes WHERE userId = (.+) AND codeHash").
+		WithArgs("42", hashRecoveryCode("aaaa-bbbb-cccc-dddd")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("DELETE FROM recovery_codes WHERE userId = (.+) AND codeHash").
+		WithArgs("42", hashRecoveryCode("aaaa-bbbb-cccc-dddd")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err = repo.UseRecoveryCode("42", "AAAA BBBB CCCC DDDD"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	if err = repo.UseRecoveryCode("42", "aaaa-bbbb-cccc-dddd"); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("DELETE FROM recovery_codes WHERE userId").
+		WithArgs("42").
+		WillReturnResult(sqlmock.NewResult(0, 9))
+	mock.
+		ExpectExec("DELETE FROM two_factor WHERE userId").
+		WithArgs("42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err = repo.Disable("42"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	// the backups read the hashes and restore them as they are
+	mock.
+		ExpectQuery("SELECT codeHash FROM recovery_codes WHERE userId").
+		WithArgs("42").
+		WillReturnRows(sqlmock.NewRows([]string{"codeHash"}).AddRow("hash1").AddRow("hash2"))
+	hashes, err := repo.RecoveryCodeHashes("42")
+	if err != nil || len(hashes) != 2 || hashes[0] != "hash1" {
+		t.Errorf("bad hashes %v, %v", hashes, err)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("DELETE FROM recovery_codes WHERE userId").
+		WithArgs("42").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectExec("DELETE FROM two_factor WHERE userId").
+		WithArgs("42").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectExec("INSERT INTO two_factor").
+		WithArgs("42", "SECRET", true, int64(101)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO recovery_codes").
+		WithArgs("42", "hash1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err = repo.Restore(&Settings{UserID: "42", Secret: "SECRET", Enabled: true, LastCounter: 101}, []string{"hash1"})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("DELETE FROM recovery_codes WHERE userId").
+		WithArgs("42").
+		WillReturnError(fmt.Errorf("db_error"))
+	mock.ExpectRollback()
+	if err = repo.Restore(&Settings{UserID: "42"}, nil); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	mock.
+		ExpectQuery("SELECT secret, enabled, lastCounter FROM two_factor").
+		WillReturnError(fmt.Errorf("db_error"))
+	if _, err = repo.Get("42"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
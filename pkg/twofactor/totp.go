@@ -0,0 +1,123 @@
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The codes are the RFC 6238 defaults the authenticator apps expect
+const (
+	Period = 30 * time.Second
+	Digits = 6
+	// Skew is the number of periods before and after the current one whose codes are accepted, for the clock drift
+	Skew = 1
+)
+
+var (
+	// ErrNotEnrolled is returned for a user who didn't set up the two-factor authentication
+	ErrNotEnrolled = errors.New("Two-factor authentication isn't set up")
+	// ErrEnabled is returned on enrolling a user whose two-factor authentication is already enabled
+	ErrEnabled = errors.New("Two-factor authentication is already enabled")
+	// ErrBadCode is returned for a wrong, expired or already used code
+	ErrBadCode = errors.New("Invalid code")
+)
+
+// encoding of the secrets as the otpauth URIs and the authenticator apps expect them
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Settings are the two-factor settings of a user
+type Settings struct {
+	UserID string
+	Secret string
+	// Enabled is set once the first code is verified, till then the login doesn't ask for the codes
+	Enabled bool
+	// LastCounter is the period of the last accepted code, the codes of it and the earlier ones are rejected
+	LastCounter int64
+}
+
+// NewSecret makes a random 160 bit secret encoded in base32
+func NewSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return encoding.EncodeToString(b)
+}
+
+// URI is the otpauth URI of the secret the authenticator apps scan as a QR code
+func URI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(Digits))
+	params.Set("period", strconv.Itoa(int(Period/time.Second)))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + params.Encode()
+}
+
+// Counter is the number of the period the time falls into
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code is the code of the secret for the period
+func Code(secret string, counter int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, counter), nil
+}
+
+// Verify checks the code against the periods around now and returns the period it matches,
+// the periods up to lastCounter are skipped, so every code works once
+func Verify(secret, code string, now time.Time, lastCounter int64) (int64, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if len(code) != Digits {
+		return 0, ErrBadCode
+	}
+	current := Counter(now)
+	for counter := current - Skew; counter <= current+Skew; counter++ {
+		if counter > lastCounter && hmac.Equal([]byte(hotp(key, counter)), []byte(code)) {
+			return counter, nil
+		}
+	}
+	return 0, ErrBadCode
+}
+
+// decodeSecret accepts the secret the way the users retype it: in lower case and with spaces
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("bad two-factor secret")
+	}
+	return key, nil
+}
+
+// hotp is the RFC 4226 code of the counter
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
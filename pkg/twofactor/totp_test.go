@@ -0,0 +1,95 @@
+package twofactor
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// the RFC 6238 codes cut to 6 digits
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		code, err := Code(rfcSecret, Counter(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+		if code != c.code {
+			t.Errorf("at %d expected %s, got %s", c.unix, c.code, code)
+		}
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := NewSecret()
+	now := time.Now()
+	current := Counter(now)
+	code, _ := Code(secret, current)
+
+	counter, err := Verify(strings.ToLower(secret), code[:3]+" "+code[3:], now, 0)
+	if err != nil || counter != current {
+		t.Errorf("bad counter %d, %v", counter, err)
+	}
+	// a used code is rejected
+	if _, err = Verify(secret, code, now, current); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+	// the clock drift of a period is allowed
+	if counter, err = Verify(secret, code, now.Add(Period), 0); err != nil || counter != current {
+		t.Errorf("bad counter %d, %v", counter, err)
+	}
+	if _, err = Verify(secret, code, now.Add(3*Period), 0); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+	if _, err = Verify(secret, "12345", now, 0); err != ErrBadCode {
+		t.Errorf("expected %v, got %v", ErrBadCode, err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("reddit clone", "jane", "SECRET"))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	params := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/reddit clone:jane" ||
+		params.Get("secret") != "SECRET" || params.Get("issuer") != "reddit clone" ||
+		params.Get("digits") != "6" || params.Get("period") != "30" {
+		t.Errorf("bad uri %s", uri)
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes := NewRecoveryCodes()
+	if len(codes) != RecoveryCodes {
+		t.Fatalf("expected %d codes, got %d", RecoveryCodes, len(codes))
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 19 || strings.Count(code, "-") != 3 || seen[code] {
+			t.Errorf("bad code %s", code)
+		}
+		seen[code] = true
+	}
+	// the codes are typed back in any case and without the dashes
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", " ", -1))
+	if hashRecoveryCode(typed) != hashRecoveryCode(codes[0]) {
+		t.Errorf("code %s doesn't match %s", typed, codes[0])
+	}
+}
@@ -0,0 +1,55 @@
+package user
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// maxEmailLength is the longest address SMTP accepts
+const maxEmailLength = 254
+
+// ErrBadEmail is returned for a malformed email address
+var ErrBadEmail = errors.New("Invalid email address")
+
+// NormalizeEmail checks the bare address like "jane@example.com" and returns it without the surrounding spaces
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if len(email) > maxEmailLength {
+		return "", ErrBadEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", ErrBadEmail
+	}
+	return email, nil
+}
+
+// nullableEmail keeps the missing emails NULL in MySQL, so they don't collide in the unique key
+type nullableEmail struct {
+	email *string
+}
+
+// Scan reads a NULL as an empty email
+func (n nullableEmail) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*n.email = ""
+	case []byte:
+		*n.email = string(v)
+	case string:
+		*n.email = v
+	default:
+		return errors.New("Bad email column")
+	}
+	return nil
+}
+
+// Value writes an empty email as a NULL
+func (n nullableEmail) Value() (driver.Value, error) {
+	if *n.email == "" {
+		return nil, nil
+	}
+	return *n.email, nil
+}
@@ -0,0 +1,15 @@
+package user
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	email, err := NormalizeEmail(" jane@example.com ")
+	if err != nil || email != "jane@example.com" {
+		t.Errorf("bad email %q, %v", email, err)
+	}
+	for _, bad := range []string{"", "jane", "Jane <jane@example.com>", "jane@example.com, john@example.com", "jane@"} {
+		if _, err = NormalizeEmail(bad); err != ErrBadEmail {
+			t.Errorf("%q: expected %v, got %v", bad, ErrBadEmail, err)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package user
+
+import "errors"
+
+// Identity is an account of the user at an external OpenID Connect provider
+type Identity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+// ErrIdentityLinked is returned when the identity already belongs to a user
+var ErrIdentityLinked = errors.New("Identity is already linked")
@@ -0,0 +1,396 @@
+package user
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MemoryRepo keeps the Users in memory, it's used to run the app without MySQL
+type MemoryRepo struct {
+	mu     sync.RWMutex
+	lastID int64
+	data   map[string]*User
+	// roles keeps the granted roles except the admin role which is the Admin flag
+	roles map[string]Roles
+	// identities maps the external identities to the ids of their users
+	identities map[Identity]string
+	// Hasher hashes the passwords, DefaultHasher is used when it's nil
+	Hasher PasswordHasher
+}
+
+// NewMemoryRepo creates a new in-memory repository for Users
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		data:       make(map[string]*User),
+		roles:      make(map[string]Roles),
+		identities: make(map[Identity]string),
+	}
+}
+
+// Authorize takes care about authorising a user
+func (repo *MemoryRepo) Authorize(login, pass string) (*User, error) {
+	u, err := repo.GetByUserName(login)
+	if err == ErrNoUser {
+		// hashing takes as long as checking a password, so the missing accounts can't be told by the timing
+		hasherOrDefault(repo.Hasher).Hash(pass)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	match, rehash, err := hasherOrDefault(repo.Hasher).Verify(u.PasswordHash, pass)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, ErrBadPass
+	}
+	if rehash {
+		hash, err := hasherOrDefault(repo.Hasher).Hash(pass)
+		if err == nil {
+			repo.mu.Lock()
+			if stored, ok := repo.data[u.ID]; ok {
+				stored.PasswordHash = hash
+			}
+			repo.mu.Unlock()
+			u.PasswordHash = hash
+		}
+	}
+
+	return u, nil
+}
+
+// Register creates a new User in the repository when they sign up
+func (repo *MemoryRepo) Register(user *User) (*User, error) {
+	// hashing is slow on purpose, so it's done before taking the lock
+	hash, err := hasherOrDefault(repo.Hasher).Hash(user.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, u := range repo.data {
+		if strings.EqualFold(u.Username, user.Username) {
+			return nil, ErrUserExists
+		}
+	}
+	if repo.emailTaken(user.Email, "") {
+		return nil, ErrEmailExists
+	}
+
+	user.PasswordHash = hash
+	user.Token = ""
+	// the roles are granted by the admins only
+	user.Admin = false
+	user.EmailVerified = false
+
+	repo.lastID++
+	user.ID = strconv.FormatInt(repo.lastID, 10)
+
+	stored := *user
+	stored.Password = ""
+	repo.data[stored.ID] = &stored
+
+	return user, nil
+}
+
+// GetByUserName retrieves a User by their User Name (Login)
+func (repo *MemoryRepo) GetByUserName(login string) (*User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, u := range repo.data {
+		if strings.EqualFold(u.Username, login) {
+			res := *u
+			return &res, nil
+		}
+	}
+	return nil, ErrNoUser
+}
+
+// GetByEmail retrieves a User by their email, the emails are compared ignoring the case
+func (repo *MemoryRepo) GetByEmail(email string) (*User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, u := range repo.data {
+		if u.Email != "" && strings.EqualFold(u.Email, email) {
+			res := *u
+			return &res, nil
+		}
+	}
+	return nil, ErrNoUser
+}
+
+// GetByID retrieves a User by their ID
+func (repo *MemoryRepo) GetByID(ID string) (*User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	u, ok := repo.data[ID]
+	if !ok {
+		return nil, ErrNoUser
+	}
+	res := *u
+	return &res, nil
+}
+
+// All retrieves all the Users ordered by ID
+func (repo *MemoryRepo) All() ([]*User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	users := make([]*User, 0, len(repo.data))
+	for _, u := range repo.data {
+		res := *u
+		users = append(users, &res)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return idLess(users[i].ID, users[j].ID)
+	})
+	return users, nil
+}
+
+// Save stores the User keeping its ID, the existing User with the same ID is replaced
+func (repo *MemoryRepo) Save(user *User) error {
+	return repo.save(user, false)
+}
+
+// SaveWithoutSecrets stores the User like Save but the existing User with the same ID keeps
+// its password hash and token, e.g. when they aren't known
+func (repo *MemoryRepo) SaveWithoutSecrets(user *User) error {
+	return repo.save(user, true)
+}
+
+// save stores the User, the existing User with the same ID keeps its secrets when asked
+func (repo *MemoryRepo) save(user *User, keepSecrets bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, u := range repo.data {
+		if strings.EqualFold(u.Username, user.Username) && u.ID != user.ID {
+			return ErrUserExists
+		}
+	}
+	if repo.emailTaken(user.Email, user.ID) {
+		return ErrEmailExists
+	}
+	stored := *user
+	stored.Password = ""
+	if existing, ok := repo.data[stored.ID]; ok && keepSecrets {
+		stored.PasswordHash = existing.PasswordHash
+		stored.Token = existing.Token
+	}
+	repo.data[stored.ID] = &stored
+	// the next registered users must not reuse the saved ids
+	if id, err := strconv.ParseInt(stored.ID, 10, 64); err == nil && id > repo.lastID {
+		repo.lastID = id
+	}
+	return nil
+}
+
+// SetEmail replaces the email of the user, the new one isn't verified yet
+func (repo *MemoryRepo) SetEmail(userID, email string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	u, ok := repo.data[userID]
+	if !ok {
+		return ErrNoUser
+	}
+	if repo.emailTaken(email, userID) {
+		return ErrEmailExists
+	}
+	u.Email = email
+	u.EmailVerified = false
+	return nil
+}
+
+// VerifyEmail marks the email of the user verified if it's still their email, ErrNoUser is returned otherwise
+func (repo *MemoryRepo) VerifyEmail(userID, email string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	u, ok := repo.data[userID]
+	if !ok || u.Email == "" || !strings.EqualFold(u.Email, email) {
+		return ErrNoUser
+	}
+	u.EmailVerified = true
+	return nil
+}
+
+// SetPassword replaces the password of the user, the caller checks the user may do it
+func (repo *MemoryRepo) SetPassword(userID, password string) error {
+	hash, err := hasherOrDefault(repo.Hasher).Hash(password)
+	if err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	u, ok := repo.data[userID]
+	if !ok {
+		return ErrNoUser
+	}
+	u.PasswordHash = hash
+	return nil
+}
+
+// Roles returns the roles of the user, the admin role is the Admin flag
+func (repo *MemoryRepo) Roles(userID string) (Roles, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	u, ok := repo.data[userID]
+	if !ok {
+		return nil, ErrNoUser
+	}
+	roles := Roles{RoleUser}
+	if u.Admin {
+		roles = append(roles, RoleAdmin)
+	}
+	roles = append(roles, repo.roles[userID]...)
+	sortRoles(roles)
+	return roles, nil
+}
+
+// GrantRole grants the role to the user, granting it again changes nothing
+func (repo *MemoryRepo) GrantRole(userID string, role Role) error {
+	if !role.Grantable() {
+		return ErrUnknownRole
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	u, ok := repo.data[userID]
+	if !ok {
+		return ErrNoUser
+	}
+	if role == RoleAdmin {
+		u.Admin = true
+		return nil
+	}
+	if !repo.roles[userID].Has(role) {
+		repo.roles[userID] = append(repo.roles[userID], role)
+	}
+	return nil
+}
+
+// RevokeRole revokes the role from the user, revoking a missing role changes nothing
+func (repo *MemoryRepo) RevokeRole(userID string, role Role) error {
+	if !role.Grantable() {
+		return ErrUnknownRole
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	u, ok := repo.data[userID]
+	if !ok {
+		return ErrNoUser
+	}
+	if role == RoleAdmin {
+		u.Admin = false
+		return nil
+	}
+	granted := Roles{}
+	for _, r := range repo.roles[userID] {
+		if r != role {
+			granted = append(granted, r)
+		}
+	}
+	repo.roles[userID] = granted
+	return nil
+}
+
+// GetByIdentity retrieves the User the external identity is linked to
+func (repo *MemoryRepo) GetByIdentity(identity Identity) (*User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	u, ok := repo.data[repo.identities[identity]]
+	if !ok {
+		return nil, ErrNoUser
+	}
+	res := *u
+	return &res, nil
+}
+
+// RegisterExternal creates a new User signing in with the external identity,
+// the User has no password, so they can't log in with one
+func (repo *MemoryRepo) RegisterExternal(user *User, identity Identity) (*User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.identities[identity]; ok {
+		return nil, ErrIdentityLinked
+	}
+	for _, u := range repo.data {
+		if strings.EqualFold(u.Username, user.Username) {
+			return nil, ErrUserExists
+		}
+	}
+	if repo.emailTaken(user.Email, "") {
+		return nil, ErrEmailExists
+	}
+
+	user.PasswordHash = ""
+	user.Token = ""
+	user.Admin = false
+
+	repo.lastID++
+	user.ID = strconv.FormatInt(repo.lastID, 10)
+
+	stored := *user
+	stored.Password = ""
+	repo.data[stored.ID] = &stored
+	repo.identities[identity] = stored.ID
+
+	return user, nil
+}
+
+// Identities returns the external identities linked to the user
+func (repo *MemoryRepo) Identities(userID string) ([]Identity, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	identities := []Identity{}
+	for identity, id := range repo.identities {
+		if id == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		if identities[i].Issuer != identities[j].Issuer {
+			return identities[i].Issuer < identities[j].Issuer
+		}
+		return identities[i].Subject < identities[j].Subject
+	})
+	return identities, nil
+}
+
+// LinkIdentity links the external identity to the user, moving it from another user if needed
+func (repo *MemoryRepo) LinkIdentity(userID string, identity Identity) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.data[userID]; !ok {
+		return ErrNoUser
+	}
+	repo.identities[identity] = userID
+	return nil
+}
+
+// emailTaken reports whether another user has the email, the caller holds the lock
+func (repo *MemoryRepo) emailTaken(email, userID string) bool {
+	if email == "" {
+		return false
+	}
+	for _, u := range repo.data {
+		if u.ID != userID && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// idLess compares numeric ids as numbers and the rest as strings
+func idLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
@@ -0,0 +1,246 @@
+package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemoryRepo(t *testing.T) {
+	repo := NewMemoryRepo()
+	repo.Hasher = testHasher
+
+	registered, err := repo.Register(&User{Username: "login", Password: "password"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if match, _, _ := testHasher.Verify(registered.PasswordHash, "password"); registered.ID == "" || !match {
+		t.Fatalf("bad registered user %+v", registered)
+	}
+
+	// the usernames are unique in any case, like in MySQL
+	_, err = repo.Register(&User{Username: "LOGIN", Password: "another"})
+	if err != ErrUserExists {
+		t.Errorf("expected %v, got %v", ErrUserExists, err)
+	}
+
+	u, err := repo.GetByID(registered.ID)
+	if err != nil || u.Username != "login" || u.Password != "" {
+		t.Errorf("bad user %+v, %v", u, err)
+	}
+	if _, err = repo.GetByID("unknown"); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+
+	u, err = repo.Authorize("login", "password")
+	if err != nil || u.ID != registered.ID {
+		t.Errorf("bad user %+v, %v", u, err)
+	}
+	if _, err = repo.Authorize("login", "wrong"); err != ErrBadPass {
+		t.Errorf("expected %v, got %v", ErrBadPass, err)
+	}
+	if _, err = repo.Authorize("unknown", "password"); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+}
+
+func TestMemoryRepoSave(t *testing.T) {
+	repo := NewMemoryRepo()
+	repo.Hasher = testHasher
+
+	err := repo.Save(&User{ID: "10", Username: "restored", PasswordHash: getSHA256("password")})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	// the legacy hash is upgraded on login
+	if _, err = repo.Authorize("restored", "password"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+	u, _ := repo.GetByID("10")
+	if match, rehash, _ := testHasher.Verify(u.PasswordHash, "password"); !match || rehash {
+		t.Errorf("hash wasn't upgraded: %q", u.PasswordHash)
+	}
+	hash := u.PasswordHash
+	err = repo.SaveWithoutSecrets(&User{ID: "10", Username: "restored", Email: "restored@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if u, _ = repo.GetByID("10"); u.PasswordHash != hash || u.Email != "restored@example.com" {
+		t.Errorf("bad user saved without secrets %+v", u)
+	}
+	err = repo.Save(&User{ID: "11", Username: "restored"})
+	if err != ErrUserExists {
+		t.Errorf("expected %v, got %v", ErrUserExists, err)
+	}
+
+	registered, _ := repo.Register(&User{Username: "new", Password: "password"})
+	if registered.ID != "11" {
+		t.Errorf("expected id 11, got %s", registered.ID)
+	}
+
+	users, err := repo.All()
+	if err != nil || len(users) != 2 || users[0].ID != "10" || users[1].ID != "11" {
+		t.Errorf("bad users %+v, %v", users, err)
+	}
+}
+
+func TestMemoryRepoRoles(t *testing.T) {
+	repo := NewMemoryRepo()
+	repo.Hasher = testHasher
+
+	// the admin flag of a new user is ignored
+	u, err := repo.Register(&User{Username: "login", Password: "password", Admin: true})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	roles, err := repo.Roles(u.ID)
+	if err != nil || !reflect.DeepEqual(roles, Roles{RoleUser}) {
+		t.Errorf("bad roles %v, %v", roles, err)
+	}
+
+	for _, role := range []Role{RoleAdmin, RoleModerator, RoleModerator} {
+		if err = repo.GrantRole(u.ID, role); err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+	roles, _ = repo.Roles(u.ID)
+	if !reflect.DeepEqual(roles, Roles{RoleUser, RoleModerator, RoleAdmin}) {
+		t.Errorf("bad granted roles %v", roles)
+	}
+	if stored, _ := repo.GetByID(u.ID); !stored.Admin {
+		t.Errorf("admin role didn't set the admin flag")
+	}
+
+	if err = repo.RevokeRole(u.ID, RoleAdmin); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if err = repo.RevokeRole(u.ID, RoleModerator); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	roles, _ = repo.Roles(u.ID)
+	if !reflect.DeepEqual(roles, Roles{RoleUser}) {
+		t.Errorf("bad revoked roles %v", roles)
+	}
+
+	if err = repo.GrantRole(u.ID, RoleUser); err != ErrUnknownRole {
+		t.Errorf("expected %v, got %v", ErrUnknownRole, err)
+	}
+	if err = repo.GrantRole("unknown", RoleModerator); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+	if _, err = repo.Roles("unknown"); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+}
+
+func TestMemoryRepoIdentities(t *testing.T) {
+	repo := NewMemoryRepo()
+	repo.Hasher = testHasher
+	identity := Identity{Issuer: "https://idp.example.com", Subject: "42"}
+
+	if _, err := repo.GetByIdentity(identity); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+	u, err := repo.RegisterExternal(&User{Username: "jane", Password: "ignored"}, identity)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	found, err := repo.GetByIdentity(identity)
+	if err != nil || found.ID != u.ID || found.PasswordHash != "" {
+		t.Errorf("bad user %+v, %v", found, err)
+	}
+	// the external users have no password
+	if _, err = repo.Authorize("jane", ""); err != ErrBadPass {
+		t.Errorf("expected %v, got %v", ErrBadPass, err)
+	}
+
+	if _, err = repo.RegisterExternal(&User{Username: "other"}, identity); err != ErrIdentityLinked {
+		t.Errorf("expected %v, got %v", ErrIdentityLinked, err)
+	}
+	if _, err = repo.RegisterExternal(&User{Username: "jane"}, Identity{Issuer: "https://idp.example.com", Subject: "43"}); err != ErrUserExists {
+		t.Errorf("expected %v, got %v", ErrUserExists, err)
+	}
+
+	// linking moves the identity to another user
+	other, _ := repo.Register(&User{Username: "john", Password: "password"})
+	if err = repo.LinkIdentity(other.ID, identity); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	identities, _ := repo.Identities(other.ID)
+	if !reflect.DeepEqual(identities, []Identity{identity}) {
+		t.Errorf("bad identities %v", identities)
+	}
+	if identities, _ = repo.Identities(u.ID); len(identities) != 0 {
+		t.Errorf("identity wasn't moved, %v left", identities)
+	}
+	if err = repo.LinkIdentity("unknown", identity); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+}
+
+func TestMemoryRepoSetPassword(t *testing.T) {
+	repo := NewMemoryRepo()
+	repo.Hasher = testHasher
+	u, _ := repo.Register(&User{Username: "jane", Email: "jane@example.com", Password: "old_password"})
+
+	if err := repo.SetPassword(u.ID, "new_password"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if _, err := repo.Authorize("jane", "old_password"); err != ErrBadPass {
+		t.Errorf("expected %v, got %v", ErrBadPass, err)
+	}
+	found, err := repo.Authorize("jane", "new_password")
+	if err != nil || found.Email != "jane@example.com" {
+		t.Errorf("bad user %+v, %v", found, err)
+	}
+	if err = repo.SetPassword("unknown", "new_password"); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+}
+
+func TestMemoryRepoEmails(t *testing.T) {
+	repo := NewMemoryRepo()
+	repo.Hasher = testHasher
+	jane, _ := repo.Register(&User{Username: "jane", Email: "jane@example.com", Password: "password", EmailVerified: true})
+	if jane.EmailVerified {
+		t.Errorf("the email is verified on registration")
+	}
+	if _, err := repo.Register(&User{Username: "john", Email: "JANE@example.com", Password: "password"}); err != ErrEmailExists {
+		t.Errorf("expected %v, got %v", ErrEmailExists, err)
+	}
+	john, _ := repo.Register(&User{Username: "john", Password: "password"})
+	// many users may have no email
+	if _, err := repo.Register(&User{Username: "bob", Password: "password"}); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	found, err := repo.GetByEmail("Jane@Example.com")
+	if err != nil || found.ID != jane.ID {
+		t.Errorf("bad user %+v, %v", found, err)
+	}
+	if _, err = repo.GetByEmail(""); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+
+	// the link sent to another email doesn't verify the current one
+	if err = repo.VerifyEmail(jane.ID, "jane@example.org"); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+	if err = repo.VerifyEmail(jane.ID, "jane@example.com"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if found, _ = repo.GetByID(jane.ID); !found.EmailVerified {
+		t.Errorf("email isn't verified %+v", found)
+	}
+	if err = repo.SetEmail(john.ID, "jane@example.com"); err != ErrEmailExists {
+		t.Errorf("expected %v, got %v", ErrEmailExists, err)
+	}
+	if err = repo.SetEmail(jane.ID, "jane@example.org"); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if found, _ = repo.GetByID(jane.ID); found.Email != "jane@example.org" || found.EmailVerified {
+		t.Errorf("bad user %+v", found)
+	}
+	if err = repo.SetEmail("unknown", "x@example.com"); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+}
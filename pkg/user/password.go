@@ -0,0 +1,163 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHash is returned when a stored password hash has an unsupported format
+var ErrUnknownHash = errors.New("Unknown password hash format")
+
+// PasswordHasher hashes passwords into self-describing encoded strings,
+// any of them can verify the hashes made by the others and by the legacy unsalted SHA-256
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify checks the password against the hash and reports whether the hash
+	// is outdated and has to be replaced with a new one
+	Verify(hash, password string) (match bool, rehash bool, err error)
+}
+
+// DefaultHasher is used by the repositories without a Hasher set
+var DefaultHasher PasswordHasher = &BcryptHasher{Cost: bcrypt.DefaultCost}
+
+// NewHasher returns the hasher of the scheme: bcrypt or argon2id
+func NewHasher(scheme string) (PasswordHasher, error) {
+	switch scheme {
+	case "bcrypt":
+		return &BcryptHasher{Cost: bcrypt.DefaultCost}, nil
+	case "argon2id":
+		return NewArgon2idHasher(), nil
+	}
+	return nil, fmt.Errorf("unknown password hasher %q", scheme)
+}
+
+// BcryptHasher makes hashes like "$2a$10$..."
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash hashes the password with a random salt
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+// Verify checks the password, the hashes of other schemes or costs have to be rehashed
+func (h *BcryptHasher) Verify(hash, password string) (bool, bool, error) {
+	match, err := verifyPassword(hash, password)
+	if !match || err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || cost != h.Cost, nil
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2idHasher makes hashes in the PHC string format like "$argon2id$v=19$m=65536,t=1,p=4$salt$key"
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// NewArgon2idHasher creates a hasher with the parameters recommended for interactive logins
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+// Hash hashes the password with a random salt
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks the password, the hashes of other schemes or parameters have to be rehashed
+func (h *Argon2idHasher) Verify(hash, password string) (bool, bool, error) {
+	match, err := verifyPassword(hash, password)
+	if !match || err != nil {
+		return false, false, err
+	}
+	params, _, key, err := decodeArgon2id(hash)
+	rehash := err != nil || params.Time != h.Time || params.Memory != h.Memory ||
+		params.Threads != h.Threads || uint32(len(key)) != h.KeyLen
+	return true, rehash, nil
+}
+
+// decodeArgon2id parses a hash made by Argon2idHasher
+func decodeArgon2id(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || !strings.HasPrefix(hash, argon2idPrefix) {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	params := &Argon2idHasher{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	return params, salt, key, nil
+}
+
+// verifyPassword checks the password against a hash of any supported scheme,
+// an empty hash never matches, e.g. for the users restored without secrets
+func verifyPassword(hash, password string) (bool, error) {
+	switch {
+	case hash == "":
+		return false, nil
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(hash, argon2idPrefix):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+		calc := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(calc, key) == 1, nil
+	case len(hash) == sha256.Size:
+		// the legacy raw unsalted SHA-256 digest
+		return subtle.ConstantTimeCompare([]byte(getSHA256(password)), []byte(hash)) == 1, nil
+	}
+	return false, ErrUnknownHash
+}
@@ -0,0 +1,100 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHasher keeps the tests fast
+var testHasher = &BcryptHasher{Cost: bcrypt.MinCost}
+
+func testArgon2idHasher() *Argon2idHasher {
+	h := NewArgon2idHasher()
+	h.Memory = 1024
+	return h
+}
+
+func TestHashers(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"$2a$":       testHasher,
+		"$argon2id$": testArgon2idHasher(),
+	}
+	for prefix, h := range hashers {
+		hash, err := h.Hash("password")
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+		if !strings.HasPrefix(hash, prefix) {
+			t.Errorf("bad hash %q", hash)
+		}
+		another, _ := h.Hash("password")
+		if another == hash {
+			t.Errorf("hashes aren't salted: %q", hash)
+		}
+
+		match, rehash, err := h.Verify(hash, "password")
+		if err != nil || !match || rehash {
+			t.Errorf("bad %s verification %v, %v, %v", prefix, match, rehash, err)
+		}
+		match, _, err = h.Verify(hash, "wrong")
+		if err != nil || match {
+			t.Errorf("wrong password matched %s: %v, %v", prefix, match, err)
+		}
+
+		// the legacy hashes are verified and upgraded
+		match, rehash, err = h.Verify(getSHA256("password"), "password")
+		if err != nil || !match || !rehash {
+			t.Errorf("bad legacy verification %v, %v, %v", match, rehash, err)
+		}
+		match, _, err = h.Verify(getSHA256("password"), "wrong")
+		if err != nil || match {
+			t.Errorf("wrong password matched the legacy hash: %v, %v", match, err)
+		}
+
+		// users restored without secrets can't log in
+		match, _, err = h.Verify("", "")
+		if err != nil || match {
+			t.Errorf("empty hash matched: %v, %v", match, err)
+		}
+		_, _, err = h.Verify("plain text", "plain text")
+		if err != ErrUnknownHash {
+			t.Errorf("expected %v, got %v", ErrUnknownHash, err)
+		}
+	}
+
+	// switching the scheme or parameters upgrades the hashes on login
+	bcryptHash, _ := testHasher.Hash("password")
+	match, rehash, err := testArgon2idHasher().Verify(bcryptHash, "password")
+	if err != nil || !match || !rehash {
+		t.Errorf("bcrypt hash wasn't upgraded to argon2id: %v, %v, %v", match, rehash, err)
+	}
+	match, rehash, err = (&BcryptHasher{Cost: bcrypt.MinCost + 1}).Verify(bcryptHash, "password")
+	if err != nil || !match || !rehash {
+		t.Errorf("bcrypt cost wasn't upgraded: %v, %v, %v", match, rehash, err)
+	}
+	argonHash, _ := testArgon2idHasher().Hash("password")
+	stronger := testArgon2idHasher()
+	stronger.Time = 2
+	match, rehash, err = stronger.Verify(argonHash, "password")
+	if err != nil || !match || !rehash {
+		t.Errorf("argon2id parameters weren't upgraded: %v, %v, %v", match, rehash, err)
+	}
+
+	_, _, err = testHasher.Verify("$argon2id$v=19$m=bad$salt$key", "password")
+	if err != ErrUnknownHash {
+		t.Errorf("expected %v, got %v", ErrUnknownHash, err)
+	}
+}
+
+func TestNewHasher(t *testing.T) {
+	for _, scheme := range []string{"bcrypt", "argon2id"} {
+		if _, err := NewHasher(scheme); err != nil {
+			t.Errorf("unexpected err: %s", err)
+		}
+	}
+	if _, err := NewHasher("md5"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -3,12 +3,15 @@ package user
 import (
 	"crypto/sha256"
 	"database/sql"
+	"sort"
 	"strconv"
 )
 
 // Repo represent the Users Repository
 type Repo struct {
 	DB *sql.DB
+	// Hasher hashes the passwords, DefaultHasher is used when it's nil
+	Hasher PasswordHasher
 }
 
 // NewRepo creates a new repository for Users
@@ -19,15 +22,33 @@ func NewRepo(db *sql.DB) *Repo {
 // Authorize takes care about authorising a user
 func (repo *Repo) Authorize(login, pass string) (*User, error) {
 	u, err := repo.GetByUserName(login)
+	if err == ErrNoUser {
+		// hashing takes as long as checking a password, so the missing accounts can't be told by the timing
+		hasherOrDefault(repo.Hasher).Hash(pass)
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// u.Token, _ = ljwt.IssueNewToken(u.ID, u.Username)
-	calcHash := getSHA256(pass)
-	if u.PasswordHash != calcHash {
+	match, rehash, err := hasherOrDefault(repo.Hasher).Verify(u.PasswordHash, pass)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
 		return nil, ErrBadPass
 	}
+	if rehash {
+		// the outdated hash is upgraded on login, a failed upgrade is retried on the next one
+		hash, err := hasherOrDefault(repo.Hasher).Hash(pass)
+		if err == nil {
+			_, err = repo.DB.Exec("UPDATE users SET passwordHash = ? WHERE id = ?", hash, u.ID)
+		}
+		if err == nil {
+			u.PasswordHash = hash
+		}
+	}
 
 	return u, nil
 }
@@ -38,9 +59,22 @@ func (repo *Repo) Register(user *User) (*User, error) {
 	if u != nil {
 		return nil, ErrUserExists
 	}
+	if user.Email != "" {
+		u, _ = repo.GetByEmail(user.Email)
+		if u != nil {
+			return nil, ErrEmailExists
+		}
+	}
 
-	user.PasswordHash = getSHA256(user.Password)
+	hash, err := hasherOrDefault(repo.Hasher).Hash(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = hash
 	user.Token = ""
+	// the roles are granted by the admins only
+	user.Admin = false
+	user.EmailVerified = false
 
 	uID, err := repo.add(user)
 	if err != nil {
@@ -52,6 +86,7 @@ func (repo *Repo) Register(user *User) (*User, error) {
 		return nil, err
 	}
 
+	user.ID = u.ID
 	return user, nil
 }
 
@@ -60,32 +95,350 @@ func (repo *Repo) GetByUserName(login string) (*User, error) {
 	user := &User{}
 	// QueryRow сам закрывает коннект
 	err := repo.DB.
-		QueryRow("SELECT id, username, admin, passwordHash FROM users WHERE username = ?", login).
-		Scan(&user.ID, &user.Username, &user.Admin, &user.PasswordHash)
+		QueryRow("SELECT id, username, email, emailVerified, admin, passwordHash FROM users WHERE username = ?", login).
+		Scan(&user.ID, &user.Username, nullableEmail{&user.Email}, &user.EmailVerified, &user.Admin, &user.PasswordHash)
 	if err != nil {
 		return nil, ErrNoUser
 	}
 	return user, nil
 }
 
+// GetByEmail retrieves a User by their email, the emails are compared ignoring the case
+func (repo *Repo) GetByEmail(email string) (*User, error) {
+	user := &User{}
+	err := repo.DB.
+		QueryRow("SELECT id, username, email, emailVerified, admin, passwordHash FROM users WHERE email = ?", email).
+		Scan(&user.ID, &user.Username, nullableEmail{&user.Email}, &user.EmailVerified, &user.Admin, &user.PasswordHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 // GetByID retrieves a User by their ID from the database
 func (repo *Repo) GetByID(ID string) (*User, error) {
 	user := &User{}
 	err := repo.DB.
-		QueryRow("SELECT id, username, admin, passwordHash, token FROM users WHERE id = ?", ID).
-		Scan(&user.ID, &user.Username, &user.Admin, &user.PasswordHash, &user.Token)
+		QueryRow("SELECT id, username, email, emailVerified, admin, passwordHash, token FROM users WHERE id = ?", ID).
+		Scan(&user.ID, &user.Username, nullableEmail{&user.Email}, &user.EmailVerified, &user.Admin, &user.PasswordHash, &user.Token)
 	if err != nil {
 		return nil, ErrNoUser
 	}
 	return user, nil
 }
 
+// All retrieves all the Users ordered by ID
+func (repo *Repo) All() ([]*User, error) {
+	rows, err := repo.DB.Query("SELECT id, username, email, emailVerified, admin, passwordHash, token FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user := &User{}
+		err = rows.Scan(&user.ID, &user.Username, nullableEmail{&user.Email}, &user.EmailVerified, &user.Admin, &user.PasswordHash, &user.Token)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Save stores the User keeping its ID, the existing User with the same ID is replaced
+func (repo *Repo) Save(user *User) error {
+	return repo.save(user, ", `passwordHash` = VALUES(`passwordHash`), `token` = VALUES(`token`)")
+}
+
+// SaveWithoutSecrets stores the User like Save but the existing User with the same ID keeps
+// its password hash and token, e.g. when they aren't known
+func (repo *Repo) SaveWithoutSecrets(user *User) error {
+	return repo.save(user, "")
+}
+
+// save inserts the User or updates the existing one with the same ID, the secrets are updated by the extra columns
+func (repo *Repo) save(user *User, updateSecrets string) error {
+	_, err := repo.DB.Exec(
+		"INSERT INTO users (`id`, `username`, `email`, `emailVerified`, `admin`, `passwordHash`, `token`) VALUES (?, ?, ?, ?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE `username` = VALUES(`username`), `email` = VALUES(`email`), "+
+			"`emailVerified` = VALUES(`emailVerified`), `admin` = VALUES(`admin`)"+updateSecrets,
+		user.ID,
+		user.Username,
+		nullableEmail{&user.Email},
+		user.EmailVerified,
+		user.Admin,
+		user.PasswordHash,
+		user.Token,
+	)
+	return err
+}
+
+// SetEmail replaces the email of the user, the new one isn't verified yet
+func (repo *Repo) SetEmail(userID, email string) error {
+	u, err := repo.GetByEmail(email)
+	if err == nil && u.ID != userID {
+		return ErrEmailExists
+	}
+	if err != nil && err != ErrNoUser {
+		return err
+	}
+	_, err = repo.DB.Exec("UPDATE users SET email = ?, emailVerified = 0 WHERE id = ?", nullableEmail{&email}, userID)
+	return err
+}
+
+// VerifyEmail marks the email of the user verified if it's still their email, ErrNoUser is returned otherwise
+func (repo *Repo) VerifyEmail(userID, email string) error {
+	result, err := repo.DB.Exec("UPDATE users SET emailVerified = 1 WHERE id = ? AND email = ?", userID, email)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+	// MySQL counts only the changed rows, so the email may have been verified already
+	var verified bool
+	err = repo.DB.
+		QueryRow("SELECT emailVerified FROM users WHERE id = ? AND email = ?", userID, email).
+		Scan(&verified)
+	if err == sql.ErrNoRows {
+		return ErrNoUser
+	}
+	return err
+}
+
+// SetPassword replaces the password of the user, the caller checks the user may do it
+func (repo *Repo) SetPassword(userID, password string) error {
+	hash, err := hasherOrDefault(repo.Hasher).Hash(password)
+	if err != nil {
+		return err
+	}
+	result, err := repo.DB.Exec("UPDATE users SET passwordHash = ? WHERE id = ?", hash, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoUser
+	}
+	return nil
+}
+
+// Roles returns the roles of the user, the admin role is the Admin flag
+func (repo *Repo) Roles(userID string) (Roles, error) {
+	rows, err := repo.DB.Query(
+		"SELECT users.admin, user_roles.role FROM users "+
+			"LEFT JOIN user_roles ON user_roles.userId = users.id WHERE users.id = ?",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	roles := Roles{RoleUser}
+	for rows.Next() {
+		var admin bool
+		var role sql.NullString
+		err = rows.Scan(&admin, &role)
+		if err != nil {
+			return nil, err
+		}
+		if !found && admin {
+			roles = append(roles, RoleAdmin)
+		}
+		found = true
+		if role.Valid {
+			roles = append(roles, Role(role.String))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNoUser
+	}
+	sortRoles(roles)
+	return roles, nil
+}
+
+// GrantRole grants the role to the user, granting it again changes nothing
+func (repo *Repo) GrantRole(userID string, role Role) error {
+	if !role.Grantable() {
+		return ErrUnknownRole
+	}
+	if role == RoleAdmin {
+		_, err := repo.DB.Exec("UPDATE users SET admin = 1 WHERE id = ?", userID)
+		return err
+	}
+	_, err := repo.DB.Exec("INSERT IGNORE INTO user_roles (`userId`, `role`) VALUES (?, ?)", userID, string(role))
+	return err
+}
+
+// RevokeRole revokes the role from the user, revoking a missing role changes nothing
+func (repo *Repo) RevokeRole(userID string, role Role) error {
+	if !role.Grantable() {
+		return ErrUnknownRole
+	}
+	if role == RoleAdmin {
+		_, err := repo.DB.Exec("UPDATE users SET admin = 0 WHERE id = ?", userID)
+		return err
+	}
+	_, err := repo.DB.Exec("DELETE FROM user_roles WHERE userId = ? AND role = ?", userID, string(role))
+	return err
+}
+
+// GetByIdentity retrieves the User the external identity is linked to
+func (repo *Repo) GetByIdentity(identity Identity) (*User, error) {
+	user := &User{}
+	err := repo.DB.
+		QueryRow(
+			"SELECT users.id, users.username, users.email, users.emailVerified, users.admin, users.passwordHash FROM users "+
+				"JOIN user_identities ON user_identities.userId = users.id "+
+				"WHERE user_identities.issuer = ? AND user_identities.subject = ?",
+			identity.Issuer, identity.Subject,
+		).
+		Scan(&user.ID, &user.Username, nullableEmail{&user.Email}, &user.EmailVerified, &user.Admin, &user.PasswordHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// RegisterExternal creates a new User signing in with the external identity,
+// the User has no password, so they can't log in with one
+func (repo *Repo) RegisterExternal(user *User, identity Identity) (*User, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	uID, err := registerExternal(tx, user, identity)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	user.ID = uID
+	user.PasswordHash = ""
+	user.Token = ""
+	user.Admin = false
+	return user, nil
+}
+
+// registerExternal inserts the User with the identity and returns the new id
+func registerExternal(tx *sql.Tx, user *User, identity Identity) (string, error) {
+	var id string
+	err := tx.QueryRow(
+		"SELECT userId FROM user_identities WHERE issuer = ? AND subject = ?",
+		identity.Issuer, identity.Subject,
+	).Scan(&id)
+	if err == nil {
+		return "", ErrIdentityLinked
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	err = tx.QueryRow("SELECT id FROM users WHERE username = ?", user.Username).Scan(&id)
+	if err == nil {
+		return "", ErrUserExists
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	if user.Email != "" {
+		err = tx.QueryRow("SELECT id FROM users WHERE email = ?", user.Email).Scan(&id)
+		if err == nil {
+			return "", ErrEmailExists
+		}
+		if err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO users (`username`, `email`, `emailVerified`, `admin`, `passwordHash`, `token`) VALUES (?, ?, ?, 0, '', '')",
+		user.Username,
+		nullableEmail{&user.Email},
+		user.EmailVerified,
+	)
+	if err != nil {
+		return "", err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	id = strconv.FormatInt(lastID, 10)
+	_, err = tx.Exec(
+		"INSERT INTO user_identities (`issuer`, `subject`, `userId`) VALUES (?, ?, ?)",
+		identity.Issuer, identity.Subject, id,
+	)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Identities returns the external identities linked to the user
+func (repo *Repo) Identities(userID string) ([]Identity, error) {
+	rows, err := repo.DB.Query(
+		"SELECT issuer, subject FROM user_identities WHERE userId = ? ORDER BY issuer, subject",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		identity := Identity{}
+		err = rows.Scan(&identity.Issuer, &identity.Subject)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// LinkIdentity links the external identity to the user, moving it from another user if needed
+func (repo *Repo) LinkIdentity(userID string, identity Identity) error {
+	_, err := repo.DB.Exec(
+		"INSERT INTO user_identities (`issuer`, `subject`, `userId`) VALUES (?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE `userId` = VALUES(`userId`)",
+		identity.Issuer, identity.Subject, userID,
+	)
+	return err
+}
+
 // add saves a new user into the database
 func (repo *Repo) add(user *User) (string, error) {
 
 	result, err := repo.DB.Exec(
-		"INSERT INTO users (`username`, `admin`, `passwordHash`, `token`) VALUES (?, ?, ?, ?)",
+		"INSERT INTO users (`username`, `email`, `emailVerified`, `admin`, `passwordHash`, `token`) VALUES (?, ?, ?, ?, ?, ?)",
 		user.Username,
+		nullableEmail{&user.Email},
+		user.EmailVerified,
 		user.Admin,
 		user.PasswordHash,
 		user.Token,
@@ -101,6 +454,22 @@ func (repo *Repo) add(user *User) (string, error) {
 	return retID, nil
 }
 
+// sortRoles orders the roles from the least privileged one
+func sortRoles(roles Roles) {
+	rank := map[Role]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+	sort.SliceStable(roles, func(i, j int) bool {
+		return rank[roles[i]] < rank[roles[j]]
+	})
+}
+
+func hasherOrDefault(h PasswordHasher) PasswordHasher {
+	if h == nil {
+		return DefaultHasher
+	}
+	return h
+}
+
+// getSHA256 is the legacy unsalted password hash, it's only verified and never stored anymore
 func getSHA256(value string) string {
 	sha256Instance := sha256.New()
 	sha256Instance.Write([]byte(value))
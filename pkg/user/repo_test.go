@@ -21,7 +21,7 @@ func TestGetByID(t *testing.T) {
 
 	// good query
 	rows := sqlmock.
-		NewRows([]string{"username", "admin", "id", "passwordHash", "token"})
+		NewRows([]string{"username", "email", "emailVerified", "admin", "id", "passwordHash", "token"})
 	user := &User{
 		Username:     "Coolguy",
 		Admin:        false,
@@ -33,11 +33,11 @@ func TestGetByID(t *testing.T) {
 		user,
 	}
 	for _, item := range expect {
-		rows = rows.AddRow(item.ID, item.Username, item.Admin, item.PasswordHash, item.Token)
+		rows = rows.AddRow(item.ID, item.Username, item.Email, item.EmailVerified, item.Admin, item.PasswordHash, item.Token)
 	}
 
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash, token").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash, token").
 		WithArgs(userID).
 		WillReturnRows(rows)
 
@@ -60,7 +60,7 @@ func TestGetByID(t *testing.T) {
 
 	// query db error
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash, token").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash, token").
 		WithArgs(userID).
 		WillReturnError(fmt.Errorf("db_error"))
 
@@ -87,7 +87,7 @@ func TestGetByName(t *testing.T) {
 
 	// good query
 	rows := sqlmock.
-		NewRows([]string{"username", "admin", "id", "passwordHash"})
+		NewRows([]string{"username", "email", "emailVerified", "admin", "id", "passwordHash"})
 	user := &User{
 		Username:     login,
 		Admin:        false,
@@ -98,11 +98,11 @@ func TestGetByName(t *testing.T) {
 		user,
 	}
 	for _, item := range expect {
-		rows = rows.AddRow(item.ID, item.Username, item.Admin, item.PasswordHash)
+		rows = rows.AddRow(item.ID, item.Username, item.Email, item.EmailVerified, item.Admin, item.PasswordHash)
 	}
 
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
 		WithArgs(login).
 		WillReturnRows(rows)
 
@@ -125,7 +125,7 @@ func TestGetByName(t *testing.T) {
 
 	// query db error
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
 		WithArgs(login).
 		WillReturnError(fmt.Errorf("db_error"))
 
@@ -154,7 +154,7 @@ func TestAuthorize(t *testing.T) {
 
 	// good query
 	rows := sqlmock.
-		NewRows([]string{"username", "admin", "id", "passwordHash"})
+		NewRows([]string{"username", "email", "emailVerified", "admin", "id", "passwordHash"})
 	user := &User{
 		Username:     login,
 		Admin:        false,
@@ -165,16 +165,23 @@ func TestAuthorize(t *testing.T) {
 		user,
 	}
 	for _, item := range expect {
-		rows = rows.AddRow(item.ID, item.Username, item.Admin, item.PasswordHash)
+		rows = rows.AddRow(item.ID, item.Username, item.Email, item.EmailVerified, item.Admin, item.PasswordHash)
 	}
 
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
 		WithArgs(login).
 		WillReturnRows(rows)
 
+	// the legacy hash is upgraded on login
+	mock.
+		ExpectExec("UPDATE users SET passwordHash").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
 	repo := &Repo{
-		DB: db,
+		DB:     db,
+		Hasher: testHasher,
 	}
 	item, err := repo.Authorize(login, password)
 	if err != nil {
@@ -185,14 +192,50 @@ func TestAuthorize(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 		return
 	}
+	if match, rehash, _ := testHasher.Verify(item.PasswordHash, password); !match || rehash {
+		t.Errorf("hash wasn't upgraded: %q", item.PasswordHash)
+		return
+	}
+	item.PasswordHash = hash
 	if !reflect.DeepEqual(item, expect[0]) {
 		t.Errorf("results not match, want %v, have %v", expect[0], item)
 		return
 	}
 
+	// the up to date hash is kept
+	upgraded, _ := testHasher.Hash(password)
+	mock.
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
+		WithArgs(login).
+		WillReturnRows(sqlmock.
+			NewRows([]string{"username", "email", "emailVerified", "admin", "id", "passwordHash"}).
+			AddRow(userID, login, "", false, false, upgraded))
+	item, err = repo.Authorize(login, password)
+	if err != nil || item.PasswordHash != upgraded {
+		t.Errorf("bad user %+v, %v", item, err)
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+
+	// wrong password
+	mock.
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
+		WithArgs(login).
+		WillReturnRows(sqlmock.
+			NewRows([]string{"username", "email", "emailVerified", "admin", "id", "passwordHash"}).
+			AddRow(userID, login, "", false, false, upgraded))
+	_, err = repo.Authorize(login, "wrong")
+	if err != ErrBadPass {
+		t.Errorf("expected %v, got %v", ErrBadPass, err)
+		return
+	}
+
 	// query db error
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
 		WithArgs(login).
 		WillReturnError(fmt.Errorf("db_error"))
 
@@ -208,11 +251,11 @@ func TestAuthorize(t *testing.T) {
 
 	// incorrect hash error
 	for _, item := range expect {
-		rows = rows.AddRow(item.ID, item.Username, item.Admin, "incorrectHash")
+		rows = rows.AddRow(item.ID, item.Username, item.Email, item.EmailVerified, item.Admin, "incorrectHash")
 	}
 
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
 		WithArgs(login).
 		WillReturnRows(rows)
 
@@ -250,6 +293,8 @@ func TestAdd(t *testing.T) {
 	mock.
 		ExpectExec("INSERT INTO users").
 		WithArgs(user.Username,
+			nil,
+			false,
 			user.Admin,
 			user.PasswordHash,
 			user.Token).
@@ -275,6 +320,8 @@ func TestAdd(t *testing.T) {
 	mock.
 		ExpectExec("INSERT INTO users").
 		WithArgs(user.Username,
+			nil,
+			false,
 			user.Admin,
 			user.PasswordHash,
 			user.Token).
@@ -294,6 +341,8 @@ func TestAdd(t *testing.T) {
 	mock.
 		ExpectExec("INSERT INTO users").
 		WithArgs(user.Username,
+			nil,
+			false,
 			user.Admin,
 			user.PasswordHash,
 			user.Token).
@@ -324,7 +373,7 @@ func TestRegister(t *testing.T) {
 
 	// good query
 	rows := sqlmock.
-		NewRows([]string{"username", "admin", "id", "passwordHash", "token"})
+		NewRows([]string{"username", "email", "emailVerified", "admin", "id", "passwordHash", "token"})
 	user := &User{
 		Username: login,
 		Admin:    false,
@@ -336,29 +385,32 @@ func TestRegister(t *testing.T) {
 	}
 
 	for _, item := range expect {
-		rows = rows.AddRow(item.ID, item.Username, item.Admin, hash, item.Token)
+		rows = rows.AddRow(item.ID, item.Username, item.Email, item.EmailVerified, item.Admin, hash, item.Token)
 	}
 
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
 		WithArgs(login).
 		WillReturnError(fmt.Errorf("user_not_found"))
 
 	mock.
 		ExpectExec("INSERT INTO users").
 		WithArgs(user.Username,
+			nil,
+			false,
 			user.Admin,
-			hash,
+			sqlmock.AnyArg(),
 			user.Token).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash, token").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash, token").
 		WithArgs(userID).
 		WillReturnRows(rows)
 
 	repo := &Repo{
-		DB: db,
+		DB:     db,
+		Hasher: testHasher,
 	}
 
 	item, err := repo.Register(user)
@@ -374,13 +426,19 @@ func TestRegister(t *testing.T) {
 		t.Errorf("results not match, want %v, have %v", expect[0], item)
 		return
 	}
+	if match, _, _ := testHasher.Verify(item.PasswordHash, password); !match {
+		t.Errorf("bad password hash %q", item.PasswordHash)
+		return
+	}
 
 	// insert user error
 	mock.
 		ExpectExec("INSERT INTO users").
 		WithArgs(user.Username,
+			nil,
+			false,
 			user.Admin,
-			user.PasswordHash,
+			sqlmock.AnyArg(),
 			user.Token).
 		WillReturnError(fmt.Errorf("db_error"))
 
@@ -398,13 +456,15 @@ func TestRegister(t *testing.T) {
 	mock.
 		ExpectExec("INSERT INTO users").
 		WithArgs(user.Username,
+			nil,
+			false,
 			user.Admin,
-			hash,
+			sqlmock.AnyArg(),
 			user.Token).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash, token").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash, token").
 		WithArgs(userID).
 		WillReturnError(fmt.Errorf("db_error"))
 
@@ -420,7 +480,7 @@ func TestRegister(t *testing.T) {
 
 	// user already exists error
 	rows = sqlmock.
-		NewRows([]string{"username", "admin", "id", "passwordHash"})
+		NewRows([]string{"username", "email", "emailVerified", "admin", "id", "passwordHash"})
 	user = &User{
 		Username:     login,
 		Admin:        false,
@@ -431,11 +491,11 @@ func TestRegister(t *testing.T) {
 		user,
 	}
 	for _, item := range expect {
-		rows = rows.AddRow(item.ID, item.Username, item.Admin, item.PasswordHash)
+		rows = rows.AddRow(item.ID, item.Username, item.Email, item.EmailVerified, item.Admin, item.PasswordHash)
 	}
 
 	mock.
-		ExpectQuery("SELECT id, username, admin, passwordHash").
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash").
 		WithArgs(login).
 		WillReturnRows(rows)
 
@@ -449,3 +509,428 @@ func TestRegister(t *testing.T) {
 		return
 	}
 }
+
+func TestAllAndSave(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := &Repo{
+		DB:     db,
+		Hasher: testHasher,
+	}
+	expect := []*User{
+		{ID: "1", Username: "first", PasswordHash: "hash1"},
+		{ID: "2", Username: "second", Admin: true, PasswordHash: "hash2", Token: "token"},
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "username", "email", "emailVerified", "admin", "passwordHash", "token"})
+	for _, item := range expect {
+		rows = rows.AddRow(item.ID, item.Username, item.Email, item.EmailVerified, item.Admin, item.PasswordHash, item.Token)
+	}
+	mock.
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash, token FROM users ORDER BY id").
+		WillReturnRows(rows)
+
+	users, err := repo.All()
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(users, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, users)
+		return
+	}
+
+	// query db error
+	mock.
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash, token FROM users").
+		WillReturnError(fmt.Errorf("db_error"))
+	_, err = repo.All()
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	mock.
+		ExpectExec("INSERT INTO users (.+) ON DUPLICATE KEY UPDATE").
+		WithArgs("2", "second", nil, false, true, "hash2", "token").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	err = repo.Save(expect[1])
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// the existing user keeps its password hash and token
+	mock.
+		ExpectExec("ON DUPLICATE KEY UPDATE (.+) `admin` = VALUES\\(`admin`\\)$").
+		WithArgs("2", "second", nil, false, true, "", "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	err = repo.SaveWithoutSecrets(&User{ID: "2", Username: "second", Admin: true})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	mock.
+		ExpectExec("INSERT INTO users").
+		WillReturnError(fmt.Errorf("db_error"))
+	err = repo.Save(expect[1])
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepo(db)
+
+	mock.
+		ExpectQuery("SELECT users.admin, user_roles.role FROM users LEFT JOIN user_roles").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"admin", "role"}).AddRow(true, "moderator"))
+	roles, err := repo.Roles("1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(roles, Roles{RoleUser, RoleModerator, RoleAdmin}) {
+		t.Errorf("bad roles %v", roles)
+	}
+
+	// a user without granted roles
+	mock.
+		ExpectQuery("SELECT users.admin, user_roles.role FROM users LEFT JOIN user_roles").
+		WithArgs("2").
+		WillReturnRows(sqlmock.NewRows([]string{"admin", "role"}).AddRow(false, nil))
+	roles, err = repo.Roles("2")
+	if err != nil || !reflect.DeepEqual(roles, Roles{RoleUser}) {
+		t.Errorf("bad roles %v, %v", roles, err)
+	}
+
+	mock.
+		ExpectQuery("SELECT users.admin, user_roles.role FROM users LEFT JOIN user_roles").
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"admin", "role"}))
+	_, err = repo.Roles("3")
+	if err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+
+	mock.
+		ExpectQuery("SELECT users.admin, user_roles.role FROM users").
+		WillReturnError(fmt.Errorf("db_error"))
+	_, err = repo.Roles("1")
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	// the admin role is the admin flag, the rest are kept in user_roles
+	mock.
+		ExpectExec("UPDATE users SET admin = 1 WHERE id = ?").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT IGNORE INTO user_roles").
+		WithArgs("1", "moderator").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("UPDATE users SET admin = 0 WHERE id = ?").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("DELETE FROM user_roles WHERE userId = \\? AND role = \\?").
+		WithArgs("1", "moderator").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, step := range []func() error{
+		func() error { return repo.GrantRole("1", RoleAdmin) },
+		func() error { return repo.GrantRole("1", RoleModerator) },
+		func() error { return repo.RevokeRole("1", RoleAdmin) },
+		func() error { return repo.RevokeRole("1", RoleModerator) },
+	} {
+		if err = step(); err != nil {
+			t.Errorf("unexpected err: %s", err)
+			return
+		}
+	}
+
+	if err = repo.GrantRole("1", Role("root")); err != ErrUnknownRole {
+		t.Errorf("expected %v, got %v", ErrUnknownRole, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepo(db)
+	identity := Identity{Issuer: "https://idp.example.com", Subject: "42"}
+
+	mock.
+		ExpectQuery("SELECT users.id, users.username, users.email, users.emailVerified, users.admin, users.passwordHash FROM users JOIN user_identities").
+		WithArgs(identity.Issuer, identity.Subject).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "emailVerified", "admin", "passwordHash"}).AddRow("1", "jane", "", false, false, ""))
+	u, err := repo.GetByIdentity(identity)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if u.ID != "1" || u.Username != "jane" {
+		t.Errorf("bad user %+v", u)
+	}
+
+	mock.
+		ExpectQuery("SELECT users.id").
+		WithArgs(identity.Issuer, identity.Subject).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "emailVerified", "admin", "passwordHash"}))
+	if _, err = repo.GetByIdentity(identity); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+
+	// a new user is created together with the identity
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT userId FROM user_identities").
+		WithArgs(identity.Issuer, identity.Subject).
+		WillReturnRows(sqlmock.NewRows([]string{"userId"}))
+	mock.
+		ExpectQuery("SELECT id FROM users WHERE username = ?").
+		WithArgs("jane").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.
+		ExpectExec("INSERT INTO users").
+		WithArgs("jane", nil, false).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.
+		ExpectExec("INSERT INTO user_identities").
+		WithArgs(identity.Issuer, identity.Subject, "2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	u, err = repo.RegisterExternal(&User{Username: "jane", Password: "ignored", Admin: true}, identity)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if u.ID != "2" || u.PasswordHash != "" || u.Admin {
+		t.Errorf("bad user %+v", u)
+	}
+
+	// the username is taken
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT userId FROM user_identities").
+		WillReturnRows(sqlmock.NewRows([]string{"userId"}))
+	mock.
+		ExpectQuery("SELECT id FROM users WHERE username = ?").
+		WithArgs("jane").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectRollback()
+	if _, err = repo.RegisterExternal(&User{Username: "jane"}, identity); err != ErrUserExists {
+		t.Errorf("expected %v, got %v", ErrUserExists, err)
+	}
+
+	// the identity is linked already
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT userId FROM user_identities").
+		WillReturnRows(sqlmock.NewRows([]string{"userId"}).AddRow("1"))
+	mock.ExpectRollback()
+	if _, err = repo.RegisterExternal(&User{Username: "jane"}, identity); err != ErrIdentityLinked {
+		t.Errorf("expected %v, got %v", ErrIdentityLinked, err)
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT userId FROM user_identities").
+		WillReturnError(fmt.Errorf("db_error"))
+	mock.ExpectRollback()
+	if _, err = repo.RegisterExternal(&User{Username: "jane"}, identity); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	mock.
+		ExpectQuery("SELECT issuer, subject FROM user_identities WHERE userId = ?").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"issuer", "subject"}).AddRow(identity.Issuer, identity.Subject))
+	identities, err := repo.Identities("1")
+	if err != nil || !reflect.DeepEqual(identities, []Identity{identity}) {
+		t.Errorf("bad identities %v, %v", identities, err)
+	}
+
+	mock.
+		ExpectExec("INSERT INTO user_identities .* ON DUPLICATE KEY UPDATE").
+		WithArgs(identity.Issuer, identity.Subject, "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = repo.LinkIdentity("1", identity); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := &Repo{DB: db, Hasher: testHasher}
+
+	mock.
+		ExpectExec("UPDATE users SET passwordHash").
+		WithArgs(sqlmock.AnyArg(), "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = repo.SetPassword("1", "new_password"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.
+		ExpectExec("UPDATE users SET passwordHash").
+		WithArgs(sqlmock.AnyArg(), "2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err = repo.SetPassword("2", "new_password"); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+
+	mock.
+		ExpectExec("UPDATE users SET passwordHash").
+		WillReturnError(fmt.Errorf("db_error"))
+	if err = repo.SetPassword("1", "new_password"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestEmails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("can't create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := &Repo{DB: db, Hasher: testHasher}
+	columns := []string{"id", "username", "email", "emailVerified", "admin", "passwordHash"}
+
+	// the taken email is checked on registration
+	mock.
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash FROM users WHERE username").
+		WithArgs("john").
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash FROM users WHERE email").
+		WithArgs("jane@example.com").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("1", "jane", "jane@example.com", true, false, ""))
+	if _, err = repo.Register(&User{Username: "john", Email: "jane@example.com", Password: "password"}); err != ErrEmailExists {
+		t.Errorf("expected %v, got %v", ErrEmailExists, err)
+	}
+
+	// a missing email is NULL
+	mock.
+		ExpectQuery("SELECT id, username, email, emailVerified, admin, passwordHash, token FROM users WHERE id").
+		WithArgs("2").
+		WillReturnRows(sqlmock.NewRows(append(columns, "token")).AddRow("2", "john", nil, false, false, "", ""))
+	u, err := repo.GetByID("2")
+	if err != nil || u.Email != "" {
+		t.Errorf("bad user %+v, %v", u, err)
+	}
+
+	mock.
+		ExpectQuery("SELECT (.+) FROM users WHERE email").
+		WithArgs("jane@example.com").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("1", "jane", "jane@example.com", true, false, ""))
+	if err = repo.SetEmail("2", "jane@example.com"); err != ErrEmailExists {
+		t.Errorf("expected %v, got %v", ErrEmailExists, err)
+	}
+
+	mock.
+		ExpectQuery("SELECT (.+) FROM users WHERE email").
+		WithArgs("john@example.com").
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.
+		ExpectExec("UPDATE users SET email = (.+), emailVerified = 0 WHERE id").
+		WithArgs("john@example.com", "2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = repo.SetEmail("2", "john@example.com"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	mock.
+		ExpectQuery("SELECT (.+) FROM users WHERE email").
+		WillReturnError(fmt.Errorf("db_error"))
+	if err = repo.SetEmail("2", "john@example.com"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	mock.
+		ExpectExec("UPDATE users SET emailVerified = 1 WHERE id = (.+) AND email").
+		WithArgs("2", "john@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = repo.VerifyEmail("2", "john@example.com"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	// the email verified already is fine
+	mock.
+		ExpectExec("UPDATE users SET emailVerified = 1 WHERE id = (.+) AND email").
+		WithArgs("2", "john@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("SELECT emailVerified FROM users WHERE id = (.+) AND email").
+		WithArgs("2", "john@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"emailVerified"}).AddRow(1))
+	if err = repo.VerifyEmail("2", "john@example.com"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	// the email replaced since the link was sent isn't verified
+	mock.
+		ExpectExec("UPDATE users SET emailVerified = 1 WHERE id = (.+) AND email").
+		WithArgs("2", "old@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("SELECT emailVerified FROM users WHERE id = (.+) AND email").
+		WithArgs("2", "old@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"emailVerified"}))
+	if err = repo.VerifyEmail("2", "old@example.com"); err != ErrNoUser {
+		t.Errorf("expected %v, got %v", ErrNoUser, err)
+	}
+
+	mock.
+		ExpectExec("UPDATE users SET emailVerified = 1 WHERE id").
+		WillReturnError(fmt.Errorf("db_error"))
+	if err = repo.VerifyEmail("2", "john@example.com"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
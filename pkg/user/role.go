@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"errors"
+)
+
+// Role groups the permissions granted to a user
+type Role string
+
+// Roles of the users, everybody has the user role, the admin role is the Admin flag
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Permission allows an action beyond managing one's own content
+type Permission string
+
+// Permissions granted by the roles
+const (
+	// PermModerate allows deleting anyone's posts and comments
+	PermModerate Permission = "moderate"
+	// PermManageRoles allows granting and revoking the roles
+	PermManageRoles Permission = "manageRoles"
+)
+
+// ErrUnknownRole is returned for the roles which can't be granted or revoked
+var ErrUnknownRole = errors.New("Unknown role")
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser:      {},
+	RoleModerator: {PermModerate},
+	RoleAdmin:     {PermModerate, PermManageRoles},
+}
+
+// Grantable reports whether the role is granted explicitly, the user role is implied
+func (r Role) Grantable() bool {
+	_, ok := rolePermissions[r]
+	return ok && r != RoleUser
+}
+
+// Roles are the roles of a user
+type Roles []Role
+
+// Has reports whether the role is among the roles
+func (rs Roles) Has(role Role) bool {
+	for _, r := range rs {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether any of the roles grants the permission
+func (rs Roles) Can(p Permission) bool {
+	for _, r := range rs {
+		for _, granted := range rolePermissions[r] {
+			if granted == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type rolesKey string
+
+// RolesKey keeps the roles of the authorized user in the request context
+var RolesKey rolesKey = "rolesKey"
+
+// RolesFromContext returns the roles of the authorized user, they are empty for the anonymous requests
+func RolesFromContext(ctx context.Context) Roles {
+	roles, _ := ctx.Value(RolesKey).(Roles)
+	return roles
+}
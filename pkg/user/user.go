@@ -4,12 +4,14 @@ import "errors"
 
 // User entity representation
 type User struct {
-	Username     string `json:"username"`
-	Admin        bool   `json:"amdin"`
-	ID           string `json:"id"`
-	PasswordHash string `json:"passwordHash"`
-	Password     string `json:"password"`
-	Token        string `json:"token"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	Admin         bool   `json:"admin"`
+	ID            string `json:"id"`
+	PasswordHash  string `json:"passwordHash"`
+	Password      string `json:"password"`
+	Token         string `json:"token"`
 }
 
 var (
@@ -17,4 +19,5 @@ var (
 	ErrBadPass       = errors.New("Invald password")
 	ErrInternalError = errors.New("Internal server error")
 	ErrUserExists    = errors.New("User name already exists")
+	ErrEmailExists   = errors.New("Email is already taken")
 )
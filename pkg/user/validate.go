@@ -0,0 +1,143 @@
+package user
+
+import (
+	"errors"
+	"strings"
+)
+
+// Limits of the usernames and passwords
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+	MinPasswordLength = 8
+	// MaxPasswordLength bounds the hashing work, bcrypt ignores the bytes after the 72nd anyway
+	MaxPasswordLength = 72
+	// minPasswordChars is the number of the distinct characters of a password, so "aaaaaaaa" isn't taken
+	minPasswordChars = 4
+)
+
+// The errors of the fields, they read as the continuations of the field names, e.g. "username is reserved"
+var (
+	ErrUsernameLength   = errors.New("must be 3 to 32 characters long")
+	ErrUsernameChars    = errors.New("may contain only latin letters, digits, '.', '_' and '-'")
+	ErrUsernameStart    = errors.New("must start with a letter or a digit")
+	ErrUsernameReserved = errors.New("is reserved")
+	ErrPasswordShort    = errors.New("must be at least 8 characters long")
+	ErrPasswordLong     = errors.New("must be at most 72 characters long")
+	ErrPasswordSimple   = errors.New("is too simple")
+	ErrPasswordCommon   = errors.New("is too common")
+	ErrPasswordName     = errors.New("must not contain the username")
+	ErrEmailInvalid     = errors.New("is not a valid email address")
+	ErrAlreadyTaken     = errors.New("is already taken")
+)
+
+// reservedUsernames could be mistaken for the staff or the app pages, they are reserved in any case
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true, "staff": true,
+	"moderator": true, "mod": true, "support": true, "help": true, "security": true,
+	"api": true, "login": true, "logout": true, "register": true, "me": true,
+	"post": true, "posts": true, "static": true, "null": true, "undefined": true,
+	"anonymous": true, "redditclone": true,
+}
+
+// commonPasswords are the most used passwords long enough to pass the length check
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true, "p@ssw0rd": true,
+	"12345678": true, "123456789": true, "1234567890": true, "87654321": true, "11111111": true,
+	"qwertyuiop": true, "qwerty123": true, "qwerty12": true, "1q2w3e4r": true, "1qaz2wsx": true,
+	"iloveyou": true, "sunshine": true, "princess": true, "football": true, "baseball": true,
+	"welcome1": true, "superman": true, "trustno1": true, "starwars": true, "whatever": true,
+	"letmein1": true, "zaq12wsx": true, "abc12345": true, "abcd1234": true, "computer": true,
+	"michelle": true, "jennifer": true, "mustang1": true, "shadow12": true, "master12": true,
+	"asdfghjkl": true, "asdf1234": true, "changeme": true, "internet": true, "dragon12": true,
+}
+
+// FieldError tells what is wrong with a field of a request
+type FieldError struct {
+	Field string
+	// Value is the rejected value, it's empty for the secrets
+	Value string
+	Err   error
+}
+
+// ValidationError lists the invalid fields
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, fe := range e {
+		parts = append(parts, fe.Field+" "+fe.Err.Error())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ValidateUsername checks the username of a new user
+func ValidateUsername(name string) error {
+	if len(name) < MinUsernameLength || len(name) > MaxUsernameLength {
+		return ErrUsernameLength
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+		default:
+			return ErrUsernameChars
+		}
+	}
+	if c := name[0]; c == '.' || c == '_' || c == '-' {
+		return ErrUsernameStart
+	}
+	if reservedUsernames[strings.ToLower(name)] {
+		return ErrUsernameReserved
+	}
+	return nil
+}
+
+// ValidatePassword checks the strength of a new password of the user
+func ValidatePassword(password, username string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordShort
+	}
+	if len(password) > MaxPasswordLength {
+		return ErrPasswordLong
+	}
+	chars := map[rune]bool{}
+	for _, r := range password {
+		chars[r] = true
+	}
+	if len(chars) < minPasswordChars {
+		return ErrPasswordSimple
+	}
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return ErrPasswordCommon
+	}
+	// the legacy short usernames would match too many passwords
+	if len(username) >= MinUsernameLength && strings.Contains(lower, strings.ToLower(username)) {
+		return ErrPasswordName
+	}
+	return nil
+}
+
+// ValidateRegistration checks the fields of a new user and normalizes the email,
+// it returns a ValidationError listing all the invalid fields
+func ValidateRegistration(u *User) error {
+	errs := ValidationError{}
+	if err := ValidateUsername(u.Username); err != nil {
+		errs = append(errs, FieldError{Field: "username", Value: u.Username, Err: err})
+	}
+	if err := ValidatePassword(u.Password, u.Username); err != nil {
+		errs = append(errs, FieldError{Field: "password", Err: err})
+	}
+	if u.Email != "" {
+		email, err := NormalizeEmail(u.Email)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "email", Value: u.Email, Err: ErrEmailInvalid})
+		} else {
+			u.Email = email
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
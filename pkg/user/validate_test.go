@@ -0,0 +1,83 @@
+package user
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+	}{
+		{"jane_doe", nil},
+		{"Jane.Doe-42", nil},
+		{"42jane", nil},
+		{"jo", ErrUsernameLength},
+		{strings.Repeat("a", 33), ErrUsernameLength},
+		{"jane doe", ErrUsernameChars},
+		{"jané", ErrUsernameChars},
+		{"<script>", ErrUsernameChars},
+		{"_jane", ErrUsernameStart},
+		{".jane", ErrUsernameStart},
+		{"Admin", ErrUsernameReserved},
+		{"API", ErrUsernameReserved},
+	}
+	for _, c := range cases {
+		if err := ValidateUsername(c.name); err != c.err {
+			t.Errorf("%q: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	cases := []struct {
+		password string
+		err      error
+	}{
+		{"correct horse battery", nil},
+		{"s3cure-enough", nil},
+		{"short1!", ErrPasswordShort},
+		{strings.Repeat("ab12", 19), ErrPasswordLong},
+		{"aaaaaaaaaa", ErrPasswordSimple},
+		{"abababab", ErrPasswordSimple},
+		{"Password1", ErrPasswordCommon},
+		{"12345678", ErrPasswordCommon},
+		{"qwerty123", ErrPasswordCommon},
+		{"my-jane_doe-pass", ErrPasswordName},
+		{"MY-JANE_DOE-PASS", ErrPasswordName},
+	}
+	for _, c := range cases {
+		if err := ValidatePassword(c.password, "jane_doe"); err != c.err {
+			t.Errorf("%q: expected %v, got %v", c.password, c.err, err)
+		}
+	}
+	// the legacy short usernames aren't looked for
+	if err := ValidatePassword("bobsled-race", "bo"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+}
+
+func TestValidateRegistration(t *testing.T) {
+	u := &User{Username: "jane_doe", Password: "s3cure-enough", Email: " jane@example.com "}
+	if err := ValidateRegistration(u); err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if u.Email != "jane@example.com" {
+		t.Errorf("email isn't normalized: %q", u.Email)
+	}
+
+	err := ValidateRegistration(&User{Username: "admin", Password: "admin", Email: "nope"})
+	expected := ValidationError{
+		{Field: "username", Value: "admin", Err: ErrUsernameReserved},
+		{Field: "password", Err: ErrPasswordShort},
+		{Field: "email", Value: "nope", Err: ErrEmailInvalid},
+	}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("results not match, want %v, have %v", expected, err)
+	}
+	if err.Error() != "username is reserved, password must be at least 8 characters long, email is not a valid email address" {
+		t.Errorf("bad message %q", err.Error())
+	}
+}